@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/handlers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/ical"
+)
+
+// startICalSync imports every room's external iCal calendar now, & then every app.ICalSyncInterval,
+// in the background, until ctx is done. jobs is done once it has stopped
+func startICalSync(ctx context.Context, jobs *sync.WaitGroup) {
+	if app.ICalSyncInterval <= 0 {
+		return
+	}
+
+	importer := ical.NewImporter(handlers.Repo.DB)
+
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+
+		// NOTES: a time.Ticker sends on its channel C every interval, which makes it easy to run a job
+		// on a schedule in the background
+		ticker := time.NewTicker(app.ICalSyncInterval)
+		defer ticker.Stop()
+
+		for {
+			syncCtx, cancel := context.WithTimeout(ctx, app.ICalSyncInterval)
+			if err := importer.SyncAll(syncCtx); err != nil && ctx.Err() == nil {
+				app.Logger.Error("iCal import", "error", err)
+			}
+			cancel()
+
+			// NOTES: select waits for whichever channel is ready first, so this waits for the next tick,
+			// but stops straight away when the app is shutting down
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/gob"
-	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -14,13 +19,17 @@ import (
 	"github.com/gustavNdamukong/hotel-bookings/internal/driver"
 	"github.com/gustavNdamukong/hotel-bookings/internal/handlers"
 	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/metrics"
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/photos"
+	"github.com/gustavNdamukong/hotel-bookings/internal/reminders"
 	"github.com/gustavNdamukong/hotel-bookings/internal/render"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
+var settings config.Settings
 var session *scs.SessionManager
 var infoLog *log.Logger
 var errorLog *log.Logger
@@ -29,16 +38,38 @@ var errorLog *log.Logger
 func main() {
 	// NOTES: add to debug notes that the equivalent of dump & die in go is
 	// log.Fatal(err) coz it will abort the app execution & log the error. Remember to import log above though
-	db, err := run()
+	db, err := run(os.Args[1:], os.LookupEnv)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
-	defer close(app.MailChan)
+	started := time.Now()
+
+	// NOTES: signal.NotifyContext gives a context that is done when the app is asked to stop, ie Ctrl+C
+	// (SIGINT) in the terminal, or SIGTERM from eg docker or systemd. Without it, the app just dies on the spot,
+	// dropping whatever requests it was in the middle of
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// NOTES: a new install starts with an example sender, & sends no emails until an admin sets the real one
+	if property, err := handlers.Repo.DB.GetProperty(ctx); err == nil && !property.HasSender() {
+		app.Logger.Warn("no sender email is set, so no emails will be sent until one is set at /admin/property")
+	}
+
+	app.Logger.Info("Starting mail worker")
+	mailCtx, stopMail := context.WithCancel(context.Background())
+	mailWorker := mail.NewOutboxWorker(handlers.Repo.DB, app.Mailer)
+	mailWorker.Logger = app.Logger
+	app.MailWorker = mailWorker
+	mailStopped := startMailWorker(mailCtx, mailWorker)
 
-	fmt.Println("Starting mail listener")
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
 
-	listenForMail()
+	app.Logger.Info("Starting iCal calendar import")
+	startICalSync(jobsCtx, &jobs)
+
+	app.Logger.Info("Starting scheduled guest emails")
+	startNotificationScheduler(jobsCtx, &jobs)
 	/* We dont wanna be sending an email every time we start our server, just yet
 	msg := models.MailData{
 		To:      "john@do.ca",
@@ -384,46 +415,88 @@ func main() {
 	*/
 	//----------------------end sending email with standard library------------------------
 
-	fmt.Printf(fmt.Sprintf("Starting application on port %s", portNumber))
+	app.Logger.Info("Starting application", "addr", settings.Addr(), "production", app.InProduction)
 
 	serve := &http.Server{
-		Addr:    portNumber,
+		Addr:    settings.Addr(),
 		Handler: routes(&app),
 	}
 
-	err = serve.ListenAndServe()
+	// the server runs in the background, so that main can wait for it to fail, or for the signal to stop
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- serve.ListenAndServe()
+	}()
+
+	failed := false
+	select {
+	case err := <-serverErr:
+		// eg the port is already in use. Still stop everything else cleanly
+		app.Logger.Error("server failed", "error", err)
+		failed = true
+	case <-ctx.Done():
+		app.Logger.Info("Shutting down, press Ctrl+C again to stop straight away")
+	}
+	// from here a second signal kills the app as usual
+	stop()
+
+	err = shutdown{
+		server:      serve,
+		stopJobs:    stopJobs,
+		jobs:        &jobs,
+		stopMail:    stopMail,
+		mailStopped: mailStopped,
+		mailWorker:  mailWorker,
+		repo:        handlers.Repo.DB,
+		db:          db,
+		started:     started,
+	}.run(settings.ShutdownTimeout)
 	if err != nil {
-		log.Fatal(err)
+		app.Logger.Error("shutdown", "error", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
 	}
 }
 
-func run() (*driver.DB, error) {
+// run sets the app up from its settings, read from args (the command line flags) & lookupEnv (the environment),
+// & connects to the DB
+func run(args []string, lookupEnv func(string) (string, bool)) (*driver.DB, error) {
 	// Register the models.Reservation type with gob
 	// What kind of stuff will i be putting in the session. Register them all here
 	gob.Register(models.Reservation{})
+	gob.Register(models.Booking{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
 
-	// read flags
-	inProduction := flag.Bool("production", true, "Application is in production")
-	useCache := flag.Bool("cache", true, "Use template cache")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPass := flag.String("dbpass", "", "Database password")
-	dbPort := flag.String("dbport", "5432", "Database port")
-	dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require)")
-
-	flag.Parse()
+	// read the settings: defaults, then the config file, the environment & finally the flags
+	loaded, err := config.LoadSettings(args, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	settings = loaded
 
-	if *dbName == "" || *dbUser == "" {
-		fmt.Println("Missing required flags")
-		os.Exit(1)
+	// set up logging. Create a structured logger that writes to the terminal (os.Stdout), as text in
+	// development, & as JSON lines in production so log collectors can search it by field, eg request_id
+	logLevel, _ := logging.ParseLevel(settings.LogLevel) // LoadSettings has checked it
+	logger, err := logging.New(os.Stdout, settings.LogFormat, logLevel)
+	if err != nil {
+		return nil, err
 	}
+	app.Logger = logger
+	// NOTES: slog.NewLogLogger makes an old-style *log.Logger whose lines go through the structured logger
+	// at the given level, for code that still logs with Println
+	infoLog = slog.NewLogLogger(logger.Handler(), slog.LevelInfo)
+	app.InfoLog = infoLog
+	errorLog = slog.NewLogLogger(logger.Handler(), slog.LevelError)
+	app.ErrorLog = errorLog
 	/*
-		NOTES: The above 'read flags' section is how you create commands to be used in the CLI.
+		NOTES: The app used to read its settings straight from flags, as below. They are now defined in
+		config.bindFlags (internal/config/settings.go), & can also come from a config file or environment variables.
+		The flags are still how you create commands to be used in the CLI.
 		You use the built-in flag object
 		when it comes to postgres the values for using SSL are
 			disable (default)
@@ -509,42 +582,67 @@ func run() (*driver.DB, error) {
 		  it works fine. Try login in too.
 	*/
 
-	// NOTES: How to create a channel
-	//------------------------------------
-	mailChan := make(chan models.MailData)
-	//add it to the app's config (struct)
-	app.MailChan = mailChan
-	// Note that we close this channel coz all channels must be closed for performance reasons
-	// We cannot close it in this run() func since its like an init func that runs once the app
-	// starts & sets up everything, if we clode it here it will close the channel as soon as it
-	// creates it-hence we close it outside this channel, at the start of this main() func like
-	// so: defer close(app.MailChan) in the same way we close the DB connect func (defer db.SQL.Close())
-	//------------------------------------
-
-	// change this to true when in production
-	app.InProduction = *inProduction
-	app.UseCache = *useCache
-
-	// set up logging. Create a new logger that writes to the terminal (os.Stdout), prefix the msg
-	// with "INFO" & a tab, followed by the date & time
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
+	// NOTES: emails used to be sent through an in-memory channel (app.MailChan), which lost any queued
+	// emails when the app stopped. Now handlers queue them in the email_outbox table instead, & the mail
+	// worker started in main() sends them (see send-mail.go)
+
+	app.InProduction = settings.Production
+	app.UseCache = settings.UseCache
+	app.DBTimeout = settings.DB.Timeout
+	app.BaseURL = settings.BaseURL
+	app.CancelCutoff = settings.CancelCutoff
+	app.Maintenance = settings.Maintenance
+	app.ICalSyncInterval = settings.ICalSyncInterval
+	app.MailPollInterval = settings.Mail.PollInterval
+	app.NotificationInterval = settings.Notifications.Interval
+	// the schedules were already checked by LoadSettings
+	app.NotificationSchedules, _ = reminders.ParseSchedules(settings.Notifications.Schedules)
+
+	app.Metrics = metrics.New()
+
+	appMailer, err := newMailer(settings.Mail)
+	if err != nil {
+		return nil, err
+	}
+	// count the emails sent & failed, after retries, for /metrics
+	app.Mailer = app.Metrics.Mailer(appMailer)
 
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	// uploaded room photos are kept on the local disk & served by the app under /uploads, see routes()
+	app.PhotoStorage, err = photos.NewDiskStorage(settings.Uploads.Dir, "/uploads")
+	if err != nil {
+		return nil, err
+	}
+	app.MaxUploadSize = int64(settings.Uploads.MaxSizeMB) << 20
+
+	app.SigningKey = []byte(settings.SigningKey)
+	if len(app.SigningKey) == 0 {
+		// without a fixed key, links in emails stop working whenever the app restarts. LoadSettings only
+		// allows this in development
+		app.SigningKey = make([]byte, 32)
+		if _, err := rand.Read(app.SigningKey); err != nil {
+			return nil, err
+		}
+		app.Logger.Warn("No -signingkey given, using a random one. Guest reservation links will break on restart")
+	}
+
+	app.Currency = settings.Payments.Currency
+	app.Payments, err = newPaymentProvider(settings.Payments, settings.BaseURL)
+	if err != nil {
+		return nil, err
+	}
 
 	// initialise a session
 	session = scs.New()
 
 	// optionally set lifetime of session
 	// 24 hours. A syntax error in this time specification will cause the session setting & retrieving of data not to work
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = settings.Session.Lifetime
 
 	// Name sets the name of the session cookie. It should not contain
 	// The default cookie name is "session".
 	// If your application uses two different sessions, you must make sure that
 	// the cookie name for each of these sessions is unique.
-	session.Cookie.Name = "testProj_session_id"
+	session.Cookie.Name = settings.Session.CookieName
 	//by default it uses cookie for itas data storage, but it has different storages u can choose from eg DBs
 	session.Cookie.Persist = true // should the cookie persist after user closes the browser?
 	session.Cookie.SameSite = http.SameSiteLaxMode
@@ -553,25 +651,38 @@ func run() (*driver.DB, error) {
 	app.Session = session
 
 	// connect to DB
-	log.Println("Connecting to DB")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	db, err := driver.ConnectSQL(connectionString)
+	app.Logger.Info("Connecting to DB", "host", settings.DB.Host, "database", settings.DB.Name)
+	db, err := driver.ConnectSQL(settings.DB.DSN(), driver.Pool{
+		MaxOpenConns:    settings.DB.MaxOpenConns,
+		MaxIdleConns:    settings.DB.MaxIdleConns,
+		ConnMaxLifetime: settings.DB.ConnMaxLifetime,
+	})
 	// connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s" port=5432 hotel-bookings user=user ")
 	//db, err := driver.ConnectSQL("host=localhost port=5432 dbname=hotel-bookings user=user password=")
 	if err != nil {
-		log.Fatal("Cannot cronnecting to database! Dying...")
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
 	}
-	log.Println("Connected to database")
+	app.Logger.Info("Connected to database")
 
 	templateCache, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal("Cannot create template cache")
-		return nil, err
+		return nil, fmt.Errorf("cannot create template cache: %w", err)
 	}
 
 	app.DefaultAppTitle = "Hotel Reservation App"
 	app.TemplateCache = templateCache
 
+	emailTemplates, err := mail.NewTemplates(mail.DefaultTemplateDir, app.UseCache)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create email template cache: %w", err)
+	}
+	emailTemplates.Currency = app.Currency
+	app.EmailTemplates = emailTemplates
+
+	if err := reminders.CheckTemplates(app.NotificationSchedules, emailTemplates); err != nil {
+		return nil, fmt.Errorf("-notifications: %w", err)
+	}
+
 	//set things up with our handlers
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
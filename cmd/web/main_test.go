@@ -1,13 +1,27 @@
 package main
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
+
+// noEnv is an empty environment, so the tests don't depend on any BOOKINGS_ variables set where they run
+func noEnv(string) (string, bool) { return "", false }
 
 // NOTES: add note that to run tests from CLI, u need to be in directory where test files are
 // if you want to run specific tests.
 // NOTES: find out what you want to do if you want to run all tests in the app, in one go
 func TestRun(t *testing.T) {
-	_, err := run()
-	if err != nil {
-		t.Error("Failed run()")
+	// run used to call os.Exit when required flags were missing, which stopped the tests. It now returns
+	// an error listing every setting that is missing or invalid
+	_, err := run([]string{"-mailer=carrier-pigeon"}, noEnv)
+	if err == nil {
+		t.Fatal("expected run() to fail without the database settings")
+	}
+
+	for _, want := range []string{"-dbname is required", "-dbuser is required", "-mailer must be one of"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to mention %q, got %q", want, err)
+		}
 	}
 }
@@ -1,9 +1,22 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/gustavNdamukong/hotel-bookings/internal/apikeys"
+	"github.com/gustavNdamukong/hotel-bookings/internal/handlers"
 	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
+	"github.com/gustavNdamukong/hotel-bookings/internal/roles"
 	"github.com/justinas/nosurf"
 )
 
@@ -19,15 +32,169 @@ func NoSurf(next http.Handler) http.Handler {
 		Secure:   app.InProduction,
 		SameSite: http.SameSiteLaxMode,
 	})
+
+	// the /api routes are called by machine clients that never load one of our forms, so they can't
+	// send a csrf token. They send an API key in the Authorization header instead, which a browser never adds
+	// to a request another site makes it send. NOTES: APIAuth also lets in staff logged in with the session
+	// cookie, which a browser does send, so a request without the header still needs the token
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/api/") && r.Header.Get("Authorization") != ""
+	})
+
+	// a form sent without a valid token gets the 400 error page, instead of nosurf's plain text. NoSurf runs
+	// before SessionLoad, so the page needs the session loading for it
+	csrfHandler.SetFailureHandler(SessionLoad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+	})))
 	return csrfHandler
 }
 
+// LimitUploadSize stops a form with files in it (a multipart form, eg a room photo) from being any bigger than
+// the biggest upload allowed, plus 1MB for its other fields. It comes before NoSurf, which reads the whole
+// form to find the CSRF token. Other forms are limited to 10MB by ParseForm() anyway
+func LimitUploadSize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			r.Body = http.MaxBytesReader(w, r.Body, app.MaxUploadSize+1<<20)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // NOTES: SessionLoad is a middleware func to make your application session-aware, in other words, make it use sessions
 // Without it basically; you won't be able to save & retrieve data from a session
 func SessionLoad(next http.Handler) http.Handler {
 	// LoadAndSave() is a built-in func that auto-loads & saves session data for the current request &
 	// sends the session token to & from the client in a cookie
-	return session.LoadAndSave(next)
+	return session.LoadAndSave(recordUser(loadRole(next)))
+}
+
+// loadRole puts the logged in user's role in the session if it isn't there. Sessions from before roles were
+// added have a user_id but no role, & would otherwise be locked out of admin until the user logged in again
+func loadRole(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if session.Exists(r.Context(), "user_id") && !session.Exists(r.Context(), "role") {
+			user, err := handlers.Repo.DB.GetUserById(r.Context(), session.GetInt(r.Context(), "user_id"))
+			if err != nil {
+				// without a role, they can only use what needs none. It is looked up again on their next request
+				app.Logger.ErrorContext(r.Context(), "cannot load the user's role", "error", err)
+			} else {
+				session.Put(r.Context(), "role", string(roles.FromAccessLevel(user.AccessLevel)))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordUser tells RequestLogger who is logged in, which it can only find out once the session is loaded
+func recordUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := logging.Info(r.Context()); info != nil {
+			info.UserID = session.GetInt(r.Context(), "user_id")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestID gives every request an ID, which is put in its context so that everything logged about the
+// request (including by the repository & the mail worker) has it. The ID is taken from the X-Request-ID
+// header if the load balancer sent one, & is sent back in the same header
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.HeaderRequestID)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+
+		w.Header().Set(logging.HeaderRequestID, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// RequestLogger logs every request once it has been handled: its method, chi route pattern, status,
+// how long it took & who made it. Use it after RequestID, so the line has the request ID
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, info := logging.WithRequestInfo(r.Context())
+		r = r.WithContext(ctx)
+
+		// NOTES: RemoteAddr is 'host:port'. SplitHostPort also copes with IPv6 addresses like '[::1]:8080'
+		info.IP = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			info.IP = host
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		// NOTES: defer makes sure the request is logged even if a handler panics (Recoverer, further down
+		// the chain, turns the panic into a 500 first)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := ""
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+
+			level := slog.LevelInfo
+			switch {
+			case status >= 500:
+				level = slog.LevelError
+			case route == "/healthz" || route == "/readyz" || route == "/metrics":
+				// the load balancer & Prometheus call these every few seconds, so they would drown out
+				// everything else
+				level = slog.LevelDebug
+			}
+
+			app.Logger.LogAttrs(r.Context(), level, "request",
+				slog.String("method", r.Method),
+				slog.String("route", route),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Duration("duration", time.Since(start)),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Int("user_id", info.UserID),
+				slog.Int("api_key_id", info.APIKeyID),
+			)
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// Recoverer turns a panic in a handler into the 500 error page, & logs it with its stack trace. It is used
+// after SessionLoad, as the page needs the session. chi's middleware.Recoverer still catches panics
+// anywhere else, eg in /metrics, with a plain 500
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				// NOTES: http.ErrAbortHandler is how a handler deliberately aborts a response, so it is passed on
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+				helpers.ServerError(w, r, fmt.Errorf("panic: %v", rvr))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Maintenance shows the maintenance page (a 503) while the site is in maintenance mode. Logged in staff can
+// still use the site, & the login page & static files stay up so that they can log in
+func Maintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Maintenance && !helpers.IsAuthenticated(r) &&
+			r.URL.Path != "/user/login" && !strings.HasPrefix(r.URL.Path, "/static/") {
+			helpers.ErrorPage(w, r, http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // NOTES: Here is how you create a middleware. In this case we want to create one that will be used on
@@ -47,3 +214,77 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireRole only lets logged in users with the given role, or a more trusted one, through. Use it after
+// Auth, on routes that not all staff should be able to use.
+// NOTES: like APIAuth, it takes an argument so it returns the middleware. To apply it to a single route
+// in a group, use mux.With(RequireRole(roles.Manager)).Get(...)
+func RequireRole(min roles.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !helpers.HasRole(r, min) {
+				helpers.ClientError(w, r, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIAuth is the /api version of Auth. It lets a request through if it carries an
+// "Authorization: Bearer <key>" header with an active API key that has the given scope, or if it comes
+// from a logged in admin's browser (which NoSurf makes send a CSRF token to change anything). Instead of
+// redirecting to the login page, it sends back a JSON error.
+// NOTES: because APIAuth needs an argument, it returns the middleware rather than being one itself, so it
+// is used like this: mux.Use(APIAuth(apikeys.ScopeReservationsRead))
+func APIAuth(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				if !helpers.IsAuthenticated(r) {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					helpers.ErrorJSON(w, http.StatusUnauthorized, "authentication required", nil)
+					return
+				}
+				// staff in a browser get the same rights they have in admin: anyone can read, but only
+				// managers can change or cancel reservations
+				if scope != apikeys.ScopeReservationsRead && !helpers.HasRole(r, roles.Manager) {
+					helpers.ErrorJSON(w, http.StatusForbidden, "your role does not allow this", nil)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, ok := apikeys.FromHeader(header)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				helpers.ErrorJSON(w, http.StatusUnauthorized, "the Authorization header must be 'Bearer <api key>'", nil)
+				return
+			}
+
+			apiKey, err := handlers.Repo.DB.GetAPIKeyByHash(r.Context(), apikeys.Hash(key))
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot check API key", nil)
+				return
+			}
+			if err != nil || !apiKey.RevokedAt.IsZero() {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				helpers.ErrorJSON(w, http.StatusUnauthorized, "invalid API key", nil)
+				return
+			}
+
+			if info := logging.Info(r.Context()); info != nil {
+				info.APIKeyID = apiKey.ID
+			}
+
+			if !apikeys.HasScope(apiKey, scope) {
+				helpers.ErrorJSON(w, http.StatusForbidden, "this API key does not have the "+scope+" scope", nil)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,8 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/gustavNdamukong/hotel-bookings/internal/apikeys"
+	"github.com/gustavNdamukong/hotel-bookings/internal/handlers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
+	"github.com/gustavNdamukong/hotel-bookings/internal/roles"
 )
 
 /*
@@ -32,6 +42,44 @@ func TestNoSurf(t *testing.T) {
 	}
 }
 
+func TestNoSurf_API(t *testing.T) {
+	// a rejected request gets the 400 error page, which needs the session & the helpers
+	helpers.NewHelpers(&app)
+	if session == nil {
+		session = scs.New()
+		defer func() { session = nil }()
+	}
+
+	var tests = []struct {
+		name               string
+		header             string
+		expectedStatusCode int
+	}{
+		{"API key", "Bearer hb_test_write", http.StatusOK},
+		// eg a form on another site, sent with a logged in manager's session cookie
+		{"cookie only", "", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		var myH myHandler
+		h := NoSurf(&myH)
+
+		req := httptest.NewRequest("POST", "/api/v1/reservations", strings.NewReader(`{"room_id": 1}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "session", Value: "some-session-token"})
+		if e.header != "" {
+			req.Header.Set("Authorization", e.header)
+		}
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
 func TestSessionLoad(t *testing.T) {
 	// we need a way to setup the environment before this test runs
 	var myH myHandler
@@ -44,3 +92,146 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not an http.Handler, but is %T", v))
 	}
 }
+
+func TestRequireRole(t *testing.T) {
+	var myH myHandler
+	h := RequireRole(roles.Manager)(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not an http.Handler, but is %T", v))
+	}
+}
+
+func TestLoadRole(t *testing.T) {
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+	if session == nil {
+		session = scs.New()
+		defer func() { session = nil }()
+	}
+
+	var tests = []struct {
+		name         string
+		userID       int
+		role         string
+		expectedRole string
+	}{
+		// logged in before roles were added, so the role comes from their access_level
+		{"session without a role", 1, "", string(roles.Manager)},
+		{"session with a role", 1, string(roles.Owner), string(roles.Owner)},
+		{"user gone", 9, "", ""},
+		{"not logged in", 0, "", ""},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		if e.userID != 0 {
+			session.Put(ctx, "user_id", e.userID)
+		}
+		if e.role != "" {
+			session.Put(ctx, "role", e.role)
+		}
+
+		var myH myHandler
+		loadRole(&myH).ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+
+		if role := session.GetString(ctx, "role"); role != e.expectedRole {
+			t.Errorf("%s: expected role %q but got %q", e.name, e.expectedRole, role)
+		}
+	}
+}
+
+// apiAuthTests is the data for the APIAuth tests. The test repository knows about the keys used here
+var apiAuthTests = []struct {
+	name               string
+	header             string
+	scope              string
+	expectedStatusCode int
+}{
+	{"read key, read scope", "Bearer hb_test_read", apikeys.ScopeReservationsRead, http.StatusOK},
+	{"read key, write scope", "Bearer hb_test_read", apikeys.ScopeReservationsWrite, http.StatusForbidden},
+	{"write key, write scope", "Bearer hb_test_write", apikeys.ScopeReservationsWrite, http.StatusOK},
+	{"revoked key", "Bearer hb_test_revoked", apikeys.ScopeReservationsRead, http.StatusUnauthorized},
+	{"unknown key", "Bearer hb_nope", apikeys.ScopeReservationsRead, http.StatusUnauthorized},
+	{"not bearer", "Basic dXNlcjpwYXNz", apikeys.ScopeReservationsRead, http.StatusUnauthorized},
+}
+
+func TestAPIAuth(t *testing.T) {
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+
+	for _, e := range apiAuthTests {
+		var myH myHandler
+		h := APIAuth(e.scope)(&myH)
+
+		req := httptest.NewRequest("GET", "/api/v1/reservations/1", nil)
+		req.Header.Set("Authorization", e.header)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	})))
+
+	// a valid ID sent by the load balancer is kept
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(logging.HeaderRequestID, "lb-1234")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if seen != "lb-1234" || rr.Header().Get(logging.HeaderRequestID) != "lb-1234" {
+		t.Errorf("expected request ID lb-1234, got %q in the context & %q in the response", seen, rr.Header().Get(logging.HeaderRequestID))
+	}
+
+	// one that could break the logs is replaced
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(logging.HeaderRequestID, "bad\nid")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if seen == "" || seen == "bad\nid" || rr.Header().Get(logging.HeaderRequestID) != seen {
+		t.Errorf("expected a new request ID, got %q", seen)
+	}
+}
+
+func TestLimitUploadSize(t *testing.T) {
+	app.MaxUploadSize = 1 << 20
+	defer func() { app.MaxUploadSize = 0 }()
+
+	var tests = []struct {
+		name        string
+		contentType string
+		size        int
+		expectedErr bool
+	}{
+		{"small upload", "multipart/form-data; boundary=x", 1 << 20, false},
+		{"big upload", "multipart/form-data; boundary=x", 3 << 20, true},
+		{"other form", "application/x-www-form-urlencoded", 3 << 20, false},
+	}
+
+	for _, e := range tests {
+		var err error
+		h := LimitUploadSize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err = io.ReadAll(r.Body)
+		}))
+
+		req := httptest.NewRequest("POST", "/admin/rooms/1/photos", strings.NewReader(strings.Repeat("x", e.size)))
+		req.Header.Set("Content-Type", e.contentType)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if (err != nil) != e.expectedErr {
+			t.Errorf("%s: expected an error %v, but got %v", e.name, e.expectedErr, err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/config"
+	"github.com/gustavNdamukong/hotel-bookings/internal/payments"
+)
+
+// newPaymentProvider builds the gateway guests pay their deposits through, or returns nil if payments are
+// turned off
+func newPaymentProvider(s config.PaymentSettings, baseURL string) (payments.Provider, error) {
+	switch s.Provider {
+	case "":
+		return nil, nil
+	case "fake":
+		// the fake gateway's webhooks come from the app itself, so without a secret a random one will do
+		secret := []byte(s.WebhookSecret)
+		if len(secret) == 0 {
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+		}
+		return payments.NewFakeProvider(secret, baseURL+"/payments/fake"), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q, use fake or leave it empty", s.Provider)
+	}
+}
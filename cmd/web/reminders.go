@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/handlers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/reminders"
+)
+
+// startNotificationScheduler queues the scheduled guest emails (eg reminders before arrival) that are due
+// now, & then every app.NotificationInterval, in the background, until ctx is done. The mail worker then
+// sends them. jobs is done once it has stopped
+func startNotificationScheduler(ctx context.Context, jobs *sync.WaitGroup) {
+	if app.NotificationInterval <= 0 || len(app.NotificationSchedules) == 0 {
+		return
+	}
+
+	scheduler := reminders.NewScheduler(handlers.Repo.DB, app.EmailTemplates, app.NotificationSchedules, app.BaseURL, app.SigningKey)
+
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+
+		ticker := time.NewTicker(app.NotificationInterval)
+		defer ticker.Stop()
+
+		for {
+			runCtx, cancel := context.WithTimeout(ctx, app.NotificationInterval)
+			queued, err := scheduler.Run(runCtx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				app.Logger.Error("scheduled emails", "error", err)
+			}
+			if queued > 0 {
+				app.Logger.Info("queued scheduled emails", "count", queued)
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
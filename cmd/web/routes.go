@@ -5,66 +5,192 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/gustavNdamukong/hotel-bookings/internal/apikeys"
 	"github.com/gustavNdamukong/hotel-bookings/internal/config"
 	"github.com/gustavNdamukong/hotel-bookings/internal/handlers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/photos"
+	"github.com/gustavNdamukong/hotel-bookings/internal/roles"
 )
 
 func routes(app *config.AppConfig) http.Handler {
 	// create an http handler (aka a MUX or multiplexer)
 	mux := chi.NewRouter()
 
+	// give every request an ID & log it. These come first so that requests that panic are logged & counted too
+	mux.Use(RequestID)
+	mux.Use(RequestLogger)
+	// count every request, by its route, for /metrics
+	mux.Use(app.Metrics.Middleware)
 	mux.Use(middleware.Recoverer)
-	mux.Use(NoSurf) //ignore any post request that doesn't have a proper CSRF token
-	// NOTES: Here is how you use a middleware already defined in 'cmd/web/middleware.go/
-	mux.Use(SessionLoad)
-
-	mux.Get("/", handlers.Repo.Home)
-	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
-	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
-
-	// NOTES: How to parse a URL parameter sent from an HTML link
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
-	mux.Get("/book-room", handlers.Repo.BookRoom)
-
-	mux.Get("/contact", handlers.Repo.Contact)
-
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
-	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Post("/user/login", handlers.Repo.PostShowLogin)
-
-	mux.Get("/user/logout", handlers.Repo.Logout)
-
-	//create a file server to serve any files or images etc
-	fileserver := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileserver))
-
-	// NOTES: How to define a group of routes only available ONLY to authenticated users
-	// In this case, we are saying this should apply to any route that starts with '/admin'. This will be
-	// the group eg '/admin/properties', '/admin/dashboard' etc
-	mux.Route("/admin", func(mux chi.Router) {
-		// NOTES: Here is how you use a middleware. This middleware 'Auth' is defined in 'cmd/web/middleware.go/
-		// in this case, we want to apply the 'Auth' middleware to all routes in this group, which inthis case will
-		// only allow access to authenticaterd users.
-
-		// NOTES: Commenting the following line out (mux.Use(Auth)) turns off authentrication for this route group
-		mux.Use(Auth)
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-
-		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
-
-		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminShowPostReservation)
+
+	// the load balancer & Prometheus call these without cookies, so they are registered before the session
+	// & CSRF middleware below, & need neither
+	mux.Get("/healthz", handlers.Repo.Healthz)
+	mux.Get("/readyz", handlers.Repo.Readyz)
+	mux.Get("/metrics", handlers.Repo.Metrics)
+	// the payment provider calls this to tell us how payments went. It has no cookies or CSRF token either, &
+	// is checked by the webhook's signature instead
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+
+	// NOTES: chi doesn't allow mux.Use() after routes have been added to a router, so every other route is in
+	// this group, which has middleware of its own
+	mux.Group(func(mux chi.Router) {
+		mux.Use(LimitUploadSize)
+		mux.Use(NoSurf) //ignore any post request that doesn't have a proper CSRF token
+		// NOTES: Here is how you use a middleware already defined in 'cmd/web/middleware.go/
+		mux.Use(SessionLoad)
+		// panics from here on get the 500 error page, which needs the session
+		mux.Use(Recoverer)
+		mux.Use(Maintenance)
+
+		// NOTES: set inside the group, chi runs these with the group's middleware, so the 404 & 405 pages have
+		// the session. chi doesn't pass them on to subrouters from inside a group, so /admin & /api/v1 set them too
+		mux.NotFound(handlers.Repo.NotFound)
+		mux.MethodNotAllowed(handlers.Repo.MethodNotAllowed)
+
+		mux.Get("/", handlers.Repo.Home)
+		mux.Get("/about", handlers.Repo.About)
+		mux.Get("/rooms", handlers.Repo.Rooms)
+		mux.Get("/rooms/{slug}", handlers.Repo.Room)
+		// the rooms' old pages, from before every room had its page under /rooms
+		mux.Get("/generals-quarters", handlers.Repo.OldRoomPage)
+		mux.Get("/majors-suite", handlers.Repo.OldRoomPage)
+		mux.Get("/search-availability", handlers.Repo.Availability)
+		mux.Post("/search-availability", handlers.Repo.PostAvailability)
+		mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+
+		// NOTES: How to parse a URL parameter sent from an HTML link
+		mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+		mux.Get("/book-room", handlers.Repo.BookRoom)
+
+		mux.Get("/contact", handlers.Repo.Contact)
+
+		mux.Get("/make-reservation", handlers.Repo.Reservation)
+		mux.Post("/make-reservation", handlers.Repo.PostReservation)
+		mux.Get("/make-reservation/remove/{index}", handlers.Repo.RemoveFromBooking)
+		mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+		// the fake payment provider's checkout page, for development. It is a 404 with any other provider
+		mux.Get("/payments/fake/{intent}", handlers.Repo.FakeCheckout)
+		mux.Post("/payments/fake/{intent}", handlers.Repo.PostFakeCheckout)
+
+		// guests manage their reservation through the signed link in their confirmation email
+		mux.Get("/reservations/manage/{token}", handlers.Repo.GuestManageReservation)
+		mux.Post("/reservations/manage/{token}/cancel", handlers.Repo.GuestCancelReservation)
+		mux.Post("/reservations/manage/{token}/dates", handlers.Repo.GuestChangeReservationDates)
+		mux.Get("/user/login", handlers.Repo.ShowLogin)
+		mux.Post("/user/login", handlers.Repo.PostShowLogin)
+
+		mux.Get("/user/logout", handlers.Repo.Logout)
+
+		// the iCal feed of a room's reservations & blocks, for OTAs. The token in the URL is the room's secret
+		mux.Get("/ical/{token}", handlers.Repo.ICalFeed)
+
+		//create a file server to serve any files or images etc
+		fileserver := http.FileServer(http.Dir("./static/"))
+		mux.Handle("/static/*", http.StripPrefix("/static", fileserver))
+
+		// room photos uploaded by admins. Only photos kept on the local disk are served by the app
+		if disk, ok := app.PhotoStorage.(*photos.DiskStorage); ok {
+			mux.Handle("/uploads/*", http.StripPrefix("/uploads", http.FileServer(http.Dir(disk.Dir))))
+		}
+
+		// NOTES: How to define a group of routes only available ONLY to authenticated users
+		// In this case, we are saying this should apply to any route that starts with '/admin'. This will be
+		// the group eg '/admin/properties', '/admin/dashboard' etc
+		mux.Route("/admin", func(mux chi.Router) {
+			mux.NotFound(handlers.Repo.NotFound)
+			mux.MethodNotAllowed(handlers.Repo.MethodNotAllowed)
+
+			// NOTES: Here is how you use a middleware. This middleware 'Auth' is defined in 'cmd/web/middleware.go/
+			// in this case, we want to apply the 'Auth' middleware to all routes in this group, which inthis case will
+			// only allow access to authenticaterd users.
+
+			// NOTES: Commenting the following line out (mux.Use(Auth)) turns off authentrication for this route group
+			mux.Use(Auth)
+			mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+
+			// every member of staff (front-desk & up) can view reservations & move them on, eg check guests in.
+			// Only managers can cancel them, which AdminPostReservationStatus checks itself
+			mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+			mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+			mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+
+			mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+			mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminShowPostReservation)
+			mux.Post("/reservations/{src}/{id}/status", handlers.Repo.AdminPostReservationStatus)
+
+			// only managers (& owners) can block rooms
+			// NOTES: mux.With() applies middleware to just the route it is chained onto
+			mux.With(RequireRole(roles.Manager)).Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+
+			// managing the rooms themselves, their photos, seasonal rates & calendars (which import blocks), is for managers too
+			mux.Group(func(mux chi.Router) {
+				mux.Use(RequireRole(roles.Manager))
+				mux.Get("/rooms", handlers.Repo.AdminRooms)
+				mux.Get("/rooms/new", handlers.Repo.AdminRoom)
+				mux.Post("/rooms/new", handlers.Repo.AdminPostRoom)
+				mux.Get("/rooms/{id}", handlers.Repo.AdminRoom)
+				mux.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+				mux.Get("/archive-room/{id}/do", handlers.Repo.AdminArchiveRoom)
+				mux.Get("/restore-room/{id}/do", handlers.Repo.AdminRestoreRoom)
+				mux.Get("/move-room/{id}/{dir}/do", handlers.Repo.AdminMoveRoom)
+				mux.Get("/rooms/{id}/photos", handlers.Repo.AdminRoomPhotos)
+				mux.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhoto)
+				mux.Post("/room-photos/{id}/caption", handlers.Repo.AdminPostRoomPhotoCaption)
+				mux.Get("/delete-room-photo/{id}/do", handlers.Repo.AdminDeleteRoomPhoto)
+				mux.Get("/move-room-photo/{id}/{dir}/do", handlers.Repo.AdminMoveRoomPhoto)
+				mux.Get("/rooms/{id}/seasons", handlers.Repo.AdminRoomSeasons)
+				mux.Get("/rooms/{id}/seasons/new", handlers.Repo.AdminRoomSeason)
+				mux.Post("/rooms/{id}/seasons/new", handlers.Repo.AdminPostRoomSeason)
+				mux.Get("/rooms/{id}/seasons/{season}", handlers.Repo.AdminRoomSeason)
+				mux.Post("/rooms/{id}/seasons/{season}", handlers.Repo.AdminPostRoomSeason)
+				mux.Get("/delete-room-season/{id}/do", handlers.Repo.AdminDeleteRoomSeason)
+				mux.Get("/room-calendars", handlers.Repo.AdminRoomCalendars)
+				mux.Get("/rooms/{id}/calendar", handlers.Repo.AdminRoomCalendar)
+				mux.Post("/rooms/{id}/calendar", handlers.Repo.AdminPostRoomCalendar)
+				mux.Post("/rooms/{id}/calendar/sync", handlers.Repo.AdminSyncRoomCalendar)
+				mux.Post("/rooms/{id}/calendar/upload", handlers.Repo.AdminUploadRoomCalendar)
+				mux.Get("/email-outbox", handlers.Repo.AdminEmailOutbox)
+				mux.Get("/resend-email/{id}/do", handlers.Repo.AdminResendEmail)
+				mux.Get("/audit-events", handlers.Repo.AdminAuditEvents)
+				mux.Get("/audit-events/export", handlers.Repo.AdminExportAuditEvents)
+				mux.Post("/payments/{id}/capture", handlers.Repo.AdminCapturePayment)
+				mux.Post("/payments/{id}/refund", handlers.Repo.AdminRefundPayment)
+			})
+
+			// only owners can hand out API keys & change the property's settings
+			mux.Group(func(mux chi.Router) {
+				mux.Use(RequireRole(roles.Owner))
+				mux.Get("/api-keys", handlers.Repo.AdminAPIKeys)
+				mux.Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+				mux.Get("/revoke-api-key/{id}/do", handlers.Repo.AdminRevokeAPIKey)
+				mux.Get("/property", handlers.Repo.AdminProperty)
+				mux.Post("/property", handlers.Repo.AdminPostProperty)
+				mux.Post("/rooms/{id}/owner", handlers.Repo.AdminPostRoomOwner)
+			})
+		})
+
+		// the versioned JSON API, used by the channel manager & any other machine clients
+		mux.Route("/api/v1", func(mux chi.Router) {
+			// these send JSON errors here, as the requests are under /api
+			mux.NotFound(handlers.Repo.NotFound)
+			mux.MethodNotAllowed(handlers.Repo.MethodNotAllowed)
+
+			mux.Get("/rooms", handlers.Repo.APIRooms)
+			mux.Get("/availability", handlers.Repo.APIAvailability)
+
+			// NOTES: mux.Group() applies middleware to some routes of a group without adding to their path
+			mux.Group(func(mux chi.Router) {
+				mux.Use(APIAuth(apikeys.ScopeReservationsRead))
+				mux.Get("/reservations/{id}", handlers.Repo.APIGetReservation)
+			})
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(APIAuth(apikeys.ScopeReservationsWrite))
+				mux.Post("/reservations", handlers.Repo.APIPostReservation)
+				mux.Delete("/reservations/{id}", handlers.Repo.APICancelReservation)
+			})
+		})
 	})
 
 	return mux
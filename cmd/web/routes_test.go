@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi"
@@ -22,3 +24,108 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux, type is %T", v))
 	}
 }
+
+// routesTests are the routes the app must have. The handlers' tests register the same routes on a router of
+// their own (see internal/handlers/setup_test.go), so a route added there must be added here & in routes.go too
+var routesTests = []string{
+	"GET /healthz",
+	"GET /readyz",
+	"GET /metrics",
+	"POST /payments/webhook",
+	"GET /",
+	"GET /about",
+	"GET /rooms",
+	"GET /rooms/{slug}",
+	"GET /generals-quarters",
+	"GET /majors-suite",
+	"GET /search-availability",
+	"POST /search-availability",
+	"POST /search-availability-json",
+	"GET /choose-room/{id}",
+	"GET /book-room",
+	"GET /contact",
+	"GET /make-reservation",
+	"POST /make-reservation",
+	"GET /make-reservation/remove/{index}",
+	"GET /reservation-summary",
+	"GET /payments/fake/{intent}",
+	"POST /payments/fake/{intent}",
+	"GET /reservations/manage/{token}",
+	"POST /reservations/manage/{token}/cancel",
+	"POST /reservations/manage/{token}/dates",
+	"GET /user/login",
+	"POST /user/login",
+	"GET /user/logout",
+	"GET /ical/{token}",
+	"GET /admin/dashboard",
+	"GET /admin/reservations-new",
+	"GET /admin/reservations-all",
+	"GET /admin/reservations-calendar",
+	"POST /admin/reservations-calendar",
+	"GET /admin/reservations/{src}/{id}/show",
+	"POST /admin/reservations/{src}/{id}",
+	"POST /admin/reservations/{src}/{id}/status",
+	"GET /admin/rooms",
+	"GET /admin/rooms/new",
+	"POST /admin/rooms/new",
+	"GET /admin/rooms/{id}",
+	"POST /admin/rooms/{id}",
+	"GET /admin/archive-room/{id}/do",
+	"GET /admin/restore-room/{id}/do",
+	"GET /admin/move-room/{id}/{dir}/do",
+	"GET /admin/rooms/{id}/photos",
+	"POST /admin/rooms/{id}/photos",
+	"POST /admin/room-photos/{id}/caption",
+	"GET /admin/delete-room-photo/{id}/do",
+	"GET /admin/move-room-photo/{id}/{dir}/do",
+	"GET /admin/rooms/{id}/seasons",
+	"GET /admin/rooms/{id}/seasons/new",
+	"POST /admin/rooms/{id}/seasons/new",
+	"GET /admin/rooms/{id}/seasons/{season}",
+	"POST /admin/rooms/{id}/seasons/{season}",
+	"GET /admin/delete-room-season/{id}/do",
+	"GET /admin/room-calendars",
+	"GET /admin/rooms/{id}/calendar",
+	"POST /admin/rooms/{id}/calendar",
+	"POST /admin/rooms/{id}/calendar/sync",
+	"POST /admin/rooms/{id}/calendar/upload",
+	"GET /admin/email-outbox",
+	"GET /admin/resend-email/{id}/do",
+	"GET /admin/audit-events",
+	"GET /admin/audit-events/export",
+	"POST /admin/payments/{id}/capture",
+	"POST /admin/payments/{id}/refund",
+	"GET /admin/api-keys",
+	"POST /admin/api-keys",
+	"GET /admin/revoke-api-key/{id}/do",
+	"GET /admin/property",
+	"POST /admin/property",
+	"POST /admin/rooms/{id}/owner",
+	"GET /api/v1/rooms",
+	"GET /api/v1/availability",
+	"GET /api/v1/reservations/{id}",
+	"POST /api/v1/reservations",
+	"DELETE /api/v1/reservations/{id}",
+}
+
+// TestRoutes_Registered walks the app's real router, so the routes the handlers are tested on can't be missing
+// from the one the app serves
+func TestRoutes_Registered(t *testing.T) {
+	var app config.AppConfig
+
+	registered := map[string]bool{}
+	err := chi.Walk(routes(&app).(*chi.Mux), func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// NOTES: routes of a subrouter, eg /admin, are walked with a '/*/' where the subrouter is mounted
+		registered[method+" "+strings.ReplaceAll(route, "/*/", "/")] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range routesTests {
+		if !registered[e] {
+			t.Errorf("route %s is not registered", e)
+		}
+	}
+}
@@ -1,91 +1,93 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"strings"
 	"time"
 
-	"github.com/gustavNdamukong/hotel-bookings/internal/models"
-	mail "github.com/xhit/go-simple-mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/config"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
 )
 
-func listenForMail() {
-	//code in a channel needs to run in the background (asynchronously) for it to
-	// fulfil the purpose of a channel. It should never stop the app from running
-	// in go you do that by prefixing the code execution or call to any func with
-	// the 'go' keyword eg we can also do that to execute an anonymous func like so:
+// newMailer builds the Mailer the app sends its emails through. Whatever the kind, failed sends are retried
+// & then saved to the dead-letter file
+func newMailer(s config.MailSettings) (mail.Mailer, error) {
+	var m mail.Mailer
+	var err error
 
-	// NOTES: Here's how to create an anonymous func in go
-	go func() {
-		// This for loop means that we will be listening to this channel indefinitely
-		for {
-			msg := <-app.MailChan
-			sendMsg(msg)
-		}
-	}()
+	switch s.Kind {
+	case "smtp":
+		m, err = mail.NewSMTPMailer(s.SMTP)
+	case "file":
+		m, err = mail.NewFileMailer(s.Dir)
+	case "memory":
+		m = mail.NewMemoryMailer()
+	default:
+		err = fmt.Errorf("unknown mailer %q, use one of smtp, file or memory", s.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
 
+	// emails that still fail are marked failed in the outbox, for admins to resend, so the dead-letter
+	// file is optional
+	var deadLetters mail.DeadLetterQueue
+	if s.DeadLetter != "" {
+		deadLetters = &mail.FileDeadLetterQueue{Path: s.DeadLetter}
+	}
+
+	return mail.NewRetryMailer(m, s.Retries, 2*time.Second, deadLetters), nil
 }
 
-// sendMsg is a custom func. It doesn't return anything. It just sends email
-func sendMsg(m models.MailData) {
-	server := mail.NewSMTPClient()
+// startMailWorker sends the emails queued in the outbox, checking for new ones every app.MailPollInterval,
+// in the background, until ctx is done. ctx being done also cuts off the batch being sent, & any retry it is
+// waiting on. The returned channel is closed once it has stopped; anything still in the outbox is then sent
+// by the worker's Drain when the app shuts down (see shutdown.go)
+func startMailWorker(ctx context.Context, worker *mail.OutboxWorker) <-chan struct{} {
+	stopped := make(chan struct{})
 
-	//tell it where the mail server is. We will install a dummy mail server on our machine shortly
-	server.Host = "localhost"
+	// code that loops forever needs to run in the background (asynchronously) so that it never stops
+	// the app from running. In go you do that by prefixing the code execution or call to any func with
+	// the 'go' keyword eg we can also do that to execute an anonymous func like so:
 
-	//tell it which port the mail server will be exposing
-	// our dummy test server which we will install will use port 1025. Real mail servers use ports
-	// like port 25, 587, or 465
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second //(10 secs)
-	server.SendTimeout = 10 * time.Second    //(10 secs)
+	// NOTES: Here's how to create an anonymous func in go
+	go func() {
+		defer close(stopped)
 
-	//In production you will likely need more settings like:
-	/*server.Username ...
-	server.Password ...
-	server.Encryption ...
-	etc */
+		ticker := time.NewTicker(app.MailPollInterval)
+		defer ticker.Stop()
 
-	client, err := server.Connect()
-	if err != nil {
-		errorLog.Println(err)
-	}
+		// warned is whether we have said that there is no sender yet, so it isn't said again on every tick
+		warned := false
 
-	//Construct your email message in a format that our client understands. We do this using m
-	// mail has a method NewMSG() below which returns a struct which is a pointer to email & a new email msg
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+		// This for loop means that we will be checking the outbox until the app shuts down
+		for {
+			// keep going while there are full batches waiting, rather than sending one batch per tick
+			sent, err := worker.Drain(ctx)
+			switch {
+			case errors.Is(err, mail.ErrNoSender):
+				if !warned {
+					app.Logger.Warn("mail worker is not sending emails until the sender email is set in the property settings")
+					warned = true
+				}
+			case err != nil:
+				app.Logger.Error("mail worker", "error", err)
+				warned = false
+			default:
+				warned = false
+			}
+			if sent > 0 {
+				app.Logger.Info("sent emails", "count", sent)
+			}
 
-	//check if a template has ben specified
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		// grab the template (from /email-templates)
-		// NOTES: How to read from files from disk. It uses the input/output utility (ioutil)
-		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
-		if err != nil {
-			app.ErrorLog.Println(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
 		}
+	}()
 
-		//cast that template data to a string from the array of bytes returned by the ioutil's ReadFile() func
-		// NOTES: Here is how to quickly cast data into a string-using the string() helper func
-		mailTemplate := string(data)
-
-		//now we need to substitute the data placeholders in the template files. fmt.Sprintf()
-		//	will not cut is this time
-		// we will need a proper (dedicated) string function strings.Replace(). The last argument
-		//	1 tells Go to do the replacement just once.
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
-	}
-
-	err = email.Send(client)
-	if err != nil {
-		errorLog.Println(err)
-	} else {
-		log.Println("Email sent!")
-	}
+	return stopped
 }
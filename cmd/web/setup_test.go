@@ -1,9 +1,13 @@
 package main
 
 import (
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
 )
 
 // TestMain() is a standard function that is used in all setup_test.go files. It will be run
@@ -11,6 +15,8 @@ import (
 // it will use 'testing.M.run()' (m.run()) at the end of this TestMain() function to continue
 // to run all your other tests.
 func TestMain(m *testing.M) {
+	// the middleware & shutdown log through app.Logger, which run() would normally set up
+	app.Logger, _ = logging.New(io.Discard, logging.FormatText, slog.LevelInfo)
 
 	os.Exit(m.Run())
 }
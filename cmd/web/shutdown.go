@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/driver"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// shutdown is everything main has started, which has to be stopped, in order, when the app is stopped
+type shutdown struct {
+	server *http.Server
+	// stopJobs stops the iCal import & the scheduled emails, & jobs is done once they have
+	stopJobs context.CancelFunc
+	jobs     *sync.WaitGroup
+	// stopMail stops the mail worker's loop, & mailStopped is closed once it has
+	stopMail    context.CancelFunc
+	mailStopped <-chan struct{}
+	mailWorker  *mail.OutboxWorker
+	repo        repository.DatabaseRepo
+	db          *driver.DB
+	started     time.Time
+}
+
+// run stops the app within timeout, & logs a summary of how it went.
+// NOTES: the order matters. Requests & background jobs are stopped first, since they can queue emails. Once
+// they have, nothing else can be queued, so the mail worker sends whatever is left, & only then is the DB
+// pool closed. Emails that can't be sent in time stay in the outbox, & go out when the app starts again
+func (s shutdown) run(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+
+	// stop accepting requests & wait for the ones in flight to finish
+	requests := "all requests finished"
+	if err := s.server.Shutdown(ctx); err != nil {
+		requests = "requests still running were cut off"
+		errs = append(errs, fmt.Errorf("stopping server: %w", err))
+		s.server.Close()
+	}
+
+	// stop the background jobs that queue emails
+	s.stopJobs()
+	if !wait(ctx, s.jobs.Wait) {
+		errs = append(errs, errors.New("background jobs did not stop in time"))
+	}
+
+	// stop the mail worker's loop, then send whatever is still queued.
+	// NOTES: if the loop hasn't stopped, it may still be sending, so the queued emails are left for it (or for
+	// the next start) rather than being sent twice at once, & the DB pool it uses is left open
+	s.stopMail()
+	mailStopped := wait(ctx, func() { <-s.mailStopped })
+	sent := 0
+	if mailStopped {
+		var err error
+		sent, err = s.mailWorker.Drain(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sending queued emails: %w", err))
+		}
+	} else {
+		app.Logger.Warn("mail worker did not stop in time, so queued emails are left in the outbox")
+		errs = append(errs, errors.New("mail worker did not stop in time"))
+	}
+
+	// the shutdown deadline may have passed by now, so counting what's left gets a context of its own
+	countCtx, cancelCount := context.WithTimeout(context.Background(), 5*time.Second)
+	pending, err := s.repo.AllOutboxEmails(countCtx, models.OutboxPending)
+	cancelCount()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("counting queued emails: %w", err))
+	}
+
+	if mailStopped {
+		if err := s.db.SQL.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing database: %w", err))
+		}
+	}
+
+	app.Logger.Info("Shut down",
+		"uptime", time.Since(s.started).Round(time.Second).String(),
+		"requests", requests,
+		"emails_sent", sent,
+		"emails_left", len(pending))
+
+	return errors.Join(errs...)
+}
+
+// wait calls f, & reports whether it returned before ctx was done
+func wait(ctx context.Context, f func()) bool {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/driver"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository/dbrepo"
+)
+
+func TestShutdown(t *testing.T) {
+	// a request that is still running when the app is stopped
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("finished"))
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{string(body), err}
+	}()
+	<-started
+
+	// a background job that runs until it is stopped
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	jobStopped := false
+	go func() {
+		defer jobs.Done()
+		<-jobsCtx.Done()
+		jobStopped = true
+	}()
+
+	repo := dbrepo.NewTestingRepo(&app)
+	mailCtx, stopMail := context.WithCancel(context.Background())
+	mailStopped := make(chan struct{})
+	go func() {
+		<-mailCtx.Done()
+		close(mailStopped)
+	}()
+
+	// sql.Open doesn't connect, so there's no need for a real database here
+	conn, err := sql.Open("pgx", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &driver.DB{SQL: conn}
+
+	err = shutdown{
+		server:      srv,
+		stopJobs:    stopJobs,
+		jobs:        &jobs,
+		stopMail:    stopMail,
+		mailStopped: mailStopped,
+		mailWorker:  mail.NewOutboxWorker(repo, mail.NewMemoryMailer()),
+		repo:        repo,
+		db:          db,
+		started:     time.Now(),
+	}.run(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := <-response; res.err != nil || res.body != "finished" {
+		t.Errorf("expected the in-flight request to finish, got %q %v", res.body, res.err)
+	}
+	if !jobStopped {
+		t.Error("expected the background job to be stopped")
+	}
+	if err := conn.Ping(); err == nil {
+		t.Error("expected the database pool to be closed")
+	}
+}
+
+func TestShutdown_MailWorkerStuck(t *testing.T) {
+	srv := &http.Server{}
+	repo := dbrepo.NewTestingRepo(&app)
+
+	conn, err := sql.Open("pgx", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a mail worker whose loop never stops, eg one stuck in a slow SMTP server
+	err = shutdown{
+		server:      srv,
+		stopJobs:    func() {},
+		jobs:        &sync.WaitGroup{},
+		stopMail:    func() {},
+		mailStopped: make(chan struct{}),
+		mailWorker:  mail.NewOutboxWorker(repo, mail.NewMemoryMailer()),
+		repo:        repo,
+		db:          &driver.DB{SQL: conn},
+		started:     time.Now(),
+	}.run(100 * time.Millisecond)
+	if err == nil {
+		t.Error("expected an error for the mail worker not stopping")
+	}
+
+	// the loop may still be using the pool, so it must be left open
+	if err := conn.Ping(); err != nil && err.Error() == "sql: database is closed" {
+		t.Error("expected the database pool to be left open")
+	}
+}
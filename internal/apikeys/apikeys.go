@@ -0,0 +1,74 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// The scopes an API key can carry. A key can only use the /api routes its scopes allow
+const (
+	ScopeReservationsRead  = "reservations:read"
+	ScopeReservationsWrite = "reservations:write"
+)
+
+// Scopes lists every scope, in the order the admin page shows them
+var Scopes = []string{ScopeReservationsRead, ScopeReservationsWrite}
+
+// keyPrefix starts every key we hand out, so that a leaked key is easy to recognise
+const keyPrefix = "hb_"
+
+// Generate makes a new random API key. It returns the key itself, which is shown to the admin once &
+// never stored, the first few characters of it to help admins tell keys apart, & the hash we store
+func Generate() (key, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+
+	key = keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:len(keyPrefix)+6], Hash(key), nil
+}
+
+// Hash returns the hash of a key, as stored in the api_keys table.
+// NOTES: unlike passwords, keys are long & random, so a fast hash like sha256 is enough & lets us look
+// a key up by its hash, which we could not do with bcrypt
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FromHeader pulls the key out of an "Authorization: Bearer <key>" header. ok is false if there isn't one
+func FromHeader(header string) (key string, ok bool) {
+	scheme, key, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	key = strings.TrimSpace(key)
+	return key, key != ""
+}
+
+// HasScope reports whether the key carries the given scope
+func HasScope(k models.APIKey, scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidScope reports whether scope is one we know about
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
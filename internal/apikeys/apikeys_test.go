@@ -0,0 +1,62 @@
+package apikeys
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+func TestGenerate(t *testing.T) {
+	key, prefix, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, prefix) {
+		t.Errorf("expected key %s to start with %s", key, prefix)
+	}
+
+	if hash != Hash(key) {
+		t.Error("expected the returned hash to be the hash of the key")
+	}
+
+	other, _, _, _ := Generate()
+	if other == key {
+		t.Error("expected two generated keys to differ")
+	}
+}
+
+var headerTests = []struct {
+	name   string
+	header string
+	key    string
+	ok     bool
+}{
+	{"bearer", "Bearer hb_abc", "hb_abc", true},
+	{"lower case scheme", "bearer hb_abc", "hb_abc", true},
+	{"empty", "", "", false},
+	{"basic auth", "Basic dXNlcjpwYXNz", "", false},
+	{"no key", "Bearer ", "", false},
+}
+
+func TestFromHeader(t *testing.T) {
+	for _, e := range headerTests {
+		key, ok := FromHeader(e.header)
+		if key != e.key || ok != e.ok {
+			t.Errorf("%s: expected (%q, %t) but got (%q, %t)", e.name, e.key, e.ok, key, ok)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	k := models.APIKey{Scopes: []string{ScopeReservationsRead}}
+
+	if !HasScope(k, ScopeReservationsRead) {
+		t.Error("expected key to have the read scope")
+	}
+
+	if HasScope(k, ScopeReservationsWrite) {
+		t.Error("did not expect key to have the write scope")
+	}
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"reflect"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// Redacted is shown instead of the values of secret columns, so the audit trail shows that eg a password was
+// changed without keeping it
+const Redacted = "[redacted]"
+
+// Fields is a snapshot of a row, by column name, eg as read with postgres' to_jsonb()
+type Fields map[string]any
+
+// ignored are columns that change with every write, or every run of a job, so they would only clutter the trail
+var ignored = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	// set on a room's calendar every time the calendar import job runs
+	"last_import_at": true,
+}
+
+// secret are columns whose values must never be copied into the trail
+var secret = map[string]bool{
+	"password": true,
+	"key_hash": true,
+	// the token in a room's public iCal feed URL, which would give anyone who can read the trail the feed
+	"export_token": true,
+}
+
+// Diff returns the columns that are different in after than in before, with their old & new values. A nil
+// before is a new row & a nil after a deleted one, so every column is in the diff. It returns an empty diff
+// if nothing but the ignored columns changed
+func Diff(before, after Fields) map[string]models.AuditChange {
+	changes := make(map[string]models.AuditChange)
+
+	add := func(column string) {
+		if ignored[column] {
+			return
+		}
+		if _, done := changes[column]; done {
+			return
+		}
+
+		was, hadWas := before[column]
+		is, hasIs := after[column]
+		// NOTES: reflect.DeepEqual compares the values inside maps & slices too, eg the scopes of an API key
+		if hadWas == hasIs && reflect.DeepEqual(was, is) {
+			return
+		}
+
+		if secret[column] {
+			was, is = redact(hadWas), redact(hasIs)
+		}
+		changes[column] = models.AuditChange{Before: was, After: is}
+	}
+
+	for column := range before {
+		add(column)
+	}
+	for column := range after {
+		add(column)
+	}
+
+	return changes
+}
+
+// redact is what is shown for a secret column's value, which is nothing if the column wasn't there
+func redact(present bool) any {
+	if !present {
+		return nil
+	}
+	return Redacted
+}
@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+var diffTests = []struct {
+	name     string
+	before   Fields
+	after    Fields
+	expected map[string]models.AuditChange
+}{
+	{
+		name:   "new row",
+		before: nil,
+		after:  Fields{"id": 1, "status": "pending", "created_at": "2050-01-01"},
+		expected: map[string]models.AuditChange{
+			"id":     {Before: nil, After: 1},
+			"status": {Before: nil, After: "pending"},
+		},
+	},
+	{
+		name:   "deleted row",
+		before: Fields{"id": 1, "room_id": 2},
+		after:  nil,
+		expected: map[string]models.AuditChange{
+			"id":      {Before: 1, After: nil},
+			"room_id": {Before: 2, After: nil},
+		},
+	},
+	{
+		name:     "unchanged",
+		before:   Fields{"id": 1, "status": "pending", "updated_at": "2050-01-01"},
+		after:    Fields{"id": 1, "status": "pending", "updated_at": "2050-01-02"},
+		expected: map[string]models.AuditChange{},
+	},
+	{
+		name:   "changed",
+		before: Fields{"id": 1, "status": "pending", "capacity": json.Number("2")},
+		after:  Fields{"id": 1, "status": "confirmed", "capacity": json.Number("2")},
+		expected: map[string]models.AuditChange{
+			"status": {Before: "pending", After: "confirmed"},
+		},
+	},
+	{
+		name:   "secret",
+		before: Fields{"id": 1, "password": "old hash"},
+		after:  Fields{"id": 1, "password": "new hash"},
+		expected: map[string]models.AuditChange{
+			"password": {Before: Redacted, After: Redacted},
+		},
+	},
+	{
+		name:   "new secret",
+		before: nil,
+		after:  Fields{"key_hash": "hash"},
+		expected: map[string]models.AuditChange{
+			"key_hash": {Before: nil, After: Redacted},
+		},
+	},
+	{
+		name:   "new room calendar",
+		before: nil,
+		after:  Fields{"id": 1, "room_id": 2, "export_token": "feed-token", "import_url": ""},
+		expected: map[string]models.AuditChange{
+			"id":           {Before: nil, After: 1},
+			"room_id":      {Before: nil, After: 2},
+			"export_token": {Before: nil, After: Redacted},
+			"import_url":   {Before: nil, After: ""},
+		},
+	},
+	{
+		name:   "room calendar feed token changed",
+		before: Fields{"id": 1, "room_id": 2, "export_token": "old-token", "import_url": ""},
+		after:  Fields{"id": 1, "room_id": 2, "export_token": "new-token", "import_url": "https://example.com/cal.ics"},
+		expected: map[string]models.AuditChange{
+			"export_token": {Before: Redacted, After: Redacted},
+			"import_url":   {Before: "", After: "https://example.com/cal.ics"},
+		},
+	},
+}
+
+func TestDiff(t *testing.T) {
+	for _, e := range diffTests {
+		changes := Diff(e.before, e.after)
+		if !reflect.DeepEqual(changes, e.expected) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, changes)
+		}
+	}
+}
+
+func TestAuditChange_String(t *testing.T) {
+	c := models.AuditChange{Before: nil, After: json.Number("1000000")}
+	if c.String() != "(none) → 1000000" {
+		t.Errorf("unexpected change %s", c.String())
+	}
+}
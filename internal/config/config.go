@@ -3,9 +3,15 @@ package config
 import (
 	"html/template"
 	"log"
+	"log/slog"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/metrics"
+	"github.com/gustavNdamukong/hotel-bookings/internal/payments"
+	"github.com/gustavNdamukong/hotel-bookings/internal/photos"
+	"github.com/gustavNdamukong/hotel-bookings/internal/reminders"
 )
 
 // Holds the application config
@@ -13,9 +19,47 @@ type AppConfig struct {
 	UseCache        bool
 	TemplateCache   map[string]*template.Template
 	DefaultAppTitle string
-	InfoLog         *log.Logger
 	InProduction    bool
 	Session         *scs.SessionManager
-	ErrorLog        *log.Logger
-	MailChan        chan models.MailData
+	// Logger is the app's structured logger. Log with its ...Context methods & the request's context, eg
+	// m.App.Logger.ErrorContext(r.Context(), ...), so each line has the request ID
+	Logger *slog.Logger
+	// InfoLog & ErrorLog write through Logger, at info & error level, for code that wants a *log.Logger
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	// Mailer sends the emails queued in the email outbox, eg through SMTP, or to files in development
+	Mailer mail.Mailer
+	// MailWorker is the worker sending the outbox, which /readyz checks is running
+	MailWorker *mail.OutboxWorker
+	// Metrics counts requests, emails & reservations for /metrics
+	Metrics *metrics.Metrics
+	// EmailTemplates renders emails from the templates in ./email-templates
+	EmailTemplates *mail.Templates
+	// MailPollInterval is how often the mail worker checks the outbox for emails to send
+	MailPollInterval time.Duration
+	// DBTimeout is how long any single DB query is allowed to run
+	DBTimeout time.Duration
+	// SigningKey signs the links guests get to manage their reservations. If it changes, old links stop working
+	SigningKey []byte
+	// BaseURL is where the site is served from, eg https://example.com, for links in emails
+	BaseURL string
+	// CancelCutoff is how long before arrival guests can still cancel or change their reservation themselves
+	CancelCutoff time.Duration
+	// ICalSyncInterval is how often rooms' external iCal calendars are imported. 0 turns the import off
+	ICalSyncInterval time.Duration
+	// NotificationSchedules are the emails guests get before they arrive & after they leave
+	NotificationSchedules []reminders.Schedule
+	// NotificationInterval is how often scheduled guest emails that are due get queued. 0 turns them off
+	NotificationInterval time.Duration
+	// Maintenance shows the maintenance page to everyone but logged in staff
+	Maintenance bool
+	// PhotoStorage keeps the room photos admins upload
+	PhotoStorage photos.Storage
+	// MaxUploadSize is the biggest photo, in bytes, that can be uploaded
+	MaxUploadSize int64
+	// Payments takes guests' deposits when they book. It is nil when payments are turned off, & guests then
+	// pay nothing up front
+	Payments payments.Provider
+	// Currency is what guests pay in, eg usd
+	Currency string
 }
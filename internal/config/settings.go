@@ -0,0 +1,426 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/reminders"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the name of every environment variable the app reads, eg BOOKINGS_DBNAME for -dbname
+const EnvPrefix = "BOOKINGS_"
+
+// Settings are everything the app can be configured with, before it is turned into an AppConfig.
+// NOTES: settings are merged in this order, each one overriding the ones before it:
+//
+//	defaults (DefaultSettings) -> config file (-config, see config.yml.example) -> environment variables -> flags
+//
+// so eg a password can be kept out of the config file by setting BOOKINGS_DBPASS, & any setting can still
+// be changed for one run with a flag
+type Settings struct {
+	Production bool   `yaml:"production"`
+	UseCache   bool   `yaml:"cache"`
+	Port       int    `yaml:"port"`
+	BaseURL    string `yaml:"base_url"`
+	// SigningKey signs the links guests get to manage their reservations. Required in production
+	SigningKey       string        `yaml:"signing_key"`
+	CancelCutoff     time.Duration `yaml:"cancel_cutoff"`
+	ICalSyncInterval time.Duration `yaml:"ical_interval"`
+	// ShutdownTimeout is how long the app has to finish requests & send queued emails when it is stopped
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// LogLevel is the least important level logged: debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+	// LogFormat is text or json. It defaults to json in production, for log collectors, & text otherwise
+	LogFormat string `yaml:"log_format"`
+	// Maintenance shows the maintenance page to everyone but logged in staff
+	Maintenance bool `yaml:"maintenance"`
+
+	Session       SessionSettings      `yaml:"session"`
+	DB            DBSettings           `yaml:"database"`
+	Mail          MailSettings         `yaml:"mail"`
+	Notifications NotificationSettings `yaml:"notifications"`
+	Uploads       UploadSettings       `yaml:"uploads"`
+	Payments      PaymentSettings      `yaml:"payments"`
+}
+
+// SessionSettings configure the session cookie
+type SessionSettings struct {
+	CookieName string        `yaml:"cookie_name"`
+	Lifetime   time.Duration `yaml:"lifetime"`
+}
+
+// DBSettings are the postgres connection & pool settings
+type DBSettings struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Name     string `yaml:"name"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// SSL is the postgres sslmode: disable, prefer or require
+	SSL string `yaml:"ssl"`
+	// Timeout is how long any single query is allowed to run
+	Timeout         time.Duration `yaml:"timeout"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// DSN is the connection string for the driver
+func (d DBSettings) DSN() string {
+	return fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s", d.Host, d.Port, d.Name, d.User, d.Password, d.SSL)
+}
+
+// MailSettings configure how emails are sent
+type MailSettings struct {
+	// Kind is how emails are sent: smtp, file or memory
+	Kind string          `yaml:"mailer"`
+	SMTP mail.SMTPConfig `yaml:"smtp"`
+	// Dir is where emails are written to when Kind is file
+	Dir     string `yaml:"dir"`
+	Retries int    `yaml:"retries"`
+	// DeadLetter is a file emails that could not be sent are also saved to. Optional
+	DeadLetter   string        `yaml:"dead_letter"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// NotificationSettings configure the scheduled emails guests get before they arrive & after they leave
+type NotificationSettings struct {
+	// Schedules are written as kind:days:before|after:template, separated by commas
+	Schedules string        `yaml:"schedules"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
+// UploadSettings configure where uploaded room photos are kept
+type UploadSettings struct {
+	// Dir is the folder photos are saved in. The app serves it under /uploads
+	Dir string `yaml:"dir"`
+	// MaxSizeMB is the biggest photo, in megabytes, that can be uploaded
+	MaxSizeMB int `yaml:"max_size_mb"`
+}
+
+// PaymentSettings configure how guests pay their deposits
+type PaymentSettings struct {
+	// Provider is the payment gateway: fake, or empty to turn payments off
+	Provider string `yaml:"provider"`
+	// WebhookSecret is what the gateway signs its webhooks with. Required in production
+	WebhookSecret string `yaml:"webhook_secret"`
+	Currency      string `yaml:"currency"`
+}
+
+// DefaultSettings are the settings used for anything not set in the config file, environment or flags
+func DefaultSettings() Settings {
+	return Settings{
+		Production:       false,
+		UseCache:         true,
+		Port:             8080,
+		CancelCutoff:     48 * time.Hour,
+		ICalSyncInterval: time.Hour,
+		ShutdownTimeout:  30 * time.Second,
+		LogLevel:         "info",
+		Session: SessionSettings{
+			CookieName: "testProj_session_id",
+			Lifetime:   24 * time.Hour,
+		},
+		DB: DBSettings{
+			Host:            "localhost",
+			Port:            5432,
+			SSL:             "disable",
+			Timeout:         3 * time.Second,
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 5 * time.Minute,
+		},
+		Mail: MailSettings{
+			Kind: "smtp",
+			SMTP: mail.SMTPConfig{
+				Host:       "localhost",
+				Port:       1025,
+				Encryption: mail.EncryptionNone,
+			},
+			Dir:          "./tmp/mail",
+			Retries:      3,
+			PollInterval: 5 * time.Second,
+		},
+		Notifications: NotificationSettings{
+			Schedules: reminders.DefaultSchedules,
+			Interval:  time.Hour,
+		},
+		Uploads: UploadSettings{
+			Dir:       "./uploads",
+			MaxSizeMB: 10,
+		},
+		Payments: PaymentSettings{
+			Currency: "usd",
+		},
+	}
+}
+
+// Addr is the address the server listens on, eg :8080
+func (s Settings) Addr() string {
+	return fmt.Sprintf(":%d", s.Port)
+}
+
+// bindFlags defines a flag for every setting, which writes straight into s
+func bindFlags(fs *flag.FlagSet, s *Settings, configFile *string) {
+	fs.StringVar(configFile, "config", "", "YAML file to read settings from (see config.yml.example)")
+
+	fs.BoolVar(&s.Production, "production", s.Production, "Application is in production")
+	fs.BoolVar(&s.UseCache, "cache", s.UseCache, "Use template cache")
+	fs.IntVar(&s.Port, "port", s.Port, "Port to listen on")
+	fs.StringVar(&s.BaseURL, "baseurl", s.BaseURL, "URL the site is served from, used for links in emails (default http://localhost:<port>)")
+	fs.StringVar(&s.SigningKey, "signingkey", s.SigningKey, "Secret key for signing guest reservation links")
+	fs.DurationVar(&s.CancelCutoff, "cancelcutoff", s.CancelCutoff, "How long before arrival guests can still cancel or change dates (eg 48h)")
+	fs.DurationVar(&s.ICalSyncInterval, "icalinterval", s.ICalSyncInterval, "How often to import rooms' external iCal calendars (0 to turn off)")
+	fs.StringVar(&s.LogLevel, "loglevel", s.LogLevel, "Least important level to log (debug, info, warn, error)")
+	fs.StringVar(&s.LogFormat, "logformat", s.LogFormat, "Log format, text or json (default json in production, text otherwise)")
+	fs.BoolVar(&s.Maintenance, "maintenance", s.Maintenance, "Show the maintenance page to everyone but logged in staff")
+	fs.DurationVar(&s.ShutdownTimeout, "shutdowntimeout", s.ShutdownTimeout, "How long to wait for requests to finish & queued emails to be sent when stopping")
+
+	fs.StringVar(&s.Session.CookieName, "sessioncookie", s.Session.CookieName, "Name of the session cookie")
+	fs.DurationVar(&s.Session.Lifetime, "sessionlifetime", s.Session.Lifetime, "How long sessions last")
+
+	fs.StringVar(&s.DB.Host, "dbhost", s.DB.Host, "Database host")
+	fs.IntVar(&s.DB.Port, "dbport", s.DB.Port, "Database port")
+	fs.StringVar(&s.DB.Name, "dbname", s.DB.Name, "Database name")
+	fs.StringVar(&s.DB.User, "dbuser", s.DB.User, "Database user")
+	fs.StringVar(&s.DB.Password, "dbpass", s.DB.Password, "Database password")
+	fs.StringVar(&s.DB.SSL, "dbssl", s.DB.SSL, "Database ssl settings (disable, prefer, require)")
+	fs.DurationVar(&s.DB.Timeout, "dbtimeout", s.DB.Timeout, "Timeout for each database query (eg 3s)")
+	fs.IntVar(&s.DB.MaxOpenConns, "dbmaxopen", s.DB.MaxOpenConns, "Most database connections open at once")
+	fs.IntVar(&s.DB.MaxIdleConns, "dbmaxidle", s.DB.MaxIdleConns, "Most idle database connections kept open")
+	fs.DurationVar(&s.DB.ConnMaxLifetime, "dbmaxlifetime", s.DB.ConnMaxLifetime, "How long a database connection is reused for")
+
+	fs.StringVar(&s.Mail.Kind, "mailer", s.Mail.Kind, "How to send emails (smtp, file, memory)")
+	fs.StringVar(&s.Mail.SMTP.Host, "smtphost", s.Mail.SMTP.Host, "SMTP server host")
+	fs.IntVar(&s.Mail.SMTP.Port, "smtpport", s.Mail.SMTP.Port, "SMTP server port")
+	fs.StringVar(&s.Mail.SMTP.Username, "smtpuser", s.Mail.SMTP.Username, "SMTP username")
+	fs.StringVar(&s.Mail.SMTP.Password, "smtppass", s.Mail.SMTP.Password, "SMTP password")
+	fs.StringVar(&s.Mail.SMTP.Encryption, "smtpencryption", s.Mail.SMTP.Encryption, "SMTP encryption (none, starttls, tls)")
+	fs.DurationVar(&s.Mail.SMTP.Timeout, "smtptimeout", s.Mail.SMTP.Timeout, "Timeout for connecting to & sending through the SMTP server")
+	fs.StringVar(&s.Mail.Dir, "maildir", s.Mail.Dir, "Folder emails are written to when -mailer=file")
+	fs.IntVar(&s.Mail.Retries, "mailretries", s.Mail.Retries, "How many times to try sending each email")
+	fs.StringVar(&s.Mail.DeadLetter, "maildeadletter", s.Mail.DeadLetter, "File emails that could not be sent are also saved to (failed emails are always kept in the outbox)")
+	fs.DurationVar(&s.Mail.PollInterval, "mailpoll", s.Mail.PollInterval, "How often the mail worker checks the outbox for emails to send")
+
+	fs.StringVar(&s.Notifications.Schedules, "notifications", s.Notifications.Schedules, "Scheduled guest emails, as kind:days:before|after:template separated by commas (empty to turn off)")
+	fs.DurationVar(&s.Notifications.Interval, "notificationinterval", s.Notifications.Interval, "How often to queue scheduled guest emails that are due (0 to turn off)")
+
+	fs.StringVar(&s.Uploads.Dir, "uploaddir", s.Uploads.Dir, "Folder uploaded room photos are saved in")
+	fs.IntVar(&s.Uploads.MaxSizeMB, "uploadmaxmb", s.Uploads.MaxSizeMB, "Biggest room photo that can be uploaded, in megabytes")
+
+	fs.StringVar(&s.Payments.Provider, "payments", s.Payments.Provider, "Payment gateway deposits are taken with (fake, or empty to turn payments off)")
+	fs.StringVar(&s.Payments.WebhookSecret, "paymentsecret", s.Payments.WebhookSecret, "Secret the payment gateway signs its webhooks with")
+	fs.StringVar(&s.Payments.Currency, "currency", s.Payments.Currency, "Currency guests pay in (eg usd)")
+}
+
+// EnvName is the environment variable for a flag, eg BOOKINGS_DBNAME for dbname
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(flagName)
+}
+
+// LoadSettings merges the defaults, the config file, the environment (read with lookupEnv, eg os.LookupEnv)
+// & the flags in args, & validates the result
+func LoadSettings(args []string, lookupEnv func(string) (string, bool)) (Settings, error) {
+	// NOTES: flags have to be parsed first, to find the config file, but they must override everything else.
+	// So they are parsed into a scratch copy here, & only the flags actually given are copied over at the end
+	var configFile string
+	given := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	scratch := DefaultSettings()
+	bindFlags(given, &scratch, &configFile)
+	if err := given.Parse(args); err != nil {
+		return Settings{}, err
+	}
+
+	if configFile == "" {
+		configFile, _ = lookupEnv(EnvName("config"))
+	}
+
+	s := DefaultSettings()
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return Settings{}, fmt.Errorf("reading config file: %w", err)
+		}
+		// UnmarshalStrict fails on keys we don't know, so a typo in the file isn't silently ignored
+		if err := yaml.UnmarshalStrict(data, &s); err != nil {
+			return Settings{}, fmt.Errorf("reading config file %s: %w", configFile, err)
+		}
+	}
+
+	var unused string
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	bindFlags(fs, &s, &unused)
+
+	var problems []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := lookupEnv(EnvName(f.Name)); ok && f.Name != "config" {
+			if err := fs.Set(f.Name, v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid value %q", EnvName(f.Name), v))
+			}
+		}
+	})
+	given.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			// the value was already parsed once, so it can't fail now
+			_ = fs.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if s.BaseURL == "" {
+		s.BaseURL = fmt.Sprintf("http://localhost:%d", s.Port)
+	}
+	if s.LogFormat == "" {
+		s.LogFormat = logging.FormatText
+		if s.Production {
+			s.LogFormat = logging.FormatJSON
+		}
+	}
+	s.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
+
+	problems = append(problems, s.validate()...)
+	if len(problems) > 0 {
+		return Settings{}, &SettingsError{Problems: problems}
+	}
+
+	return s, nil
+}
+
+// SettingsError lists everything wrong with the settings, so they can all be fixed in one go
+type SettingsError struct {
+	Problems []string
+}
+
+func (e *SettingsError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// validate returns a problem for every missing or invalid setting. Each one names the flag, which is also
+// how the setting is found in the environment (see EnvName)
+func (s Settings) validate() []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if s.Port < 1 || s.Port > 65535 {
+		add("-port must be between 1 & 65535")
+	}
+	if u, err := url.Parse(s.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("-baseurl must be a full http(s) URL, eg https://example.com")
+	}
+	if s.Production && s.SigningKey == "" {
+		add("-signingkey is required in production")
+	}
+	if s.CancelCutoff < 0 {
+		add("-cancelcutoff can't be negative")
+	}
+	if s.ICalSyncInterval < 0 {
+		add("-icalinterval can't be negative")
+	}
+	if s.ShutdownTimeout <= 0 {
+		add("-shutdowntimeout must be more than 0")
+	}
+	if _, err := logging.ParseLevel(s.LogLevel); err != nil {
+		add("-loglevel: %s", err)
+	}
+	if s.LogFormat != logging.FormatText && s.LogFormat != logging.FormatJSON {
+		add("-logformat must be text or json")
+	}
+
+	if s.Session.CookieName == "" {
+		add("-sessioncookie is required")
+	}
+	if s.Session.Lifetime <= 0 {
+		add("-sessionlifetime must be more than 0")
+	}
+
+	if s.DB.Host == "" {
+		add("-dbhost is required")
+	}
+	if s.DB.Name == "" {
+		add("-dbname is required")
+	}
+	if s.DB.User == "" {
+		add("-dbuser is required")
+	}
+	if s.DB.Port < 1 || s.DB.Port > 65535 {
+		add("-dbport must be between 1 & 65535")
+	}
+	switch s.DB.SSL {
+	case "disable", "prefer", "require":
+	default:
+		add("-dbssl must be one of disable, prefer or require")
+	}
+	if s.DB.Timeout <= 0 {
+		add("-dbtimeout must be more than 0")
+	}
+	if s.DB.MaxOpenConns < 1 {
+		add("-dbmaxopen must be at least 1")
+	}
+	if s.DB.MaxIdleConns < 0 || s.DB.MaxIdleConns > s.DB.MaxOpenConns {
+		add("-dbmaxidle must be between 0 & -dbmaxopen")
+	}
+	if s.DB.ConnMaxLifetime < 0 {
+		add("-dbmaxlifetime can't be negative")
+	}
+
+	switch s.Mail.Kind {
+	case "smtp":
+		if _, err := mail.NewSMTPMailer(s.Mail.SMTP); err != nil {
+			add("SMTP settings: %s", err)
+		}
+	case "file":
+		if s.Mail.Dir == "" {
+			add("-maildir is required when -mailer=file")
+		}
+	case "memory":
+	default:
+		add("-mailer must be one of smtp, file or memory")
+	}
+	if s.Mail.Retries < 1 {
+		add("-mailretries must be at least 1")
+	}
+	if s.Mail.PollInterval <= 0 {
+		add("-mailpoll must be more than 0")
+	}
+
+	if _, err := reminders.ParseSchedules(s.Notifications.Schedules); err != nil {
+		add("-notifications: %s", err)
+	}
+	if s.Notifications.Interval < 0 {
+		add("-notificationinterval can't be negative")
+	}
+
+	if s.Uploads.Dir == "" {
+		add("-uploaddir is required")
+	}
+	if s.Uploads.MaxSizeMB < 1 || s.Uploads.MaxSizeMB > 100 {
+		add("-uploadmaxmb must be between 1 & 100")
+	}
+
+	switch s.Payments.Provider {
+	case "":
+	case "fake":
+		// the fake gateway's checkout page lets anyone "pay" for their booking
+		if s.Production {
+			add("-payments=fake takes no real money, so can't be used in production")
+		}
+	default:
+		add("-payments must be fake, or empty to turn payments off")
+	}
+	if s.Production && s.Payments.Provider != "" && s.Payments.WebhookSecret == "" {
+		add("-paymentsecret is required in production")
+	}
+	if len(s.Payments.Currency) != 3 {
+		add("-currency must be a 3 letter code, eg usd")
+	}
+
+	return problems
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a lookupEnv func for a fixed environment
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestLoadSettings_Defaults(t *testing.T) {
+	s, err := LoadSettings([]string{"-dbname=bookings", "-dbuser=user"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaultSettings()
+	if s.Port != want.Port || s.DB.MaxOpenConns != want.DB.MaxOpenConns || s.Session.CookieName != want.Session.CookieName {
+		t.Errorf("expected the defaults, got %+v", s)
+	}
+	if s.Production {
+		t.Error("expected production to be off by default")
+	}
+	if s.BaseURL != "http://localhost:8080" || s.Addr() != ":8080" {
+		t.Errorf("unexpected base URL %q or address %q", s.BaseURL, s.Addr())
+	}
+}
+
+func TestLoadSettings_Precedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(file, []byte(`
+port: 9000
+base_url: https://example.com/
+session:
+  cookie_name: from_file
+database:
+  name: bookings
+  user: file_user
+  max_open_conns: 20
+mail:
+  mailer: file
+  smtp:
+    host: smtp.example.com
+    port: 587
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadSettings([]string{"-dbuser=flag_user", "-dbmaxidle=2"}, env(map[string]string{
+		"BOOKINGS_CONFIG":        file,
+		"BOOKINGS_DBUSER":        "env_user",
+		"BOOKINGS_DBPASS":        "secret",
+		"BOOKINGS_MAILPOLL":      "30s",
+		"BOOKINGS_SESSIONCOOKIE": "from_env",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"port from file", s.Port, 9000},
+		{"base url from file, without its trailing slash", s.BaseURL, "https://example.com"},
+		{"db name from file", s.DB.Name, "bookings"},
+		{"pool from file", s.DB.MaxOpenConns, 20},
+		{"smtp from file", s.Mail.SMTP.Port, 587},
+		{"env overrides file", s.Session.CookieName, "from_env"},
+		{"password from env", s.DB.Password, "secret"},
+		{"duration from env", s.Mail.PollInterval, 30 * time.Second},
+		{"flag overrides env & file", s.DB.User, "flag_user"},
+		{"pool from flag", s.DB.MaxIdleConns, 2},
+		{"default kept", s.DB.Host, "localhost"},
+	}
+	for _, e := range tests {
+		if e.got != e.want {
+			t.Errorf("%s: expected %v, got %v", e.name, e.want, e.got)
+		}
+	}
+}
+
+func TestLoadSettings_Invalid(t *testing.T) {
+	_, err := LoadSettings([]string{"-production", "-dbport=0", "-dbmaxopen=2", "-dbmaxidle=3", "-notifications=oops",
+		"-payments=fake", "-currency=dollars"}, env(map[string]string{
+		"BOOKINGS_MAILRETRIES": "lots",
+	}))
+
+	var se *SettingsError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected a SettingsError, got %v", err)
+	}
+
+	// every problem is reported at once
+	for _, want := range []string{
+		"BOOKINGS_MAILRETRIES",
+		"-signingkey is required in production",
+		"-dbname is required",
+		"-dbuser is required",
+		"-dbport",
+		"-dbmaxidle",
+		"-notifications",
+		"-payments=fake takes no real money",
+		"-paymentsecret is required in production",
+		"-currency",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to mention %q, got:\n%s", want, err)
+		}
+	}
+}
+
+func TestLoadSettings_BadFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(file, []byte("databse:\n  name: typo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadSettings([]string{"-config=" + file}, env(nil)); err == nil {
+		t.Error("expected an error for an unknown key in the config file")
+	}
+	if _, err := LoadSettings([]string{"-config=" + file + ".missing"}, env(nil)); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+	if _, err := LoadSettings([]string{"-nosuchflag"}, env(nil)); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+}
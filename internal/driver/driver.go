@@ -21,23 +21,27 @@ type DB struct {
 
 var dbConn = &DB{}
 
-// max num of DB connections this application can have
-const maxOpenDbConn = 10
-
-// max num of idle DB connections allowed
-const maxIdleDbConn = 5
-const maxDbLifetime = 5 * time.Minute
+// Pool limits the DB connections this application can have. The limits used to be constants here; now they
+// come from the app's settings (see config.DBSettings)
+type Pool struct {
+	// max num of DB connections this application can have
+	MaxOpenConns int
+	// max num of idle DB connections allowed
+	MaxIdleConns int
+	// how long a connection is reused for before it is closed
+	ConnMaxLifetime time.Duration
+}
 
 // ConnectSQL creates a connection pool for postgres
-func ConnectSQL(dsn string) (*DB, error) {
+func ConnectSQL(dsn string, pool Pool) (*DB, error) {
 	newDb, err := NewDatabase(dsn)
 	if err != nil {
-		// DOC: panic means you want the prog to halt coz if this doesn't work, nothing else should work
-		panic(err)
+		// DOC: this used to panic, but returning the error lets main report it clearly & exit
+		return nil, err
 	}
-	newDb.SetMaxOpenConns(maxOpenDbConn)
-	newDb.SetMaxIdleConns(maxIdleDbConn)
-	newDb.SetConnMaxLifetime(maxDbLifetime)
+	newDb.SetMaxOpenConns(pool.MaxOpenConns)
+	newDb.SetMaxIdleConns(pool.MaxIdleConns)
+	newDb.SetConnMaxLifetime(pool.ConnMaxLifetime)
 
 	dbConn.SQL = newDb
 
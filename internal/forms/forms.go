@@ -3,7 +3,10 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -67,6 +70,15 @@ func (f *Form) MinLength(field string, length int) bool {
 	return true
 }
 
+// MaxLength checks that a field is no longer than length characters
+func (f *Form) MaxLength(field string, length int) bool {
+	if utf8.RuneCountInString(f.Get(field)) > length {
+		f.Errors.Add(field, fmt.Sprintf("This field must be at most %d characters long", length))
+		return false
+	}
+	return true
+}
+
 func (f *Form) IsEmail(field string) bool {
 	if !govalidator.IsEmail(f.Get(field)) {
 		f.Errors.Add(field, "Invalid email address")
@@ -74,3 +86,62 @@ func (f *Form) IsEmail(field string) bool {
 	}
 	return true
 }
+
+// IsURL checks that a field is an http or https URL. An empty field passes; use Required for that
+func (f *Form) IsURL(field string) bool {
+	x := f.Get(field)
+	if x == "" {
+		return true
+	}
+
+	u, err := url.ParseRequestURI(x)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		f.Errors.Add(field, "Invalid URL, it should start with http:// or https://")
+		return false
+	}
+	return true
+}
+
+// slugPattern is what a slug looks like: lower case words of letters & digits joined by single hyphens
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// IsSlug checks that a field can be used in a URL as a slug, eg 'generals-quarters'
+func (f *Form) IsSlug(field string) bool {
+	if !slugPattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lower case letters, digits & single hyphens, eg generals-quarters")
+		return false
+	}
+	return true
+}
+
+// IntBetween checks that a field is a whole number from min to max
+func (f *Form) IntBetween(field string, min, max int) bool {
+	n, err := strconv.Atoi(strings.TrimSpace(f.Get(field)))
+	if err != nil || n < min || n > max {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a whole number from %d to %d", min, max))
+		return false
+	}
+	return true
+}
+
+// Occupancy checks that the adults & children fields are how many guests can stay in a room that sleeps
+// capacity: at least 1 adult, no fewer than 0 children & no more than capacity guests in all
+func (f *Form) Occupancy(adults, children string, capacity int) bool {
+	a, err := strconv.Atoi(strings.TrimSpace(f.Get(adults)))
+	if err != nil || a < 1 {
+		f.Errors.Add(adults, "At least 1 adult must stay in the room")
+		return false
+	}
+
+	c, err := strconv.Atoi(strings.TrimSpace(f.Get(children)))
+	if err != nil || c < 0 {
+		f.Errors.Add(children, "This field must be a whole number of children")
+		return false
+	}
+
+	if a+c > capacity {
+		f.Errors.Add(adults, fmt.Sprintf("This room sleeps at most %d guests", capacity))
+		return false
+	}
+	return true
+}
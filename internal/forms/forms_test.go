@@ -185,3 +185,133 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("Form says email field is invalid when it is valid")
 	}
 }
+
+func TestForm_IsURL(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"", true},
+		{"https://www.airbnb.com/calendar/ical/123.ics?s=abc", true},
+		{"http://localhost:8080/ical/x.ics", true},
+		{"ftp://example.com/cal.ics", false},
+		{"example.com/cal.ics", false},
+		{"https://", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("import_url", e.value)
+		form := New(postedData)
+
+		if got := form.IsURL("import_url"); got != e.expected {
+			t.Errorf("IsURL(%q): expected %t but got %t", e.value, e.expected, got)
+		}
+
+		if form.Valid() != e.expected {
+			t.Errorf("IsURL(%q): expected form to be valid: %t", e.value, e.expected)
+		}
+	}
+}
+
+func TestForm_IsSlug(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"generals-quarters", true},
+		{"room-2", true},
+		{"", false},
+		{"Generals-Quarters", false},
+		{"generals--quarters", false},
+		{"-generals", false},
+		{"generals quarters", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("slug", e.value)
+		form := New(postedData)
+
+		if got := form.IsSlug("slug"); got != e.expected {
+			t.Errorf("IsSlug(%q): expected %t but got %t", e.value, e.expected, got)
+		}
+	}
+}
+
+func TestForm_IntBetween(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"1", true},
+		{"20", true},
+		{"0", false},
+		{"21", false},
+		{"two", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("capacity", e.value)
+		form := New(postedData)
+
+		if got := form.IntBetween("capacity", 1, 20); got != e.expected {
+			t.Errorf("IntBetween(%q): expected %t but got %t", e.value, e.expected, got)
+		}
+	}
+}
+
+func TestForm_MaxLength(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"", true},
+		{"five!", true},
+		{"façade", true},
+		{"seven!!", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("caption", e.value)
+		form := New(postedData)
+
+		if got := form.MaxLength("caption", 6); got != e.expected || form.Valid() != e.expected {
+			t.Errorf("MaxLength(%q): expected %t but got %t", e.value, e.expected, got)
+		}
+	}
+}
+
+func TestForm_Occupancy(t *testing.T) {
+	tests := []struct {
+		adults     string
+		children   string
+		expected   bool
+		errorField string
+	}{
+		{"2", "0", true, ""},
+		{"1", "1", true, ""},
+		{"2", "1", false, "adults"},
+		{"0", "2", false, "adults"},
+		{"", "0", false, "adults"},
+		{"1", "-1", false, "children"},
+		{"1", "one", false, "children"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("adults", e.adults)
+		postedData.Add("children", e.children)
+		form := New(postedData)
+
+		if got := form.Occupancy("adults", "children", 2); got != e.expected {
+			t.Errorf("Occupancy(%q, %q): expected %t but got %t", e.adults, e.children, e.expected, got)
+		}
+		if e.errorField != "" && form.Errors.Get(e.errorField) == "" {
+			t.Errorf("Occupancy(%q, %q): expected an error on %s", e.adults, e.children, e.errorField)
+		}
+	}
+}
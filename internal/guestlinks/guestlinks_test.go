@@ -0,0 +1,41 @@
+package guestlinks
+
+import (
+	"errors"
+	"testing"
+)
+
+var testKey = []byte("test-signing-key")
+
+func TestSignVerify(t *testing.T) {
+	token := Sign(testKey, 42)
+
+	id, err := Verify(testKey, token)
+	if err != nil {
+		t.Fatalf("expected token to verify but got %s", err)
+	}
+
+	if id != 42 {
+		t.Errorf("expected id 42 but got %d", id)
+	}
+}
+
+var badTokens = []struct {
+	name  string
+	token string
+}{
+	{"empty", ""},
+	{"no signature", "42"},
+	{"not a number", "abc." + signature(testKey, 42)},
+	{"other reservation", "43." + signature(testKey, 42)},
+	{"other key", Sign([]byte("another-key"), 42)},
+	{"tampered signature", Sign(testKey, 42) + "x"},
+}
+
+func TestVerify_Invalid(t *testing.T) {
+	for _, e := range badTokens {
+		if _, err := Verify(testKey, e.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken but got %v", e.name, err)
+		}
+	}
+}
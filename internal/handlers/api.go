@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/pricing"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// apiDateLayout is the date format used for all dates sent to & from the /api routes
+const apiDateLayout = "2006-01-02"
+
+// apiRoom is a room as the /api routes show it
+type apiRoom struct {
+	ID       int    `json:"id"`
+	RoomName string `json:"room_name"`
+	Capacity int    `json:"capacity"`
+}
+
+// apiNight is one priced night of a stay
+type apiNight struct {
+	Date    string `json:"date"`
+	Rate    int    `json:"rate"`
+	Season  string `json:"season,omitempty"`
+	Weekend bool   `json:"weekend"`
+}
+
+// apiReservation is a reservation as the /api routes show it. Prices are in cents
+type apiReservation struct {
+	ID         int        `json:"id"`
+	FirstName  string     `json:"first_name"`
+	LastName   string     `json:"last_name"`
+	Email      string     `json:"email"`
+	Phone      string     `json:"phone"`
+	StartDate  string     `json:"start_date"`
+	EndDate    string     `json:"end_date"`
+	RoomID     int        `json:"room_id"`
+	Adults     int        `json:"adults"`
+	Children   int        `json:"children"`
+	Status     string     `json:"status"`
+	TotalPrice int        `json:"total_price"`
+	Nights     []apiNight `json:"nights,omitempty"`
+}
+
+// apiReservationRequest is the JSON body expected by APIPostReservation
+type apiReservationRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+	// Adults is 1 if no guests are sent, for clients from before guests were counted
+	Adults   int `json:"adults"`
+	Children int `json:"children"`
+}
+
+func newAPIRoom(room models.Room) apiRoom {
+	return apiRoom{
+		ID:       room.ID,
+		RoomName: room.RoomName,
+		Capacity: room.Capacity,
+	}
+}
+
+func newAPIReservation(res models.Reservation) apiReservation {
+	out := apiReservation{
+		ID:         res.ID,
+		FirstName:  res.FirstName,
+		LastName:   res.LastName,
+		Email:      res.Email,
+		Phone:      res.Phone,
+		StartDate:  res.StartDate.Format(apiDateLayout),
+		EndDate:    res.EndDate.Format(apiDateLayout),
+		RoomID:     res.RoomId,
+		Adults:     res.Adults,
+		Children:   res.Children,
+		Status:     res.Status,
+		TotalPrice: res.TotalPrice,
+	}
+
+	for _, n := range res.Quote.Nights {
+		out.Nights = append(out.Nights, apiNight{
+			Date:    n.Date.Format(apiDateLayout),
+			Rate:    n.Rate,
+			Season:  n.SeasonName,
+			Weekend: n.Weekend,
+		})
+	}
+
+	return out
+}
+
+// APIRooms lists all rooms
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot get rooms", nil)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, room := range rooms {
+		out = append(out, newAPIRoom(room))
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, out)
+}
+
+// APIAvailability lists the rooms that are free between the 'start' & 'end' query parameters, & that sleep
+// the 'adults' & 'children' query parameters (1 adult & no children if they are left out)
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	start, end, fields := apiDates(r.URL.Query().Get("start"), r.URL.Query().Get("end"), "start", "end")
+	if fields != nil {
+		helpers.ErrorJSON(w, http.StatusBadRequest, "invalid dates", fields)
+		return
+	}
+
+	form := forms.New(r.URL.Query())
+	defaultGuests(form, "adults", "children")
+	form.IntBetween("adults", 1, maxRoomCapacity)
+	form.IntBetween("children", 0, maxRoomCapacity)
+	if !form.Valid() {
+		helpers.ErrorJSON(w, http.StatusBadRequest, "invalid number of guests", form.Errors)
+		return
+	}
+	adults, _ := strconv.Atoi(strings.TrimSpace(form.Get("adults")))
+	children, _ := strconv.Atoi(strings.TrimSpace(form.Get("children")))
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), start, end, adults+children)
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot search availability", nil)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, room := range rooms {
+		out = append(out, newAPIRoom(room))
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, out)
+}
+
+// APIPostReservation prices & books a stay sent as a JSON apiReservationRequest
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	var body apiReservationRequest
+	// NOTES: json.NewDecoder() reads JSON straight from the request body into a struct
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helpers.ErrorJSON(w, http.StatusBadRequest, "request body must be a JSON reservation", nil)
+		return
+	}
+
+	// validate with the same rules as the make-reservation form
+	form := forms.New(url.Values{
+		"first_name": {body.FirstName},
+		"last_name":  {body.LastName},
+		"email":      {body.Email},
+	})
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	fields := map[string][]string(form.Errors)
+
+	start, end, dateFields := apiDates(body.StartDate, body.EndDate, "start_date", "end_date")
+	for k, v := range dateFields {
+		fields[k] = append(fields[k], v...)
+	}
+
+	if len(fields) > 0 {
+		helpers.ErrorJSON(w, http.StatusUnprocessableEntity, "there were some errors with the reservation", fields)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), body.RoomID)
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusUnprocessableEntity, "there were some errors with the reservation",
+			map[string][]string{"room_id": {"No such room"}})
+		return
+	}
+
+	if body.Adults == 0 && body.Children == 0 {
+		body.Adults = 1
+	}
+	guests := forms.New(url.Values{
+		"adults":   {strconv.Itoa(body.Adults)},
+		"children": {strconv.Itoa(body.Children)},
+	})
+	if !guests.Occupancy("adults", "children", room.Capacity) {
+		helpers.ErrorJSON(w, http.StatusUnprocessableEntity, "there were some errors with the reservation", guests.Errors)
+		return
+	}
+
+	quote, err := m.quote(r.Context(), body.RoomID, start, end)
+	if err != nil {
+		var minStay *pricing.MinStayError
+		switch {
+		case errors.As(err, &minStay), errors.Is(err, pricing.ErrNoNights):
+			helpers.ErrorJSON(w, http.StatusUnprocessableEntity, err.Error(), nil)
+		default:
+			helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot get the price for that room", nil)
+		}
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName:  body.FirstName,
+		LastName:   body.LastName,
+		Email:      body.Email,
+		Phone:      body.Phone,
+		StartDate:  start,
+		EndDate:    end,
+		RoomId:     body.RoomID,
+		Room:       room,
+		TotalPrice: quote.Total,
+		Quote:      quote,
+		Adults:     body.Adults,
+		Children:   body.Children,
+	}
+
+	property, err := m.DB.GetProperty(r.Context())
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot insert reservation into database", nil)
+		return
+	}
+
+	reservation.ID, err = m.DB.InsertReservationWithRestriction(r.Context(), reservation, m.reservationEmails(property))
+	if err != nil {
+		var notAvailable *repository.RoomNotAvailableError
+		if errors.As(err, &notAvailable) {
+			helpers.ErrorJSON(w, http.StatusConflict, notAvailable.Error(), nil)
+			return
+		}
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot insert reservation into database", nil)
+		return
+	}
+	m.App.Metrics.ReservationCreated()
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", reservation.ID))
+	helpers.WriteJSON(w, http.StatusCreated, newAPIReservation(reservation))
+}
+
+// APIGetReservation shows one reservation
+func (m *Repository) APIGetReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, r)
+	if !ok {
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, newAPIReservation(res))
+}
+
+// APICancelReservation cancels a reservation, which frees up its room for those dates again. The reservation
+// is kept, with the status 'cancelled'
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.ChangeReservationStatus(r.Context(), models.ReservationStatusChange{
+		ReservationID: res.ID,
+		ToStatus:      models.ReservationCancelled,
+		// staff using the API from their browser are recorded, API keys are not anyone in particular
+		UserID: m.App.Session.GetInt(r.Context(), "user_id"),
+		Source: models.StatusChangedByAPI,
+		Reason: "Cancelled through the API",
+	})
+	if errors.Is(err, repository.ErrStatusChange) {
+		helpers.ErrorJSON(w, http.StatusConflict, fmt.Sprintf("a %s reservation can't be cancelled", res.Status), nil)
+		return
+	}
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot cancel reservation", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiReservation looks up the reservation named by the {id} URL parameter. If it can't, it writes the
+// error response itself & returns false
+func (m *Repository) apiReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusBadRequest, "invalid reservation id", nil)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ErrorJSON(w, http.StatusNotFound, "reservation not found", nil)
+		return res, false
+	}
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot get reservation", nil)
+		return res, false
+	}
+
+	return res, true
+}
+
+// apiDates parses an arrival & departure date. Any problems are returned as field errors, keyed by the
+// given field names
+func apiDates(startStr, endStr, startField, endField string) (time.Time, time.Time, map[string][]string) {
+	fields := map[string][]string{}
+
+	start, err := time.Parse(apiDateLayout, startStr)
+	if err != nil {
+		fields[startField] = append(fields[startField], "Must be a date like 2050-01-31")
+	}
+
+	end, err := time.Parse(apiDateLayout, endStr)
+	if err != nil {
+		fields[endField] = append(fields[endField], "Must be a date like 2050-01-31")
+	}
+
+	if len(fields) == 0 && !end.After(start) {
+		fields[endField] = append(fields[endField], "Must be after "+startField)
+	}
+
+	if len(fields) == 0 {
+		return start, end, nil
+	}
+	return start, end, fields
+}
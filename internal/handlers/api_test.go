@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+)
+
+// apiTests is the data for the /api/v1 tests. They go through the test router so that the {id} URL
+// parameters get filled in by chi
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	body               string
+	expectedStatusCode int
+	expectedFields     []string
+}{
+	{"rooms", "GET", "/api/v1/rooms", "", http.StatusOK, nil},
+	{"availability", "GET", "/api/v1/availability?start=2050-01-01&end=2050-01-02", "", http.StatusOK, nil},
+	{"availability bad dates", "GET", "/api/v1/availability?start=x&end=2050-01-02", "", http.StatusBadRequest, []string{"start"}},
+	{"availability for a family", "GET", "/api/v1/availability?start=2040-01-01&end=2040-01-02&adults=2&children=2", "", http.StatusOK, nil},
+	{"availability bad guests", "GET", "/api/v1/availability?start=2040-01-01&end=2040-01-02&adults=0&children=x", "", http.StatusBadRequest, []string{"adults", "children"}},
+	{"availability end before start", "GET", "/api/v1/availability?start=2050-01-02&end=2050-01-01", "", http.StatusBadRequest, []string{"end"}},
+	{"book", "POST", "/api/v1/reservations",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-03","room_id":1}`,
+		http.StatusCreated, nil},
+	{"book too many guests", "POST", "/api/v1/reservations",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-03","room_id":1,"adults":2,"children":1}`,
+		http.StatusUnprocessableEntity, []string{"adults"}},
+	{"book not json", "POST", "/api/v1/reservations", "first_name=John", http.StatusBadRequest, nil},
+	{"book invalid", "POST", "/api/v1/reservations",
+		`{"first_name":"J","last_name":"","email":"nope","start_date":"2050-01-01","end_date":"x","room_id":1}`,
+		http.StatusUnprocessableEntity, []string{"first_name", "last_name", "email", "end_date"}},
+	{"book unknown room", "POST", "/api/v1/reservations",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-03","room_id":3}`,
+		http.StatusUnprocessableEntity, []string{"room_id"}},
+	{"book room taken", "POST", "/api/v1/reservations",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2070-01-01","end_date":"2070-01-03","room_id":1}`,
+		http.StatusConflict, nil},
+	{"book insert fails", "POST", "/api/v1/reservations",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-03","room_id":2}`,
+		http.StatusInternalServerError, nil},
+	{"get reservation", "GET", "/api/v1/reservations/1", "", http.StatusOK, nil},
+	{"get missing reservation", "GET", "/api/v1/reservations/101", "", http.StatusNotFound, nil},
+	{"get bad id", "GET", "/api/v1/reservations/abc", "", http.StatusBadRequest, nil},
+	{"cancel reservation", "DELETE", "/api/v1/reservations/1", "", http.StatusNoContent, nil},
+	{"cancel missing reservation", "DELETE", "/api/v1/reservations/101", "", http.StatusNotFound, nil},
+	{"cancel cancelled reservation", "DELETE", "/api/v1/reservations/99", "", http.StatusConflict, nil},
+}
+
+func TestAPI(t *testing.T) {
+	routes := getRoutes()
+
+	for _, e := range apiTests {
+		req := httptest.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		if rr.Code == http.StatusNoContent {
+			continue
+		}
+
+		var resp helpers.JSONResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s: response is not a JSON envelope: %s", e.name, err)
+			continue
+		}
+
+		if rr.Code >= 400 {
+			if resp.Error == nil || resp.Error.Status != rr.Code {
+				t.Errorf("%s: expected an error envelope with status %d but got %s", e.name, rr.Code, rr.Body.String())
+				continue
+			}
+			for _, f := range e.expectedFields {
+				if _, ok := resp.Error.Fields[f]; !ok {
+					t.Errorf("%s: expected an error for field %s but got %v", e.name, f, resp.Error.Fields)
+				}
+			}
+		} else if resp.Data == nil {
+			t.Errorf("%s: expected data but got none", e.name)
+		}
+	}
+}
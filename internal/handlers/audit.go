@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// auditPageSize is the most audit events the admin page shows. The export has all of them
+const auditPageSize = 200
+
+// auditFilter reads the audit trail's filters from the query string, ie q (searched for), entity, entity_id
+// & from & to (the first & last days, as 2006-01-02)
+func auditFilter(query url.Values) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		Search: strings.TrimSpace(query.Get("q")),
+		Entity: query.Get("entity"),
+	}
+
+	if id := query.Get("entity_id"); id != "" {
+		entityID, err := strconv.Atoi(id)
+		if err != nil {
+			return filter, errors.New("The ID must be a number")
+		}
+		filter.EntityID = entityID
+	}
+
+	layout := "2006-01-02"
+	for _, d := range []struct {
+		param string
+		date  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if query.Get(d.param) == "" {
+			continue
+		}
+		date, err := time.Parse(layout, query.Get(d.param))
+		if err != nil {
+			return filter, errors.New("Dates must be like 2006-01-02")
+		}
+		*d.date = date
+	}
+
+	return filter, nil
+}
+
+// AdminAuditEvents shows the audit trail, newest first, searched & filtered by the query string (see
+// auditFilter), with a link to export the same events as CSV
+func (m *Repository) AdminAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r.URL.Query())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/admin/audit-events", http.StatusSeeOther)
+		return
+	}
+	filter.Limit = auditPageSize
+
+	events, err := m.DB.AllAuditEvents(r.Context(), filter)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	entities, err := m.DB.AuditEntities(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["events"] = events
+	data["entities"] = entities
+	data["full"] = len(events) == auditPageSize
+
+	// the filters are shown again in the form, & passed on to the export
+	query := r.URL.Query()
+	stringMap := make(map[string]string)
+	for _, param := range []string{"q", "entity", "entity_id", "from", "to"} {
+		stringMap[param] = query.Get(param)
+	}
+	stringMap["export_query"] = query.Encode()
+
+	renderPage(w, r, "admin-audit-events.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminExportAuditEvents downloads every audit event that matches the query string (see auditFilter) as CSV
+func (m *Repository) AdminExportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r.URL.Query())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/admin/audit-events", http.StatusSeeOther)
+		return
+	}
+
+	events, err := m.DB.AllAuditEvents(r.Context(), filter)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=audit-%s.csv",
+		time.Now().Format("2006-01-02")))
+
+	// NOTES: csv.Writer quotes any value that needs it, eg the changes, which are JSON & so full of commas
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "created_at", "user_id", "user", "api_key_id", "action", "entity", "entity_id",
+		"changes", "ip", "request_id"})
+
+	for _, e := range events {
+		changes, err := json.Marshal(e.Changes)
+		if err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "cannot export audit event", "audit_event_id", e.ID, "error", err)
+			continue
+		}
+
+		out.Write([]string{
+			strconv.Itoa(e.ID),
+			e.Created_at.Format(time.RFC3339),
+			strconv.Itoa(e.UserID),
+			e.Actor(),
+			strconv.Itoa(e.APIKeyID),
+			e.Action,
+			e.Entity,
+			strconv.Itoa(e.EntityID),
+			string(changes),
+			e.IP,
+			e.RequestID,
+		})
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot write audit export", "error", err)
+	}
+}
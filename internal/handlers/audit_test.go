@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var adminAuditEventsTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{"all events", "/admin/audit-events", http.StatusOK, "", "pending → confirmed"},
+	{"filtered", "/admin/audit-events?entity=reservations&entity_id=1", http.StatusOK, "", "Admin User"},
+	{"bad id", "/admin/audit-events?entity_id=one", http.StatusSeeOther, "/admin/audit-events", ""},
+	{"bad date", "/admin/audit-events?to=31/01/2050", http.StatusSeeOther, "/admin/audit-events", ""},
+}
+
+func TestRepository_AdminAuditEvents(t *testing.T) {
+	for _, e := range adminAuditEventsTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminAuditEvents)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminExportAuditEvents(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/audit-events/export", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminExportAuditEvents)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected a CSV content type, but got %s", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+		t.Errorf("expected the export to download, but got %s", cd)
+	}
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a header & 1 event, but got %d lines", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], "id,created_at,user_id,user,") {
+		t.Errorf("unexpected header row %s", lines[0])
+	}
+	// the changes are JSON, so they are quoted, with their own quotes doubled
+	if !strings.Contains(lines[1], `"{""status"":{""before"":""pending"",""after"":""confirmed""}}"`) {
+		t.Errorf("unexpected event row %s", lines[1])
+	}
+}
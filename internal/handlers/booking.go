@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// addToBooking adds a room the guest has chosen to their booking. If the booking already has that room for
+// some of the same nights, eg because the guest went back & picked it again, it is replaced
+func addToBooking(booking models.Booking, res models.Reservation) models.Booking {
+	var reservations []models.Reservation
+	for _, existing := range booking.Reservations {
+		if existing.RoomId == res.RoomId && res.StartDate.Before(existing.EndDate) && res.EndDate.After(existing.StartDate) {
+			continue
+		}
+		reservations = append(reservations, existing)
+	}
+	booking.Reservations = append(reservations, res)
+
+	booking.TotalPrice = 0
+	for _, r := range booking.Reservations {
+		booking.TotalPrice += r.TotalPrice
+	}
+
+	return booking
+}
+
+// defaultGuests fills in 1 adult & no children for a form that doesn't ask how many guests are staying, eg
+// the form on a room's page. Fields that were sent but left empty are still checked, & fail
+func defaultGuests(form *forms.Form, adults, children string) {
+	if _, ok := form.Values[adults]; !ok {
+		form.Set(adults, "1")
+	}
+	if _, ok := form.Values[children]; !ok {
+		form.Set(children, "0")
+	}
+}
+
+// roomName is the name of a room in a booking, for telling the guest which of their rooms has a problem
+func roomName(booking models.Booking, roomID int) string {
+	for _, res := range booking.Reservations {
+		if res.RoomId == roomID {
+			return res.Room.RoomName
+		}
+	}
+	return "that room"
+}
+
+// RemoveFromBooking takes a room out of the booking the guest is making. The room is given by its place
+// in the booking, as the same room could be in it twice for different dates
+func (m *Repository) RemoveFromBooking(w http.ResponseWriter, r *http.Request) {
+	booking, _ := m.App.Session.Get(r.Context(), "booking").(models.Booking)
+
+	i, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err == nil && i >= 0 && i < len(booking.Reservations) {
+		booking.TotalPrice -= booking.Reservations[i].TotalPrice
+		booking.Reservations = append(booking.Reservations[:i], booking.Reservations[i+1:]...)
+	}
+
+	if len(booking.Reservations) == 0 {
+		m.App.Session.Remove(r.Context(), "booking")
+		m.App.Session.Put(r.Context(), "warning", "There are no rooms in your booking. Search for a room to book")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "booking", booking)
+	m.App.Session.Put(r.Context(), "flash", "Room removed from your booking")
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// bookingEmails builds the emails sent when a booking is made: one confirmation to the guest listing all
+// the rooms they booked, & a notification to the owner of each room. Like reservationEmails, it returns a
+// func, as the emails need the IDs of the booking's reservations
+func (m *Repository) bookingEmails(property models.Property) repository.BookingEmails {
+	return func(booking models.Booking) ([]models.MailData, error) {
+		manageURLs := make([]string, len(booking.Reservations))
+		for i, res := range booking.Reservations {
+			manageURLs[i] = m.manageURL(res.ID)
+		}
+
+		guest, err := m.App.EmailTemplates.Render(booking.Email, property.SenderEmail, mail.BookingConfirmation{
+			Booking:    booking,
+			ManageURLs: manageURLs,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		msgs := []models.MailData{guest}
+
+		for _, res := range booking.Reservations {
+			ownerName, ownerEmail := notificationRecipient(property, res.Room)
+			if ownerEmail == "" {
+				continue
+			}
+			owner, err := m.App.EmailTemplates.Render(ownerEmail, property.SenderEmail, mail.OwnerNotification{
+				Reservation: res,
+				OwnerName:   ownerName,
+			})
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, owner)
+		}
+
+		return msgs, nil
+	}
+}
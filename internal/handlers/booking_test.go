@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// testStay is a reservation of room from start to end, priced at 100 dollars
+func testStay(roomID int, start, end string) models.Reservation {
+	startDate, _ := time.Parse("2006-01-02", start)
+	endDate, _ := time.Parse("2006-01-02", end)
+	return models.Reservation{
+		RoomId:     roomID,
+		StartDate:  startDate,
+		EndDate:    endDate,
+		TotalPrice: 10000,
+		Room:       models.Room{ID: roomID, RoomName: "Room"},
+	}
+}
+
+func TestAddToBooking(t *testing.T) {
+	var tests = []struct {
+		name          string
+		booking       []models.Reservation
+		add           models.Reservation
+		expectedRooms int
+		expectedTotal int
+	}{
+		{"first room", nil, testStay(1, "2050-01-01", "2050-01-03"), 1, 10000},
+		{"second room", []models.Reservation{testStay(1, "2050-01-01", "2050-01-03")}, testStay(2, "2050-01-01", "2050-01-03"), 2, 20000},
+		{"same room again", []models.Reservation{testStay(1, "2050-01-01", "2050-01-03")}, testStay(1, "2050-01-02", "2050-01-04"), 1, 10000},
+		{"same room later", []models.Reservation{testStay(1, "2050-01-01", "2050-01-03")}, testStay(1, "2050-01-03", "2050-01-05"), 2, 20000},
+	}
+
+	for _, e := range tests {
+		booking := addToBooking(models.Booking{Reservations: e.booking}, e.add)
+
+		if len(booking.Reservations) != e.expectedRooms {
+			t.Errorf("failed %s: expected %d rooms, but got %d", e.name, e.expectedRooms, len(booking.Reservations))
+		}
+		if booking.TotalPrice != e.expectedTotal {
+			t.Errorf("failed %s: expected a total of %d, but got %d", e.name, e.expectedTotal, booking.TotalPrice)
+		}
+		if last := booking.Reservations[len(booking.Reservations)-1]; !last.StartDate.Equal(e.add.StartDate) {
+			t.Errorf("failed %s: the room added is not in the booking", e.name)
+		}
+	}
+}
+
+func TestRepository_Reservation_Booking(t *testing.T) {
+	// the guest has a room in their booking already, & comes back to the page without choosing another
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "booking", models.Booking{Reservations: []models.Reservation{testStay(1, "2050-01-01", "2050-01-03")}})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Reservation handler returned wrong response code: got %d, instead of %d", rr.Code, http.StatusOK)
+	}
+
+	// now they choose a second room, which is added to the booking
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", testStay(2, "2050-01-01", "2050-01-03"))
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+
+	booking, _ := session.Get(ctx, "booking").(models.Booking)
+	if rr.Code != http.StatusOK || len(booking.Reservations) != 2 {
+		t.Errorf("Reservation handler did not add the room to the booking: got %d & %d rooms", rr.Code, len(booking.Reservations))
+	}
+	if !strings.Contains(rr.Body.String(), `href="/make-reservation/remove/1"`) {
+		t.Error("Reservation handler did not list both rooms")
+	}
+	if session.Exists(ctx, "reservation") {
+		t.Error("Reservation handler left the chosen room in the session")
+	}
+}
+
+func TestRepository_PostReservation_Booking(t *testing.T) {
+	var tests = []struct {
+		name             string
+		roomIDs          []string
+		starts           []string
+		ends             []string
+		expectedLocation string
+		expectedRooms    int
+	}{
+		{"two rooms", []string{"1", "1"}, []string{"2050-01-01", "2050-01-05"}, []string{"2050-01-03", "2050-01-07"}, "/reservation-summary", 2},
+		{"one room taken", []string{"1", "1"}, []string{"2050-01-01", "2070-01-01"}, []string{"2050-01-03", "2070-01-03"}, "/search-availability", 0},
+		{"missing dates", []string{"1", "1"}, []string{"2050-01-01"}, []string{"2050-01-03"}, "/", 0},
+		{"no rooms", nil, nil, nil, "/", 0},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.ca"},
+			"room_id":    e.roomIDs,
+			"start_date": e.starts,
+			"end_date":   e.ends,
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "booking", models.Booking{Reservations: []models.Reservation{testStay(1, "2050-01-01", "2050-01-03")}})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if rr.Code != http.StatusSeeOther || actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected a redirect to %s, but got %d to %s", e.name, e.expectedLocation, rr.Code, actualLoc)
+		}
+
+		booking, _ := session.Get(ctx, "new_booking").(models.Booking)
+		if len(booking.Reservations) != e.expectedRooms {
+			t.Errorf("failed %s: expected %d rooms booked, but got %d", e.name, e.expectedRooms, len(booking.Reservations))
+		}
+
+		// the booking the guest was making is only done with once it is saved
+		if e.expectedRooms > 0 && session.Exists(ctx, "booking") {
+			t.Errorf("failed %s: the booking being made is still in the session", e.name)
+		}
+		if e.expectedRooms == 0 && !session.Exists(ctx, "booking") {
+			t.Errorf("failed %s: the booking being made was lost", e.name)
+		}
+	}
+}
+
+func TestRepository_RemoveFromBooking(t *testing.T) {
+	var tests = []struct {
+		name             string
+		index            string
+		expectedLocation string
+		expectedRooms    int
+	}{
+		{"first room", "0", "/make-reservation", 1},
+		{"second room", "1", "/make-reservation", 1},
+		{"non-existent room", "5", "/make-reservation", 2},
+		{"invalid index", "x", "/make-reservation", 2},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/make-reservation/remove/"+e.index, nil)
+		ctx := getCtx(req)
+		ctx = addURLParams(ctx, map[string]string{"index": e.index})
+		req = req.WithContext(ctx)
+		session.Put(ctx, "booking", addToBooking(models.Booking{Reservations: []models.Reservation{testStay(1, "2050-01-01", "2050-01-03")}},
+			testStay(2, "2050-01-01", "2050-01-03")))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.RemoveFromBooking).ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if rr.Code != http.StatusSeeOther || actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected a redirect to %s, but got %d to %s", e.name, e.expectedLocation, rr.Code, actualLoc)
+		}
+
+		booking, _ := session.Get(ctx, "booking").(models.Booking)
+		if len(booking.Reservations) != e.expectedRooms {
+			t.Errorf("failed %s: expected %d rooms left, but got %d", e.name, e.expectedRooms, len(booking.Reservations))
+		}
+		if booking.TotalPrice != e.expectedRooms*10000 {
+			t.Errorf("failed %s: expected the total to be for %d rooms, but got %d", e.name, e.expectedRooms, booking.TotalPrice)
+		}
+	}
+
+	// taking out the last room leaves no booking, so the guest is sent to search for a room again
+	req, _ := http.NewRequest("GET", "/make-reservation/remove/0", nil)
+	ctx := getCtx(req)
+	ctx = addURLParams(ctx, map[string]string{"index": "0"})
+	req = req.WithContext(ctx)
+	session.Put(ctx, "booking", models.Booking{Reservations: []models.Reservation{testStay(1, "2050-01-01", "2050-01-03")}})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.RemoveFromBooking).ServeHTTP(rr, req)
+
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/search-availability" || session.Exists(ctx, "booking") {
+		t.Errorf("removing the last room: expected a redirect to /search-availability & no booking, but got %s", actualLoc)
+	}
+}
+
+func TestRepository_PostAvailability_Guests(t *testing.T) {
+	// the test repo's General's Quarters sleeps 2 & Major's Suite sleeps 4
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedRooms      []string
+	}{
+		{"no guests given", url.Values{}, http.StatusOK, []string{"General&#39;s Quarters", "Major&#39;s Suite"}},
+		{"two guests", url.Values{"adults": {"2"}, "children": {"0"}}, http.StatusOK, []string{"General&#39;s Quarters", "Major&#39;s Suite"}},
+		{"a family", url.Values{"adults": {"2"}, "children": {"2"}}, http.StatusOK, []string{"Major&#39;s Suite"}},
+		{"too many", url.Values{"adults": {"4"}, "children": {"1"}}, http.StatusSeeOther, nil},
+		{"no adults", url.Values{"adults": {"0"}, "children": {"2"}}, http.StatusSeeOther, nil},
+		{"invalid", url.Values{"adults": {"two"}}, http.StatusSeeOther, nil},
+	}
+
+	for _, e := range tests {
+		e.postedData.Set("start", "2040-01-01")
+		e.postedData.Set("end", "2040-01-02")
+
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		for _, room := range e.expectedRooms {
+			if !strings.Contains(rr.Body.String(), room) {
+				t.Errorf("failed %s: expected %s to be offered", e.name, room)
+			}
+		}
+		if len(e.expectedRooms) == 1 && strings.Contains(rr.Body.String(), "General&#39;s Quarters") {
+			t.Errorf("failed %s: a room too small was offered", e.name)
+		}
+	}
+}
+
+func TestRepository_PostReservation_Guests(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		adults             string
+		children           string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"room sleeps them", "1", "1", "1", http.StatusSeeOther, ""},
+		{"too many for the room", "1", "2", "1", http.StatusOK, "This room sleeps at most 2 guests"},
+		// the test repo then fails to save room 2, but it gets past the check of its guests
+		{"bigger room", "2", "2", "1", http.StatusSeeOther, ""},
+		{"no adults", "1", "0", "1", http.StatusOK, "At least 1 adult"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.ca"},
+			"room_id":    {e.roomID},
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-03"},
+			"adults_0":   {e.adults},
+			"children_0": {e.children},
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
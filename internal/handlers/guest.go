@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
+	"github.com/gustavNdamukong/hotel-bookings/internal/guestlinks"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/pricing"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// manageURL is the link a guest uses to view, change or cancel their reservation
+func (m *Repository) manageURL(reservationID int) string {
+	return fmt.Sprintf("%s/reservations/manage/%s", m.App.BaseURL, guestlinks.Sign(m.App.SigningKey, reservationID))
+}
+
+// guestCanChange checks if a reservation's arrival is still far enough away for the guest to cancel
+// or change it themselves
+func (m *Repository) guestCanChange(arrival time.Time) bool {
+	return time.Until(arrival) > m.App.CancelCutoff
+}
+
+// guestReservation looks up the reservation named by the {token} URL parameter. If it can't, it
+// sends the guest to the home page with an error & returns false
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := guestlinks.Verify(m.App.SigningKey, chi.URLParam(r, "token"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "That reservation link is not valid")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationById(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "We could not find that reservation. It may have been cancelled")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	}
+
+	if res.Status == models.ReservationCancelled {
+		m.App.Session.Put(r.Context(), "error", "This reservation has been cancelled")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	}
+
+	return res, true
+}
+
+// GuestManageReservation shows a guest their reservation, with forms to cancel it or change its dates
+func (m *Repository) GuestManageReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderManageReservation(w, r, res, forms.New(nil))
+}
+
+func (m *Repository) renderManageReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	stringMap["cutoff_hours"] = fmt.Sprintf("%d", int(m.App.CancelCutoff.Hours()))
+
+	// guests can only change reservations that could still be cancelled, eg not once they've checked in
+	intMap := make(map[string]int)
+	if m.guestCanChange(res.StartDate) && models.CanChangeStatus(res.Status, models.ReservationCancelled) {
+		intMap["can_change"] = 1
+	}
+
+	renderPage(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Form:      form,
+	})
+}
+
+// GuestCancelReservation lets a guest cancel their reservation, as long as it is not too close to arrival
+func (m *Repository) GuestCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	manage := fmt.Sprintf("/reservations/manage/%s", chi.URLParam(r, "token"))
+
+	if !m.guestCanChange(res.StartDate) {
+		m.App.Session.Put(r.Context(), "error", "It is too late to cancel this reservation online. Please contact us")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.ChangeReservationStatus(r.Context(), models.ReservationStatusChange{
+		ReservationID: res.ID,
+		ToStatus:      models.ReservationCancelled,
+		Source:        models.StatusChangedByGuest,
+		Reason:        "Cancelled by the guest",
+	})
+	if errors.Is(err, repository.ErrStatusChange) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled online. Please contact us")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot cancel reservation")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	// the reservation is already cancelled, so if the email can't be queued we just log it
+	property, err := m.DB.GetProperty(r.Context())
+	if err == nil {
+		var msg models.MailData
+		msg, err = m.App.EmailTemplates.Render(res.Email, property.SenderEmail, mail.Cancellation{Reservation: res})
+		if err == nil {
+			err = m.DB.QueueEmail(r.Context(), msg)
+		}
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot queue cancellation email", "reservation_id", res.ID, "error", err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// GuestChangeReservationDates lets a guest move their reservation to new dates. The new dates are priced
+// again & go through the same availability check as a new booking
+func (m *Repository) GuestChangeReservationDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	manage := fmt.Sprintf("/reservations/manage/%s", chi.URLParam(r, "token"))
+
+	if !m.guestCanChange(res.StartDate) {
+		m.App.Session.Put(r.Context(), "error", "It is too late to change this reservation online. Please contact us")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot parse form")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Choose an arrival date")
+	} else if !m.guestCanChange(startDate) {
+		form.Errors.Add("start_date", fmt.Sprintf("Arrival must be more than %d hours from now", int(m.App.CancelCutoff.Hours())))
+	}
+
+	endDate, err := time.Parse(layout, form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Choose a departure date")
+	}
+
+	if !form.Valid() {
+		m.renderManageReservation(w, r, res, form)
+		return
+	}
+
+	quote, err := m.quote(r.Context(), res.RoomId, startDate, endDate)
+	if err != nil {
+		var minStay *pricing.MinStayError
+		switch {
+		case errors.As(err, &minStay), errors.Is(err, pricing.ErrNoNights):
+			m.App.Session.Put(r.Context(), "error", err.Error())
+		default:
+			m.App.Session.Put(r.Context(), "error", "cannot get the price for those dates")
+		}
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.TotalPrice = quote.Total
+	res.Quote = quote
+
+	property, err := m.DB.GetProperty(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot change reservation")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.ChangeReservationDates(r.Context(), res, m.datesChangedEmails(property))
+	if err != nil {
+		var notAvailable *repository.RoomNotAvailableError
+		if errors.As(err, &notAvailable) {
+			m.App.Session.Put(r.Context(), "error", "Sorry, the room is not available for those dates. Please choose other dates.")
+		} else {
+			m.App.Session.Put(r.Context(), "error", "cannot change reservation")
+		}
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation dates have been changed")
+	http.Redirect(w, r, manage, http.StatusSeeOther)
+}
+
+// datesChangedEmails builds the email telling a guest their reservation has new dates
+func (m *Repository) datesChangedEmails(property models.Property) repository.ReservationEmails {
+	return func(res models.Reservation) ([]models.MailData, error) {
+		msg, err := m.App.EmailTemplates.Render(res.Email, property.SenderEmail, mail.DatesChanged{
+			Reservation: res,
+			ManageURL:   m.manageURL(res.ID),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return []models.MailData{msg}, nil
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/guestlinks"
+)
+
+// guestTests is the data for the guest self-service tests. The test repo's reservation 100 has already
+// started, so it can't be changed, 99 has been cancelled, & reservations over 100 don't exist
+var guestTests = []struct {
+	name               string
+	method             string
+	reservationID      int
+	token              string
+	path               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{"view", "GET", 1, "", "", nil, http.StatusOK, "", "Change Dates"},
+	{"view too late", "GET", 100, "", "", nil, http.StatusOK, "", "contact us"},
+	{"view bad token", "GET", 0, "1.nope", "", nil, http.StatusSeeOther, "/", ""},
+	{"view missing", "GET", 101, "", "", nil, http.StatusSeeOther, "/", ""},
+	{"view cancelled", "GET", 99, "", "", nil, http.StatusSeeOther, "/", ""},
+	{"cancel cancelled", "POST", 99, "", "/cancel", url.Values{}, http.StatusSeeOther, "/", ""},
+	{"cancel", "POST", 1, "", "/cancel", url.Values{}, http.StatusSeeOther, "/", ""},
+	{"cancel too late", "POST", 100, "", "/cancel", url.Values{}, http.StatusSeeOther, "/reservations/manage/", ""},
+	{"cancel bad token", "POST", 0, "2.nope", "/cancel", url.Values{}, http.StatusSeeOther, "/", ""},
+	{"change dates", "POST", 1, "", "/dates",
+		url.Values{"start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}},
+		http.StatusSeeOther, "/reservations/manage/", ""},
+	{"change dates taken", "POST", 1, "", "/dates",
+		url.Values{"start_date": {"2070-02-01"}, "end_date": {"2070-02-03"}},
+		http.StatusSeeOther, "/reservations/manage/", ""},
+	{"change dates db error", "POST", 1, "", "/dates",
+		url.Values{"start_date": {"2060-01-01"}, "end_date": {"2060-01-03"}},
+		http.StatusSeeOther, "/reservations/manage/", ""},
+	{"change dates no nights", "POST", 1, "", "/dates",
+		url.Values{"start_date": {"2050-02-03"}, "end_date": {"2050-02-01"}},
+		http.StatusSeeOther, "/reservations/manage/", ""},
+	{"change dates missing", "POST", 1, "", "/dates", url.Values{},
+		http.StatusOK, "", "This field cannot be blank"},
+	{"change dates to the past", "POST", 1, "", "/dates",
+		url.Values{"start_date": {"2020-02-01"}, "end_date": {"2020-02-03"}},
+		http.StatusOK, "", "Arrival must be more than 48 hours from now"},
+	{"change dates too late", "POST", 100, "", "/dates",
+		url.Values{"start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}},
+		http.StatusSeeOther, "/reservations/manage/", ""},
+}
+
+func TestGuestManageReservation(t *testing.T) {
+	routes := getRoutes()
+
+	for _, e := range guestTests {
+		token := e.token
+		if token == "" {
+			token = guestlinks.Sign(app.SigningKey, e.reservationID)
+		}
+
+		var req *http.Request
+		if e.postedData != nil {
+			req = httptest.NewRequest(e.method, "/reservations/manage/"+token+e.path, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(e.method, "/reservations/manage/"+token+e.path, nil)
+		}
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			expected := e.expectedLocation
+			if strings.HasSuffix(expected, "/manage/") {
+				expected += token
+			}
+			if actualLoc.String() != expected {
+				t.Errorf("%s: expected location %s, but got location %s", e.name, expected, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q but did not", e.name, e.expectedHTML)
+		}
+	}
+}
@@ -1,23 +1,30 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/apikeys"
 	"github.com/gustavNdamukong/hotel-bookings/internal/config"
 	"github.com/gustavNdamukong/hotel-bookings/internal/driver"
 	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
 	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/pricing"
 	"github.com/gustavNdamukong/hotel-bookings/internal/render"
 	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
 	"github.com/gustavNdamukong/hotel-bookings/internal/repository/dbrepo"
+	"github.com/gustavNdamukong/hotel-bookings/internal/roles"
 )
 
 // Repo the repository used by the handlers
@@ -50,9 +57,26 @@ func NewHandlers(r *Repository) {
 	Repo = r
 }
 
+// renderPage renders a page, or the 500 error page if it can't be rendered, eg because its template is broken
+func renderPage(w http.ResponseWriter, r *http.Request, tmpl string, tData *models.TemplateData) {
+	if err := render.Template(w, r, tmpl, tData); err != nil {
+		helpers.ServerError(w, r, err)
+	}
+}
+
+// NotFound is the handler for any URL that has no route
+func (m *Repository) NotFound(w http.ResponseWriter, r *http.Request) {
+	helpers.ClientError(w, r, http.StatusNotFound)
+}
+
+// MethodNotAllowed is the handler for a route used with the wrong method, eg a GET to a POST only route
+func (m *Repository) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	helpers.ClientError(w, r, http.StatusMethodNotAllowed)
+}
+
 // Home is the handler for the home page
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "index.page.tmpl", &models.TemplateData{})
+	renderPage(w, r, "index.page.tmpl", &models.TemplateData{})
 }
 
 // About is the handler for the about page
@@ -61,23 +85,7 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 	stringMap := make(map[string]string)
 
 	// send data to the template
-	render.Template(w, r, "about.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-	})
-}
-
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
-}
-
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	stringMap := make(map[string]string)
-	stringMap["title"] = "Majors suit page"
-
-	// send the data to the template
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{
+	renderPage(w, r, "about.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 	})
 }
@@ -88,7 +96,7 @@ func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
 	stringMap["title"] = "Search availability page"
 
 	// send the data to the template
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+	renderPage(w, r, "search-availability.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 	})
 }
@@ -120,14 +128,35 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// only rooms that sleep everyone staying are offered
+	form := forms.New(r.PostForm)
+	defaultGuests(form, "adults", "children")
+	if !form.IntBetween("adults", 1, maxRoomCapacity) || !form.IntBetween("children", 0, maxRoomCapacity) {
+		m.App.Session.Put(r.Context(), "error", "Please choose how many adults & children are staying")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	adults, _ := strconv.Atoi(strings.TrimSpace(form.Get("adults")))
+	children, _ := strconv.Atoi(strings.TrimSpace(form.Get("children")))
+
 	// check availability of all rooms (it should return a slice of room models)
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate, adults+children)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
+	// rooms the guest has already added to their booking for these dates are not offered again
+	booking, _ := m.App.Session.Get(r.Context(), "booking").(models.Booking)
+	var available []models.Room
+	for _, room := range rooms {
+		if !booking.HasRoom(room.ID, startDate, endDate) {
+			available = append(available, room)
+		}
+	}
+	rooms = available
+
 	if len(rooms) == 0 {
 		// no availabile rooms
 		m.App.Session.Put(r.Context(), "error", "No availabile room")
@@ -146,11 +175,13 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
-	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
+	renderPage(w, r, "choose-room.page.tmpl", &models.TemplateData{
 		Data: data,
 	})
 }
@@ -189,23 +220,23 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, startD)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	endDate, err := time.Parse(layout, endD)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomId(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomId(r.Context(), startDate, endDate, roomID)
 	if err != nil {
 		// can't parse form, so return appropriate json
 		resp := jsonResponse{
@@ -252,47 +283,62 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 // Contact renders the contact page
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+	renderPage(w, r, "contact.page.tmpl", &models.TemplateData{})
 }
 
-// Reservation renders the 'make-reservation' page and displays a form
+// Reservation renders the 'make-reservation' page and displays a form. The room the guest has just chosen
+// is added to their booking, which can hold several rooms, eg for a family. The page lists them all, & the
+// form books them all in one go
 func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
+	// the booking is kept in the session while the guest adds rooms to it, like a shopping cart
+	booking, _ := m.App.Session.Get(r.Context(), "booking").(models.Booking)
+
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
+	if !ok && len(booking.Reservations) == 0 {
 		m.App.Session.Put(r.Context(), "error", "cannot get reservation from session")
 		//NOTES: How to redirect user to another route
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	room, err := m.DB.GetRoomById(reservation.RoomId)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "cannot find room with that id")
-		//NOTES: How to redirect user to another route
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
+	if ok {
+		room, err := m.DB.GetRoomById(r.Context(), reservation.RoomId)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "cannot find room with that id")
+			//NOTES: How to redirect user to another route
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
 
-	reservation.Room.RoomName = room.RoomName
+		reservation.Room.RoomName = room.RoomName
+		reservation.Room.Capacity = room.Capacity
 
-	// put the reservation model in the session as we will need it later
-	m.App.Session.Put(r.Context(), "reservation", reservation)
+		// rooms booked from their own page, rather than a search, haven't been told how many guests are staying
+		if reservation.Adults < 1 {
+			reservation.Adults = 1
+		}
 
-	// NOTES: When it comes to dates, when accepting data with dates from the browser eg forms,
-	//	the dates need to be converted from strings to time.Time, and vice versa. In this case,
-	//	we need to pass data from the reservation model stored in the session to a view HTML form,
-	//	so we need to re-convert the start & end dates to string formats again. Here is how to do it.
-	startD := reservation.StartDate.Format("2006-01-02")
-	endD := reservation.EndDate.Format("2006-01-02")
+		// work out what the stay will cost so we can show the guest before they book
+		quote, err := m.quote(r.Context(), reservation.RoomId, reservation.StartDate, reservation.EndDate)
+		if err != nil {
+			m.quoteError(w, r, err)
+			return
+		}
+		reservation.Quote = quote
+		reservation.TotalPrice = quote.Total
+
+		// the room is in the booking now, so it isn't added again if the guest reloads the page
+		booking = addToBooking(booking, reservation)
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "booking", booking)
+	}
 
 	stringMap := make(map[string]string)
-	stringMap["start_date"] = startD
-	stringMap["end_date"] = endD
 	stringMap["title"] = "Make Reservation"
 
 	data := make(map[string]interface{})
-	data["reservation"] = reservation
+	data["booking"] = booking
 
 	// send the data to the template
 	//notice how we send an empty form to the target form view.
@@ -300,7 +346,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	// NOTES: This is how you pass data to a view. In this case we pass data we have
 	//	prepared above (stringMap & data) to the correspondiong keys: StringMap & Data
 	//	that are part of the models.TemplateData struct that are passed to all views.
-	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+	renderPage(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 		Form:      forms.New(nil),
 		Data:      data,
@@ -314,7 +360,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		if !ok {
 			// NOTES: How to generate an error string (ServerError() is a custom function,
 			//	checkout its content-in internal/helpers/helpers.go)
-			helpers.ServerError(w, errors.New("Cannot get reservation data from session"))
+			helpers.ServerError(w, r, errors.New("Cannot get reservation data from session"))
 			return
 		}
 
@@ -439,54 +485,93 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	startD := r.Form.Get("start_date")
-	endD := r.Form.Get("end_date")
-
-	// 2020-01-01 -- 01/02 03:04:05PM '06 -0700
-
-	layout := "2006-01-02"
-
-	startDate, err := time.Parse(layout, startD)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse start date")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-
-	endDate, err := time.Parse(layout, endD)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't get parse end date")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-
-	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "invalid data!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-
-	room, err := m.DB.GetRoomById(roomID)
-	if err != nil {
+	// NOTES: the form has a room_id, start_date & end_date for each room in the booking. r.Form.Get() only
+	//	gives us the first value of a field, so to get all of them we index into r.Form, which is a
+	//	map of each field's name to a slice of its values
+	roomIDs := r.Form["room_id"]
+	starts := r.Form["start_date"]
+	ends := r.Form["end_date"]
+	if len(roomIDs) == 0 || len(starts) != len(roomIDs) || len(ends) != len(roomIDs) {
 		m.App.Session.Put(r.Context(), "error", "invalid data!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	reservation := models.Reservation{
+	booking := models.Booking{
 		FirstName: r.Form.Get("first_name"),
 		LastName:  r.Form.Get("last_name"),
 		Phone:     r.Form.Get("phone"),
 		Email:     r.Form.Get("email"),
-		StartDate: startDate,
-		EndDate:   endDate,
-		RoomId:    roomID,
-		Room:      room,
 	}
 
 	form := forms.New(r.PostForm)
 
+	// 2020-01-01 -- 01/02 03:04:05PM '06 -0700
+
+	layout := "2006-01-02"
+
+	for i := range roomIDs {
+		startDate, err := time.Parse(layout, starts[i])
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't parse start date")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		endDate, err := time.Parse(layout, ends[i])
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't get parse end date")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		roomID, err := strconv.Atoi(roomIDs[i])
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "invalid data!")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		room, err := m.DB.GetRoomById(r.Context(), roomID)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "invalid data!")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		// price the stay again now, rather than trusting anything from the form or session
+		quote, err := m.quote(r.Context(), roomID, startDate, endDate)
+		if err != nil {
+			m.quoteError(w, r, err)
+			return
+		}
+
+		// NOTES: the guests staying in each room are fields of their own, eg adults_0 & children_0 for the
+		//	first room, so that the form can show which room has too many guests
+		adultsField, childrenField := fmt.Sprintf("adults_%d", i), fmt.Sprintf("children_%d", i)
+		defaultGuests(form, adultsField, childrenField)
+		form.Occupancy(adultsField, childrenField, room.Capacity)
+		adults, _ := strconv.Atoi(strings.TrimSpace(form.Get(adultsField)))
+		children, _ := strconv.Atoi(strings.TrimSpace(form.Get(childrenField)))
+
+		// each room is a reservation of its own, made out to the guest who booked
+		booking.Reservations = append(booking.Reservations, models.Reservation{
+			FirstName:  booking.FirstName,
+			LastName:   booking.LastName,
+			Phone:      booking.Phone,
+			Email:      booking.Email,
+			StartDate:  startDate,
+			EndDate:    endDate,
+			RoomId:     roomID,
+			Room:       room,
+			Quote:      quote,
+			TotalPrice: quote.Total,
+			Adults:     adults,
+			Children:   children,
+		})
+		booking.TotalPrice += quote.Total
+	}
+
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3)
 	// TODO: validate submitted email address using the installed Govalidator library
@@ -494,17 +579,14 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 	if !form.Valid() {
 		data := make(map[string]interface{})
-		data["reservation"] = reservation
+		data["booking"] = booking
 
-		// We need to pass back some data into StringMap which the form's hidden fields use
 		stringMap := make(map[string]string)
-		stringMap["start_date"] = startD
-		stringMap["end_date"] = endD
 		stringMap["title"] = "Make Reservation"
 
 		//http.Error(w, "my own error message", http.StatusSeeOther)
 		m.App.Session.Put(r.Context(), "error", "There were some errors, fix them and try again")
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+		renderPage(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 			Form:      form,
 			Data:      data,
 			StringMap: stringMap,
@@ -512,97 +594,137 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Now save this reservation to the DB
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	// Now save the booking to the DB. This re-checks that every room is still free & inserts the booking,
+	// its reservations & their room restrictions (which block the rooms for these dates) in one transaction,
+	// so that someone else booking the same room at the same time can't give us a double booking, & the
+	// guest never ends up with only some of the rooms they need.
+	// The confirmation emails are queued in the same transaction, for the mail worker to send.
+	property, err := m.DB.GetProperty(r.Context())
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "cannot insert reservation into database")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
-		// NOTES: this is how we can make use of our error helper to throw errors
 		return
 	}
 
-	// prepare a model which we will send to a func to resstrict a room that has been reserved
-	// that's why we pass it the newReservationID of the newly inserted reservation ID above
-	restriction := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomId:        roomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-		// These last 3 we might need in future, but tey're not part of the DB fields
-		/*Room: 		xxxxx,
-		Reservation:    xxxxx,
-		Restriction:	xxxxx, */
-	}
-
-	//send the model to the func to do the restriction insertion
-	err = m.DB.InsertRoomRestriction(restriction)
+	booking, err = m.DB.InsertBooking(r.Context(), booking, m.bookingEmails(property))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "cannot insert room restriction!")
+		// NOTES: errors.As() is how you check if an error (or any error it wraps) is of a given type
+		var notAvailable *repository.RoomNotAvailableError
+		if errors.As(err, &notAvailable) {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s is no longer available for those dates. Please choose other dates.",
+				roomName(booking, notAvailable.RoomID)))
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+		m.App.Session.Put(r.Context(), "error", "cannot insert reservation into database")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	for range booking.Reservations {
+		m.App.Metrics.ReservationCreated()
+	}
 
-	//-------------------------------------------
-	// send email notifications - first to guest
-	htmlMessage := fmt.Sprintf(`
-			<strong>Reservation Confirmation</strong><br>
-			Dear %s, <br>
-			This is to confirm your reservation from %s to %s.
-		`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+	// the guest's booking is done, so they start a new one next time
+	m.App.Session.Remove(r.Context(), "booking")
+	m.App.Session.Put(r.Context(), "new_booking", booking)
 
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "gustavfn@yahoo.co.uk",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+	// rooms that ask for a deposit are paid for with the payment provider, which sends the guest back to the
+	// summary once they have paid. Their reservations stay pending until then
+	checkoutURL, err := m.startPayment(r.Context(), booking)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot start payment", "booking_id", booking.ID, "error", err)
+		m.App.Session.Put(r.Context(), "warning", "Your rooms are held, but we could not take your deposit. We will be in touch to arrange it")
+	} else if checkoutURL != "" {
+		http.Redirect(w, r, checkoutURL, http.StatusSeeOther)
+		return
 	}
 
-	m.App.MailChan <- msg
-	//-------------------------------------------
+	//http response 'StatusSeeOther' is equal to http response code 303
+	//which is ideal for redirections to handle post requests
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 
-	//-------------------------------------------
-	// send email notifications - first to property owner
-	htmlMessage = fmt.Sprintf(`
-			<strong>Reservation Notification</strong><br>
-			Dear %s, <br>
-			This is to notify you of a new reservation that has been booked for your property%s.<br>
-			The Booking is by %s %s and the reservation is <br>
-			from %s to %s.<br>
+}
 
-			Kind regards<br>
-			The dream team
-		`, "IDoNotKnowOwnerName", reservation.Room.RoomName, reservation.FirstName, reservation.LastName,
-		reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+// reservationEmails builds the emails sent when a reservation is made: a confirmation to the guest & a
+// notification to the owner of the room. It returns a repository.ReservationEmails, as the emails need the
+// reservation's ID
+func (m *Repository) reservationEmails(property models.Property) repository.ReservationEmails {
+	return func(reservation models.Reservation) ([]models.MailData, error) {
+		guest, err := m.App.EmailTemplates.Render(reservation.Email, property.SenderEmail, mail.Confirmation{
+			Reservation: reservation,
+			ManageURL:   m.manageURL(reservation.ID),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		msgs := []models.MailData{guest}
+
+		ownerName, ownerEmail := notificationRecipient(property, reservation.Room)
+		if ownerEmail != "" {
+			owner, err := m.App.EmailTemplates.Render(ownerEmail, property.SenderEmail, mail.OwnerNotification{
+				Reservation: reservation,
+				OwnerName:   ownerName,
+			})
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, owner)
+		}
 
-	msg = models.MailData{
-		To:       "IDoNotKnowOwnerEmail@gmail.com",
-		From:     "gustavfn@yahoo.co.uk",
-		Subject:  "Reservation Notification",
-		Content:  htmlMessage,
-		Template: "basic.html",
+		return msgs, nil
 	}
+}
 
-	m.App.MailChan <- msg
-	//-------------------------------------------
+// notificationRecipient returns who should hear about reservations of a room: the room's own owner if it
+// has one, otherwise the property's owner. The email is empty if nobody has been set up yet
+func notificationRecipient(property models.Property, room models.Room) (string, string) {
+	if room.OwnerEmail != "" {
+		return room.OwnerName, room.OwnerEmail
+	}
+	return property.OwnerName, property.OwnerEmail
+}
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	//http response 'StatusSeeOther' is equal to http response code 303
-	//which is ideal for redirections to handle post requests
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+// quote prices a stay in a room from its base, seasonal & weekend rates
+func (m *Repository) quote(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error) {
+	rate, err := m.DB.GetRoomRateByRoomId(ctx, roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
 
+	seasons, err := m.DB.GetSeasonalRatesForRoomByDate(ctx, roomID, start, end)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	return pricing.Quote(rate, seasons, start, end)
+}
+
+// quoteError tells the guest why we could not price their stay & sends them back to pick other dates
+func (m *Repository) quoteError(w http.ResponseWriter, r *http.Request, err error) {
+	var minStay *pricing.MinStayError
+	switch {
+	case errors.As(err, &minStay):
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, the minimum stay for those dates is %d nights", minStay.MinStay))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+	case errors.Is(err, pricing.ErrNoNights):
+		m.App.Session.Put(r.Context(), "error", "Your departure date must be after your arrival date")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+	default:
+		m.App.Session.Put(r.Context(), "error", "cannot get the price for that room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
 }
 
-// ReservationSummary displays the reservation summary page
+// ReservationSummary displays the reservation summary page, with every room of the booking the guest has just made
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
 	//NOTES: this is how you retrieve a struct passed to a session var. We use Session.Get(...)
 	//and we chain the struct type at the end of it aka type-assert, thereby asserting that
-	//what is stored in the 'reservation' key in the session is indeed a Reservation model.
+	//what is stored in the 'new_booking' key in the session is indeed a Booking model.
 	//Notice that this is as opposed to grabbing a string from the session-where you
 	//would use Session.GetString()
 	// NOTES: document how you would store & retrieve a struct from the session
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	booking, ok := m.App.Session.Get(r.Context(), "new_booking").(models.Booking)
 	if !ok {
 		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from session")
 		//the http response code 'StatusTemporaryRedirect' is essentially a 301 code
@@ -611,23 +733,23 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	}
 
 	// NOTES: How to remove an item from the session
-	m.App.Session.Remove(r.Context(), "reservation")
+	m.App.Session.Remove(r.Context(), "new_booking")
+
+	// each room of the booking is a reservation the guest can manage on its own
+	manageURLs := make([]string, len(booking.Reservations))
+	for i, res := range booking.Reservations {
+		manageURLs[i] = m.manageURL(res.ID)
+	}
 
 	// NOTES: document in data types how when initialising a map, if an interface{} type
 	// is declared for its value, that indicates it will contain a struct. In the case below,
-	// reservation is a struct. Also refer to notes on how structs can be interfaces.
+	// booking is a struct. Also refer to notes on how structs can be interfaces.
 	data := make(map[string]interface{})
-	data["reservation"] = reservation
-
-	startD := reservation.StartDate.Format("2006-01-02")
-	endD := reservation.EndDate.Format("2006-01-02")
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = startD
-	stringMap["end_date"] = endD
+	data["booking"] = booking
+	data["manage_urls"] = manageURLs
 
-	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
-		Data:      data,
-		StringMap: stringMap,
+	renderPage(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+		Data: data,
 	})
 }
 
@@ -665,7 +787,8 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	//  res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "cannot get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
@@ -691,19 +814,22 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, startD)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "can't parse start date")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
 	endDate, err := time.Parse(layout, endD)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "can't parse end date")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	room, err := m.DB.GetRoomById(roomID)
+	room, err := m.DB.GetRoomById(r.Context(), roomID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "cannot find room with that id")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
@@ -721,7 +847,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 
 // ShowLogin shows the login screen
 func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+	renderPage(w, r, "login.page.tmpl", &models.TemplateData{
 		Form: forms.New(nil),
 	})
 }
@@ -732,7 +858,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	_ = m.App.Session.RenewToken(r.Context())
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.WarnContext(r.Context(), "cannot parse login form", "error", err)
 	}
 
 	email := r.Form.Get("email")
@@ -745,21 +871,23 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	form.IsEmail("email")
 
 	if !form.Valid() {
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		renderPage(w, r, "login.page.tmpl", &models.TemplateData{
 			Form: form,
 		})
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, accessLevel, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.InfoContext(r.Context(), "failed login", "error", err)
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
 	}
 
-	//need to store their id in the session
+	//need to store their id in the session, & their role, which decides what they can do in admin
 	m.App.Session.Put(r.Context(), "user_id", id)
+	m.App.Session.Put(r.Context(), "role", string(roles.FromAccessLevel(accessLevel)))
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -775,36 +903,47 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+	renderPage(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
 }
 
-// AdminReservations shows all reservations in admin dashboard
+// AdminReservations shows all reservations in admin dashboard. The 'status' query parameter
+// (eg ?status=checked-in) shows only the reservations with that status
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	status := r.URL.Query().Get("status")
+	if !slices.Contains(models.ReservationStatuses, status) {
+		status = ""
+	}
+
+	reservations, err := m.DB.AllReservations(r.Context(), status)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	data["statuses"] = models.ReservationStatuses
 
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	renderPage(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
 	})
 }
 
-// AdminNewReservations shows all new reservations in admin dashboard
+// AdminNewReservations shows all new (ie pending) reservations in admin dashboard
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.DB.AllReservations(r.Context(), models.ReservationPending)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+	renderPage(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
 		Data: data,
 	})
 }
@@ -818,7 +957,8 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
 	}
 
 	src := exploded[3]
@@ -832,15 +972,30 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 
 	// get reservation from DB
-	res, err := m.DB.GetReservationById(id)
+	res, err := m.DB.GetReservationById(r.Context(), id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	changes, err := m.DB.ReservationStatusChanges(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	payments, err := m.DB.PaymentsByReservationId(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
 	}
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["status_changes"] = changes
+	data["payments"] = payments
 
-	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+	renderPage(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 		Data:      data,
 		Form:      forms.New(nil),
@@ -851,14 +1006,14 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 func (m *Repository) AdminShowPostReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -867,9 +1022,9 @@ func (m *Repository) AdminShowPostReservation(w http.ResponseWriter, r *http.Req
 	stringMap["src"] = src
 
 	// get reservation from DB
-	res, err := m.DB.GetReservationById(id)
+	res, err := m.DB.GetReservationById(r.Context(), id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -880,9 +1035,9 @@ func (m *Repository) AdminShowPostReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	err = m.DB.UpdateReservation(r.Context(), res)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -959,9 +1114,9 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	intMap := make(map[string]int)
 	intMap["days_in_month"] = lastOfMonth.Day()
 
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -984,9 +1139,9 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 		}
 
 		// get all the restrictions (existing bookings) for the current month
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), room.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 		// loop through the restrictions & determine whether its a reservation or a block
@@ -1024,56 +1179,72 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 
 	}
 
-	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+	renderPage(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 		Data:      data,
 		IntMap:    intMap,
 	})
 }
 
-// AdminProcessReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
-	err := m.DB.UpdateProcessed(id, 1)
+// AdminPostReservationStatus moves a reservation to the status in the form, eg checks the guest in, & records
+// who did it & why. Only managers can cancel reservations, & cancelling or marking a no-show needs a reason
+func (m *Repository) AdminPostReservationStatus(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
-	}
-
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
-
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+		helpers.ServerError(w, r, err)
+		return
 	}
-}
 
-// AdminDeleteReservation deletes a reservation
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
-	_ = m.DB.DeleteReservation(id)
+	show := fmt.Sprintf("/admin/reservations/%s/%d/show?y=%s&m=%s", src, id, r.Form.Get("year"), r.Form.Get("month"))
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	status := r.Form.Get("status")
+	reason := strings.TrimSpace(r.Form.Get("reason"))
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	if status == models.ReservationCancelled && !helpers.HasRole(r, roles.Manager) {
+		helpers.ClientError(w, r, http.StatusForbidden)
+		return
+	}
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	if reason == "" && (status == models.ReservationCancelled || status == models.ReservationNoShow) {
+		m.App.Session.Put(r.Context(), "error", "Please give a reason")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
 	}
+
+	err = m.DB.ChangeReservationStatus(r.Context(), models.ReservationStatusChange{
+		ReservationID: id,
+		ToStatus:      status,
+		UserID:        m.App.Session.GetInt(r.Context(), "user_id"),
+		Source:        models.StatusChangedByAdmin,
+		Reason:        reason,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, repository.ErrStatusChange) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("This reservation can't be marked as %s", status))
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot change reservation status", "reservation_id", id, "status", status, "error", err)
+		m.App.Session.Put(r.Context(), "error", "cannot change reservation status")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked as %s", status))
+	http.Redirect(w, r, show, http.StatusSeeOther)
 }
 
 // AdminPostReservationsCalendar handles post of reservation calendar
 func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -1081,9 +1252,9 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 	month, _ := strconv.Atoi(r.Form.Get("m"))
 
 	// process blocks
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -1117,10 +1288,10 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 				if val > 0 {
 					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, name)) {
 						// delete the restriction by id
-						log.Println("Would delete block", value, name)
-						err := m.DB.DeleteBlockById(value)
+						m.App.Logger.DebugContext(r.Context(), "deleting block", "restriction_id", value, "room_id", room.ID)
+						err := m.DB.DeleteBlockById(r.Context(), value)
 						if err != nil {
-							log.Println(err)
+							m.App.Logger.ErrorContext(r.Context(), "cannot delete block", "restriction_id", value, "error", err)
 						}
 					}
 				}
@@ -1142,9 +1313,9 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 			//	The latter will not work. This is for a 4 digit year, a two digit month, and a 1 or 2 digit day.
 			startDate, _ := time.Parse("2006-01-2", date)
 			// insert the new block
-			err := m.DB.InsertBlockForRoom(roomID, startDate)
+			err := m.DB.InsertBlockForRoom(r.Context(), roomID, startDate)
 			if err != nil {
-				log.Println(err)
+				m.App.Logger.ErrorContext(r.Context(), "cannot block room", "room_id", roomID, "date", date, "error", err)
 			}
 		}
 	}
@@ -1152,3 +1323,106 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 	m.App.Session.Put(r.Context(), "flash", "Changes saved")
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
 }
+
+// AdminAPIKeys lists the API keys & shows the form to create a new one
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := m.DB.AllAPIKeys(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["api_keys"] = keys
+	data["scopes"] = apikeys.Scopes
+
+	// a key that was just created is only ever shown this once
+	stringMap := make(map[string]string)
+	stringMap["new_key"] = m.App.Session.PopString(r.Context(), "new_api_key")
+
+	renderPage(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostAPIKey creates a new API key
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	// NOTES: r.Form["scopes"] (rather than r.Form.Get("scopes")) gives every value of a field that was
+	// posted more than once, like a group of checkboxes with the same name
+	scopes := r.Form["scopes"]
+	if len(scopes) == 0 {
+		form.Errors.Add("scopes", "Choose at least one scope")
+	}
+	for _, s := range scopes {
+		if !apikeys.ValidScope(s) {
+			form.Errors.Add("scopes", fmt.Sprintf("Unknown scope %s", s))
+		}
+	}
+
+	if !form.Valid() {
+		keys, err := m.DB.AllAPIKeys(r.Context())
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["api_keys"] = keys
+		data["scopes"] = apikeys.Scopes
+
+		renderPage(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	key, prefix, hash, err := apikeys.Generate()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	_, err = m.DB.InsertAPIKey(r.Context(), models.APIKey{
+		UserID:  m.App.Session.GetInt(r.Context(), "user_id"),
+		Name:    form.Get("name"),
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  scopes,
+	})
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot save API key")
+		http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "new_api_key", key)
+	m.App.Session.Put(r.Context(), "flash", "API key created. Copy it now, it will not be shown again")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminRevokeAPIKey revokes an API key
+func (m *Repository) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot revoke API key")
+		http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "API key revoked")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/gustavNdamukong/hotel-bookings/internal/driver"
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
 )
@@ -39,9 +41,14 @@ var theTests = []struct {
 	expectedStatusCode int
 }{
 	{"home", "/", "GET", http.StatusOK},
+	{"healthz", "/healthz", "GET", http.StatusOK},
+	{"metrics", "/metrics", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
 	{"generals-quarters", "/generals-quarters", "GET", http.StatusOK},
 	{"majors-suite", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"room", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"non-existent room", "/rooms/nope", "GET", http.StatusNotFound},
 	{"search-availability", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
 	{"non-existent", "/green/eggs/and/ham", "GET", http.StatusNotFound},
@@ -50,9 +57,37 @@ var theTests = []struct {
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
 	{"new res", "/admin/reservations-new", "GET", http.StatusOK},
 	{"all res", "/admin/reservations-all", "GET", http.StatusOK},
+	{"cancelled res", "/admin/reservations-all?status=cancelled", "GET", http.StatusOK},
 	{"show res", "/admin/reservations/new/1/show", "GET", http.StatusOK},
 	{"show res cal", "/admin/reservations-calendar", "GET", http.StatusOK},
 	{"show res cal with params", "/admin/reservations-calendar?y=2020&m=1", "GET", http.StatusOK},
+	{"api keys", "/admin/api-keys", "GET", http.StatusOK},
+	{"email outbox", "/admin/email-outbox", "GET", http.StatusOK},
+	{"property", "/admin/property", "GET", http.StatusOK},
+	{"admin rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"admin new room", "/admin/rooms/new", "GET", http.StatusOK},
+	{"admin room", "/admin/rooms/1", "GET", http.StatusOK},
+	{"admin non-existent room", "/admin/rooms/x", "GET", http.StatusNotFound},
+	{"archive room", "/admin/archive-room/1/do", "GET", http.StatusOK},
+	{"restore room", "/admin/restore-room/1/do", "GET", http.StatusOK},
+	{"move room", "/admin/move-room/2/up/do", "GET", http.StatusOK},
+	{"room photos", "/admin/rooms/1/photos", "GET", http.StatusOK},
+	{"non-existent room photos", "/admin/rooms/x/photos", "GET", http.StatusNotFound},
+	{"room seasons", "/admin/rooms/1/seasons", "GET", http.StatusOK},
+	{"new room season", "/admin/rooms/1/seasons/new", "GET", http.StatusOK},
+	{"room season", "/admin/rooms/1/seasons/1", "GET", http.StatusOK},
+	{"another room's season", "/admin/rooms/2/seasons/1", "GET", http.StatusNotFound},
+	{"non-existent room season", "/admin/rooms/1/seasons/9", "GET", http.StatusNotFound},
+	{"move room photo", "/admin/move-room-photo/2/up/do", "GET", http.StatusOK},
+	{"non-existent room photo", "/admin/move-room-photo/9/up/do", "GET", http.StatusNotFound},
+	{"failed emails", "/admin/email-outbox?status=failed", "GET", http.StatusOK},
+	{"resend email", "/admin/resend-email/1/do", "GET", http.StatusOK},
+	{"resend missing email", "/admin/resend-email/101/do", "GET", http.StatusOK},
+	{"audit log", "/admin/audit-events", "GET", http.StatusOK},
+	{"filtered audit log", "/admin/audit-events?q=status&entity=reservations&entity_id=1&from=2050-01-01&to=2050-01-31", "GET", http.StatusOK},
+	{"audit log bad date", "/admin/audit-events?from=yesterday", "GET", http.StatusOK},
+	{"audit log fails", "/admin/audit-events?q=fail", "GET", http.StatusInternalServerError},
+	{"audit export", "/admin/audit-events/export?entity=reservations", "GET", http.StatusOK},
 
 	// {"post-search-availability", "/search-availability", "Post", []postData{
 	// 	{key: "start", value: "2020-01-01"},
@@ -103,9 +138,51 @@ func TestHandlers(t *testing.T) {
 	}
 }
 
+// errorPageTests is the data for the error page tests
+var errorPageTests = []struct {
+	name               string
+	url                string
+	method             string
+	expectedStatusCode int
+	expectedInBody     string
+}{
+	{"page not found", "/green/eggs/and/ham", "GET", http.StatusNotFound, "Page not found"},
+	{"wrong method", "/about", "POST", http.StatusMethodNotAllowed, "Error 405"},
+	{"api not found", "/api/v1/nope", "GET", http.StatusNotFound, `"message":"not found"`},
+	{"json not allowed", "/search-availability-json", "GET", http.StatusMethodNotAllowed, `"status":405`},
+}
+
+func TestErrorPages(t *testing.T) {
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	for _, e := range errorPageTests {
+		req, _ := http.NewRequest(e.method, ts.URL+e.url, nil)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("for %s expected %d but got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+		}
+		if !strings.Contains(string(body), e.expectedInBody) {
+			t.Errorf("for %s expected the response to contain %q, got %q", e.name, e.expectedInBody, body)
+		}
+	}
+}
+
 func TestRepository_Reservation(t *testing.T) {
+	layout := "2006-01-02"
+	startDate, _ := time.Parse(layout, "2050-01-01")
+	endDate, _ := time.Parse(layout, "2050-01-03")
+
 	reservation := models.Reservation{
-		RoomId: 1,
+		RoomId:    1,
+		StartDate: startDate,
+		EndDate:   endDate,
 		Room: models.Room{
 			ID:       1,
 			RoomName: "General's Quarters",
@@ -379,6 +456,60 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler failed when trying to insert restriction: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+
+	// test for room having been booked by someone else in the meantime
+	postedData = url.Values{}
+	postedData.Add("start_date", "2070-01-01")
+	postedData.Add("end_date", "2070-01-02")
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.ca")
+	postedData.Add("phone", "1234567890")
+	postedData.Add("room_id", "1")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler failed when room no longer available: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/search-availability" {
+		t.Errorf("PostReservation handler failed when room no longer available: expected location /search-availability, but got %s", actualLoc.String())
+	}
+
+	// test for a stay that cannot be priced (departure is not after arrival)
+	postedData = url.Values{}
+	postedData.Add("start_date", "2050-01-02")
+	postedData.Add("end_date", "2050-01-02")
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.ca")
+	postedData.Add("phone", "1234567890")
+	postedData.Add("room_id", "1")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	actualLoc, _ = rr.Result().Location()
+	if rr.Code != http.StatusSeeOther || actualLoc.String() != "/search-availability" {
+		t.Errorf("PostReservation handler failed when stay cannot be priced: got %d to %s", rr.Code, actualLoc.String())
+	}
 }
 
 func TestNewRepo(t *testing.T) {
@@ -906,13 +1037,14 @@ var testPostAvailabilityData = []struct {
 
 func TestRepository_ReservationSummary(t *testing.T) {
 	/*****************************************
-	// first case -- reservation in session
+	// first case -- booking in session
 	*****************************************/
-	reservation := models.Reservation{
-		RoomId: 1,
-		Room: models.Room{
-			ID:       1,
-			RoomName: "General's Quarters",
+	booking := models.Booking{
+		ID:        1,
+		FirstName: "John",
+		Reservations: []models.Reservation{
+			{ID: 1, RoomId: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+			{ID: 2, RoomId: 2, Room: models.Room{ID: 2, RoomName: "Major's Suite"}},
 		},
 	}
 
@@ -921,7 +1053,7 @@ func TestRepository_ReservationSummary(t *testing.T) {
 	req = req.WithContext(ctx)
 
 	rr := httptest.NewRecorder()
-	session.Put(ctx, "reservation", reservation)
+	session.Put(ctx, "new_booking", booking)
 
 	handler := http.HandlerFunc(Repo.ReservationSummary)
 
@@ -931,6 +1063,11 @@ func TestRepository_ReservationSummary(t *testing.T) {
 		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
 
+	// every room of the booking is on the summary
+	if !strings.Contains(rr.Body.String(), "General&#39;s Quarters") || !strings.Contains(rr.Body.String(), "Major&#39;s Suite") {
+		t.Error("ReservationSummary handler did not show every room of the booking")
+	}
+
 	/*****************************************
 	// second case -- reservation not in session
 	*****************************************/
@@ -1090,6 +1227,45 @@ var loginTests = []struct {
 		`action="/user/login"`,
 		"",
 	},
+	{
+		"front-desk-credentials",
+		"desk@here.ca",
+		http.StatusSeeOther,
+		"",
+		"/",
+	},
+}
+
+// loginRoleTests is the data for the TestLogin_Role tests. The test repo gives me@here.ca access level 3
+// & desk@here.ca access level 1
+var loginRoleTests = []struct {
+	email        string
+	expectedRole string
+}{
+	{"me@here.ca", "owner"},
+	{"desk@here.ca", "front-desk"},
+	{"jack@nimble.com", ""},
+}
+
+func TestLogin_Role(t *testing.T) {
+	for _, e := range loginRoleTests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", "password")
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler.ServeHTTP(rr, req)
+
+		if role := session.GetString(ctx, "role"); role != e.expectedRole {
+			t.Errorf("%s: expected role %q in session but got %q", e.email, e.expectedRole, role)
+		}
+	}
 }
 
 func TestLogin(t *testing.T) {
@@ -1315,84 +1491,158 @@ func TestPostReservationCalendar(t *testing.T) {
 	}
 }
 
-var adminProcessReservationTests = []struct {
-	name                 string
-	queryParams          string
-	expectedResponseCode int
-	expectedLocation     string
+// adminPostReservationStatusTests is the data for the AdminPostReservationStatus tests. The test repo's
+// reservations are confirmed, except 99 which is cancelled, & reservations over 100 don't exist
+var adminPostReservationStatusTests = []struct {
+	name               string
+	id                 string
+	role               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedError      bool
 }{
-	{
-		name:                 "process-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-	{
-		name:                 "process-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
+	{"check in", "1", "front-desk", url.Values{"status": {"checked-in"}}, http.StatusSeeOther, false},
+	{"check in back to cal", "1", "front-desk", url.Values{"status": {"checked-in"}, "year": {"2050"}, "month": {"01"}}, http.StatusSeeOther, false},
+	{"no-show", "1", "front-desk", url.Values{"status": {"no-show"}, "reason": {"Never arrived"}}, http.StatusSeeOther, false},
+	{"no-show without reason", "1", "front-desk", url.Values{"status": {"no-show"}}, http.StatusSeeOther, true},
+	{"cancel", "1", "manager", url.Values{"status": {"cancelled"}, "reason": {"Guest called"}}, http.StatusSeeOther, false},
+	{"cancel without reason", "1", "manager", url.Values{"status": {"cancelled"}}, http.StatusSeeOther, true},
+	{"cancel as front desk", "1", "front-desk", url.Values{"status": {"cancelled"}, "reason": {"Guest called"}}, http.StatusForbidden, false},
+	{"not allowed", "1", "front-desk", url.Values{"status": {"checked-out"}}, http.StatusSeeOther, true},
+	{"already cancelled", "99", "front-desk", url.Values{"status": {"confirmed"}}, http.StatusSeeOther, true},
+	{"unknown status", "1", "front-desk", url.Values{"status": {"lost"}}, http.StatusSeeOther, true},
+	{"db error", "1", "front-desk", url.Values{"status": {"checked-in"}, "reason": {"fail"}}, http.StatusSeeOther, true},
+	{"non-existent", "101", "front-desk", url.Values{"status": {"checked-in"}}, http.StatusNotFound, false},
 }
 
-func TestAdminProcessReservation(t *testing.T) {
-	for _, e := range adminProcessReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+func TestAdminPostReservationStatus(t *testing.T) {
+	for _, e := range adminPostReservationStatusTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/status", strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
+		ctx = addURLParams(ctx, map[string]string{"src": "all", "id": e.id})
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "role", e.role)
 
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminProcessReservation)
+		handler := http.HandlerFunc(Repo.AdminPostReservationStatus)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Code == http.StatusSeeOther {
+			actualLoc, _ := rr.Result().Location()
+			expected := fmt.Sprintf("/admin/reservations/all/%s/show?y=%s&m=%s", e.id, e.postedData.Get("year"), e.postedData.Get("month"))
+			if actualLoc.String() != expected {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, expected, actualLoc.String())
+			}
+		}
+
+		hasError := session.GetString(ctx, "error") != ""
+		if hasError != e.expectedError {
+			t.Errorf("failed %s: expected an error %v, but got %v", e.name, e.expectedError, hasError)
 		}
 	}
 }
 
-var adminDeleteReservationTests = []struct {
-	name                 string
-	queryParams          string
-	expectedResponseCode int
-	expectedLocation     string
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+// adminPostAPIKeyTests is the data for the AdminPostAPIKey tests
+var adminPostAPIKeyTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
 }{
 	{
-		name:                 "delete-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		name: "valid key",
+		postedData: url.Values{
+			"name":   {"Channel manager"},
+			"scopes": {"reservations:read", "reservations:write"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/api-keys",
 	},
 	{
-		name:                 "delete-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		name: "no scopes",
+		postedData: url.Values{
+			"name": {"Channel manager"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Choose at least one scope",
+	},
+	{
+		name: "unknown scope",
+		postedData: url.Values{
+			"name":   {"Channel manager"},
+			"scopes": {"rooms:delete"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Unknown scope rooms:delete",
+	},
+	{
+		name: "no name",
+		postedData: url.Values{
+			"scopes": {"reservations:read"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field cannot be blank",
+	},
+	{
+		name: "insert fails",
+		postedData: url.Values{
+			"name":   {"fail"},
+			"scopes": {"reservations:read"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/api-keys",
 	},
 }
 
-func TestAdminDeleteReservation(t *testing.T) {
-	for _, e := range adminDeleteReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+func TestAdminPostAPIKey(t *testing.T) {
+	for _, e := range adminPostAPIKeyTests {
+		req, _ := http.NewRequest("POST", "/admin/api-keys", strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler := http.HandlerFunc(Repo.AdminPostAPIKey)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
 		}
 	}
 }
 
-func getCtx(req *http.Request) context.Context {
-	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
-	if err != nil {
-		log.Println(err)
+// addURLParams adds chi URL parameters (eg the {id} in /admin/rooms/{id}/calendar) to a context, for tests
+// that call a handler directly instead of going through the router
+func addURLParams(ctx context.Context, params map[string]string) context.Context {
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
 	}
-	return ctx
+	return context.WithValue(ctx, chi.RouteCtxKey, rctx)
 }
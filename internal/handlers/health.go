@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+)
+
+// Healthz tells the load balancer the app is alive. It deliberately checks nothing else: if the database is
+// down, restarting the app won't help, so that is left to Readyz
+func (m *Repository) Healthz(w http.ResponseWriter, r *http.Request) {
+	helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyCheck is the result of one of the checks made by Readyz
+type readyCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Readyz tells the load balancer whether the app can serve requests: the database answers, the page
+// templates are loaded & the mail worker is running. It answers 503 if any of them isn't, so the load
+// balancer stops sending requests here until they are
+func (m *Repository) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]readyCheck{}
+
+	if err := m.DB.Ping(r.Context()); err != nil {
+		checks["database"] = readyCheck{Error: err.Error()}
+	} else {
+		checks["database"] = readyCheck{OK: true}
+	}
+
+	if len(m.App.TemplateCache) == 0 {
+		checks["templates"] = readyCheck{Error: "template cache is empty"}
+	} else {
+		checks["templates"] = readyCheck{OK: true}
+	}
+
+	// the worker checks the outbox every MailPollInterval, but a batch of slow sends can hold it up for as
+	// long as the lease on the batch
+	switch worker := m.App.MailWorker; {
+	case worker == nil || worker.LastChecked().IsZero():
+		checks["mail_worker"] = readyCheck{Error: "mail worker is not running"}
+	case time.Since(worker.LastChecked()) > m.App.MailPollInterval+worker.Lease:
+		checks["mail_worker"] = readyCheck{Error: "mail worker last checked the outbox at " + worker.LastChecked().Format(time.RFC3339)}
+	default:
+		checks["mail_worker"] = readyCheck{OK: true}
+	}
+
+	status := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	helpers.WriteJSON(w, status, checks)
+}
+
+// Metrics shows the app's metrics, in the Prometheus text format
+func (m *Repository) Metrics(w http.ResponseWriter, r *http.Request) {
+	// NOTES: this is the content type of version 0.0.4 of the Prometheus text format, the one Prometheus reads
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.App.Metrics.Write(w, m.DB.Stats()); err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot write metrics", "error", err)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/metrics"
+)
+
+func TestRepository_Readyz(t *testing.T) {
+	defer func() { app.MailWorker = nil }()
+
+	readyz := func() (int, map[string]readyCheck) {
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.Readyz).ServeHTTP(rr, req)
+
+		var resp struct {
+			Data map[string]readyCheck `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return rr.Code, resp.Data
+	}
+
+	// without a mail worker, the app isn't ready
+	app.MailWorker = nil
+	code, checks := readyz()
+	if code != http.StatusServiceUnavailable || checks["mail_worker"].OK || !checks["database"].OK || !checks["templates"].OK {
+		t.Errorf("expected only the mail worker check to fail, got %d %+v", code, checks)
+	}
+
+	// once the worker has checked the outbox, it is
+	app.MailWorker = mail.NewOutboxWorker(Repo.DB, mail.NewMemoryMailer())
+	if _, err := app.MailWorker.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code, checks := readyz(); code != http.StatusOK {
+		t.Errorf("expected the app to be ready, got %d %+v", code, checks)
+	}
+}
+
+func TestRepository_Metrics(t *testing.T) {
+	app.Metrics = metrics.New()
+	defer func() { app.Metrics = nil }()
+
+	// a couple of requests through the middleware, to a route with a URL parameter
+	mux := chi.NewRouter()
+	mux.Use(app.Metrics.Middleware)
+	mux.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) { time.Sleep(time.Millisecond) })
+	mux.Get("/metrics", Repo.Metrics)
+	for _, url := range []string{"/rooms/1", "/rooms/2", "/no-such-page"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	app.Metrics.ReservationCreated()
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`bookings_http_requests_total{method="GET",route="/rooms/{id}",code="200"} 2`,
+		`bookings_http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		`bookings_http_request_duration_seconds_count{method="GET",route="/rooms/{id}"} 2`,
+		`bookings_http_request_duration_seconds_bucket{method="GET",route="/rooms/{id}",le="+Inf"} 2`,
+		"bookings_reservations_created_total 1",
+		"bookings_mail_sent_total 0",
+		"bookings_db_open_connections 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to include %q, got:\n%s", want, body)
+		}
+	}
+}
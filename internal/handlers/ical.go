@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/ical"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// maxICalUpload is the largest .ics file admins can upload
+const maxICalUpload = 5 << 20
+
+// newCalendarToken makes the secret token for a room's iCal export URL
+func newCalendarToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ICalFeed serves a room's reservations & blocks as an iCalendar feed, for OTAs to sync from.
+// The URL holds the room's secret export token, eg /ical/{token}.ics
+func (m *Repository) ICalFeed(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(chi.URLParam(r, "token"), ".ics")
+
+	cal, err := m.DB.GetRoomCalendarByExportToken(r.Context(), token)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// OTAs only care about what is coming up, so we send from a month ago to two years ahead
+	now := time.Now()
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), cal.RoomId, now.AddDate(0, -1, 0), now.AddDate(2, 0, 0))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var events []ical.Event
+	for _, rr := range restrictions {
+		// NOTES: a restriction_id of 1 is a reservation, anything else is an owner block. We never put guest
+		// details in the feed, as anyone with the URL can read it
+		e := ical.Event{
+			UID:     ical.UID(rr.ID),
+			Start:   rr.StartDate,
+			End:     rr.EndDate,
+			Summary: "Blocked",
+		}
+		if rr.RestrictionID == 1 {
+			e.Summary = "Reserved"
+		}
+		// blocks imported from an OTA keep their UID, so that OTA recognises them as its own
+		if rr.ExternalUID != "" {
+			e.UID = rr.ExternalUID
+		}
+		events = append(events, e)
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=room-%d.ics", cal.RoomId))
+	if err := ical.Write(w, cal.Room.RoomName, events); err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot write iCal feed", "room_id", cal.RoomId, "error", err)
+	}
+}
+
+// AdminRoomCalendars lists the rooms, with links to their iCal settings
+func (m *Repository) AdminRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	renderPage(w, r, "admin-room-calendars.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// roomCalendar gets the iCal settings of the room named by the {id} URL parameter, creating them the first
+// time they are asked for
+func (m *Repository) roomCalendar(r *http.Request) (models.RoomCalendar, error) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return models.RoomCalendar{}, err
+	}
+
+	cal, err := m.DB.GetRoomCalendarByRoomId(r.Context(), roomID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return cal, err
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), roomID)
+	if err != nil {
+		return cal, err
+	}
+
+	token, err := newCalendarToken()
+	if err != nil {
+		return cal, err
+	}
+
+	cal = models.RoomCalendar{
+		RoomId:      roomID,
+		ExportToken: token,
+		Room:        room,
+	}
+
+	cal.ID, err = m.DB.InsertRoomCalendar(r.Context(), cal)
+	return cal, err
+}
+
+// AdminRoomCalendar shows a room's iCal export URL & the forms to import external calendars
+func (m *Repository) AdminRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	cal, err := m.roomCalendar(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot get the calendar for that room")
+		http.Redirect(w, r, "/admin/room-calendars", http.StatusSeeOther)
+		return
+	}
+
+	m.renderRoomCalendar(w, r, cal, forms.New(nil))
+}
+
+func (m *Repository) renderRoomCalendar(w http.ResponseWriter, r *http.Request, cal models.RoomCalendar, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["calendar"] = cal
+
+	stringMap := make(map[string]string)
+	stringMap["export_url"] = fmt.Sprintf("%s/ical/%s.ics", m.App.BaseURL, cal.ExportToken)
+
+	renderPage(w, r, "admin-room-calendar.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// AdminPostRoomCalendar saves a room's import URL, & gives its export feed a new URL if asked to
+func (m *Repository) AdminPostRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	cal, err := m.roomCalendar(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot get the calendar for that room")
+		http.Redirect(w, r, "/admin/room-calendars", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.IsURL("import_url")
+	if !form.Valid() {
+		m.renderRoomCalendar(w, r, cal, form)
+		return
+	}
+
+	cal.ImportURL = strings.TrimSpace(form.Get("import_url"))
+
+	// anyone who had the old export URL, eg an OTA we stopped using, loses access to the feed
+	if form.Get("new_token") == "1" {
+		cal.ExportToken, err = newCalendarToken()
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	err = m.DB.UpdateRoomCalendar(r.Context(), cal)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot save calendar settings")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Calendar settings saved")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendar", cal.RoomId), http.StatusSeeOther)
+}
+
+// AdminSyncRoomCalendar imports a room's external calendar straight away, rather than waiting for the
+// next scheduled import
+func (m *Repository) AdminSyncRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	cal, err := m.roomCalendar(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot get the calendar for that room")
+		http.Redirect(w, r, "/admin/room-calendars", http.StatusSeeOther)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/rooms/%d/calendar", cal.RoomId)
+
+	if cal.ImportURL == "" {
+		m.App.Session.Put(r.Context(), "error", "Set an import URL first")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	n, err := ical.NewImporter(m.DB).Sync(r.Context(), cal)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot import calendar", "room_id", cal.RoomId, "error", err)
+		m.App.Session.Put(r.Context(), "error", "cannot import calendar: "+err.Error())
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %d event(s)", n))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminUploadRoomCalendar imports an uploaded .ics file as blocks on a room. Uploads only ever add or move
+// blocks; unlike the import URL, they don't remove blocks whose events are missing from the file
+func (m *Repository) AdminUploadRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	cal, err := m.roomCalendar(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot get the calendar for that room")
+		http.Redirect(w, r, "/admin/room-calendars", http.StatusSeeOther)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/rooms/%d/calendar", cal.RoomId)
+
+	// NOTES: file uploads are sent as multipart forms, which must be parsed with ParseMultipartForm
+	// rather than ParseForm. NoSurf has already parsed the form by now, so how big the whole request can be is
+	// limited by the LimitUploadSize middleware instead, & the file's own size is checked below
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot read upload, is the file too big?")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	file, header, err := r.FormFile("ics")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose an .ics file to upload")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxICalUpload {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Calendar files can't be bigger than %dMB", maxICalUpload>>20))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	events, err := ical.Parse(file)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "That file is not a valid iCal file")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	n, err := ical.NewImporter(m.DB).Import(r.Context(), cal.RoomId, events, false)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot import calendar", "room_id", cal.RoomId, "error", err)
+		m.App.Session.Put(r.Context(), "error", "cannot import calendar")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %d event(s)", n))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
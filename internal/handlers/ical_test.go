@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// icalTests is the data for the iCal feed & room calendar admin tests. In the test repo, room 1 has
+// calendar settings with the export token "room-1-token", room 2 has none yet & room 3 fails
+var icalTests = []struct {
+	name               string
+	method             string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{"feed", "GET", "/ical/room-1-token.ics", nil, http.StatusOK, "", "BEGIN:VCALENDAR"},
+	{"feed bad token", "GET", "/ical/guess.ics", nil, http.StatusNotFound, "", ""},
+	{"list", "GET", "/admin/room-calendars", nil, http.StatusOK, "", ""},
+	{"settings", "GET", "/admin/rooms/1/calendar", nil, http.StatusOK, "", "room-1-token"},
+	{"settings created", "GET", "/admin/rooms/2/calendar", nil, http.StatusOK, "", "/ical/"},
+	{"settings error", "GET", "/admin/rooms/3/calendar", nil, http.StatusSeeOther, "/admin/room-calendars", ""},
+	{"save", "POST", "/admin/rooms/1/calendar",
+		url.Values{"import_url": {"https://www.airbnb.com/calendar/ical/1.ics"}, "new_token": {"1"}},
+		http.StatusSeeOther, "/admin/rooms/1/calendar", ""},
+	{"save bad url", "POST", "/admin/rooms/1/calendar",
+		url.Values{"import_url": {"not a url"}},
+		http.StatusOK, "", "Invalid URL"},
+	{"sync without url", "POST", "/admin/rooms/1/calendar/sync", url.Values{},
+		http.StatusSeeOther, "/admin/rooms/1/calendar", ""},
+}
+
+func TestICal(t *testing.T) {
+	routes := getRoutes()
+
+	for _, e := range icalTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req = httptest.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(e.method, e.url, nil)
+		}
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// uploadTests is the data for the .ics upload tests. The test repo fails to save an event with the UID "fail"
+var uploadTests = []struct {
+	name          string
+	file          string
+	expectedFlash string
+	expectedError string
+}{
+	{"valid", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:ota-1\r\nDTSTART;VALUE=DATE:20500101\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", "Imported 1 event(s)", ""},
+	{"not ical", "<html></html>", "", "That file is not a valid iCal file"},
+	{"db error", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:fail\r\nDTSTART;VALUE=DATE:20500101\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", "", "cannot import calendar"},
+	{"no file", "", "", "Choose an .ics file to upload"},
+	{"too big", strings.Repeat("x", maxICalUpload+1), "", "Calendar files can't be bigger than 5MB"},
+}
+
+func TestRepository_AdminUploadRoomCalendar(t *testing.T) {
+	for _, e := range uploadTests {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		if e.file != "" {
+			fw, _ := mw.CreateFormFile("ics", "room.ics")
+			fw.Write([]byte(e.file))
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/calendar/upload", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		ctx := getCtx(req)
+		req = req.WithContext(addURLParams(ctx, map[string]string{"id": "1"}))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminUploadRoomCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, flash)
+		}
+
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
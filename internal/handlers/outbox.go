@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// AdminEmailOutbox lists the newest emails in the outbox. The 'status' query parameter (eg ?status=failed)
+// shows only the emails with that status
+func (m *Repository) AdminEmailOutbox(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.OutboxPending, models.OutboxSending, models.OutboxSent, models.OutboxFailed:
+	default:
+		status = ""
+	}
+
+	emails, err := m.DB.AllOutboxEmails(r.Context(), status)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["emails"] = emails
+	data["statuses"] = []string{models.OutboxPending, models.OutboxSending, models.OutboxSent, models.OutboxFailed}
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	renderPage(w, r, "admin-email-outbox.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminResendEmail puts a failed email back in the queue, for the mail worker to try again
+func (m *Repository) AdminResendEmail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.ResendOutboxEmail(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "Only failed emails can be resent")
+		http.Redirect(w, r, "/admin/email-outbox?status=failed", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot resend email")
+		http.Redirect(w, r, "/admin/email-outbox?status=failed", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Email queued to be sent again")
+	http.Redirect(w, r, "/admin/email-outbox?status=failed", http.StatusSeeOther)
+}
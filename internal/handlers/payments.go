@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/payments"
+	"github.com/gustavNdamukong/hotel-bookings/internal/render"
+)
+
+// maxWebhookSize is the biggest webhook we read. Payment events are a few hundred bytes
+const maxWebhookSize = 64 << 10
+
+// paymentStatuses are the payment statuses each webhook event moves a payment to
+var paymentStatuses = map[string]string{
+	payments.EventAuthorized: models.PaymentAuthorized,
+	payments.EventSucceeded:  models.PaymentSucceeded,
+	payments.EventFailed:     models.PaymentFailed,
+	payments.EventRefunded:   models.PaymentRefunded,
+}
+
+// startPayment asks the payment provider for the deposit of a booking the guest has just made, & returns
+// where the guest pays it. It returns an empty URL if there is nothing to pay, eg because payments are
+// turned off or none of the rooms asks for a deposit
+func (m *Repository) startPayment(ctx context.Context, booking models.Booking) (string, error) {
+	deposit := booking.Deposit()
+	if m.App.Payments == nil || deposit == 0 {
+		return "", nil
+	}
+
+	intent, err := m.App.Payments.CreateIntent(ctx, payments.IntentRequest{
+		Amount:      deposit,
+		Currency:    m.App.Currency,
+		Description: fmt.Sprintf("Deposit for booking #%d", booking.ID),
+		ReturnURL:   m.App.BaseURL + "/reservation-summary",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// NOTES: the guest pays for every room at once, so each reservation's part of the deposit is a payment of
+	// its own, all of them with the same intent. That way a room can be refunded on its own later
+	var ps []models.Payment
+	for _, res := range booking.Reservations {
+		if res.Quote.Deposit == 0 {
+			continue
+		}
+		ps = append(ps, models.Payment{
+			ReservationID: res.ID,
+			Provider:      m.App.Payments.Name(),
+			IntentID:      intent.ID,
+			Amount:        res.Quote.Deposit,
+		})
+	}
+
+	if err := m.DB.InsertPayments(ctx, ps); err != nil {
+		return "", err
+	}
+
+	return intent.CheckoutURL, nil
+}
+
+// errAmountMismatch is returned for payment events that say the guest paid a different amount to the
+// deposits we asked for
+var errAmountMismatch = errors.New("the amount paid is not the amount of the deposits")
+
+// applyPaymentEvent updates the payments of the event's intent, which confirms their reservations once the
+// guest has paid. Events we don't use are ignored
+func (m *Repository) applyPaymentEvent(ctx context.Context, event payments.Event) error {
+	status, ok := paymentStatuses[event.Type]
+	if !ok {
+		return nil
+	}
+
+	provider := m.App.Payments.Name()
+	ps, err := m.DB.PaymentsByIntentId(ctx, provider, event.IntentID)
+	if err != nil {
+		return err
+	}
+
+	// eg a payment made in another app that uses the same account with the provider. There is nothing we can
+	// do with it, & telling the provider it failed would only make it send it again
+	if len(ps) == 0 {
+		m.App.Logger.WarnContext(ctx, "payment webhook for an unknown intent", "event_id", event.ID,
+			"intent_id", event.IntentID)
+		return nil
+	}
+
+	// NOTES: a reservation is only confirmed once the whole of its deposit has been paid. The amount of a
+	// refund event is what was refunded, so it isn't checked
+	if status == models.PaymentAuthorized || status == models.PaymentSucceeded {
+		total := 0
+		for _, p := range ps {
+			total += p.Amount
+		}
+		if event.Amount != total {
+			return fmt.Errorf("%w: %d was paid for deposits of %d", errAmountMismatch, event.Amount, total)
+		}
+	}
+
+	cancelled, err := m.DB.UpdatePaymentStatus(ctx, provider, event.IntentID, status)
+	if err != nil {
+		return err
+	}
+	m.refundCancelled(ctx, cancelled)
+
+	return nil
+}
+
+// refundCancelled gives back the deposits guests paid for reservations that had been cancelled by the time
+// they paid. Deposits that are only authorized haven't been taken, so they are logged for an admin to release
+// with the provider. Anything that can't be refunded is logged, to be refunded from admin
+func (m *Repository) refundCancelled(ctx context.Context, cancelled []models.Payment) {
+	for _, p := range cancelled {
+		attrs := []any{"payment_id", p.ID, "reservation_id", p.ReservationID, "amount", p.Amount}
+
+		if p.Status != models.PaymentSucceeded {
+			m.App.Logger.WarnContext(ctx, "deposit authorized for a cancelled reservation, release it with the payment provider", attrs...)
+			continue
+		}
+
+		if err := m.App.Payments.Refund(ctx, p.IntentID, p.Amount); err != nil {
+			m.App.Logger.ErrorContext(ctx, "deposit paid for a cancelled reservation, but cannot refund it", append(attrs, "error", err)...)
+			continue
+		}
+
+		if err := m.DB.RefundPayment(ctx, p.ID, p.Amount); err != nil {
+			m.App.Logger.ErrorContext(ctx, "refunded the deposit of a cancelled reservation but cannot record it", append(attrs, "error", err)...)
+			continue
+		}
+
+		m.App.Logger.InfoContext(ctx, "refunded the deposit of a cancelled reservation", attrs...)
+	}
+}
+
+// PaymentWebhook is where the payment provider tells us how payments went. Only webhooks signed with our
+// secret are accepted
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if m.App.Payments == nil {
+		helpers.ErrorJSON(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusRequestEntityTooLarge, "webhook too large", nil)
+		return
+	}
+
+	event, err := m.App.Payments.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		m.App.Logger.WarnContext(r.Context(), "rejected payment webhook", "error", err)
+		helpers.ErrorJSON(w, http.StatusBadRequest, "invalid signature", nil)
+		return
+	}
+
+	// a payment of the wrong amount is flagged to the provider, rather than confirming the reservations
+	err = m.applyPaymentEvent(r.Context(), event)
+	if errors.Is(err, errAmountMismatch) {
+		m.App.Logger.ErrorContext(r.Context(), "rejected payment webhook", "event_id", event.ID,
+			"intent_id", event.IntentID, "error", err)
+		helpers.ErrorJSON(w, http.StatusUnprocessableEntity, "amount does not match", nil)
+		return
+	}
+
+	// NOTES: a 500 makes the provider send the webhook again later, so a payment is never lost to eg the
+	// database being down for a moment
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot apply payment webhook", "event_id", event.ID,
+			"intent_id", event.IntentID, "error", err)
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "cannot apply payment event", nil)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, map[string]string{"received": event.ID})
+}
+
+// fakeIntent looks up the {intent} URL parameter with the fake payment provider, & shows the 404 page if
+// the fake provider isn't the one in use, or it has no such intent
+func (m *Repository) fakeIntent(w http.ResponseWriter, r *http.Request) (*payments.FakeProvider, payments.FakeIntent, bool) {
+	fake, ok := m.App.Payments.(*payments.FakeProvider)
+	if !ok {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return nil, payments.FakeIntent{}, false
+	}
+
+	intent, ok := fake.Intent(chi.URLParam(r, "intent"))
+	if !ok {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return nil, intent, false
+	}
+
+	return fake, intent, true
+}
+
+// FakeCheckout is the fake payment provider's checkout page, where guests "pay" their deposit in development
+func (m *Repository) FakeCheckout(w http.ResponseWriter, r *http.Request) {
+	_, intent, ok := m.fakeIntent(w, r)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["intent"] = intent
+
+	renderPage(w, r, "fake-checkout.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// PostFakeCheckout pays (or declines) a fake payment. The fake provider's webhook is applied straight away,
+// just as if the provider had sent it to PaymentWebhook, & the guest then goes back to their booking
+func (m *Repository) PostFakeCheckout(w http.ResponseWriter, r *http.Request) {
+	fake, intent, ok := m.fakeIntent(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	eventType := payments.EventFailed
+	switch r.Form.Get("outcome") {
+	case "pay":
+		eventType = payments.EventSucceeded
+	case "authorize":
+		eventType = payments.EventAuthorized
+	}
+
+	payload, header, err := fake.Complete(intent.ID, eventType)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Can't pay: %s", err))
+		http.Redirect(w, r, "/payments/fake/"+intent.ID, http.StatusSeeOther)
+		return
+	}
+
+	event, err := fake.VerifyWebhook(payload, header)
+	if err == nil {
+		err = m.applyPaymentEvent(r.Context(), event)
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if eventType == payments.EventFailed {
+		m.App.Session.Put(r.Context(), "error", "Your payment was declined. Your rooms are held, but not confirmed until your deposit is paid")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Thank you, your deposit has been paid & your booking is confirmed")
+	}
+	http.Redirect(w, r, intent.ReturnURL, http.StatusSeeOther)
+}
+
+// adminPayment looks up the {id} URL parameter's payment for the capture & refund actions, & where to send the
+// admin back to afterwards. It shows the 404 page if there is no such payment, or sends the admin back with an
+// error if the payment was taken with a provider that isn't set up
+func (m *Repository) adminPayment(w http.ResponseWriter, r *http.Request) (models.Payment, string, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return models.Payment{}, "", false
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	payment, err := m.DB.GetPaymentById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return payment, "", false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return payment, "", false
+	}
+
+	src := r.Form.Get("src")
+	if src == "" {
+		src = "all"
+	}
+	show := fmt.Sprintf("/admin/reservations/%s/%d/show?y=%s&m=%s", src, payment.ReservationID,
+		r.Form.Get("year"), r.Form.Get("month"))
+
+	if m.App.Payments == nil || m.App.Payments.Name() != payment.Provider {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("This payment was taken with %s, which isn't set up", payment.Provider))
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return payment, "", false
+	}
+
+	return payment, show, true
+}
+
+// AdminCapturePayment takes the money held for an authorized deposit. The provider captures the whole of the
+// intent, so this captures the deposits of every room the guest booked with it
+func (m *Repository) AdminCapturePayment(w http.ResponseWriter, r *http.Request) {
+	payment, show, ok := m.adminPayment(w, r)
+	if !ok {
+		return
+	}
+
+	if payment.Status != models.PaymentAuthorized {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Only authorized payments can be captured, & this one is %s", payment.Status))
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	if err := m.App.Payments.Capture(r.Context(), payment.IntentID); err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot capture payment", "payment_id", payment.ID, "error", err)
+		m.App.Session.Put(r.Context(), "error", "The payment provider could not capture the payment")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	// the provider will send a webhook about it too, which then changes nothing
+	cancelled, err := m.DB.UpdatePaymentStatus(r.Context(), payment.Provider, payment.IntentID, models.PaymentSucceeded)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.refundCancelled(r.Context(), cancelled)
+
+	m.App.Session.Put(r.Context(), "flash", "Payment captured")
+	http.Redirect(w, r, show, http.StatusSeeOther)
+}
+
+// AdminRefundPayment gives the guest back what is left of a reservation's deposit
+func (m *Repository) AdminRefundPayment(w http.ResponseWriter, r *http.Request) {
+	payment, show, ok := m.adminPayment(w, r)
+	if !ok {
+		return
+	}
+
+	amount := payment.Refundable()
+	if amount == 0 {
+		m.App.Session.Put(r.Context(), "error", "There is nothing left of this payment to refund")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	if err := m.App.Payments.Refund(r.Context(), payment.IntentID, amount); err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot refund payment", "payment_id", payment.ID, "error", err)
+		m.App.Session.Put(r.Context(), "error", "The payment provider could not refund the payment")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	// NOTES: the money has gone back to the guest by now, so if we can't record it, the admin needs to know
+	// rather than try again & refund the guest twice
+	if err := m.DB.RefundPayment(r.Context(), payment.ID, amount); err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "refunded payment but cannot record it", "payment_id", payment.ID,
+			"amount", amount, "error", err)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s was refunded, but it could not be recorded. Please don't refund it again", render.FormatMoney(amount)))
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s refunded", render.FormatMoney(amount)))
+	http.Redirect(w, r, show, http.StatusSeeOther)
+}
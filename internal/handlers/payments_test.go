@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/payments"
+)
+
+// testProvider is a payment provider whose capture & refund succeed, or fail with err. The test repo's
+// payments are "fake" ones, so it pretends to be the fake provider. Refunds are added to refunds, if set
+type testProvider struct {
+	payments.Provider
+	err     error
+	refunds *[]int
+}
+
+func (p testProvider) Name() string {
+	return "fake"
+}
+
+func (p testProvider) Capture(ctx context.Context, intentID string) error {
+	return p.err
+}
+
+func (p testProvider) Refund(ctx context.Context, intentID string, amount int) error {
+	if p.err == nil && p.refunds != nil {
+		*p.refunds = append(*p.refunds, amount)
+	}
+	return p.err
+}
+
+// signedWebhook is a webhook request for event, signed with secret
+func signedWebhook(secret []byte, event string) *http.Request {
+	req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(event))
+	req.Header.Set(payments.SignatureHeader, payments.Sign(secret, []byte(event), time.Now()))
+	return req
+}
+
+var paymentWebhookTests = []struct {
+	name               string
+	secret             string
+	event              string
+	expectedStatusCode int
+}{
+	{"paid", "test-webhook-secret", `{"id":"evt_1","type":"payment.succeeded","intent_id":"fake_pi_test","amount":5000}`, http.StatusOK},
+	{"ignored event", "test-webhook-secret", `{"id":"evt_2","type":"payment.disputed","intent_id":"fake_pi_test"}`, http.StatusOK},
+	{"unknown intent", "test-webhook-secret", `{"id":"evt_3","type":"payment.succeeded","intent_id":"fake_pi_unknown"}`, http.StatusOK},
+	{"database error", "test-webhook-secret", `{"id":"evt_4","type":"payment.succeeded","intent_id":"fake_pi_fail","amount":5000}`, http.StatusInternalServerError},
+	{"wrong amount", "test-webhook-secret", `{"id":"evt_6","type":"payment.succeeded","intent_id":"fake_pi_test","amount":4000}`, http.StatusUnprocessableEntity},
+	{"authorized wrong amount", "test-webhook-secret", `{"id":"evt_7","type":"payment.authorized","intent_id":"fake_pi_test","amount":1}`, http.StatusUnprocessableEntity},
+	{"partly refunded", "test-webhook-secret", `{"id":"evt_8","type":"payment.refunded","intent_id":"fake_pi_test","amount":1000}`, http.StatusOK},
+	{"cancelled reservation", "test-webhook-secret", `{"id":"evt_9","type":"payment.succeeded","intent_id":"fake_pi_cancelled","amount":5000}`, http.StatusOK},
+	{"other secret", "another-secret", `{"id":"evt_5","type":"payment.succeeded","intent_id":"fake_pi_test"}`, http.StatusBadRequest},
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	for _, e := range paymentWebhookTests {
+		req := signedWebhook([]byte(e.secret), e.event)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PaymentWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+
+	// a webhook that isn't signed at all
+	req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(paymentWebhookTests[0].event))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PaymentWebhook).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unsigned webhook: returned wrong response code: got %d, wanted %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRepository_FakeCheckout(t *testing.T) {
+	fake := app.Payments.(*payments.FakeProvider)
+
+	for _, e := range []struct {
+		name               string
+		outcome            string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedStatus     string
+	}{
+		{"paid", "pay", http.StatusSeeOther, "/reservation-summary", payments.FakeSucceeded},
+		{"authorized", "authorize", http.StatusSeeOther, "/reservation-summary", payments.FakeAuthorized},
+		{"declined", "decline", http.StatusSeeOther, "/reservation-summary", payments.FakeFailed},
+	} {
+		intent, err := fake.CreateIntent(context.Background(), payments.IntentRequest{
+			Amount:    5000,
+			Currency:  "usd",
+			ReturnURL: "/reservation-summary",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the checkout page
+		req, _ := http.NewRequest("GET", "/payments/fake/"+intent.ID, nil)
+		ctx := addURLParams(getCtx(req), map[string]string{"intent": intent.ID})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.FakeCheckout)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: checkout page returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusOK)
+		}
+		if !strings.Contains(rr.Body.String(), "$50.00") {
+			t.Errorf("%s: expected the checkout page to show the amount", e.name)
+		}
+
+		// paying it
+		req, _ = http.NewRequest("POST", "/payments/fake/"+intent.ID, strings.NewReader(url.Values{"outcome": {e.outcome}}.Encode()))
+		ctx = addURLParams(getCtx(req), map[string]string{"intent": intent.ID})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+
+		handler = http.HandlerFunc(Repo.PostFakeCheckout)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("%s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		if i, _ := fake.Intent(intent.ID); i.Status != e.expectedStatus {
+			t.Errorf("%s: expected the intent to be %s, but it is %s", e.name, e.expectedStatus, i.Status)
+		}
+	}
+
+	// an intent the fake provider doesn't have
+	req, _ := http.NewRequest("GET", "/payments/fake/fake_pi_999", nil)
+	ctx := addURLParams(getCtx(req), map[string]string{"intent": "fake_pi_999"})
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.FakeCheckout).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown intent: returned wrong response code: got %d, wanted %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+var adminPaymentTests = []struct {
+	name               string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	id                 string
+	providerErr        error
+	expectedStatusCode int
+	expectedLocation   string
+	expectedSession    string
+}{
+	{"capture", (*Repository).AdminCapturePayment, "2", nil, http.StatusSeeOther, "/admin/reservations/new/1/show?y=2050&m=01", "flash"},
+	{"capture declined", (*Repository).AdminCapturePayment, "2", errors.New("declined"), http.StatusSeeOther, "/admin/reservations/new/1/show?y=2050&m=01", "error"},
+	{"capture paid", (*Repository).AdminCapturePayment, "1", nil, http.StatusSeeOther, "/admin/reservations/new/1/show?y=2050&m=01", "error"},
+	{"refund", (*Repository).AdminRefundPayment, "1", nil, http.StatusSeeOther, "/admin/reservations/new/1/show?y=2050&m=01", "flash"},
+	{"refund declined", (*Repository).AdminRefundPayment, "1", errors.New("declined"), http.StatusSeeOther, "/admin/reservations/new/1/show?y=2050&m=01", "error"},
+	{"refunded already", (*Repository).AdminRefundPayment, "3", nil, http.StatusSeeOther, "/admin/reservations/new/1/show?y=2050&m=01", "error"},
+	{"other provider", (*Repository).AdminRefundPayment, "4", nil, http.StatusSeeOther, "/admin/reservations/new/1/show?y=2050&m=01", "error"},
+	{"no such payment", (*Repository).AdminRefundPayment, "101", nil, http.StatusNotFound, "", ""},
+}
+
+func TestRepository_AdminPayments(t *testing.T) {
+	fake := app.Payments
+	defer func() { app.Payments = fake }()
+
+	for _, e := range adminPaymentTests {
+		app.Payments = testProvider{err: e.providerErr}
+
+		postedData := url.Values{
+			"src":   {"new"},
+			"year":  {"2050"},
+			"month": {"01"},
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/payments/"+e.id, strings.NewReader(postedData.Encode()))
+		ctx := addURLParams(getCtx(req), map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedSession != "" && !session.Exists(ctx, e.expectedSession) {
+			t.Errorf("%s: expected the admin to be shown a %s message", e.name, e.expectedSession)
+		}
+	}
+}
+
+func TestRepository_startPayment(t *testing.T) {
+	fake := app.Payments.(*payments.FakeProvider)
+
+	booking := models.Booking{
+		ID: 1,
+		Reservations: []models.Reservation{
+			{ID: 1, Quote: models.Quote{Deposit: 3000}},
+			{ID: 2, Quote: models.Quote{Deposit: 2000}},
+		},
+	}
+
+	checkoutURL, err := Repo.startPayment(context.Background(), booking)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intentID := checkoutURL[strings.LastIndex(checkoutURL, "/")+1:]
+	if !strings.HasPrefix(checkoutURL, "http://localhost:8080/payments/fake/") {
+		t.Errorf("unexpected checkout URL %s", checkoutURL)
+	}
+
+	// one payment for the deposit of every room
+	intent, ok := fake.Intent(intentID)
+	if !ok || intent.Amount != 5000 {
+		t.Errorf("expected an intent for the whole deposit, but got %+v", intent)
+	}
+
+	// nothing to pay
+	booking.Reservations = []models.Reservation{{ID: 1}}
+	checkoutURL, err = Repo.startPayment(context.Background(), booking)
+	if err != nil || checkoutURL != "" {
+		t.Errorf("expected no payment for a booking without a deposit, but got %q, %v", checkoutURL, err)
+	}
+}
+
+func TestRepository_applyPaymentEvent_Cancelled(t *testing.T) {
+	fake := app.Payments
+	defer func() { app.Payments = fake }()
+
+	// a deposit paid for a cancelled reservation is given back
+	var refunds []int
+	app.Payments = testProvider{refunds: &refunds}
+
+	event := payments.Event{ID: "evt_1", Type: payments.EventSucceeded, IntentID: "fake_pi_cancelled", Amount: 5000}
+	if err := Repo.applyPaymentEvent(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 1 || refunds[0] != 5000 {
+		t.Errorf("expected the deposit of 5000 to be refunded, but got %v", refunds)
+	}
+
+	// one that is only authorized hasn't been taken, so there is nothing to refund
+	refunds = nil
+	event.Type = payments.EventAuthorized
+	if err := Repo.applyPaymentEvent(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 0 {
+		t.Errorf("expected no refund of an authorized deposit, but got %v", refunds)
+	}
+
+	// nor is a deposit for a reservation that can be confirmed
+	event = payments.Event{ID: "evt_2", Type: payments.EventSucceeded, IntentID: "fake_pi_test", Amount: 5000}
+	if err := Repo.applyPaymentEvent(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 0 {
+		t.Errorf("expected no refund, but got %v", refunds)
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// AdminProperty shows the property's settings, & who gets the notifications for each room
+func (m *Repository) AdminProperty(w http.ResponseWriter, r *http.Request) {
+	property, err := m.DB.GetProperty(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.renderProperty(w, r, property, forms.New(nil))
+}
+
+func (m *Repository) renderProperty(w http.ResponseWriter, r *http.Request, property models.Property, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["property"] = property
+	data["rooms"] = rooms
+
+	renderPage(w, r, "admin-property.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostProperty saves the property's settings
+func (m *Repository) AdminPostProperty(w http.ResponseWriter, r *http.Request) {
+	property, err := m.DB.GetProperty(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "sender_email")
+	form.IsEmail("sender_email")
+	if strings.TrimSpace(form.Get("sender_email")) == models.PlaceholderSender {
+		form.Errors.Add("sender_email", "Emails can't be sent from the example address. Use one of your own")
+	}
+	// the owner's email is optional; without it, only rooms with their own owner send notifications
+	if form.Get("owner_email") != "" {
+		form.IsEmail("owner_email")
+	}
+
+	property.Name = strings.TrimSpace(form.Get("name"))
+	property.OwnerName = strings.TrimSpace(form.Get("owner_name"))
+	property.OwnerEmail = strings.TrimSpace(form.Get("owner_email"))
+	property.SenderEmail = strings.TrimSpace(form.Get("sender_email"))
+
+	if !form.Valid() {
+		m.renderProperty(w, r, property, form)
+		return
+	}
+
+	err = m.DB.UpdateProperty(r.Context(), property)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot save property settings")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Property settings saved")
+	}
+	http.Redirect(w, r, "/admin/property", http.StatusSeeOther)
+}
+
+// AdminPostRoomOwner sets who gets the notifications for one room. Leaving the email empty sends them to the
+// property's owner again
+func (m *Repository) AdminPostRoomOwner(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid room")
+		http.Redirect(w, r, "/admin/property", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	room := models.Room{
+		ID:         id,
+		OwnerName:  strings.TrimSpace(r.Form.Get("owner_name")),
+		OwnerEmail: strings.TrimSpace(r.Form.Get("owner_email")),
+	}
+
+	form := forms.New(r.PostForm)
+	if room.OwnerEmail != "" && !form.IsEmail("owner_email") {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is not a valid email address", room.OwnerEmail))
+		http.Redirect(w, r, "/admin/property", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateRoomOwner(r.Context(), room)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot save room owner")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Room owner saved")
+	}
+	http.Redirect(w, r, "/admin/property", http.StatusSeeOther)
+}
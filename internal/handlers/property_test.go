@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+var adminPostPropertyTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{
+		name: "valid",
+		postedData: url.Values{
+			"name":         {"Hotel Bookings"},
+			"owner_name":   {"Jane"},
+			"owner_email":  {"jane@here.ca"},
+			"sender_email": {"bookings@here.ca"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name: "no owner email",
+		postedData: url.Values{
+			"name":         {"Hotel Bookings"},
+			"sender_email": {"bookings@here.ca"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name: "invalid sender email",
+		postedData: url.Values{
+			"name":         {"Hotel Bookings"},
+			"sender_email": {"bookings"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Invalid email address",
+	},
+	{
+		name: "example sender email",
+		postedData: url.Values{
+			"name":         {"Hotel Bookings"},
+			"sender_email": {"noreply@example.com"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "the example address",
+	},
+	{
+		name: "invalid owner email",
+		postedData: url.Values{
+			"name":         {"Hotel Bookings"},
+			"owner_email":  {"jane"},
+			"sender_email": {"bookings@here.ca"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Invalid email address",
+	},
+	{
+		name: "missing name",
+		postedData: url.Values{
+			"sender_email": {"bookings@here.ca"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field cannot be blank",
+	},
+	{
+		name: "update fails",
+		postedData: url.Values{
+			"name":         {"fail"},
+			"sender_email": {"bookings@here.ca"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+}
+
+func TestRepository_AdminPostProperty(t *testing.T) {
+	for _, e := range adminPostPropertyTests {
+		req, _ := http.NewRequest("POST", "/admin/property", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostProperty)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomOwner(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		ownerEmail    string
+		expectedError bool
+	}{
+		{"owner", "1", "jane@here.ca", false},
+		{"back to property owner", "1", "", false},
+		{"invalid email", "1", "jane", true},
+		{"invalid room", "x", "jane@here.ca", true},
+		{"update fails", "3", "jane@here.ca", true},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"owner_name": {"Jane"}, "owner_email": {e.ownerEmail}}
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id+"/owner", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParams(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomOwner)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		hasError := app.Session.GetString(ctx, "error") != ""
+		if hasError != e.expectedError {
+			t.Errorf("failed %s: expected an error %v, but got %v", e.name, e.expectedError, hasError)
+		}
+	}
+}
+
+func TestRepository_reservationEmails(t *testing.T) {
+	property := models.Property{OwnerName: "Owner", OwnerEmail: "owner@here.ca", SenderEmail: "bookings@here.ca"}
+	res := models.Reservation{ID: 1, FirstName: "John", Email: "john@smith.com", Room: models.Room{ID: 1}}
+
+	msgs, err := Repo.reservationEmails(property)(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].To != "john@smith.com" || msgs[1].To != "owner@here.ca" || msgs[1].From != "bookings@here.ca" {
+		t.Errorf("expected a guest & an owner email from the sender address, got %+v", msgs)
+	}
+
+	// a room with its own owner notifies them instead
+	res.Room.OwnerEmail = "room-owner@here.ca"
+	msgs, _ = Repo.reservationEmails(property)(res)
+	if len(msgs) != 2 || msgs[1].To != "room-owner@here.ca" {
+		t.Errorf("expected the room's owner to be notified, got %+v", msgs)
+	}
+
+	// nobody to notify
+	res.Room.OwnerEmail = ""
+	property.OwnerEmail = ""
+	msgs, _ = Repo.reservationEmails(property)(res)
+	if len(msgs) != 1 {
+		t.Errorf("expected only the guest's email, got %+v", msgs)
+	}
+}
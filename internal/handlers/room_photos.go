@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/photos"
+)
+
+// maxCaptionLength is the longest a photo's caption can be
+const maxCaptionLength = 200
+
+// AdminRoomPhotos shows a room's photos, with the forms to upload, caption, reorder & delete them
+func (m *Repository) AdminRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderRoomPhotos(w, r, room, forms.New(nil))
+}
+
+// roomFromURL gets the room with the id in the URL, or sends the error page & returns false
+func (m *Repository) roomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return room, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return room, false
+	}
+
+	return room, true
+}
+
+func (m *Repository) renderRoomPhotos(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	intMap := make(map[string]int)
+	intMap["max_upload_mb"] = int(m.App.MaxUploadSize >> 20)
+
+	renderPage(w, r, "admin-room-photos.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+		Form:   form,
+	})
+}
+
+// AdminPostRoomPhoto uploads a photo of a room. The photo is checked, made into its display & thumbnail
+// sizes & saved in the photo storage, then added after the room's other photos
+func (m *Repository) AdminPostRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	// NOTES: files in a multipart form over 1MB are kept in temporary files instead of memory. How big the
+	// whole request can be is limited by the LimitUploadSize middleware, before the CSRF check reads it
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.MaxLength("caption", maxCaptionLength)
+
+	file, header, err := r.FormFile("photo")
+	if errors.Is(err, http.ErrMissingFile) {
+		form.Errors.Add("photo", "Choose a photo to upload")
+	} else if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	} else {
+		defer file.Close()
+		if header.Size > m.App.MaxUploadSize {
+			form.Errors.Add("photo", fmt.Sprintf("Photos can't be bigger than %dMB", m.App.MaxUploadSize>>20))
+		}
+	}
+
+	if !form.Valid() {
+		m.renderRoomPhotos(w, r, room, form)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	variants, err := photos.Process(data)
+	if errors.Is(err, photos.ErrUnsupportedType) || errors.Is(err, photos.ErrTooManyPixels) {
+		form.Errors.Add("photo", err.Error())
+		m.renderRoomPhotos(w, r, room, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	store := m.App.PhotoStorage
+	key, err := photos.Save(r.Context(), store, fmt.Sprintf("rooms/%d", room.ID), variants)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	_, err = m.DB.InsertRoomPhoto(r.Context(), models.RoomPhoto{
+		RoomID:        room.ID,
+		Path:          store.URL(photos.DisplayKey(key)),
+		ThumbnailPath: store.URL(photos.ThumbnailKey(key)),
+		Caption:       strings.TrimSpace(form.Get("caption")),
+		StorageKey:    key,
+	})
+	if err != nil {
+		// don't leave the files behind with nothing pointing at them
+		if err := photos.Remove(r.Context(), store, key); err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "cannot remove photo", "key", key, "error", err)
+		}
+		m.App.Session.Put(r.Context(), "error", "cannot save photo")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", room.ID), http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo uploaded")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", room.ID), http.StatusSeeOther)
+}
+
+// photoFromURL gets the photo with the id in the URL, or sends the error page & returns false
+func (m *Repository) photoFromURL(w http.ResponseWriter, r *http.Request) (models.RoomPhoto, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	photo, err := m.DB.GetRoomPhoto(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return photo, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return photo, false
+	}
+
+	return photo, true
+}
+
+// AdminPostRoomPhotoCaption changes the caption of a photo
+func (m *Repository) AdminPostRoomPhotoCaption(w http.ResponseWriter, r *http.Request) {
+	photo, ok := m.photoFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	if !form.MaxLength("caption", maxCaptionLength) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Captions can't be longer than %d characters", maxCaptionLength))
+	} else if err := m.DB.UpdateRoomPhotoCaption(r.Context(), photo.ID, strings.TrimSpace(form.Get("caption"))); err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot change caption")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Caption saved")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", photo.RoomID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto removes a photo from its room, & deletes its files if it was uploaded
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	photo, ok := m.photoFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteRoomPhoto(r.Context(), photo.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot delete photo")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", photo.RoomID), http.StatusSeeOther)
+		return
+	}
+
+	// photos that are part of the site, eg under /static/images, have no files in the storage
+	if photo.StorageKey != "" {
+		// the photo is already off the site, so files that can't be deleted are only logged
+		if err := photos.Remove(r.Context(), m.App.PhotoStorage, photo.StorageKey); err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "cannot remove photo", "key", photo.StorageKey, "error", err)
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", photo.RoomID), http.StatusSeeOther)
+}
+
+// AdminMoveRoomPhoto moves a photo one place up or down its room's photos
+func (m *Repository) AdminMoveRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	photo, ok := m.photoFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), photo.RoomID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	ids := make([]int, len(room.Photos))
+	for i, p := range room.Photos {
+		ids[i] = p.ID
+	}
+
+	err = m.DB.ReorderRoomPhotos(r.Context(), room.ID, move(ids, photo.ID, chi.URLParam(r, "dir")))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot reorder photos")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", room.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/photos"
+)
+
+// uploadRequest makes a multipart form request uploading file (if it isn't nil) as the photo
+func uploadRequest(t *testing.T, roomID string, file []byte, caption string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("caption", caption)
+	if file != nil {
+		fw, err := mw.CreateFormFile("photo", "room.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(file)
+	}
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/admin/rooms/"+roomID+"/photos", &body)
+	ctx := getCtx(req)
+	ctx = addURLParams(ctx, map[string]string{"id": roomID})
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func testPhoto(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 800, 600))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRepository_AdminPostRoomPhoto(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		file               []byte
+		caption            string
+		expectedStatusCode int
+		expectedHTML       string
+		expectedFiles      int
+	}{
+		{"valid", "1", testPhoto(t), "The view", http.StatusSeeOther, "", 2},
+		{"no photo", "1", nil, "", http.StatusOK, "Choose a photo to upload", 0},
+		{"not a photo", "1", []byte("just some text"), "", http.StatusOK, "must be JPEG, PNG or GIF", 0},
+		{"too big", "1", bytes.Repeat([]byte("x"), 2<<20), "", http.StatusOK, "can&#39;t be bigger than 1MB", 0},
+		{"long caption", "1", testPhoto(t), strings.Repeat("x", 201), http.StatusOK, "at most 200 characters", 0},
+		{"insert fails", "1", testPhoto(t), "fail", http.StatusSeeOther, "", 0},
+		{"invalid room", "x", testPhoto(t), "", http.StatusNotFound, "", 0},
+	}
+
+	for _, e := range tests {
+		store := photos.NewMemoryStorage()
+		app.PhotoStorage = store
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoomPhoto)
+		handler.ServeHTTP(rr, uploadRequest(t, e.roomID, e.file, e.caption))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+
+		// a photo that is saved has its display & thumbnail files, & a failed one leaves nothing behind
+		if keys := store.Keys(); len(keys) != e.expectedFiles {
+			t.Errorf("failed %s: expected %d files in the storage, but got %v", e.name, e.expectedFiles, keys)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomPhotoCaption(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		caption            string
+		expectedStatusCode int
+		expectedError      bool
+	}{
+		{"caption", "1", "The view", http.StatusSeeOther, false},
+		{"too long", "1", strings.Repeat("x", 201), http.StatusSeeOther, true},
+		{"non-existent photo", "9", "The view", http.StatusNotFound, false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"caption": {e.caption}}
+		req, _ := http.NewRequest("POST", "/admin/room-photos/"+e.id+"/caption", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addURLParams(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomPhotoCaption)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		hasError := app.Session.GetString(ctx, "error") != ""
+		if hasError != e.expectedError {
+			t.Errorf("failed %s: expected an error %v, but got %v", e.name, e.expectedError, hasError)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomPhoto(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedFiles      int
+	}{
+		// photo 1 is part of the site, so there are no files to delete
+		{"static photo", "1", http.StatusSeeOther, 2},
+		{"uploaded photo", "2", http.StatusSeeOther, 0},
+		{"non-existent photo", "9", http.StatusNotFound, 2},
+	}
+
+	for _, e := range tests {
+		// the files of uploaded photo 2
+		store := photos.NewMemoryStorage()
+		store.Put(context.Background(), photos.DisplayKey("rooms/1/ab12"), strings.NewReader("display"))
+		store.Put(context.Background(), photos.ThumbnailKey("rooms/1/ab12"), strings.NewReader("thumb"))
+		app.PhotoStorage = store
+
+		req, _ := http.NewRequest("GET", "/admin/delete-room-photo/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		ctx = addURLParams(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoomPhoto)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if keys := store.Keys(); len(keys) != e.expectedFiles {
+			t.Errorf("failed %s: expected %d files left, but got %v", e.name, e.expectedFiles, keys)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// maxRoomCapacity is the most guests a room can be set to sleep
+const maxRoomCapacity = 20
+
+// Rooms lists the rooms on the site
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.ActiveRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	renderPage(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room is the page of one room, found by the slug in its URL. Archived rooms are not found
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && room.Archived()) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	renderPage(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// OldRoomPage sends the rooms' old pages, eg /generals-quarters, to their page under /rooms. The old paths are
+// the rooms' slugs
+func (m *Repository) OldRoomPage(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/rooms"+r.URL.Path, http.StatusMovedPermanently)
+}
+
+// AdminRooms lists all rooms, archived ones too, in the order they are shown on the site
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	renderPage(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRoom shows the form to add a room (at /admin/rooms/new) or edit one
+func (m *Repository) AdminRoom(w http.ResponseWriter, r *http.Request) {
+	var room models.Room
+
+	if idParam := chi.URLParam(r, "id"); idParam != "" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			helpers.ClientError(w, r, http.StatusNotFound)
+			return
+		}
+
+		room, err = m.DB.GetRoomById(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, r, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	} else {
+		room.Capacity = 2
+	}
+
+	m.renderRoom(w, r, room, roomFormValues(room), forms.New(nil))
+}
+
+// roomFormValues are the room form's fields for a room, so the same form can show a saved room or one with errors
+func roomFormValues(room models.Room) map[string]string {
+	return map[string]string{
+		"room_name":   room.RoomName,
+		"slug":        room.Slug,
+		"description": room.Description,
+		"capacity":    strconv.Itoa(room.Capacity),
+		"amenities":   strings.Join(room.Amenities, "\n"),
+	}
+}
+
+func (m *Repository) renderRoom(w http.ResponseWriter, r *http.Request, room models.Room, values map[string]string, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	renderPage(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		StringMap: values,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostRoom saves a new room (from /admin/rooms/new) or changes to one
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	var room models.Room
+
+	if idParam := chi.URLParam(r, "id"); idParam != "" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			helpers.ClientError(w, r, http.StatusNotFound)
+			return
+		}
+		room.ID = id
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	room.RoomName = strings.TrimSpace(form.Get("room_name"))
+	room.Description = strings.TrimSpace(form.Get("description"))
+	room.Amenities = lines(form.Get("amenities"))
+
+	// the slug can be left empty for a new room, & is then made from its name
+	room.Slug = strings.TrimSpace(form.Get("slug"))
+	if room.Slug == "" && room.ID == 0 {
+		room.Slug = slugify(room.RoomName)
+		form.Set("slug", room.Slug)
+	}
+
+	form.Required("room_name", "slug")
+	if form.Get("slug") != "" {
+		form.IsSlug("slug")
+	}
+	if form.IntBetween("capacity", 1, maxRoomCapacity) {
+		room.Capacity, _ = strconv.Atoi(strings.TrimSpace(form.Get("capacity")))
+	}
+
+	// a new room needs a price, or it can't be booked. Its rates are changed elsewhere after that
+	var rate models.RoomRate
+	if room.ID == 0 && form.IntBetween("base_rate", 1, 100000) {
+		dollars, _ := strconv.Atoi(strings.TrimSpace(form.Get("base_rate")))
+		rate = models.RoomRate{BaseRate: dollars * 100, MinStay: 1}
+	}
+	// the deposit guests pay when they book can be left empty, for none
+	if room.ID == 0 && form.Get("deposit_percent") != "" && form.IntBetween("deposit_percent", 0, 100) {
+		rate.DepositPercent, _ = strconv.Atoi(strings.TrimSpace(form.Get("deposit_percent")))
+	}
+
+	values := roomFormValues(room)
+	values["slug"] = form.Get("slug")
+	values["capacity"] = form.Get("capacity")
+	values["base_rate"] = form.Get("base_rate")
+	values["deposit_percent"] = form.Get("deposit_percent")
+
+	if !form.Valid() {
+		m.renderRoom(w, r, room, values, form)
+		return
+	}
+
+	if room.ID == 0 {
+		room.ID, err = m.DB.InsertRoom(r.Context(), room, rate)
+	} else {
+		err = m.DB.UpdateRoom(r.Context(), room)
+	}
+
+	if errors.Is(err, repository.ErrSlugTaken) {
+		form.Errors.Add("slug", "Another room already has this slug")
+		m.renderRoom(w, r, room, values, form)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot save room")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s saved", room.RoomName))
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminArchiveRoom takes a room off the site. Its reservations are kept, but it can't be booked any more
+func (m *Repository) AdminArchiveRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomArchived(w, r, true, "Room archived")
+}
+
+// AdminRestoreRoom puts an archived room back on the site
+func (m *Repository) AdminRestoreRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomArchived(w, r, false, "Room restored")
+}
+
+func (m *Repository) setRoomArchived(w http.ResponseWriter, r *http.Request, archived bool, done string) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.SetRoomArchived(r.Context(), id, archived)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot change room")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", done)
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminMoveRoom moves a room one place up or down the list of rooms on the site
+func (m *Repository) AdminMoveRoom(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	dir := chi.URLParam(r, "dir")
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	ids := make([]int, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID
+	}
+
+	err = m.DB.ReorderRooms(r.Context(), move(ids, id, dir))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot reorder rooms")
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// move swaps id with the id before it in ids (dir "up") or after it (dir "down"), eg to move a room up the list
+func move(ids []int, id int, dir string) []int {
+	for i := range ids {
+		if ids[i] != id {
+			continue
+		}
+		if dir == "up" && i > 0 {
+			ids[i-1], ids[i] = ids[i], ids[i-1]
+		} else if dir == "down" && i < len(ids)-1 {
+			ids[i+1], ids[i] = ids[i], ids[i+1]
+		}
+		break
+	}
+	return ids
+}
+
+// lines splits a textarea into its non-empty lines, eg a room's amenities
+func lines(s string) []string {
+	var items []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			items = append(items, line)
+		}
+	}
+	return items
+}
+
+// slugify makes a slug from a room's name, eg "General's Quarters" becomes "generals-quarters"
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			hyphen = false
+		case c == '\'':
+			// "General's" becomes "generals", not "general-s"
+		default:
+			hyphen = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var adminPostRoomTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{
+		name: "new room",
+		postedData: url.Values{
+			"room_name":       {"Colonel's Cabin"},
+			"capacity":        {"4"},
+			"base_rate":       {"120"},
+			"deposit_percent": {"20"},
+			"amenities":       {"Sea view\nFireplace"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name: "edit room",
+		id:   "1",
+		postedData: url.Values{
+			"room_name": {"General's Quarters"},
+			"slug":      {"generals-quarters"},
+			"capacity":  {"2"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name: "new room without a rate",
+		postedData: url.Values{
+			"room_name": {"Colonel's Cabin"},
+			"capacity":  {"4"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "from 1 to 100000",
+	},
+	{
+		name: "new room with too big a deposit",
+		postedData: url.Values{
+			"room_name":       {"Colonel's Cabin"},
+			"capacity":        {"4"},
+			"base_rate":       {"120"},
+			"deposit_percent": {"150"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "from 0 to 100",
+	},
+	{
+		name: "invalid slug",
+		id:   "1",
+		postedData: url.Values{
+			"room_name": {"General's Quarters"},
+			"slug":      {"General's Quarters"},
+			"capacity":  {"2"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Use only lower case letters",
+	},
+	{
+		name: "too many guests",
+		id:   "1",
+		postedData: url.Values{
+			"room_name": {"General's Quarters"},
+			"slug":      {"generals-quarters"},
+			"capacity":  {"50"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "from 1 to 20",
+	},
+	{
+		name: "slug taken",
+		id:   "1",
+		postedData: url.Values{
+			"room_name": {"General's Quarters"},
+			"slug":      {"taken"},
+			"capacity":  {"2"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Another room already has this slug",
+	},
+	{
+		name: "non-existent room",
+		id:   "3",
+		postedData: url.Values{
+			"room_name": {"General's Quarters"},
+			"slug":      {"generals-quarters"},
+			"capacity":  {"2"},
+		},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name: "insert fails",
+		postedData: url.Values{
+			"room_name": {"fail"},
+			"capacity":  {"2"},
+			"base_rate": {"120"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+}
+
+func TestRepository_AdminPostRoom(t *testing.T) {
+	for _, e := range adminPostRoomTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/new", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		if e.id != "" {
+			ctx = addURLParams(ctx, map[string]string{"id": e.id})
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"General's Quarters", "generals-quarters"},
+		{"  Major's   Suite ", "majors-suite"},
+		{"Room 101!", "room-101"},
+		{"!!!", ""},
+	}
+
+	for _, e := range tests {
+		if got := slugify(e.name); got != e.expected {
+			t.Errorf("slugify(%q): expected %q, but got %q", e.name, e.expected, got)
+		}
+	}
+}
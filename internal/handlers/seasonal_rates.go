@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gustavNdamukong/hotel-bookings/internal/forms"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// maxSeasonNameLength is the longest a season's name can be
+const maxSeasonNameLength = 100
+
+// AdminRoomSeasons lists a room's seasonal rates, which replace its base rate on the nights they cover
+func (m *Repository) AdminRoomSeasons(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	seasons, err := m.DB.SeasonalRatesByRoomId(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["seasons"] = seasons
+
+	renderPage(w, r, "admin-room-seasons.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRoomSeason shows the form to add a seasonal rate to a room (at /admin/rooms/{id}/seasons/new) or edit one
+func (m *Repository) AdminRoomSeason(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	season, ok := m.seasonFromURL(w, r, room)
+	if !ok {
+		return
+	}
+
+	m.renderRoomSeason(w, r, room, season, seasonFormValues(season), forms.New(nil))
+}
+
+// seasonFromURL gets the room's seasonal rate with the {season} id in the URL, or a new one for the room if
+// there is none. It sends the error page & returns false if the season isn't one of the room's
+func (m *Repository) seasonFromURL(w http.ResponseWriter, r *http.Request, room models.Room) (models.SeasonalRate, bool) {
+	idParam := chi.URLParam(r, "season")
+	if idParam == "" {
+		return models.SeasonalRate{RoomId: room.ID}, true
+	}
+
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.SeasonalRate{}, false
+	}
+
+	season, err := m.DB.GetSeasonalRateById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && season.RoomId != room.ID) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return season, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return season, false
+	}
+
+	return season, true
+}
+
+// seasonFormValues are the season form's fields for a seasonal rate. Rates are shown in whole units of the
+// currency, like a new room's rate
+func seasonFormValues(s models.SeasonalRate) map[string]string {
+	values := map[string]string{
+		"season_name":     s.SeasonName,
+		"start_date":      "",
+		"end_date":        "",
+		"nightly_rate":    "",
+		"min_stay":        "",
+		"deposit_percent": "",
+	}
+	if !s.StartDate.IsZero() {
+		values["start_date"] = s.StartDate.Format("2006-01-02")
+		values["end_date"] = s.EndDate.Format("2006-01-02")
+	}
+	if s.NightlyRate > 0 {
+		values["nightly_rate"] = strconv.Itoa(s.NightlyRate / 100)
+	}
+	// 0 means the room's own minimum stay & deposit, which is shown as an empty field
+	if s.MinStay > 0 {
+		values["min_stay"] = strconv.Itoa(s.MinStay)
+	}
+	if s.DepositPercent > 0 {
+		values["deposit_percent"] = strconv.Itoa(s.DepositPercent)
+	}
+	return values
+}
+
+func (m *Repository) renderRoomSeason(w http.ResponseWriter, r *http.Request, room models.Room, season models.SeasonalRate, values map[string]string, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["season"] = season
+
+	renderPage(w, r, "admin-room-season.page.tmpl", &models.TemplateData{
+		StringMap: values,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostRoomSeason saves a new seasonal rate for a room (from /admin/rooms/{id}/seasons/new) or changes to one
+func (m *Repository) AdminPostRoomSeason(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	season, ok := m.seasonFromURL(w, r, room)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("season_name", "start_date", "end_date")
+	form.MaxLength("season_name", maxSeasonNameLength)
+	season.SeasonName = strings.TrimSpace(form.Get("season_name"))
+
+	// a season's end date is the last night it covers, so a season can start & end on the same night
+	layout := "2006-01-02"
+	start, startErr := time.Parse(layout, form.Get("start_date"))
+	if startErr != nil && form.Get("start_date") != "" {
+		form.Errors.Add("start_date", "Use a date like 2050-06-01")
+	}
+	end, endErr := time.Parse(layout, form.Get("end_date"))
+	if endErr != nil && form.Get("end_date") != "" {
+		form.Errors.Add("end_date", "Use a date like 2050-08-31")
+	}
+	if startErr == nil && endErr == nil && end.Before(start) {
+		form.Errors.Add("end_date", "The season can't end before it starts")
+	}
+	season.StartDate, season.EndDate = start, end
+
+	if form.IntBetween("nightly_rate", 1, 100000) {
+		amount, _ := strconv.Atoi(strings.TrimSpace(form.Get("nightly_rate")))
+		season.NightlyRate = amount * 100
+	}
+	// the minimum stay & deposit can be left empty, for the room's own
+	season.MinStay, season.DepositPercent = 0, 0
+	if form.Get("min_stay") != "" && form.IntBetween("min_stay", 1, 365) {
+		season.MinStay, _ = strconv.Atoi(strings.TrimSpace(form.Get("min_stay")))
+	}
+	if form.Get("deposit_percent") != "" && form.IntBetween("deposit_percent", 1, 100) {
+		season.DepositPercent, _ = strconv.Atoi(strings.TrimSpace(form.Get("deposit_percent")))
+	}
+
+	if !form.Valid() {
+		values := make(map[string]string)
+		for field := range seasonFormValues(season) {
+			values[field] = form.Get(field)
+		}
+		m.renderRoomSeason(w, r, room, season, values, form)
+		return
+	}
+
+	if season.ID == 0 {
+		season.ID, err = m.DB.InsertSeasonalRate(r.Context(), season)
+	} else {
+		err = m.DB.UpdateSeasonalRate(r.Context(), season)
+	}
+
+	back := fmt.Sprintf("/admin/rooms/%d/seasons", room.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot save season")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s saved", season.SeasonName))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomSeason removes a seasonal rate, so its room's base rate applies to its nights again
+func (m *Repository) AdminDeleteRoomSeason(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	season, err := m.DB.GetSeasonalRateById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/rooms/%d/seasons", season.RoomId)
+	if err := m.DB.DeleteSeasonalRate(r.Context(), season.ID); err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot delete season")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s deleted", season.SeasonName))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
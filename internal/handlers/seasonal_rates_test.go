@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var adminPostRoomSeasonTests = []struct {
+	name               string
+	roomID             string
+	seasonID           string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{
+		name:   "new season",
+		roomID: "1",
+		postedData: url.Values{
+			"season_name":     {"Christmas"},
+			"start_date":      {"2050-12-20"},
+			"end_date":        {"2051-01-02"},
+			"nightly_rate":    {"200"},
+			"min_stay":        {"3"},
+			"deposit_percent": {"50"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:     "edit season",
+		roomID:   "1",
+		seasonID: "1",
+		postedData: url.Values{
+			"season_name":  {"Summer"},
+			"start_date":   {"2050-06-01"},
+			"end_date":     {"2050-06-01"},
+			"nightly_rate": {"160"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:   "ends before it starts",
+		roomID: "1",
+		postedData: url.Values{
+			"season_name":  {"Christmas"},
+			"start_date":   {"2050-12-20"},
+			"end_date":     {"2050-12-01"},
+			"nightly_rate": {"200"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "can&#39;t end before it starts",
+	},
+	{
+		name:   "bad date",
+		roomID: "1",
+		postedData: url.Values{
+			"season_name":  {"Christmas"},
+			"start_date":   {"20/12/2050"},
+			"end_date":     {"2051-01-02"},
+			"nightly_rate": {"200"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Use a date like",
+	},
+	{
+		name:   "no rate",
+		roomID: "1",
+		postedData: url.Values{
+			"season_name": {"Christmas"},
+			"start_date":  {"2050-12-20"},
+			"end_date":    {"2051-01-02"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "from 1 to 100000",
+	},
+	{
+		name:   "too big a deposit",
+		roomID: "1",
+		postedData: url.Values{
+			"season_name":     {"Christmas"},
+			"start_date":      {"2050-12-20"},
+			"end_date":        {"2051-01-02"},
+			"nightly_rate":    {"200"},
+			"deposit_percent": {"150"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "from 1 to 100",
+	},
+	{
+		name:     "another room's season",
+		roomID:   "2",
+		seasonID: "1",
+		postedData: url.Values{
+			"season_name":  {"Summer"},
+			"start_date":   {"2050-06-01"},
+			"end_date":     {"2050-08-31"},
+			"nightly_rate": {"160"},
+		},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:   "insert fails",
+		roomID: "1",
+		postedData: url.Values{
+			"season_name":  {"fail"},
+			"start_date":   {"2050-12-20"},
+			"end_date":     {"2051-01-02"},
+			"nightly_rate": {"200"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+}
+
+func TestRepository_AdminPostRoomSeason(t *testing.T) {
+	for _, e := range adminPostRoomSeasonTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/seasons/new", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		params := map[string]string{"id": e.roomID}
+		if e.seasonID != "" {
+			params["season"] = e.seasonID
+		}
+		req = req.WithContext(addURLParams(ctx, params))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomSeason)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomSeason(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"season", "1", http.StatusSeeOther, "/admin/rooms/1/seasons"},
+		{"non-existent season", "9", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/delete-room-season/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(addURLParams(ctx, map[string]string{"id": e.id}))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoomSeason)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %q, but got %q", e.name, e.expectedLocation, location)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,7 +16,12 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/gustavNdamukong/hotel-bookings/internal/config"
+	"github.com/gustavNdamukong/hotel-bookings/internal/helpers"
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/payments"
+	"github.com/gustavNdamukong/hotel-bookings/internal/photos"
 	"github.com/gustavNdamukong/hotel-bookings/internal/render"
 	"github.com/justinas/nosurf"
 )
@@ -24,14 +30,17 @@ var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
 var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"iterate":     render.Iterate,
+	"add":         render.Add,
+	"formatMoney": render.FormatMoney,
+	"atLeast":     render.AtLeast,
 }
 
 func TestMain(m *testing.M) {
 	gob.Register(models.Reservation{})
+	gob.Register(models.Booking{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
@@ -40,13 +49,11 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	app.InProduction = false
 
-	// set up logging. Create a new logger that writes to the terminal (os.Stdout), prefix the msg
-	// with "INFO" & a tab, followed by the date & time
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	// set up logging, the same way as the main app
+	logger, _ := logging.New(os.Stdout, logging.FormatText, slog.LevelInfo)
+	app.Logger = logger
+	app.InfoLog = slog.NewLogLogger(logger.Handler(), slog.LevelInfo)
+	app.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelError)
 
 	// initialise a session
 	session = scs.New()
@@ -67,21 +74,30 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
-	//to allow tests to pass, we need to simulate the sending and listening for mails which
-	//is happening in our mail application, for we do not want to actually send emails here
-	//during testing. Hence we create a similar mail channel here in the testing area with
-	//the same kinda func listenForMail() which will do something else. This way we will
-	//still achieve good test coverage.
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-	listenForMail()
+	app.SigningKey = []byte("test-signing-key")
+	app.BaseURL = "http://localhost:8080"
+	app.CancelCutoff = 48 * time.Hour
+
+	// uploaded photos are kept in memory, so tests can check what was saved
+	app.PhotoStorage = photos.NewMemoryStorage()
+	app.MaxUploadSize = 1 << 20
+
+	// payments are taken with the fake provider, so tests can sign their own webhooks
+	app.Payments = payments.NewFakeProvider([]byte("test-webhook-secret"), "http://localhost:8080/payments/fake")
+	app.Currency = "usd"
 
 	templateCache, err := render.CreateTemplateCache()
 	if err != nil {
 		log.Fatal("Cannot create template cache")
 	}
 
+	emailTemplates, err := mail.NewTemplates("./../../email-templates", true)
+	if err != nil {
+		log.Fatal("Cannot create email template cache")
+	}
+	emailTemplates.Currency = app.Currency
+	app.EmailTemplates = emailTemplates
+
 	app.TemplateCache = templateCache
 
 	//do a random global config setting change to test
@@ -91,21 +107,11 @@ func TestMain(m *testing.M) {
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	//do the same thing that this same func does in the main app,
-	//but avoid sending an email
-	go func() {
-		for {
-			//do nopthing with what we get back from the MailChannel
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 	// what am I going to put in the session
 	//we do this coz one of our handlers needs it
@@ -114,13 +120,11 @@ func getRoutes() http.Handler {
 	// change this to true when in production
 	app.InProduction = false
 
-	// set up logging. Create a new logger that writes to the terminal (os.Stdout), prefix the msg
-	// with "INFO" & a tab, followed by the date & time
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	// set up logging, the same way as the main app
+	logger, _ := logging.New(os.Stdout, logging.FormatText, slog.LevelInfo)
+	app.Logger = logger
+	app.InfoLog = slog.NewLogLogger(logger.Handler(), slog.LevelInfo)
+	app.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelError)
 
 	// set up the session. We need to do it exactly as we do in the main app
 	session = scs.New()
@@ -152,17 +156,27 @@ func getRoutes() http.Handler {
 
 	//test everything in routes.go
 	mux.Use(middleware.Recoverer)
+	mux.Use(app.Metrics.Middleware)
 
 	// NOTES: note that you need to disable CSRFToken checks for test post requests or the tests will fail.
 	// unless you try & pass in a CSRFToken with every test request, but testing that is not crucial,
 	// so its best to disable that check (comment out 'NoSurf' which is the library we used to inforce that).
 	//mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.NotFound(Repo.NotFound)
+	mux.MethodNotAllowed(Repo.MethodNotAllowed)
+
+	mux.Get("/healthz", Repo.Healthz)
+	mux.Get("/readyz", Repo.Readyz)
+	mux.Get("/metrics", Repo.Metrics)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/generals-quarters", Repo.OldRoomPage)
+	mux.Get("/majors-suite", Repo.OldRoomPage)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -172,7 +186,13 @@ func getRoutes() http.Handler {
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
+	mux.Get("/make-reservation/remove/{index}", Repo.RemoveFromBooking)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/payments/fake/{intent}", Repo.FakeCheckout)
+	mux.Post("/payments/fake/{intent}", Repo.PostFakeCheckout)
+	mux.Get("/reservations/manage/{token}", Repo.GuestManageReservation)
+	mux.Post("/reservations/manage/{token}/cancel", Repo.GuestCancelReservation)
+	mux.Post("/reservations/manage/{token}/dates", Repo.GuestChangeReservationDates)
 	//-----------------------------------
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
@@ -184,11 +204,55 @@ func getRoutes() http.Handler {
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
-	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
 
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminShowPostReservation)
+	mux.Post("/admin/reservations/{src}/{id}/status", Repo.AdminPostReservationStatus)
+
+	mux.Get("/ical/{token}", Repo.ICalFeed)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminRoom)
+	mux.Post("/admin/rooms/new", Repo.AdminPostRoom)
+	mux.Get("/admin/rooms/{id}", Repo.AdminRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostRoom)
+	mux.Get("/admin/archive-room/{id}/do", Repo.AdminArchiveRoom)
+	mux.Get("/admin/restore-room/{id}/do", Repo.AdminRestoreRoom)
+	mux.Get("/admin/move-room/{id}/{dir}/do", Repo.AdminMoveRoom)
+	mux.Get("/admin/rooms/{id}/photos", Repo.AdminRoomPhotos)
+	mux.Post("/admin/rooms/{id}/photos", Repo.AdminPostRoomPhoto)
+	mux.Post("/admin/room-photos/{id}/caption", Repo.AdminPostRoomPhotoCaption)
+	mux.Get("/admin/delete-room-photo/{id}/do", Repo.AdminDeleteRoomPhoto)
+	mux.Get("/admin/move-room-photo/{id}/{dir}/do", Repo.AdminMoveRoomPhoto)
+	mux.Get("/admin/rooms/{id}/seasons", Repo.AdminRoomSeasons)
+	mux.Get("/admin/rooms/{id}/seasons/new", Repo.AdminRoomSeason)
+	mux.Post("/admin/rooms/{id}/seasons/new", Repo.AdminPostRoomSeason)
+	mux.Get("/admin/rooms/{id}/seasons/{season}", Repo.AdminRoomSeason)
+	mux.Post("/admin/rooms/{id}/seasons/{season}", Repo.AdminPostRoomSeason)
+	mux.Get("/admin/delete-room-season/{id}/do", Repo.AdminDeleteRoomSeason)
+	mux.Get("/admin/room-calendars", Repo.AdminRoomCalendars)
+	mux.Get("/admin/rooms/{id}/calendar", Repo.AdminRoomCalendar)
+	mux.Post("/admin/rooms/{id}/calendar", Repo.AdminPostRoomCalendar)
+	mux.Post("/admin/rooms/{id}/calendar/sync", Repo.AdminSyncRoomCalendar)
+	mux.Post("/admin/rooms/{id}/calendar/upload", Repo.AdminUploadRoomCalendar)
+	mux.Get("/admin/email-outbox", Repo.AdminEmailOutbox)
+	mux.Get("/admin/resend-email/{id}/do", Repo.AdminResendEmail)
+	mux.Get("/admin/audit-events", Repo.AdminAuditEvents)
+	mux.Get("/admin/audit-events/export", Repo.AdminExportAuditEvents)
+	mux.Post("/admin/payments/{id}/capture", Repo.AdminCapturePayment)
+	mux.Post("/admin/payments/{id}/refund", Repo.AdminRefundPayment)
+
+	mux.Get("/admin/api-keys", Repo.AdminAPIKeys)
+	mux.Post("/admin/api-keys", Repo.AdminPostAPIKey)
+	mux.Get("/admin/revoke-api-key/{id}/do", Repo.AdminRevokeAPIKey)
+	mux.Get("/admin/property", Repo.AdminProperty)
+	mux.Post("/admin/property", Repo.AdminPostProperty)
+	mux.Post("/admin/rooms/{id}/owner", Repo.AdminPostRoomOwner)
+	//-----------------------------------
+	mux.Get("/api/v1/rooms", Repo.APIRooms)
+	mux.Get("/api/v1/availability", Repo.APIAvailability)
+	mux.Post("/api/v1/reservations", Repo.APIPostReservation)
+	mux.Get("/api/v1/reservations/{id}", Repo.APIGetReservation)
+	mux.Delete("/api/v1/reservations/{id}", Repo.APICancelReservation)
 	//-----------------------------------
 
 	fileServer := http.FileServer(http.Dir("./static/"))
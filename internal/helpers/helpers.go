@@ -1,11 +1,15 @@
 package helpers
 
 import (
-	"fmt"
+	"encoding/json"
 	"net/http"
 	"runtime/debug"
+	"strings"
 
 	"github.com/gustavNdamukong/hotel-bookings/internal/config"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/render"
+	"github.com/gustavNdamukong/hotel-bookings/internal/roles"
 )
 
 var app *config.AppConfig
@@ -15,20 +19,75 @@ func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
-	http.Error(w, http.StatusText(status), status)
+// ClientError sends the error page for a 4xx status, eg 404 when what was asked for doesn't exist
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.Logger.InfoContext(r.Context(), "client error", "status", status, "path", r.URL.Path)
+	ErrorPage(w, r, status)
 }
 
-func ServerError(w http.ResponseWriter, err error) {
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
 	// on a server we want as much info as possible on the error, so let's trace the error
-	// NOTES: debug.stack() is how u get the stacktrace of an arror
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	// NOTES: debug.stack() is how u get the stacktrace of an arror. The request's context adds its request ID
+	// to the log line, so the error can be matched to the request that caused it
+	app.Logger.ErrorContext(r.Context(), "server error", "error", err, "stack", string(debug.Stack()))
 	// NOTES: ideally we should email the site maintainer with a path to the error log file
-	// but for now, let's just print the error to the terminal
-	app.ErrorLog.Println(trace)
-	// give some kind of feedback to the user
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	// but for now, let's just log the error
+	// give some kind of feedback to the user. The error itself is never shown, as it may give away how the site works
+	ErrorPage(w, r, http.StatusInternalServerError)
+}
+
+// errorPage is what the error page says for a status
+type errorPage struct {
+	title   string
+	message string
+}
+
+// errorPages holds the wording of the error page for each status we send it with. Any other status gets the
+// generic wording of errorPages[0]
+var errorPages = map[int]errorPage{
+	0:                              {"Something went wrong", "Sorry, we couldn't do that."},
+	http.StatusBadRequest:          {"Something went wrong", "Sorry, we couldn't understand that request. If you sent a form, please go back, reload the page & try again."},
+	http.StatusForbidden:           {"Access denied", "Sorry, you do not have permission to see this page."},
+	http.StatusNotFound:            {"Page not found", "Sorry, the page you are looking for does not exist or has been moved."},
+	http.StatusMethodNotAllowed:    {"Something went wrong", "Sorry, that page can't be used like that."},
+	http.StatusInternalServerError: {"Something went wrong", "Sorry, something went wrong on our side. Please try again in a little while."},
+	http.StatusServiceUnavailable:  {"We'll be back soon", "We are doing some maintenance on the site. Please come back in a little while."},
+}
+
+// ErrorPage sends the error page for status, in the site's normal layout. /api & other JSON routes get a
+// JSON error instead, like the rest of their responses.
+// NOTES: the page is rendered with the session, so only use it behind the SessionLoad middleware
+func ErrorPage(w http.ResponseWriter, r *http.Request, status int) {
+	if WantsJSON(r) {
+		ErrorJSON(w, status, strings.ToLower(http.StatusText(status)), nil)
+		return
+	}
+
+	page, ok := errorPages[status]
+	if !ok {
+		page = errorPages[0]
+	}
+	data := make(map[string]interface{})
+	data["status"] = status
+	data["title"] = page.title
+	data["message"] = page.message
+
+	err := render.TemplateWithStatus(w, r, status, "error.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+	if err != nil {
+		// the error page itself (or the layout) is broken, so fall back to plain text
+		app.Logger.ErrorContext(r.Context(), "cannot render error page", "status", status, "error", err)
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+// WantsJSON checks if a request expects a JSON response: /api requests, routes ending in -json (which the
+// booking pages call with fetch()) & anything that asks for JSON in its Accept header
+func WantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.HasSuffix(r.URL.Path, "-json") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
 }
 
 // NOTES: This is how to quickly check if a user is logged in. Its simple-it returns true or false.
@@ -39,3 +98,53 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// HasRole checks if the logged in user's role is min or a more trusted one. The role is put in the
+// session as 'role' when the user logs in, or by the loadRole middleware for sessions from before roles
+func HasRole(r *http.Request, min roles.Role) bool {
+	role := roles.Role(app.Session.GetString(r.Context(), "role"))
+	return role.AtLeast(min)
+}
+
+// JSONResponse is the envelope that every /api response is wrapped in. Exactly one of Data or Error is set
+type JSONResponse struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *JSONError  `json:"error,omitempty"`
+}
+
+// JSONError describes what went wrong with an /api request. Fields holds validation errors by field name
+type JSONError struct {
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+// WriteJSON sends data to the client wrapped in a JSONResponse, with the given http status
+func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
+	writeEnvelope(w, status, JSONResponse{Data: data})
+}
+
+// ErrorJSON sends an error to the client wrapped in a JSONResponse, with the given http status
+func ErrorJSON(w http.ResponseWriter, status int, message string, fields map[string][]string) {
+	writeEnvelope(w, status, JSONResponse{
+		Error: &JSONError{
+			Status:  status,
+			Message: message,
+			Fields:  fields,
+		},
+	})
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, resp JSONResponse) {
+	out, err := json.Marshal(resp)
+	if err != nil {
+		app.Logger.Error("encoding JSON response", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// NOTES: headers must be set before calling WriteHeader(), or they will be ignored
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
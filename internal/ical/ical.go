@@ -0,0 +1,189 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Event is one all-day event in a calendar, eg a reservation or a block. End is the day after the last
+// night, the same as a reservation's departure date
+type Event struct {
+	UID     string
+	Start   time.Time
+	End     time.Time
+	Summary string
+}
+
+// ErrNoCalendar is returned by Parse when the input is not an iCalendar file
+var ErrNoCalendar = errors.New("not an iCalendar file")
+
+const dateLayout = "20060102"
+
+// Write writes events as an iCalendar (.ics) file, as described in RFC 5545.
+// NOTES: iCalendar lines must end in \r\n, not just \n, or some calendar apps will refuse the file
+func Write(w io.Writer, name string, events []Event) error {
+	b := &strings.Builder{}
+	line := func(format string, args ...interface{}) {
+		b.WriteString(fold(fmt.Sprintf(format, args...)))
+		b.WriteString("\r\n")
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//hotel-bookings//rooms//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:%s", escape(name))
+
+	for _, e := range events {
+		line("BEGIN:VEVENT")
+		line("UID:%s", escape(e.UID))
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART;VALUE=DATE:%s", e.Start.Format(dateLayout))
+		line("DTEND;VALUE=DATE:%s", e.End.Format(dateLayout))
+		line("SUMMARY:%s", escape(e.Summary))
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Parse reads the events out of an iCalendar file. Events without a UID or start date are skipped, since
+// we can't import them idempotently. An event without an end lasts one day
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var current *Event
+	seenCalendar := false
+
+	for _, l := range lines {
+		name, params, value := splitLine(l)
+
+		switch {
+		case name == "BEGIN" && value == "VCALENDAR":
+			seenCalendar = true
+		case name == "BEGIN" && value == "VEVENT":
+			current = &Event{}
+		case name == "END" && value == "VEVENT":
+			if current != nil && current.UID != "" && !current.Start.IsZero() {
+				if !current.End.After(current.Start) {
+					current.End = current.Start.AddDate(0, 0, 1)
+				}
+				events = append(events, *current)
+			}
+			current = nil
+		case current == nil:
+			// a property of the calendar itself, or of something other than an event
+		case name == "UID":
+			current.UID = unescape(value)
+		case name == "SUMMARY":
+			current.Summary = unescape(value)
+		case name == "DTSTART":
+			current.Start, _ = parseDate(params, value)
+		case name == "DTEND":
+			current.End, _ = parseDate(params, value)
+		}
+	}
+
+	if !seenCalendar {
+		return nil, ErrNoCalendar
+	}
+
+	return events, nil
+}
+
+// parseDate reads a DATE or DATE-TIME value as the date it falls on. Times are dropped, since rooms are
+// booked by the night
+func parseDate(params, value string) (time.Time, error) {
+	if len(value) < len(dateLayout) {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+
+	d, err := time.Parse(dateLayout, value[:len(dateLayout)])
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// a date-time that ends in Z is in UTC; without a TZID we take the date as written
+	if strings.HasSuffix(value, "Z") && !strings.Contains(params, "TZID") {
+		if t, err := time.Parse("20060102T150405Z", value); err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+
+	return d, nil
+}
+
+// unfold reads the lines of a file, joining lines that were folded because they were too long.
+// A folded line continues on the next line, which starts with a space or tab
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		l := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitLine splits eg 'DTSTART;VALUE=DATE:20500101' into its name (DTSTART), params (VALUE=DATE) & value
+func splitLine(l string) (name, params, value string) {
+	head, value, _ := strings.Cut(l, ":")
+	name, params, _ = strings.Cut(head, ";")
+	return strings.ToUpper(name), params, value
+}
+
+// fold splits lines longer than 75 bytes, as the spec asks
+func fold(l string) string {
+	if len(l) <= 75 {
+		return l
+	}
+
+	b := &strings.Builder{}
+	// the space that starts each continuation line counts towards its 75 bytes
+	max := 75
+	for len(l) > max {
+		cut := max
+		// don't cut a multi-byte character in half
+		for cut > 0 && l[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(l[:cut])
+		b.WriteString("\r\n ")
+		l = l[cut:]
+		max = 74
+	}
+	b.WriteString(l)
+
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
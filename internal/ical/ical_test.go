@@ -0,0 +1,98 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestWriteParse(t *testing.T) {
+	events := []Event{
+		{UID: "restriction-1@hotel-bookings", Start: date("2050-01-01"), End: date("2050-01-03"), Summary: "Reserved"},
+		{UID: "abc,123", Start: date("2050-02-01"), End: date("2050-02-02"), Summary: strings.Repeat("Blocked; ", 20)},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "General's Quarters", events); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("expected lines to be folded at 75 bytes but got %d: %s", len(l), l)
+		}
+	}
+
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(events) {
+		t.Fatalf("expected %d events but got %d", len(events), len(got))
+	}
+
+	for i, e := range events {
+		if got[i].UID != e.UID || !got[i].Start.Equal(e.Start) || !got[i].End.Equal(e.End) || got[i].Summary != e.Summary {
+			t.Errorf("event %d: expected %+v but got %+v", i, e, got[i])
+		}
+	}
+}
+
+// parseTests is the data for the Parse tests. The input is in the shapes OTAs actually send
+var parseTests = []struct {
+	name     string
+	input    string
+	expected []Event
+}{
+	{
+		"date-time & no end",
+		"BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:x1\nDTSTART:20500105T140000Z\nEND:VEVENT\nEND:VCALENDAR\n",
+		[]Event{{UID: "x1", Start: date("2050-01-05"), End: date("2050-01-06")}},
+	},
+	{
+		"folded uid",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:abc\r\n def\r\nDTSTART;VALUE=DATE:20500105\r\nDTEND;VALUE=DATE:20500108\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		[]Event{{UID: "abcdef", Start: date("2050-01-05"), End: date("2050-01-08")}},
+	},
+	{
+		"no uid is skipped",
+		"BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART;VALUE=DATE:20500105\nEND:VEVENT\nEND:VCALENDAR\n",
+		nil,
+	},
+}
+
+func TestParse(t *testing.T) {
+	for _, e := range parseTests {
+		got, err := Parse(strings.NewReader(e.input))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		if len(got) != len(e.expected) {
+			t.Errorf("%s: expected %d events but got %d", e.name, len(e.expected), len(got))
+			continue
+		}
+
+		for i, ev := range e.expected {
+			if got[i].UID != ev.UID || !got[i].Start.Equal(ev.Start) || !got[i].End.Equal(ev.End) {
+				t.Errorf("%s: expected %+v but got %+v", e.name, ev, got[i])
+			}
+		}
+	}
+}
+
+func TestParse_NotACalendar(t *testing.T) {
+	_, err := Parse(strings.NewReader("<html>Not found</html>"))
+	if !errors.Is(err, ErrNoCalendar) {
+		t.Errorf("expected ErrNoCalendar but got %v", err)
+	}
+}
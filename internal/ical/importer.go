@@ -0,0 +1,173 @@
+package ical
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// uidSuffix ends the UID of every event in our own feeds. OTAs often put the events they imported from us
+// back into their own feeds, so we skip these when importing, or we would block rooms for our own bookings
+const uidSuffix = "@hotel-bookings"
+
+// UID returns the UID of the event for one of our room restrictions
+func UID(restrictionID int) string {
+	return fmt.Sprintf("restriction-%d%s", restrictionID, uidSuffix)
+}
+
+// maxFeedSize is the most we read of an external calendar, so a broken or hostile URL can't use up our memory
+const maxFeedSize = 5 << 20
+
+// ErrForbiddenAddress is returned for external calendars on addresses we won't fetch from, ie our own server
+// & the private network it is on
+var ErrForbiddenAddress = errors.New("external calendars can't be on private or loopback addresses")
+
+// Importer turns the events of external calendars into owner blocks on our rooms
+type Importer struct {
+	DB     repository.DatabaseRepo
+	Client *http.Client
+}
+
+// NewImporter creates an Importer. Its Client only fetches calendars from public addresses
+func NewImporter(db repository.DatabaseRepo) *Importer {
+	// NOTES: the import URL is whatever an admin saved, so without this anyone who can edit a room could have
+	// the server fetch eg http://localhost/admin or a cloud metadata address for them. The address is checked
+	// as it is connected to, rather than the URL's host name, so it also covers redirects & host names that
+	// resolve to a private address. A proxy would connect for us, so none is used
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: publicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Importer{
+		DB: db,
+		Client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return errors.New("too many redirects")
+				}
+				return checkScheme(req.URL)
+			},
+		},
+	}
+}
+
+// checkScheme only lets calendars be fetched over http or https
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("external calendars must be fetched over http or https, not %q", u.Scheme)
+	}
+	return nil
+}
+
+// publicOnly is the Control of the Importer's net.Dialer. It refuses to connect to anything but a public address
+func publicOnly(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// isPublic tells whether addr is on the internet, rather than eg loopback, private or link-local (which cloud
+// metadata services are on)
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast()
+}
+
+// Import blocks a room for each of the events. Events are keyed by UID, so importing the same event again
+// just moves its block to the event's current dates. If replace is true, the room is a mirror of the
+// external calendar, & blocks imported earlier whose events are gone from it are deleted.
+// It returns how many events were imported
+func (i *Importer) Import(ctx context.Context, roomID int, events []Event, replace bool) (int, error) {
+	uids := []string{}
+	imported := 0
+
+	for _, e := range events {
+		if strings.HasSuffix(e.UID, uidSuffix) {
+			continue
+		}
+
+		if err := i.DB.UpsertExternalBlock(ctx, roomID, e.UID, e.Start, e.End); err != nil {
+			return imported, err
+		}
+		uids = append(uids, e.UID)
+		imported++
+	}
+
+	if replace {
+		if err := i.DB.DeleteExternalBlocksNotIn(ctx, roomID, uids); err != nil {
+			return imported, err
+		}
+	}
+
+	return imported, nil
+}
+
+// Sync fetches a room's external calendar & imports it
+func (i *Importer) Sync(ctx context.Context, cal models.RoomCalendar) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cal.ImportURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkScheme(req.URL); err != nil {
+		return 0, err
+	}
+
+	resp, err := i.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("fetching %s: %s", cal.ImportURL, resp.Status)
+	}
+
+	events, err := Parse(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := i.Import(ctx, cal.RoomId, events, true)
+	if err != nil {
+		return n, err
+	}
+
+	cal.LastImportAt = time.Now()
+	return n, i.DB.UpdateRoomCalendar(ctx, cal)
+}
+
+// SyncAll syncs every room that has an external calendar. One room failing does not stop the others
+func (i *Importer) SyncAll(ctx context.Context) error {
+	calendars, err := i.DB.AllRoomCalendarsWithImports(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, cal := range calendars {
+		if _, err := i.Sync(ctx, cal); err != nil {
+			errs = append(errs, fmt.Errorf("room %d: %w", cal.RoomId, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
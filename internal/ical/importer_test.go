@@ -0,0 +1,148 @@
+package ical
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// recordingRepo records the blocks the importer writes. Any other repository method panics, since the
+// importer should not be calling it
+type recordingRepo struct {
+	repository.DatabaseRepo
+	blocks  map[string]time.Time
+	kept    []string
+	updated models.RoomCalendar
+}
+
+func (r *recordingRepo) UpsertExternalBlock(ctx context.Context, roomID int, uid string, start, end time.Time) error {
+	r.blocks[uid] = start
+	return nil
+}
+
+func (r *recordingRepo) DeleteExternalBlocksNotIn(ctx context.Context, roomID int, uids []string) error {
+	r.kept = uids
+	return nil
+}
+
+func (r *recordingRepo) UpdateRoomCalendar(ctx context.Context, c models.RoomCalendar) error {
+	r.updated = c
+	return nil
+}
+
+func TestImporter_Import(t *testing.T) {
+	repo := &recordingRepo{blocks: map[string]time.Time{}}
+	importer := NewImporter(repo)
+
+	events := []Event{
+		{UID: "ota-1", Start: date("2050-01-01"), End: date("2050-01-03")},
+		{UID: UID(7), Start: date("2050-02-01"), End: date("2050-02-03")},
+	}
+
+	// importing twice must leave the same blocks
+	for i := 0; i < 2; i++ {
+		n, err := importer.Import(context.Background(), 1, events, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("expected 1 event to be imported but got %d", n)
+		}
+	}
+
+	if len(repo.blocks) != 1 || !repo.blocks["ota-1"].Equal(date("2050-01-01")) {
+		t.Errorf("expected one block for ota-1 but got %v", repo.blocks)
+	}
+
+	if len(repo.kept) != 1 || repo.kept[0] != "ota-1" {
+		t.Errorf("expected only ota-1 to be kept but got %v", repo.kept)
+	}
+}
+
+func TestImporter_Sync(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/room.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:ota-2\r\nDTSTART;VALUE=DATE:20500301\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	}))
+	defer ts.Close()
+
+	repo := &recordingRepo{blocks: map[string]time.Time{}}
+	importer := NewImporter(repo)
+	// the test server is on loopback, which the importer's own client won't fetch from
+	importer.Client = ts.Client()
+
+	n, err := importer.Sync(context.Background(), models.RoomCalendar{ID: 3, RoomId: 1, ImportURL: ts.URL + "/room.ics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Errorf("expected 1 event but got %d", n)
+	}
+
+	if repo.updated.ID != 3 || repo.updated.LastImportAt.IsZero() {
+		t.Errorf("expected the calendar's last import time to be saved but got %+v", repo.updated)
+	}
+
+	_, err = importer.Sync(context.Background(), models.RoomCalendar{RoomId: 1, ImportURL: ts.URL + "/missing.ics"})
+	if err == nil {
+		t.Error("expected an error for a missing calendar but got none")
+	}
+}
+
+func TestImporter_Sync_Forbidden(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")
+	}))
+	defer ts.Close()
+
+	importer := NewImporter(&recordingRepo{blocks: map[string]time.Time{}})
+
+	// our own server, eg http://127.0.0.1:8080
+	if _, err := importer.Sync(context.Background(), models.RoomCalendar{RoomId: 1, ImportURL: ts.URL}); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("expected ErrForbiddenAddress for a loopback calendar but got %v", err)
+	}
+
+	for _, u := range []string{"file:///etc/passwd", "ftp://example.com/room.ics"} {
+		if _, err := importer.Sync(context.Background(), models.RoomCalendar{RoomId: 1, ImportURL: u}); err == nil {
+			t.Errorf("expected an error for %s but got none", u)
+		}
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.5", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, e := range tests {
+		if got := isPublic(netip.MustParseAddr(e.addr)); got != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.addr, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// HeaderRequestID is the header a request ID is read from, eg one set by the load balancer, & sent back in
+const HeaderRequestID = "X-Request-ID"
+
+// Format is how log lines are written
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// NOTES: context keys should be of a type of your own, so they can't clash with keys set by other packages
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	requestInfoKey
+)
+
+// New creates the app's logger. Every line logged with a context (eg logger.InfoContext(r.Context(), ...))
+// gets the request ID in that context, so all the lines about one request, & the emails it queued, can be
+// found by searching for it
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch format {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+
+	return slog.New(contextHandler{h}), nil
+}
+
+// ParseLevel reads a level written as debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// contextHandler adds the request ID in the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// NOTES: WithAttrs & WithGroup have to be wrapped too, or loggers made with eg logger.With() would lose
+// the request ID
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// WithRequestID returns a copy of ctx holding the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID in ctx, or "" if there isn't one
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewRequestID makes a random request ID
+func NewRequestID() string {
+	b := make([]byte, 12)
+	// NOTES: crypto/rand.Read never returns an error on the systems go supports
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether a request ID sent by a client can be used as it is. Anything else is
+// replaced, so a client can't eg put new lines into our logs
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	return strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:") == ""
+}
+
+// RequestInfo is what the request logger learns about a request while it is handled, eg who made it, which
+// is only known once the session has been loaded further down the middleware chain
+type RequestInfo struct {
+	// UserID is the logged in member of staff, or 0
+	UserID int
+	// APIKeyID is the API key an /api request was made with, or 0
+	APIKeyID int
+	// IP is the address the request came from, eg for the audit trail
+	IP string
+}
+
+// WithRequestInfo returns a copy of ctx holding an empty RequestInfo, to be filled in as the request is handled
+func WithRequestInfo(ctx context.Context) (context.Context, *RequestInfo) {
+	info := &RequestInfo{}
+	return context.WithValue(ctx, requestInfoKey, info), info
+}
+
+// Info returns the RequestInfo in ctx, or nil if there isn't one
+func Info(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*RequestInfo)
+	return info
+}
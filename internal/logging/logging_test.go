@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNew_AddsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRequestID(context.Background(), "abc-123")
+	logger.With("room_id", 1).InfoContext(ctx, "booked")
+	logger.DebugContext(ctx, "not logged below info")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON line, got %q: %s", buf.String(), err)
+	}
+	if line["request_id"] != "abc-123" || line["msg"] != "booked" {
+		t.Errorf("expected the request ID on the line, got %v", line)
+	}
+}
+
+func TestNew_UnknownFormat(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := map[string]bool{
+		"":                       false,
+		"abc-123_x.y:z":          true,
+		"has space":              false,
+		"new\nline":              false,
+		string(make([]byte, 65)): false,
+	}
+	for id, want := range tests {
+		if got := ValidRequestID(id); got != want {
+			t.Errorf("ValidRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
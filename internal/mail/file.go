@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// FileMailer writes each email to its own .eml file in Dir instead of sending it, which is handy in
+// development. Most email apps can open .eml files
+type FileMailer struct {
+	Dir string
+}
+
+// NewFileMailer creates a FileMailer, creating dir if needed
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{Dir: dir}, nil
+}
+
+// Send writes msg to a new file
+func (m *FileMailer) Send(ctx context.Context, msg models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	email, err := compose(msg)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	// the timestamp first means the files sort in the order they were sent
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(email.GetMessage()), 0o644)
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"context"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	simplemail "github.com/xhit/go-simple-mail"
+)
+
+// Mailer sends emails. The app sends every email through a Mailer, so how they are sent (SMTP, files on
+// disk, or not at all in tests) is decided in one place when the app starts
+type Mailer interface {
+	Send(ctx context.Context, msg models.MailData) error
+}
+
+// compose builds the email for msg, ready to be sent or written out. Emails are rendered by Templates
+// before they are queued, so msg.Content is the finished HTML. When msg has a plain-text version, the email
+// carries both & the email app picks which one to show
+func compose(msg models.MailData) (*simplemail.Email, error) {
+	email := simplemail.NewMSG()
+	email.SetFrom(msg.From).AddTo(msg.To).SetSubject(msg.Subject)
+
+	if msg.Text != "" {
+		// NOTES: the alternatives in an email go from plainest to richest, so the plain text goes first
+		email.SetBody(simplemail.TextPlain, msg.Text)
+		email.AddAlternative(simplemail.TextHTML, msg.Content)
+	} else {
+		email.SetBody(simplemail.TextHTML, msg.Content)
+	}
+
+	if email.Error != nil {
+		return nil, email.Error
+	}
+
+	return email, nil
+}
@@ -0,0 +1,188 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+var testMsg = models.MailData{
+	To:      "guest@here.ca",
+	From:    "me@here.ca",
+	Subject: "Reservation Confirmation",
+	Content: "<strong>See you soon</strong>",
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+
+	if err := m.Send(context.Background(), testMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := m.Sent()
+	if len(sent) != 1 || sent[0].Subject != testMsg.Subject {
+		t.Errorf("expected 1 recorded email, got %+v", sent)
+	}
+
+	m.Reset()
+	if len(m.Sent()) != 0 {
+		t.Error("expected no emails after Reset")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := testMsg
+	msg.Text = "See you soon"
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 .eml file, got %d", len(files))
+	}
+
+	data, _ := os.ReadFile(files[0])
+	eml := string(data)
+	if !strings.Contains(eml, "Subject: Reservation Confirmation") {
+		t.Error("email is missing its subject")
+	}
+	if !strings.Contains(eml, "multipart/alternative") || !strings.Contains(eml, "text/plain") {
+		t.Error("email is missing its plain-text alternative")
+	}
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	if _, err := NewSMTPMailer(SMTPConfig{Host: "localhost", Port: 1025}); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewSMTPMailer(SMTPConfig{Port: 1025}); err == nil {
+		t.Error("expected an error without a host")
+	}
+	if _, err := NewSMTPMailer(SMTPConfig{Host: "localhost", Port: 587, Encryption: "ssl"}); err == nil {
+		t.Error("expected an error for an unknown encryption")
+	}
+}
+
+// flakyMailer fails its first 'failures' sends
+type flakyMailer struct {
+	failures int
+	calls    int
+}
+
+func (m *flakyMailer) Send(ctx context.Context, msg models.MailData) error {
+	m.calls++
+	if m.calls <= m.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestRetryMailer(t *testing.T) {
+	var tests = []struct {
+		name       string
+		failures   int
+		attempts   int
+		wantErr    bool
+		wantCalls  int
+		wantDelays []time.Duration
+	}{
+		{"first try", 0, 3, false, 1, nil},
+		{"after retries", 2, 3, false, 3, []time.Duration{time.Second, 2 * time.Second}},
+		{"gives up", 5, 3, true, 3, []time.Duration{time.Second, 2 * time.Second}},
+	}
+
+	for _, e := range tests {
+		next := &flakyMailer{failures: e.failures}
+		dlq := &MemoryDeadLetterQueue{}
+		m := NewRetryMailer(next, e.attempts, time.Second, dlq)
+
+		var delays []time.Duration
+		m.sleep = func(ctx context.Context, d time.Duration) error {
+			delays = append(delays, d)
+			return nil
+		}
+
+		err := m.Send(context.Background(), testMsg)
+		if (err != nil) != e.wantErr {
+			t.Errorf("%s: expected error %v, got %v", e.name, e.wantErr, err)
+		}
+		if next.calls != e.wantCalls {
+			t.Errorf("%s: expected %d attempts, got %d", e.name, e.wantCalls, next.calls)
+		}
+		if len(delays) != len(e.wantDelays) {
+			t.Errorf("%s: expected delays %v, got %v", e.name, e.wantDelays, delays)
+		} else {
+			for i := range delays {
+				if delays[i] != e.wantDelays[i] {
+					t.Errorf("%s: expected delays %v, got %v", e.name, e.wantDelays, delays)
+					break
+				}
+			}
+		}
+
+		letters := dlq.Letters()
+		if e.wantErr && (len(letters) != 1 || !strings.Contains(letters[0].Error, "connection refused")) {
+			t.Errorf("%s: expected the email in the dead-letter queue, got %+v", e.name, letters)
+		}
+		if !e.wantErr && len(letters) != 0 {
+			t.Errorf("%s: expected an empty dead-letter queue, got %+v", e.name, letters)
+		}
+	}
+}
+
+func TestRetryMailer_Cancelled(t *testing.T) {
+	next := &flakyMailer{failures: 5}
+	dlq := &MemoryDeadLetterQueue{}
+	m := NewRetryMailer(next, 3, time.Hour, dlq)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := m.Send(ctx, testMsg); err == nil {
+		t.Error("expected an error")
+	}
+	if next.calls != 1 {
+		t.Errorf("expected no retries once cancelled, got %d attempts", next.calls)
+	}
+	if len(dlq.Letters()) != 1 {
+		t.Error("expected the email in the dead-letter queue")
+	}
+}
+
+func TestFileDeadLetterQueue(t *testing.T) {
+	q := &FileDeadLetterQueue{Path: filepath.Join(t.TempDir(), "dlq", "dead.jsonl")}
+
+	letters, err := q.Letters()
+	if err != nil || len(letters) != 0 {
+		t.Fatalf("expected an empty queue, got %v %v", letters, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := q.Add(context.Background(), testMsg, errors.New("timeout")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	letters, err = q.Letters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 || letters[1].Msg.To != testMsg.To || letters[1].Error != "timeout" {
+		t.Errorf("unexpected dead letters %+v", letters)
+	}
+}
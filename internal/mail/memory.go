@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// MemoryMailer keeps emails in memory instead of sending them, so tests can check what would have been sent
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []models.MailData
+}
+
+// NewMemoryMailer creates a MemoryMailer
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send records msg
+func (m *MemoryMailer) Send(ctx context.Context, msg models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns a copy of every email sent so far
+func (m *MemoryMailer) Sent() []models.MailData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.MailData(nil), m.sent...)
+}
+
+// Reset forgets every email sent so far
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = nil
+}
@@ -0,0 +1,76 @@
+package mail
+
+import "github.com/gustavNdamukong/hotel-bookings/internal/models"
+
+// Message is the data for one kind of email. Its template is email-templates/<Template()>.email.html, &
+// optionally a hand-written plain-text version in <Template()>.email.txt. Templates can call .Subject, or
+// define a "subject" of their own to use instead of it
+type Message interface {
+	Template() string
+	Subject() string
+}
+
+// Confirmation is sent to a guest when they book
+type Confirmation struct {
+	Reservation models.Reservation
+	ManageURL   string
+}
+
+func (Confirmation) Template() string { return "confirmation" }
+func (Confirmation) Subject() string  { return "Reservation Confirmation" }
+
+// BookingConfirmation is sent to a guest when they book, listing every room they booked. ManageURLs has
+// the page to manage each of the booking's reservations, in the same order
+type BookingConfirmation struct {
+	Booking    models.Booking
+	ManageURLs []string
+}
+
+func (BookingConfirmation) Template() string { return "booking-confirmation" }
+func (BookingConfirmation) Subject() string  { return "Booking Confirmation" }
+
+// OwnerNotification tells the property owner about a new reservation
+type OwnerNotification struct {
+	Reservation models.Reservation
+	OwnerName   string
+}
+
+func (OwnerNotification) Template() string { return "owner-notification" }
+func (OwnerNotification) Subject() string  { return "Reservation Notification" }
+
+// Cancellation is sent to a guest when their reservation is cancelled
+type Cancellation struct {
+	Reservation models.Reservation
+}
+
+func (Cancellation) Template() string { return "cancellation" }
+func (Cancellation) Subject() string  { return "Reservation Cancelled" }
+
+// DatesChanged is sent to a guest when their reservation moves to new dates
+type DatesChanged struct {
+	Reservation models.Reservation
+	ManageURL   string
+}
+
+func (DatesChanged) Template() string { return "dates-changed" }
+func (DatesChanged) Subject() string  { return "Reservation Changed" }
+
+// Reminder is sent to a guest by the scheduler, before they arrive or after they leave. Name picks its
+// template, eg 'reminder' or 'thank-you', so new kinds of reminder only need a new template
+type Reminder struct {
+	Name         string
+	Reservation  models.Reservation
+	ManageURL    string
+	PropertyName string
+}
+
+func (r Reminder) Template() string {
+	if r.Name == "" {
+		return "reminder"
+	}
+	return r.Name
+}
+
+// Subject is used unless the template defines its own "subject", which every scheduled template must (see
+// reminders.CheckTemplates)
+func (Reminder) Subject() string { return "About Your Stay" }
@@ -0,0 +1,151 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// ErrNoSender is returned by the OutboxWorker while the property has no sender email, so nothing can be sent
+var ErrNoSender = errors.New("no sender email set in the property settings, so emails are waiting in the outbox")
+
+// OutboxWorker sends the emails waiting in the email_outbox table. Handlers never send emails themselves;
+// they queue them in the outbox (in the same transaction as whatever the email is about), so emails
+// survive restarts & crashes, & failed ones can be resent from admin
+type OutboxWorker struct {
+	DB     repository.DatabaseRepo
+	Mailer Mailer
+	// BatchSize is how many emails are claimed at a time
+	BatchSize int
+	// Lease is how long an email can be 'sending' before another worker may claim it again
+	Lease time.Duration
+	// Logger logs each email sent or failed, with the ID of the request that queued it. Optional
+	Logger *slog.Logger
+
+	// lastChecked is when the worker last checked the outbox, in unix nanoseconds, for LastChecked
+	lastChecked atomic.Int64
+}
+
+// NewOutboxWorker creates an OutboxWorker
+func NewOutboxWorker(db repository.DatabaseRepo, mailer Mailer) *OutboxWorker {
+	return &OutboxWorker{
+		DB:        db,
+		Mailer:    mailer,
+		BatchSize: 10,
+		Lease:     5 * time.Minute,
+	}
+}
+
+// Process claims a batch of emails & sends them. It returns how many were sent; any that failed are marked
+// as failed in the outbox, & their errors returned together
+func (w *OutboxWorker) Process(ctx context.Context) (int, error) {
+	_, sent, err := w.processBatch(ctx)
+	return sent, err
+}
+
+// Drain sends batches until the outbox is empty, or ctx is done, & returns how many emails were sent. It is
+// used on each tick of the mail worker, & when shutting down so that queued emails go out before we stop
+func (w *OutboxWorker) Drain(ctx context.Context) (int, error) {
+	total := 0
+	var errs []error
+
+	for {
+		claimed, sent, err := w.processBatch(ctx)
+		total += sent
+		if err != nil {
+			errs = append(errs, err)
+		}
+		// failed emails are marked failed, so they aren't claimed again & this always ends
+		if claimed < w.BatchSize || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return total, errors.Join(errs...)
+}
+
+// LastChecked is when the worker last checked the outbox, or the zero time if it never has. /readyz uses
+// it to tell whether the worker is still running
+func (w *OutboxWorker) LastChecked() time.Time {
+	n := w.lastChecked.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// processBatch is Process, & also returns how many emails were claimed. It returns -1 if none could be
+func (w *OutboxWorker) processBatch(ctx context.Context) (int, int, error) {
+	property, err := w.DB.GetProperty(ctx)
+	if err != nil {
+		return -1, 0, fmt.Errorf("getting the sender: %w", err)
+	}
+
+	// NOTES: a new install has no sender until an admin sets one in the property settings. Emails wait in
+	// the outbox until then, rather than going out from an address that isn't the hotel's
+	if !property.HasSender() {
+		w.lastChecked.Store(time.Now().UnixNano())
+		return 0, 0, ErrNoSender
+	}
+
+	emails, err := w.DB.ClaimOutboxEmails(ctx, w.BatchSize, w.Lease)
+	if err != nil {
+		return -1, 0, fmt.Errorf("claiming emails: %w", err)
+	}
+	w.lastChecked.Store(time.Now().UnixNano())
+
+	sent := 0
+	var errs []error
+	for _, e := range emails {
+		// send each email with the request ID of the request that queued it, so the mailer & the DB calls
+		// below log under the same ID as the booking that caused the email
+		emailCtx := ctx
+		if e.RequestID != "" {
+			emailCtx = logging.WithRequestID(ctx, e.RequestID)
+		}
+
+		// emails queued before the sender was set are sent from it now
+		if e.Msg.From == "" || e.Msg.From == models.PlaceholderSender {
+			e.Msg.From = property.SenderEmail
+		}
+
+		if sendErr := w.Mailer.Send(emailCtx, e.Msg); sendErr != nil {
+			errs = append(errs, fmt.Errorf("email %d: %w", e.ID, sendErr))
+			// NOTES: if ctx is done, eg the app is shutting down, the send was cut off rather than refused.
+			// The email stays 'sending', & is sent again once its lease runs out, rather than being failed
+			if ctx.Err() != nil {
+				continue
+			}
+			w.log(emailCtx, slog.LevelWarn, "email failed", e, slog.String("error", sendErr.Error()))
+			if err := w.DB.MarkOutboxEmailFailed(emailCtx, e.ID, sendErr.Error()); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		w.log(emailCtx, slog.LevelInfo, "email sent", e)
+
+		// NOTES: if this fails the email stays 'sending', & is sent again once its lease runs out. Sending
+		// an email twice is better than not at all
+		if err := w.DB.MarkOutboxEmailSent(emailCtx, e.ID); err != nil {
+			errs = append(errs, err)
+		}
+		sent++
+	}
+
+	return len(emails), sent, errors.Join(errs...)
+}
+
+func (w *OutboxWorker) log(ctx context.Context, level slog.Level, msg string, e models.OutboxEmail, attrs ...slog.Attr) {
+	if w.Logger == nil {
+		return
+	}
+	attrs = append(attrs, slog.Int("email_id", e.ID), slog.String("template", e.Msg.Template), slog.Int("attempts", e.Attempts))
+	w.Logger.LogAttrs(ctx, level, msg, attrs...)
+}
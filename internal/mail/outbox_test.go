@@ -0,0 +1,199 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// outboxRepo is an in-memory outbox. Any other repository method panics, since the worker should not be
+// calling it
+type outboxRepo struct {
+	repository.DatabaseRepo
+	emails []models.OutboxEmail
+	// sender is the property's sender email, which is "bookings@here.ca" if it is empty
+	sender string
+}
+
+func (r *outboxRepo) GetProperty(ctx context.Context) (models.Property, error) {
+	if r.sender == "" {
+		return models.Property{SenderEmail: "bookings@here.ca"}, nil
+	}
+	return models.Property{SenderEmail: r.sender}, nil
+}
+
+func (r *outboxRepo) ClaimOutboxEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	var claimed []models.OutboxEmail
+	for i := range r.emails {
+		if r.emails[i].Status == models.OutboxPending && len(claimed) < limit {
+			r.emails[i].Status = models.OutboxSending
+			r.emails[i].Attempts++
+			claimed = append(claimed, r.emails[i])
+		}
+	}
+	return claimed, nil
+}
+
+func (r *outboxRepo) MarkOutboxEmailSent(ctx context.Context, id int) error {
+	r.emails[id-1].Status = models.OutboxSent
+	return nil
+}
+
+func (r *outboxRepo) MarkOutboxEmailFailed(ctx context.Context, id int, lastError string) error {
+	r.emails[id-1].Status = models.OutboxFailed
+	r.emails[id-1].LastError = lastError
+	return nil
+}
+
+// picky fails to send emails to bounce@here.ca
+type picky struct{}
+
+func (picky) Send(ctx context.Context, msg models.MailData) error {
+	if msg.To == "bounce@here.ca" {
+		return errors.New("mailbox unavailable")
+	}
+	return nil
+}
+
+func TestOutboxWorker_Process(t *testing.T) {
+	repo := &outboxRepo{emails: []models.OutboxEmail{
+		{ID: 1, Msg: models.MailData{To: "guest@here.ca"}, Status: models.OutboxPending},
+		{ID: 2, Msg: models.MailData{To: "bounce@here.ca"}, Status: models.OutboxPending},
+		{ID: 3, Msg: models.MailData{To: "guest@here.ca"}, Status: models.OutboxSent},
+		{ID: 4, Msg: models.MailData{To: "owner@here.ca"}, Status: models.OutboxPending},
+	}}
+
+	w := NewOutboxWorker(repo, picky{})
+	w.BatchSize = 2
+
+	sent, err := w.Process(context.Background())
+	if sent != 1 {
+		t.Errorf("expected 1 email sent in the first batch, got %d", sent)
+	}
+	if err == nil {
+		t.Error("expected an error for the bounced email")
+	}
+
+	sent, err = w.Process(context.Background())
+	if sent != 1 || err != nil {
+		t.Errorf("expected the last email sent in the second batch, got %d %v", sent, err)
+	}
+
+	want := []string{models.OutboxSent, models.OutboxFailed, models.OutboxSent, models.OutboxSent}
+	for i, e := range repo.emails {
+		if e.Status != want[i] {
+			t.Errorf("email %d: expected status %s, got %s", e.ID, want[i], e.Status)
+		}
+	}
+	if repo.emails[1].LastError != "mailbox unavailable" {
+		t.Errorf("expected the send error to be recorded, got %q", repo.emails[1].LastError)
+	}
+	if repo.emails[2].Attempts != 0 {
+		t.Error("sent emails should not be claimed again")
+	}
+}
+
+func TestOutboxWorker_Drain(t *testing.T) {
+	repo := &outboxRepo{}
+	for i := 1; i <= 5; i++ {
+		to := "guest@here.ca"
+		if i == 2 {
+			to = "bounce@here.ca"
+		}
+		repo.emails = append(repo.emails, models.OutboxEmail{ID: i, Msg: models.MailData{To: to}, Status: models.OutboxPending})
+	}
+
+	w := NewOutboxWorker(repo, picky{})
+	w.BatchSize = 2
+
+	sent, err := w.Drain(context.Background())
+	if sent != 4 {
+		t.Errorf("expected 4 emails sent, got %d", sent)
+	}
+	if err == nil {
+		t.Error("expected an error for the bounced email")
+	}
+	for _, e := range repo.emails {
+		if e.Status == models.OutboxPending {
+			t.Errorf("email %d was left in the outbox", e.ID)
+		}
+	}
+
+	// a cancelled context stops after the batch it is on
+	repo.emails = append(repo.emails,
+		models.OutboxEmail{ID: 6, Status: models.OutboxPending},
+		models.OutboxEmail{ID: 7, Status: models.OutboxPending},
+		models.OutboxEmail{ID: 8, Status: models.OutboxPending},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sent, _ := w.Drain(ctx); sent != 2 {
+		t.Errorf("expected 1 batch sent once cancelled, got %d emails", sent)
+	}
+}
+
+// cutOff is a mailer that is still sending when the app shuts down: sends fail once ctx is done
+type cutOff struct{}
+
+func (cutOff) Send(ctx context.Context, msg models.MailData) error {
+	return ctx.Err()
+}
+
+func TestOutboxWorker_Cancelled(t *testing.T) {
+	repo := &outboxRepo{emails: []models.OutboxEmail{
+		{ID: 1, Msg: models.MailData{To: "guest@here.ca"}, Status: models.OutboxPending},
+	}}
+	w := NewOutboxWorker(repo, cutOff{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sent, err := w.Process(ctx); sent != 0 || err == nil {
+		t.Errorf("expected no emails sent & an error, got %d %v", sent, err)
+	}
+
+	// it is sent again once its lease runs out, rather than being failed
+	if repo.emails[0].Status != models.OutboxSending {
+		t.Errorf("expected the cut off email to stay %s, got %s", models.OutboxSending, repo.emails[0].Status)
+	}
+}
+
+func TestOutboxWorker_NoSender(t *testing.T) {
+	repo := &outboxRepo{
+		sender: models.PlaceholderSender,
+		emails: []models.OutboxEmail{
+			{ID: 1, Msg: models.MailData{To: "guest@here.ca", From: models.PlaceholderSender}, Status: models.OutboxPending},
+			{ID: 2, Msg: models.MailData{To: "guest@here.ca", From: "reservations@here.ca"}, Status: models.OutboxPending},
+		},
+	}
+	mailer := NewMemoryMailer()
+	w := NewOutboxWorker(repo, mailer)
+
+	// nothing goes out from the example address of a new install
+	sent, err := w.Drain(context.Background())
+	if sent != 0 || !errors.Is(err, ErrNoSender) {
+		t.Errorf("expected no emails sent & ErrNoSender, got %d %v", sent, err)
+	}
+	if repo.emails[0].Status != models.OutboxPending || repo.emails[0].Attempts != 0 {
+		t.Error("expected the email to wait in the outbox")
+	}
+	if w.LastChecked().IsZero() {
+		t.Error("expected the worker to have checked the outbox")
+	}
+
+	// once the admin sets the sender, the waiting emails are sent from it
+	repo.sender = "bookings@here.ca"
+	if sent, err := w.Drain(context.Background()); sent != 2 || err != nil {
+		t.Fatalf("expected 2 emails sent, got %d %v", sent, err)
+	}
+	msgs := mailer.Sent()
+	if msgs[0].From != "bookings@here.ca" {
+		t.Errorf("expected the email to be sent from the property's sender, got %s", msgs[0].From)
+	}
+	if msgs[1].From != "reservations@here.ca" {
+		t.Errorf("expected an email with its own sender to keep it, got %s", msgs[1].From)
+	}
+}
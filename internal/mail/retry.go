@@ -0,0 +1,182 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// DeadLetterQueue keeps the emails that could not be sent even after retrying, so they aren't lost
+type DeadLetterQueue interface {
+	Add(ctx context.Context, msg models.MailData, sendErr error) error
+}
+
+// DeadLetter is an email that could not be sent, & why
+type DeadLetter struct {
+	Msg      models.MailData `json:"msg"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// RetryMailer sends through another Mailer, retrying failed sends with exponential backoff: it waits
+// BaseDelay before the 2nd attempt, twice that before the 3rd, & so on. Once every attempt has failed,
+// the email goes to the DeadLetters queue
+type RetryMailer struct {
+	Next        Mailer
+	Attempts    int
+	BaseDelay   time.Duration
+	DeadLetters DeadLetterQueue
+
+	// sleep waits for d, or until ctx is done. Tests replace it so they don't have to wait
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryMailer creates a RetryMailer
+func NewRetryMailer(next Mailer, attempts int, baseDelay time.Duration, deadLetters DeadLetterQueue) *RetryMailer {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	return &RetryMailer{
+		Next:        next,
+		Attempts:    attempts,
+		BaseDelay:   baseDelay,
+		DeadLetters: deadLetters,
+		sleep:       sleep,
+	}
+}
+
+// Send sends msg, retrying if it fails. The error returned is the one from the last attempt
+func (m *RetryMailer) Send(ctx context.Context, msg models.MailData) error {
+	var err error
+	delay := m.BaseDelay
+
+	for attempt := 1; attempt <= m.Attempts; attempt++ {
+		if err = m.Next.Send(ctx, msg); err == nil {
+			return nil
+		}
+
+		if attempt == m.Attempts {
+			break
+		}
+
+		if sleepErr := m.sleep(ctx, delay); sleepErr != nil {
+			// the app is shutting down; the email still needs to go somewhere
+			break
+		}
+		delay *= 2
+	}
+
+	err = fmt.Errorf("giving up sending %q to %s: %w", msg.Subject, msg.To, err)
+
+	if m.DeadLetters != nil {
+		// NOTES: context.WithoutCancel() keeps the values of ctx but not its deadline, so the email is
+		// still saved even if ctx has just been cancelled
+		if dlqErr := m.DeadLetters.Add(context.WithoutCancel(ctx), msg, err); dlqErr != nil {
+			return fmt.Errorf("%w (and could not add it to the dead-letter queue: %s)", err, dlqErr)
+		}
+	}
+
+	return err
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MemoryDeadLetterQueue keeps dead letters in memory. They are lost when the app stops
+type MemoryDeadLetterQueue struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+// Add adds msg to the queue
+func (q *MemoryDeadLetterQueue) Add(ctx context.Context, msg models.MailData, sendErr error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.letters = append(q.letters, DeadLetter{Msg: msg, Error: sendErr.Error(), FailedAt: time.Now()})
+	return nil
+}
+
+// Letters returns a copy of the queue
+func (q *MemoryDeadLetterQueue) Letters() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]DeadLetter(nil), q.letters...)
+}
+
+// FileDeadLetterQueue appends dead letters to a file, one JSON object per line, so they survive restarts
+// & can be looked at or resent by hand
+type FileDeadLetterQueue struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Add appends msg to the file
+func (q *FileDeadLetterQueue) Add(ctx context.Context, msg models.MailData, sendErr error) error {
+	line, err := json.Marshal(DeadLetter{Msg: msg, Error: sendErr.Error(), FailedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(q.Path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(q.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Letters reads every dead letter in the file
+func (q *FileDeadLetterQueue) Letters() ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f, err := os.Open(q.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 10<<20)
+	for scanner.Scan() {
+		var l DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return letters, err
+		}
+		letters = append(letters, l)
+	}
+
+	return letters, scanner.Err()
+}
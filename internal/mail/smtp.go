@@ -0,0 +1,99 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	simplemail "github.com/xhit/go-simple-mail"
+)
+
+// The ways of securing the connection to an SMTP server
+const (
+	// EncryptionNone sends everything in the clear. Only use it for a mail server on the same machine
+	EncryptionNone = "none"
+	// EncryptionSTARTTLS connects in the clear, then upgrades the connection to TLS (usually port 587)
+	EncryptionSTARTTLS = "starttls"
+	// EncryptionTLS uses TLS from the start (usually port 465)
+	EncryptionTLS = "tls"
+)
+
+// SMTPConfig holds the settings for an SMTP server
+type SMTPConfig struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption string
+	// Timeout applies to connecting & to sending each email
+	Timeout time.Duration
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	cfg        SMTPConfig
+	encryption interface{}
+}
+
+// NewSMTPMailer creates an SMTPMailer, after checking its config
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	if cfg.Host == "" || cfg.Port < 1 {
+		return nil, fmt.Errorf("smtp host & port are required")
+	}
+
+	switch cfg.Encryption {
+	case "", EncryptionNone, EncryptionSTARTTLS, EncryptionTLS:
+	default:
+		return nil, fmt.Errorf("unknown smtp encryption %q, use one of none, starttls or tls", cfg.Encryption)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	return &SMTPMailer{cfg: cfg}, nil
+}
+
+// Send sends msg. Unlike the old sendMsg, a failed connection is returned as an error rather than going
+// on to send through a nil client
+func (m *SMTPMailer) Send(ctx context.Context, msg models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	email, err := compose(msg)
+	if err != nil {
+		return err
+	}
+
+	server := simplemail.NewSMTPClient()
+	server.Host = m.cfg.Host
+	server.Port = m.cfg.Port
+	server.Username = m.cfg.Username
+	server.Password = m.cfg.Password
+	server.KeepAlive = false
+	server.ConnectTimeout = m.cfg.Timeout
+	server.SendTimeout = m.cfg.Timeout
+
+	// NOTES: go-simple-mail calls STARTTLS 'TLS', & TLS from the start 'SSL'
+	switch m.cfg.Encryption {
+	case EncryptionSTARTTLS:
+		server.Encryption = simplemail.EncryptionTLS
+	case EncryptionTLS:
+		server.Encryption = simplemail.EncryptionSSL
+	default:
+		server.Encryption = simplemail.EncryptionNone
+	}
+
+	client, err := server.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to smtp server %s:%d: %w", m.cfg.Host, m.cfg.Port, err)
+	}
+
+	if err := email.Send(client); err != nil {
+		return fmt.Errorf("sending email to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
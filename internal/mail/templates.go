@@ -0,0 +1,253 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"path/filepath"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/pricing"
+)
+
+// DefaultTemplateDir is where email templates are read from
+const DefaultTemplateDir = "./email-templates"
+
+/*
+NOTES: email templates are laid out like the page templates in ./templates:
+  - <name>.email.html is one kind of email. It uses a layout & defines a "content" block for it
+  - *.layout.html are the layouts, eg basic.layout.html defines "basic"
+  - *.partial.html are bits shared between emails, eg "reservation-details"
+
+The same goes for the plain-text versions ending in .txt, which are parsed with text/template rather than
+html/template, as nothing in a plain-text email needs escaping. An email without a .email.txt gets a
+plain-text version made from its HTML.
+
+We can't use the funcs in the render package here (render imports config, which imports this package),
+so the few that emails need are repeated below. formatMoney shows prices in the Templates' Currency, so
+each Templates has funcs of its own.
+*/
+func (t *Templates) htmlFunctions() htmltemplate.FuncMap {
+	return htmltemplate.FuncMap{
+		"humanDate":   humanDate,
+		"formatMoney": t.formatMoney,
+	}
+}
+
+func (t *Templates) textFunctions() texttemplate.FuncMap {
+	return texttemplate.FuncMap{
+		"humanDate":   humanDate,
+		"formatMoney": t.formatMoney,
+	}
+}
+
+func humanDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func (t *Templates) formatMoney(cents int) string {
+	return pricing.FormatMoney(cents, t.Currency)
+}
+
+// Templates renders Messages into emails. The parsed templates are cached, like render.CreateTemplateCache
+// caches page templates
+type Templates struct {
+	Dir string
+	// UseCache false parses the templates again for every email, so changes show up without a restart
+	UseCache bool
+	// Currency is what prices are shown in, eg "eur". It is usd if empty
+	Currency string
+
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// NewTemplates parses the email templates in dir
+func NewTemplates(dir string, useCache bool) (*Templates, error) {
+	t := &Templates{Dir: dir, UseCache: useCache}
+
+	var err error
+	t.html, t.text, err = t.createTemplateCache()
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// createTemplateCache parses every <name>.email.html & <name>.email.txt in t.Dir with its layouts & partials,
+// keyed by name
+func (t *Templates) createTemplateCache() (map[string]*htmltemplate.Template, map[string]*texttemplate.Template, error) {
+	dir := t.Dir
+
+	htmlCache := map[string]*htmltemplate.Template{}
+	textCache := map[string]*texttemplate.Template{}
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.email.html"))
+	if err != nil {
+		return htmlCache, textCache, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".email.html")
+
+		ts, err := htmltemplate.New(filepath.Base(page)).Funcs(t.htmlFunctions()).ParseFiles(page)
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+
+		for _, pattern := range []string{"*.layout.html", "*.partial.html"} {
+			matches, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return htmlCache, textCache, err
+			}
+			if len(matches) > 0 {
+				ts, err = ts.ParseFiles(matches...)
+				if err != nil {
+					return htmlCache, textCache, err
+				}
+			}
+		}
+
+		htmlCache[name] = ts
+	}
+
+	pages, err = filepath.Glob(filepath.Join(dir, "*.email.txt"))
+	if err != nil {
+		return htmlCache, textCache, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".email.txt")
+
+		ts, err := texttemplate.New(filepath.Base(page)).Funcs(t.textFunctions()).ParseFiles(page)
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+
+		for _, pattern := range []string{"*.layout.txt", "*.partial.txt"} {
+			matches, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return htmlCache, textCache, err
+			}
+			if len(matches) > 0 {
+				ts, err = ts.ParseFiles(matches...)
+				if err != nil {
+					return htmlCache, textCache, err
+				}
+			}
+		}
+
+		textCache[name] = ts
+	}
+
+	return htmlCache, textCache, nil
+}
+
+// Defines tells whether there is an email template called name, & whether it defines its own subject
+func (t *Templates) Defines(name string) (found, subject bool) {
+	ht, ok := t.html[name]
+	if !ok {
+		return false, false
+	}
+	return true, ht.Lookup("subject") != nil
+}
+
+// Render renders msg into an email from 'from' to 'to', with both an HTML & a plain-text body
+func (t *Templates) Render(to, from string, msg Message) (models.MailData, error) {
+	htmlCache, textCache := t.html, t.text
+	if !t.UseCache {
+		var err error
+		htmlCache, textCache, err = t.createTemplateCache()
+		if err != nil {
+			return models.MailData{}, err
+		}
+	}
+
+	name := msg.Template()
+
+	ht, ok := htmlCache[name]
+	if !ok {
+		return models.MailData{}, fmt.Errorf("no email template %s.email.html", name)
+	}
+
+	var buf bytes.Buffer
+	if err := ht.Execute(&buf, msg); err != nil {
+		return models.MailData{}, fmt.Errorf("rendering %s email: %w", name, err)
+	}
+
+	out := models.MailData{
+		To:       to,
+		From:     from,
+		Subject:  msg.Subject(),
+		Content:  buf.String(),
+		Template: name,
+	}
+
+	if st := ht.Lookup("subject"); st != nil {
+		var subject bytes.Buffer
+		if err := st.Execute(&subject, msg); err != nil {
+			return models.MailData{}, fmt.Errorf("rendering %s email subject: %w", name, err)
+		}
+		// html/template escapes eg ' as &#39;, which is not wanted in a subject line
+		out.Subject = strings.TrimSpace(html.UnescapeString(subject.String()))
+	}
+
+	if tt, ok := textCache[name]; ok {
+		buf.Reset()
+		if err := tt.Execute(&buf, msg); err != nil {
+			return models.MailData{}, fmt.Errorf("rendering %s plain-text email: %w", name, err)
+		}
+		out.Text = strings.TrimSpace(buf.String()) + "\n"
+	} else {
+		out.Text = HTMLToText(out.Content)
+	}
+
+	return out, nil
+}
+
+var (
+	invisibleRe = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+	linkRe      = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	lineRe      = regexp.MustCompile(`(?i)<br\s*/?>|</(tr|li)>`)
+	blockRe     = regexp.MustCompile(`(?i)</(p|div|h[1-6]|table)>`)
+	tagRe       = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRe     = regexp.MustCompile(`\s+`)
+	blankRe     = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText makes a plain-text version of an HTML email, for email apps that don't show HTML. Links are
+// kept as 'text (url)' so they can still be followed
+func HTMLToText(s string) string {
+	// NOTES: like a browser, treat any run of spaces & newlines in the HTML as one space. Only tags
+	// like <br> & </p> start new lines
+	s = invisibleRe.ReplaceAllString(s, "")
+	s = spaceRe.ReplaceAllString(s, " ")
+	s = linkRe.ReplaceAllStringFunc(s, func(a string) string {
+		m := linkRe.FindStringSubmatch(a)
+		href, text := m[1], strings.TrimSpace(tagRe.ReplaceAllString(m[2], ""))
+		// links that go nowhere, or whose text is already the url, don't need the url repeated
+		if href == "" || href == "#" || strings.HasPrefix(href, "undefined") || html.UnescapeString(text) == html.UnescapeString(href) {
+			return text
+		}
+		return text + " (" + href + ")"
+	})
+	s = lineRe.ReplaceAllString(s, "\n")
+	s = blockRe.ReplaceAllString(s, "\n\n")
+	s = tagRe.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	s = strings.Join(lines, "\n")
+	s = blankRe.ReplaceAllString(s, "\n\n")
+
+	return strings.TrimSpace(s) + "\n"
+}
@@ -0,0 +1,221 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+var testReservation = models.Reservation{
+	ID:         1,
+	FirstName:  "<script>alert('hi')</script>",
+	LastName:   "Smith",
+	Email:      "john@smith.com",
+	StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	TotalPrice: 24000,
+	Room:       models.Room{ID: 1, RoomName: "General's Quarters"},
+	Adults:     2,
+	Children:   1,
+}
+
+// testBooking is a booking of two rooms by the guest of testReservation
+var testBooking = models.Booking{
+	ID:         1,
+	FirstName:  testReservation.FirstName,
+	LastName:   testReservation.LastName,
+	Email:      testReservation.Email,
+	TotalPrice: 48000,
+	Reservations: []models.Reservation{
+		testReservation,
+		{ID: 2, StartDate: testReservation.StartDate, EndDate: testReservation.EndDate, TotalPrice: 24000, Room: models.Room{ID: 2, RoomName: "Major's Suite"}},
+	},
+}
+
+var testManageURLs = []string{"http://x/abc", "http://x/def"}
+
+func TestTemplates_Render(t *testing.T) {
+	templates, err := NewTemplates("./../../email-templates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []Message{
+		Confirmation{Reservation: testReservation, ManageURL: "http://localhost:8080/reservations/manage/abc"},
+		BookingConfirmation{Booking: testBooking, ManageURLs: testManageURLs},
+		OwnerNotification{Reservation: testReservation, OwnerName: "Owner"},
+		Cancellation{Reservation: testReservation},
+		DatesChanged{Reservation: testReservation, ManageURL: "http://localhost:8080/reservations/manage/abc"},
+		Reminder{Reservation: testReservation, ManageURL: "http://localhost:8080/reservations/manage/abc"},
+		Reminder{Name: "thank-you", Reservation: testReservation, PropertyName: "Gus's Hotel"},
+	}
+
+	for _, msg := range msgs {
+		out, err := templates.Render("john@smith.com", "me@here.ca", msg)
+		if err != nil {
+			t.Errorf("%s: %s", msg.Template(), err)
+			continue
+		}
+
+		if out.Subject == "" || out.Template != msg.Template() || out.To != "john@smith.com" {
+			t.Errorf("%s: unexpected headers %+v", msg.Template(), out)
+		}
+		if strings.Contains(out.Content, "<script>") {
+			t.Errorf("%s: guest name was not escaped in the HTML", msg.Template())
+		}
+		if !strings.Contains(out.Content, "&lt;script&gt;") {
+			t.Errorf("%s: guest name is missing from the HTML", msg.Template())
+		}
+		if msg.Template() != "thank-you" && !strings.Contains(out.Text, "2050-01-01") {
+			t.Errorf("%s: plain text is missing the arrival date:\n%s", msg.Template(), out.Text)
+		}
+		if strings.Contains(out.Text, "<") && !strings.Contains(out.Text, "<script>") {
+			t.Errorf("%s: plain text still has HTML in it:\n%s", msg.Template(), out.Text)
+		}
+	}
+}
+
+func TestTemplates_RenderText(t *testing.T) {
+	templates, err := NewTemplates("./../../email-templates", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirmation has a hand-written .email.txt, which is not escaped as it is not HTML
+	out, err := templates.Render("john@smith.com", "me@here.ca", Confirmation{Reservation: testReservation, ManageURL: "http://x/abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.Text, "Dear <script>alert('hi')</script>,") || !strings.Contains(out.Text, "Total:     $240.00") ||
+		!strings.Contains(out.Text, "Guests:    2 adults, 1 child") {
+		t.Errorf("unexpected plain text:\n%s", out.Text)
+	}
+
+	// a booking confirmation lists every room, each with its own link
+	out, err = templates.Render("john@smith.com", "me@here.ca", BookingConfirmation{Booking: testBooking, ManageURLs: testManageURLs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"booking of 2 rooms", "Room:      Major's Suite", "this room here: http://x/def", "Total for your booking: $480.00"} {
+		if !strings.Contains(out.Text, want) {
+			t.Errorf("expected %q in plain text:\n%s", want, out.Text)
+		}
+	}
+
+	// reminder has no .email.txt, so its plain text is made from the HTML, keeping its link
+	out, err = templates.Render("john@smith.com", "me@here.ca", Reminder{Reservation: testReservation, ManageURL: "http://x/abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.Text, "You can view your reservation here: http://x/abc") {
+		t.Errorf("unexpected plain text:\n%s", out.Text)
+	}
+}
+
+func TestTemplates_RenderCurrency(t *testing.T) {
+	templates, err := NewTemplates("./../../email-templates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates.Currency = "eur"
+
+	out, err := templates.Render("john@smith.com", "me@here.ca", BookingConfirmation{Booking: testBooking, ManageURLs: testManageURLs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.Content, "€480.00") || !strings.Contains(out.Text, "Total for your booking: €480.00") {
+		t.Errorf("expected the total in euros:\n%s", out.Text)
+	}
+	if strings.Contains(out.Content, "$") || strings.Contains(out.Text, "$") {
+		t.Errorf("expected no dollars in an email in euros:\n%s", out.Text)
+	}
+}
+
+func TestTemplates_RenderSubject(t *testing.T) {
+	templates, err := NewTemplates("./../../email-templates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the thank-you template defines its own subject, which is not HTML escaped
+	out, err := templates.Render("john@smith.com", "me@here.ca", Reminder{Name: "thank-you", Reservation: testReservation, PropertyName: "Gus's Hotel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Subject != "Thank you for staying with us at Gus's Hotel" {
+		t.Errorf("unexpected subject %q", out.Subject)
+	}
+
+	// so does the reminder template, rather than getting the message's
+	out, err = templates.Render("john@smith.com", "me@here.ca", Reminder{Reservation: testReservation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Subject != "Your Stay Is Coming Up" {
+		t.Errorf("unexpected subject %q", out.Subject)
+	}
+
+	// the cancellation template doesn't, so it gets the message's
+	out, err = templates.Render("john@smith.com", "me@here.ca", Cancellation{Reservation: testReservation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Subject != (Cancellation{}).Subject() {
+		t.Errorf("unexpected subject %q", out.Subject)
+	}
+}
+
+func TestTemplates_Defines(t *testing.T) {
+	templates, err := NewTemplates("./../../email-templates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		found   bool
+		subject bool
+	}{
+		{"thank-you", true, true},
+		{"cancellation", true, false},
+		{"thank-yuo", false, false},
+	}
+	for _, e := range tests {
+		if found, subject := templates.Defines(e.name); found != e.found || subject != e.subject {
+			t.Errorf("%s: expected %v %v, got %v %v", e.name, e.found, e.subject, found, subject)
+		}
+	}
+}
+
+type unknownMessage struct{}
+
+func (unknownMessage) Template() string { return "unknown" }
+func (unknownMessage) Subject() string  { return "Unknown" }
+
+func TestTemplates_RenderUnknown(t *testing.T) {
+	templates, err := NewTemplates("./../../email-templates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := templates.Render("john@smith.com", "me@here.ca", unknownMessage{}); err == nil {
+		t.Error("expected an error for a message without a template")
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	in := `<html><head><style>p { color: red; }</style></head><body>
+		<p><strong>Hello</strong>
+		there</p>
+		<p>Line one<br>Line &amp; two</p>
+		<p><a href="https://example.com/x">your reservation</a> <a href="#">Unsubscribe</a></p>
+	</body></html>`
+
+	want := "Hello there\n\nLine one\nLine & two\n\nyour reservation (https://example.com/x) Unsubscribe\n"
+
+	if got := HTMLToText(in); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
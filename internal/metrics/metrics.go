@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// namespace starts the name of every metric, so ours are easy to tell apart from other apps' in Prometheus
+const namespace = "bookings"
+
+// buckets are the upper bounds, in seconds, of the request latency histogram. They are Prometheus' defaults
+var buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics counts what the app does, & writes it out in the Prometheus text format for /metrics.
+// NOTES: this is a small hand-written version of what the Prometheus client library does, since we only need
+// counters & one histogram. All its methods are safe to call on a nil *Metrics, which just records nothing,
+// so code (& tests) that don't set up metrics don't need to check for them
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]int64
+	latencies map[latencyKey]*histogram
+
+	mailSent            atomic.Int64
+	mailFailed          atomic.Int64
+	reservationsCreated atomic.Int64
+}
+
+type requestKey struct {
+	method, route string
+	code          int
+}
+
+type latencyKey struct {
+	method, route string
+}
+
+type histogram struct {
+	counts []int64 // one per bucket, plus the last for +Inf
+	sum    float64
+	count  int64
+}
+
+// New creates a Metrics
+func New() *Metrics {
+	return &Metrics{
+		requests:  map[requestKey]int64{},
+		latencies: map[latencyKey]*histogram{},
+	}
+}
+
+// Middleware records the count & latency of every request, by its chi route pattern, eg /rooms/{id}.
+// Using the pattern rather than the URL keeps the number of series small, however many rooms there are
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// chi only knows the route pattern once it has routed the request, ie after next has run
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+
+		m.observe(r.Method, route, code, time.Since(start))
+	})
+}
+
+func (m *Metrics) observe(method, route string, code int, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{method, route, code}]++
+
+	h, ok := m.latencies[latencyKey{method, route}]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(buckets)+1)}
+		m.latencies[latencyKey{method, route}] = h
+	}
+	seconds := took.Seconds()
+	i := sort.SearchFloat64s(buckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// ReservationCreated counts a new reservation
+func (m *Metrics) ReservationCreated() {
+	if m != nil {
+		m.reservationsCreated.Add(1)
+	}
+}
+
+// Mailer wraps next to count the emails it sends, & those it fails to send
+func (m *Metrics) Mailer(next mail.Mailer) mail.Mailer {
+	return &countingMailer{next: next, metrics: m}
+}
+
+type countingMailer struct {
+	next    mail.Mailer
+	metrics *Metrics
+}
+
+func (c *countingMailer) Send(ctx context.Context, msg models.MailData) error {
+	err := c.next.Send(ctx, msg)
+	if c.metrics != nil {
+		if err != nil {
+			c.metrics.mailFailed.Add(1)
+		} else {
+			c.metrics.mailSent.Add(1)
+		}
+	}
+	return err
+}
+
+// Write writes every metric, & the DB pool's stats, in the Prometheus text format
+func (m *Metrics) Write(w io.Writer, db sql.DBStats) error {
+	var b strings.Builder
+
+	if m != nil {
+		m.writeRequests(&b)
+
+		counter(&b, "mail_sent_total", "Emails sent.", m.mailSent.Load())
+		counter(&b, "mail_failed_total", "Emails that could not be sent, after retrying.", m.mailFailed.Load())
+		counter(&b, "reservations_created_total", "Reservations made by guests, through the site or the API.", m.reservationsCreated.Load())
+	}
+
+	gauge(&b, "db_max_open_connections", "Most database connections the pool may open.", int64(db.MaxOpenConnections))
+	gauge(&b, "db_open_connections", "Database connections open, in use or idle.", int64(db.OpenConnections))
+	gauge(&b, "db_in_use_connections", "Database connections in use.", int64(db.InUse))
+	gauge(&b, "db_idle_connections", "Idle database connections.", int64(db.Idle))
+	counter(&b, "db_wait_count_total", "Times a query had to wait for a free database connection.", db.WaitCount)
+	header(&b, "db_wait_duration_seconds_total", "Time spent waiting for a free database connection.", "counter")
+	fmt.Fprintf(&b, "%s_db_wait_duration_seconds_total %s\n", namespace, formatFloat(db.WaitDuration.Seconds()))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (m *Metrics) writeRequests(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// NOTES: go randomises the order of maps, so the keys are sorted to keep the output stable
+	requestKeys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+
+	header(b, "http_requests_total", "Requests served, by method, chi route pattern & status code.", "counter")
+	for _, k := range requestKeys {
+		fmt.Fprintf(b, "%s_http_requests_total{method=%s,route=%s,code=\"%d\"} %d\n",
+			namespace, quote(k.method), quote(k.route), k.code, m.requests[k])
+	}
+
+	latencyKeys := make([]latencyKey, 0, len(m.latencies))
+	for k := range m.latencies {
+		latencyKeys = append(latencyKeys, k)
+	}
+	sort.Slice(latencyKeys, func(i, j int) bool {
+		if latencyKeys[i].route != latencyKeys[j].route {
+			return latencyKeys[i].route < latencyKeys[j].route
+		}
+		return latencyKeys[i].method < latencyKeys[j].method
+	})
+
+	header(b, "http_request_duration_seconds", "How long requests took, by method & chi route pattern.", "histogram")
+	for _, k := range latencyKeys {
+		h := m.latencies[k]
+		labels := fmt.Sprintf("method=%s,route=%s", quote(k.method), quote(k.route))
+
+		// Prometheus buckets are cumulative: each one counts every request up to its bound
+		var cumulative int64
+		for i, bound := range buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "%s_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", namespace, labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(b, "%s_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", namespace, labels, h.count)
+		fmt.Fprintf(b, "%s_http_request_duration_seconds_sum{%s} %s\n", namespace, labels, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_http_request_duration_seconds_count{%s} %d\n", namespace, labels, h.count)
+	}
+}
+
+func header(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s_%s %s\n# TYPE %s_%s %s\n", namespace, name, help, namespace, name, kind)
+}
+
+func counter(b *strings.Builder, name, help string, value int64) {
+	header(b, name, help, "counter")
+	fmt.Fprintf(b, "%s_%s %d\n", namespace, name, value)
+}
+
+func gauge(b *strings.Builder, name, help string, value int64) {
+	header(b, name, help, "gauge")
+	fmt.Fprintf(b, "%s_%s %d\n", namespace, name, value)
+}
+
+// labelEscaper escapes the only characters Prometheus doesn't allow as they are in a label value
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote escapes & quotes a label value
+func quote(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg models.MailData) error {
+	return errors.New("connection refused")
+}
+
+type okMailer struct{}
+
+func (okMailer) Send(ctx context.Context, msg models.MailData) error { return nil }
+
+func write(t *testing.T, m *Metrics, stats sql.DBStats) string {
+	var b strings.Builder
+	if err := m.Write(&b, stats); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestMetrics_Mailer(t *testing.T) {
+	m := New()
+	m.Mailer(okMailer{}).Send(context.Background(), models.MailData{})
+	m.Mailer(okMailer{}).Send(context.Background(), models.MailData{})
+	if err := m.Mailer(failingMailer{}).Send(context.Background(), models.MailData{}); err == nil {
+		t.Error("expected the send error to be passed on")
+	}
+
+	out := write(t, m, sql.DBStats{})
+	if !strings.Contains(out, "bookings_mail_sent_total 2\n") || !strings.Contains(out, "bookings_mail_failed_total 1\n") {
+		t.Errorf("unexpected mail metrics:\n%s", out)
+	}
+}
+
+func TestMetrics_Histogram(t *testing.T) {
+	m := New()
+	m.observe("GET", "/", 200, 3*time.Millisecond)
+	m.observe("GET", "/", 200, 30*time.Millisecond)
+	m.observe("GET", "/", 500, 20*time.Second)
+
+	out := write(t, m, sql.DBStats{OpenConnections: 3, InUse: 1, Idle: 2, WaitDuration: 1500 * time.Millisecond})
+	for _, want := range []string{
+		`bookings_http_requests_total{method="GET",route="/",code="200"} 2`,
+		`bookings_http_requests_total{method="GET",route="/",code="500"} 1`,
+		// buckets are cumulative
+		`bookings_http_request_duration_seconds_bucket{method="GET",route="/",le="0.005"} 1`,
+		`bookings_http_request_duration_seconds_bucket{method="GET",route="/",le="0.05"} 2`,
+		`bookings_http_request_duration_seconds_bucket{method="GET",route="/",le="10"} 2`,
+		`bookings_http_request_duration_seconds_bucket{method="GET",route="/",le="+Inf"} 3`,
+		`bookings_http_request_duration_seconds_count{method="GET",route="/"} 3`,
+		"bookings_db_open_connections 3",
+		"bookings_db_in_use_connections 1",
+		"bookings_db_wait_duration_seconds_total 1.5",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	// a nil *Metrics records nothing, but doesn't panic either
+	var m *Metrics
+	m.ReservationCreated()
+	if err := m.Mailer(okMailer{}).Send(context.Background(), models.MailData{}); err != nil {
+		t.Error(err)
+	}
+	if out := write(t, m, sql.DBStats{}); !strings.Contains(out, "bookings_db_open_connections 0") {
+		t.Errorf("expected the DB stats, got:\n%s", out)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := quote("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("unexpected label value %s", got)
+	}
+}
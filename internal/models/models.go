@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -28,12 +29,59 @@ type User struct {
 
 // Room is the room model
 type Room struct {
-	ID         int
-	RoomName   string
+	ID       int
+	RoomName string
+	// Slug is the room's name in its URL, eg /rooms/generals-quarters
+	Slug        string
+	Description string
+	// Capacity is the most guests the room sleeps
+	Capacity int
+	// Amenities are listed on the room's page, eg 'Ocean view'
+	Amenities []string
+	// Photos are in the order they are shown in
+	Photos []RoomPhoto
+	// SortOrder is where the room is listed on the site, lowest first
+	SortOrder int
+	// ArchivedAt is when the room was taken off the site, or zero. Archived rooms keep their reservations,
+	// but can't be booked any more
+	ArchivedAt time.Time
+	// OwnerName & OwnerEmail, if set, get the notifications for this room instead of the property's owner
+	OwnerName  string
+	OwnerEmail string
+	Created_at time.Time
+	Updated_at time.Time
+}
+
+// Archived checks if the room has been taken off the site
+func (r Room) Archived() bool {
+	return !r.ArchivedAt.IsZero()
+}
+
+// RoomPhoto is a photo of a room. Path is where it is served from, eg /static/images/generals-quarters.png
+type RoomPhoto struct {
+	ID     int
+	RoomID int
+	// Path is the photo at the size shown on the room's page, & ThumbnailPath a small copy for lists. Photos
+	// from before uploads have no thumbnail
+	Path          string
+	ThumbnailPath string
+	Caption       string
+	// StorageKey is where an uploaded photo's files are kept in the photo storage, or empty for photos that
+	// are part of the site, eg under /static/images
+	StorageKey string
+	SortOrder  int
 	Created_at time.Time
 	Updated_at time.Time
 }
 
+// Thumbnail is where the photo's thumbnail is served from, or the photo itself if it has none
+func (p RoomPhoto) Thumbnail() string {
+	if p.ThumbnailPath != "" {
+		return p.ThumbnailPath
+	}
+	return p.Path
+}
+
 // Room is the room model
 type Restriction struct {
 	ID              int
@@ -55,7 +103,142 @@ type Reservation struct {
 	Created_at time.Time
 	Updated_at time.Time
 	Room       Room
-	Processed  int
+	// TotalPrice is the price of the whole stay in cents, as quoted when the reservation was made
+	TotalPrice int
+	// Quote holds the per-night breakdown of TotalPrice. It is not stored in the DB
+	Quote Quote
+	// BookingID is the booking the reservation is one of the rooms of, or 0 if it was booked on its own
+	BookingID int
+	// Adults & Children are how many guests are staying in the room. Together they can't be more than
+	// the room's Capacity
+	Adults   int
+	Children int
+	// Status is where the reservation is in its lifecycle, eg 'confirmed'. See CanChangeStatus
+	Status string
+}
+
+// Guests is how many people are staying in the reservation's room
+func (r Reservation) Guests() int {
+	return r.Adults + r.Children
+}
+
+// GuestsSummary describes who is staying in the reservation's room, eg '2 adults, 1 child'
+func (r Reservation) GuestsSummary() string {
+	s := fmt.Sprintf("%d adult", r.Adults)
+	if r.Adults != 1 {
+		s += "s"
+	}
+	switch {
+	case r.Children == 1:
+		s += ", 1 child"
+	case r.Children > 1:
+		s += fmt.Sprintf(", %d children", r.Children)
+	}
+	return s
+}
+
+// The states a reservation goes through. New reservations are pending until staff confirm them. Cancelled
+// reservations are kept, with their history, but no longer hold their room
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCheckedIn  = "checked-in"
+	ReservationCheckedOut = "checked-out"
+	ReservationCancelled  = "cancelled"
+	ReservationNoShow     = "no-show"
+)
+
+// ReservationStatuses are all the states, in the order they are listed in, eg for filtering the admin lists
+var ReservationStatuses = []string{
+	ReservationPending,
+	ReservationConfirmed,
+	ReservationCheckedIn,
+	ReservationCheckedOut,
+	ReservationCancelled,
+	ReservationNoShow,
+}
+
+// reservationTransitions are the statuses a reservation can move to from each status. Checked-out, cancelled
+// & no-show reservations are finished, so they can't move at all
+var reservationTransitions = map[string][]string{
+	ReservationPending:   {ReservationConfirmed, ReservationCancelled},
+	ReservationConfirmed: {ReservationCheckedIn, ReservationCancelled, ReservationNoShow},
+	ReservationCheckedIn: {ReservationCheckedOut},
+}
+
+// CanChangeStatus checks if a reservation is allowed to move from one status to another
+func CanChangeStatus(from, to string) bool {
+	for _, s := range reservationTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStatuses are the statuses the reservation can move to from its current one
+func (r Reservation) NextStatuses() []string {
+	return reservationTransitions[r.Status]
+}
+
+// ReservationStatusChange is one move of a reservation from one status to another. They are never changed or
+// deleted, so together they are the reservation's history
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    string
+	ToStatus      string
+	// UserID is the member of staff who made the change, or 0 if it was made by the guest or through the API
+	UserID int
+	// UserName is the name of that member of staff, when the changes are listed
+	UserName string
+	// Source is where the change was made, one of the StatusChangedBy constants
+	Source     string
+	Reason     string
+	Created_at time.Time
+}
+
+// Where a reservation's status can be changed from
+const (
+	StatusChangedByAdmin = "admin"
+	StatusChangedByGuest = "guest"
+	StatusChangedByAPI   = "api"
+	// StatusChangedByPayment is a reservation confirmed by its guest paying their deposit
+	StatusChangedByPayment = "payment"
+)
+
+// Booking is what a guest books in one go, eg two rooms for a family. Each room is one of its reservations,
+// with its own dates, price & room restriction, so it can be changed or cancelled like any other reservation
+type Booking struct {
+	ID        int
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	// TotalPrice is the price of all its reservations in cents
+	TotalPrice   int
+	Reservations []Reservation
+	Created_at   time.Time
+	Updated_at   time.Time
+}
+
+// Deposit is how much the guest pays for all the booking's rooms when they book it
+func (b Booking) Deposit() int {
+	deposit := 0
+	for _, res := range b.Reservations {
+		deposit += res.Quote.Deposit
+	}
+	return deposit
+}
+
+// HasRoom checks if the booking already has the room for any of the nights from start to end
+func (b Booking) HasRoom(roomID int, start, end time.Time) bool {
+	for _, res := range b.Reservations {
+		if res.RoomId == roomID && start.Before(res.EndDate) && end.After(res.StartDate) {
+			return true
+		}
+	}
+	return false
 }
 
 // RoomRestriction is the RoomRestriction model
@@ -66,19 +249,271 @@ type RoomRestriction struct {
 	RoomId        int
 	ReservationID int
 	RestrictionID int
-	Created_at    time.Time
-	Updated_at    time.Time
-	Room          Room
+	// ExternalUID is the iCal UID of a block imported from an OTA's calendar. It is empty for our own restrictions
+	ExternalUID string
+	Created_at  time.Time
+	Updated_at  time.Time
+	Room        Room
 	// DOC: we may not need these, but we place them here in case we need them
 	Reservation Reservation
 	Restriction Restriction
 }
 
+// RoomRate is the RoomRate model. All prices are in cents
+type RoomRate struct {
+	ID     int
+	RoomId int
+	// BaseRate is the price of one night when no season applies
+	BaseRate int
+	// WeekendUplift is the percentage added to the nightly rate for Friday & Saturday nights
+	WeekendUplift int
+	// MinStay is the minimum number of nights that can be booked
+	MinStay    int
+	Created_at time.Time
+	Updated_at time.Time
+	// DepositPercent is how much of the price guests pay when they book. 0 means they pay nothing up front
+	DepositPercent int
+}
+
+// SeasonalRate is the SeasonalRate model. It overrides the base rate of a room
+// from StartDate to EndDate (both included)
+type SeasonalRate struct {
+	ID          int
+	RoomId      int
+	SeasonName  string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+	// MinStay, if > 0, overrides the room's minimum stay for arrivals in this season
+	MinStay    int
+	Created_at time.Time
+	Updated_at time.Time
+	// DepositPercent, if > 0, overrides the room's deposit for arrivals in this season
+	DepositPercent int
+}
+
+// NightlyPrice is the price of one night of a stay
+type NightlyPrice struct {
+	Date       time.Time
+	Rate       int
+	SeasonName string
+	Weekend    bool
+}
+
+// Quote is the price of a stay in a room, night by night
+type Quote struct {
+	RoomId    int
+	StartDate time.Time
+	EndDate   time.Time
+	Nights    []NightlyPrice
+	Total     int
+	// DepositPercent is the part of Total the guest pays when they book, & Deposit how much that is
+	DepositPercent int
+	Deposit        int
+}
+
+// RoomCalendar is the RoomCalendar model. It holds a room's iCal settings: the secret token in the URL
+// of its export feed, & the URL of an OTA calendar to import blocks from
+type RoomCalendar struct {
+	ID          int
+	RoomId      int
+	ExportToken string
+	ImportURL   string
+	// LastImportAt is the zero time if the import has never run
+	LastImportAt time.Time
+	Created_at   time.Time
+	Updated_at   time.Time
+	Room         Room
+}
+
+// APIKey is the APIKey model. Only the hash of a key is stored, never the key itself
+type APIKey struct {
+	ID      int
+	UserID  int
+	Name    string
+	Prefix  string
+	KeyHash string
+	Scopes  []string
+	// RevokedAt is the zero time for keys that are still active
+	RevokedAt  time.Time
+	Created_at time.Time
+	Updated_at time.Time
+}
+
+// Property is the property model. It holds who owns the hotel & which address its emails are sent from
+type Property struct {
+	ID          int
+	Name        string
+	OwnerName   string
+	OwnerEmail  string
+	SenderEmail string
+	Created_at  time.Time
+	Updated_at  time.Time
+}
+
+// PlaceholderSender is the sender a new install starts with. No email is sent until an admin replaces it
+const PlaceholderSender = "noreply@example.com"
+
+// HasSender tells whether an admin has set the address the property's emails are sent from
+func (p Property) HasSender() bool {
+	return p.SenderEmail != "" && p.SenderEmail != PlaceholderSender
+}
+
 // MailData holds an email message
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
+	To      string
+	From    string
+	Subject string
+	// Content is the HTML body & Text the plain-text version of it
+	Content string
+	Text    string
+	// Template is the name of the email template the message was rendered from, eg 'confirmation'
 	Template string
 }
+
+// The states an email in the outbox goes through. Emails start out pending, are marked sending while a
+// worker has them, & end up sent or failed. Admins can resend failed emails, which makes them pending again
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxEmail is an email waiting in (or sent from) the email_outbox table
+type OutboxEmail struct {
+	ID  int
+	Msg MailData
+	// RequestID is the ID of the request that queued the email, if any, for tracing it in the logs
+	RequestID string
+	Status    string
+	Attempts  int
+	LastError string
+	// SentAt is the zero time for emails that have not been sent
+	SentAt     time.Time
+	Created_at time.Time
+	Updated_at time.Time
+}
+
+// AuditEvent is one change made to the data, eg a reservation being cancelled, with who made it & from where.
+// Every change made through the repository is recorded, in the same transaction as the change itself
+type AuditEvent struct {
+	ID int
+	// UserID is the member of staff who made the change, & UserName their name. APIKeyID is the API key it
+	// was made with. Both are 0 for changes made by guests or by the app itself, eg importing calendars
+	UserID   int
+	UserName string
+	APIKeyID int
+	// Action is what was done, eg 'create', 'update', 'delete' or 'change-status'
+	Action string
+	// Entity is the table that was changed, eg 'reservations', & EntityID the changed row
+	Entity   string
+	EntityID int
+	// Changes are the columns that changed, by name
+	Changes    map[string]AuditChange
+	IP         string
+	RequestID  string
+	Created_at time.Time
+}
+
+// Actor describes who made the change, for when there is no member of staff to name
+func (e AuditEvent) Actor() string {
+	switch {
+	case e.UserName != "":
+		return e.UserName
+	case e.APIKeyID != 0:
+		return fmt.Sprintf("API key #%d", e.APIKeyID)
+	case e.IP != "":
+		return "guest"
+	default:
+		return "system"
+	}
+}
+
+// AuditChange is the old & new value of one column. Before is nil for a new row & After for a deleted one
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// String shows the change as 'before → after', eg in the admin pages
+func (c AuditChange) String() string {
+	return fmt.Sprintf("%s → %s", auditValue(c.Before), auditValue(c.After))
+}
+
+func auditValue(v any) string {
+	if v == nil {
+		return "(none)"
+	}
+	return fmt.Sprint(v)
+}
+
+// AuditFilter picks which audit events to list. Empty fields match every event
+type AuditFilter struct {
+	// Search is looked for in the action, entity, IP, changes & name of who made the change
+	Search   string
+	Entity   string
+	EntityID int
+	// From & To are the first & last days to list events of
+	From time.Time
+	To   time.Time
+	// Limit is the most events to return, or 0 for all of them
+	Limit int
+}
+
+// Payment is the part of a payment taken through the payment provider that was for one reservation. A guest
+// booking several rooms pays for them all at once, so their payments share the provider's IntentID
+type Payment struct {
+	ID            int
+	ReservationID int
+	// Provider is the name of the payments.Provider the payment was taken with, eg 'fake'
+	Provider string
+	IntentID string
+	// Amount & Refunded are in cents
+	Amount     int
+	Refunded   int
+	Status     string
+	Created_at time.Time
+	Updated_at time.Time
+}
+
+// the states a payment can be in
+const (
+	// PaymentPending is a payment the guest has not made yet
+	PaymentPending = "pending"
+	// PaymentAuthorized is a payment whose money is held for us, but not taken yet
+	PaymentAuthorized = "authorized"
+	PaymentSucceeded  = "succeeded"
+	PaymentFailed     = "failed"
+	// PaymentRefunded is a payment that has been given back in full
+	PaymentRefunded = "refunded"
+)
+
+// paymentTransitions are the statuses a payment can move to from each status. A failed payment can still
+// succeed, eg when the guest tries another card
+var paymentTransitions = map[string][]string{
+	PaymentPending:    {PaymentAuthorized, PaymentSucceeded, PaymentFailed},
+	PaymentFailed:     {PaymentAuthorized, PaymentSucceeded},
+	PaymentAuthorized: {PaymentSucceeded, PaymentFailed},
+	PaymentSucceeded:  {PaymentRefunded},
+}
+
+// CanChangePaymentStatus checks if a payment is allowed to move from one status to another. Payment
+// providers can send their webhooks more than once & out of order, so this stops eg a late 'authorized'
+// from undoing a 'succeeded'
+func CanChangePaymentStatus(from, to string) bool {
+	for _, s := range paymentTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Refundable is how much of the payment can still be given back
+func (p Payment) Refundable() int {
+	if p.Status != PaymentSucceeded {
+		return 0
+	}
+	return p.Amount - p.Refunded
+}
@@ -27,4 +27,8 @@ type TemplateData struct {
 	// but if IsAuthenticated == 0, then the user is logged out.We will therefore update this in the backend
 	//whenever we login/logut a user.
 	IsAuthenticated int
+
+	// Role is the logged in user's role (eg 'front-desk', 'manager' or 'owner'), so that views can hide
+	// anything the user is not allowed to do. Check it with the 'atLeast' template func
+	Role string
 }
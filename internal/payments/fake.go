@@ -0,0 +1,193 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the statuses of a FakeIntent
+const (
+	FakeRequiresPayment = "requires_payment"
+	FakeAuthorized      = "authorized"
+	FakeSucceeded       = "succeeded"
+	FakeFailed          = "failed"
+	FakeRefunded        = "refunded"
+)
+
+// FakeIntent is a payment made with the FakeProvider
+type FakeIntent struct {
+	ID          string
+	Amount      int
+	Refunded    int
+	Currency    string
+	Description string
+	ReturnURL   string
+	Status      string
+}
+
+// FakeProvider takes pretend payments, kept in memory, for tests & local development. Guests "pay" on the
+// app's own fake checkout page, which signs its webhooks with Secret just like a real gateway would
+type FakeProvider struct {
+	Secret []byte
+	// CheckoutURL is where the fake checkout page is, eg http://localhost:8080/payments/fake. The intent's
+	// ID is added to it
+	CheckoutURL string
+
+	mu      sync.Mutex
+	intents map[string]*FakeIntent
+	next    int
+}
+
+// NewFakeProvider creates a FakeProvider
+func NewFakeProvider(secret []byte, checkoutURL string) *FakeProvider {
+	return &FakeProvider{
+		Secret:      secret,
+		CheckoutURL: strings.TrimSuffix(checkoutURL, "/"),
+		intents:     make(map[string]*FakeIntent),
+	}
+}
+
+// Name is "fake"
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// CreateIntent starts a payment for the fake checkout page
+func (p *FakeProvider) CreateIntent(ctx context.Context, req IntentRequest) (Intent, error) {
+	if req.Amount <= 0 {
+		return Intent{}, errors.New("the amount of a payment must be more than 0")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.next++
+	intent := &FakeIntent{
+		ID:          fmt.Sprintf("fake_pi_%d", p.next),
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Description: req.Description,
+		ReturnURL:   req.ReturnURL,
+		Status:      FakeRequiresPayment,
+	}
+	p.intents[intent.ID] = intent
+
+	return Intent{ID: intent.ID, CheckoutURL: p.CheckoutURL + "/" + intent.ID}, nil
+}
+
+// Capture takes the money held for an authorized intent
+func (p *FakeProvider) Capture(ctx context.Context, intentID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return ErrUnknownIntent
+	}
+	if intent.Status != FakeAuthorized {
+		return fmt.Errorf("can't capture a payment that is %s", intent.Status)
+	}
+
+	intent.Status = FakeSucceeded
+	return nil
+}
+
+// Refund gives back some or all of a paid intent
+func (p *FakeProvider) Refund(ctx context.Context, intentID string, amount int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return ErrUnknownIntent
+	}
+	if intent.Status != FakeSucceeded {
+		return fmt.Errorf("can't refund a payment that is %s", intent.Status)
+	}
+	if amount <= 0 || amount > intent.Amount-intent.Refunded {
+		return fmt.Errorf("can't refund %d of a payment of %d, of which %d has been refunded already",
+			amount, intent.Amount, intent.Refunded)
+	}
+
+	intent.Refunded += amount
+	if intent.Refunded == intent.Amount {
+		intent.Status = FakeRefunded
+	}
+	return nil
+}
+
+// VerifyWebhook checks a webhook sent by Complete
+func (p *FakeProvider) VerifyWebhook(payload []byte, header http.Header) (Event, error) {
+	var event Event
+
+	if err := VerifySignature(p.Secret, payload, header.Get(SignatureHeader), time.Now()); err != nil {
+		return event, err
+	}
+
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	return event, nil
+}
+
+// Intent returns a copy of an intent, eg for the fake checkout page to show
+func (p *FakeProvider) Intent(id string) (FakeIntent, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[id]
+	if !ok {
+		return FakeIntent{}, false
+	}
+	return *intent, true
+}
+
+// Complete is the guest paying for an intent (or their payment failing), with eventType one of EventAuthorized,
+// EventSucceeded or EventFailed. It returns the signed webhook a real gateway would send us about it
+func (p *FakeProvider) Complete(id, eventType string) ([]byte, http.Header, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[id]
+	if !ok {
+		return nil, nil, ErrUnknownIntent
+	}
+	// NOTES: a failed payment can be tried again, as with a real gateway when the guest uses another card
+	if intent.Status != FakeRequiresPayment && intent.Status != FakeFailed {
+		return nil, nil, fmt.Errorf("can't pay for a payment that is %s", intent.Status)
+	}
+
+	switch eventType {
+	case EventAuthorized:
+		intent.Status = FakeAuthorized
+	case EventSucceeded:
+		intent.Status = FakeSucceeded
+	case EventFailed:
+		intent.Status = FakeFailed
+	default:
+		return nil, nil, fmt.Errorf("unknown payment event %q", eventType)
+	}
+
+	p.next++
+	payload, err := json.Marshal(Event{
+		ID:       fmt.Sprintf("fake_evt_%d", p.next),
+		Type:     eventType,
+		IntentID: intent.ID,
+		Amount:   intent.Amount,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make(http.Header)
+	header.Set(SignatureHeader, Sign(p.Secret, payload, time.Now()))
+
+	return payload, header, nil
+}
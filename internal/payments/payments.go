@@ -0,0 +1,128 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider takes payments through a payment gateway, eg Stripe. The app only talks to a Provider, so the
+// gateway can be changed in one place, & tests & local development can use the FakeProvider
+type Provider interface {
+	// Name is kept with every payment, eg "fake", so each payment is refunded through the gateway that took it
+	Name() string
+	// CreateIntent starts a payment. The guest pays on the intent's CheckoutURL & the gateway then tells us
+	// how it went through the webhook
+	CreateIntent(ctx context.Context, req IntentRequest) (Intent, error)
+	// Capture takes the money held for an authorized intent
+	Capture(ctx context.Context, intentID string) error
+	// Refund gives amount cents of a paid intent back to the guest
+	Refund(ctx context.Context, intentID string, amount int) error
+	// VerifyWebhook checks that a webhook really came from the gateway & returns the event in it. It returns
+	// ErrInvalidSignature if it didn't
+	VerifyWebhook(payload []byte, header http.Header) (Event, error)
+}
+
+// IntentRequest is a payment to start
+type IntentRequest struct {
+	// Amount is in cents
+	Amount   int
+	Currency string
+	// Description is shown to the guest when they pay, eg 'Deposit for booking #12'
+	Description string
+	// ReturnURL is where the guest is sent back to once they have paid
+	ReturnURL string
+}
+
+// Intent is a payment the guest has still to make
+type Intent struct {
+	ID          string
+	CheckoutURL string
+}
+
+// the events a gateway's webhooks tell us about
+const (
+	// EventAuthorized means the money is held for us, for Capture to take
+	EventAuthorized = "payment.authorized"
+	// EventSucceeded means the money has been taken
+	EventSucceeded = "payment.succeeded"
+	EventFailed    = "payment.failed"
+	EventRefunded  = "payment.refunded"
+)
+
+// Event is what a webhook tells us happened to an intent
+type Event struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	IntentID string `json:"intent_id"`
+	// Amount is in cents
+	Amount int `json:"amount"`
+}
+
+// SignatureHeader is the header webhooks are signed in
+const SignatureHeader = "X-Payment-Signature"
+
+// SignatureTolerance is how old a webhook's signature can be. Older webhooks are turned away, so one that has
+// been intercepted can't be sent to us again later
+const SignatureTolerance = 5 * time.Minute
+
+var (
+	// ErrInvalidSignature is returned for webhooks that aren't signed with our secret, or were signed too long ago
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrUnknownIntent is returned for intents the gateway has no record of
+	ErrUnknownIntent = errors.New("unknown payment intent")
+)
+
+// Sign signs a webhook's payload with secret, as at t, for SignatureHeader
+// NOTES: the signature is an HMAC of the time & the payload, so neither can be changed without the secret.
+// It looks like 't=1700000000,v1=5257a869...'
+func Sign(secret, payload []byte, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, signature(secret, timestamp, payload))
+}
+
+// VerifySignature checks that header, a SignatureHeader, is a signature of payload made with secret no more
+// than SignatureTolerance before now
+func VerifySignature(secret, payload []byte, header string, now time.Time) error {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+
+	// NOTES: hmac.Equal takes as long to compare any 2 signatures, so timing it doesn't give away how much of
+	// a forged signature was right
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, payload))) {
+		return ErrInvalidSignature
+	}
+
+	if age := now.Sub(time.Unix(unix, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func signature(secret []byte, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
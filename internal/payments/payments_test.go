@@ -0,0 +1,141 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-webhook-secret")
+
+var testPayload = []byte(`{"id":"evt_1","type":"payment.succeeded","intent_id":"pi_1","amount":5000}`)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Now()
+
+	if err := VerifySignature(testSecret, testPayload, Sign(testSecret, testPayload, now), now); err != nil {
+		t.Errorf("expected signature to verify but got %s", err)
+	}
+
+	// a little clock drift between us & the gateway is fine
+	if err := VerifySignature(testSecret, testPayload, Sign(testSecret, testPayload, now.Add(time.Minute)), now); err != nil {
+		t.Errorf("expected signature from the near future to verify but got %s", err)
+	}
+}
+
+var badSignatures = []struct {
+	name   string
+	header string
+}{
+	{"empty", ""},
+	{"no signature", "t=1700000000"},
+	{"no time", "v1=5257a869"},
+	{"other secret", Sign([]byte("another-secret"), testPayload, time.Now())},
+	{"other payload", Sign(testSecret, []byte(`{"amount":1}`), time.Now())},
+	{"too old", Sign(testSecret, testPayload, time.Now().Add(-SignatureTolerance-time.Minute))},
+	{"tampered signature", Sign(testSecret, testPayload, time.Now()) + "0"},
+}
+
+func TestVerifySignature_Invalid(t *testing.T) {
+	for _, e := range badSignatures {
+		if err := VerifySignature(testSecret, testPayload, e.header, time.Now()); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature but got %v", e.name, err)
+		}
+	}
+}
+
+func TestFakeProvider_Pay(t *testing.T) {
+	p := NewFakeProvider(testSecret, "http://localhost:8080/payments/fake/")
+	ctx := context.Background()
+
+	intent, err := p.CreateIntent(ctx, IntentRequest{Amount: 5000, Currency: "usd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intent.CheckoutURL != "http://localhost:8080/payments/fake/"+intent.ID {
+		t.Errorf("unexpected checkout URL %s", intent.CheckoutURL)
+	}
+
+	payload, header, err := p.Complete(intent.ID, EventSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := p.VerifyWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("expected the webhook to verify but got %s", err)
+	}
+	if event.Type != EventSucceeded || event.IntentID != intent.ID || event.Amount != 5000 {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// paying twice isn't possible
+	if _, _, err := p.Complete(intent.ID, EventSucceeded); err == nil {
+		t.Error("expected paying twice to fail")
+	}
+
+	// some of it is refunded, then the rest
+	if err := p.Refund(ctx, intent.ID, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Refund(ctx, intent.ID, 3001); err == nil {
+		t.Error("expected refunding more than was paid to fail")
+	}
+	if err := p.Refund(ctx, intent.ID, 3000); err != nil {
+		t.Fatal(err)
+	}
+	if i, _ := p.Intent(intent.ID); i.Status != FakeRefunded || i.Refunded != 5000 {
+		t.Errorf("expected the intent to be refunded but got %+v", i)
+	}
+}
+
+func TestFakeProvider_Capture(t *testing.T) {
+	p := NewFakeProvider(testSecret, "/payments/fake")
+	ctx := context.Background()
+
+	intent, _ := p.CreateIntent(ctx, IntentRequest{Amount: 5000})
+
+	if err := p.Capture(ctx, intent.ID); err == nil {
+		t.Error("expected capturing an unpaid intent to fail")
+	}
+
+	if _, _, err := p.Complete(intent.ID, EventAuthorized); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Refund(ctx, intent.ID, 5000); err == nil {
+		t.Error("expected refunding a payment that hasn't been captured to fail")
+	}
+	if err := p.Capture(ctx, intent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if i, _ := p.Intent(intent.ID); i.Status != FakeSucceeded {
+		t.Errorf("expected the intent to have succeeded but got %s", i.Status)
+	}
+
+	if err := p.Capture(ctx, "fake_pi_99"); !errors.Is(err, ErrUnknownIntent) {
+		t.Errorf("expected ErrUnknownIntent but got %v", err)
+	}
+}
+
+func TestFakeProvider_VerifyWebhook_Invalid(t *testing.T) {
+	p := NewFakeProvider(testSecret, "/payments/fake")
+
+	header := make(http.Header)
+	header.Set(SignatureHeader, Sign([]byte("another-secret"), testPayload, time.Now()))
+
+	if _, err := p.VerifyWebhook(testPayload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature but got %v", err)
+	}
+
+	// a webhook that is signed, but isn't an event
+	header.Set(SignatureHeader, Sign(testSecret, []byte("nonsense"), time.Now()))
+	if _, err := p.VerifyWebhook([]byte("nonsense"), header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature but got %v", err)
+	}
+
+	if _, err := p.CreateIntent(context.Background(), IntentRequest{Amount: 0}); err == nil {
+		t.Error("expected a payment of nothing to fail")
+	}
+}
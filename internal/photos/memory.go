@@ -0,0 +1,72 @@
+package photos
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// MemoryStorage keeps photos in memory, so tests can upload photos without touching the disk
+type MemoryStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemoryStorage creates a MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string][]byte)}
+}
+
+// Put keeps a copy of the file
+func (s *MemoryStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[key] = data
+	return nil
+}
+
+// Delete forgets the file
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, key)
+	return nil
+}
+
+// URL is a made up path for the file
+func (s *MemoryStorage) URL(key string) string {
+	return "/uploads/" + key
+}
+
+// Get returns the file saved as key
+func (s *MemoryStorage) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.files[key]
+	return bytes.Clone(data), ok
+}
+
+// Keys returns the keys of every file saved, in no particular order
+func (s *MemoryStorage) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for k := range s.files {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,206 @@
+package photos
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+
+	// NOTES: image.Decode() only knows the formats whose packages are imported. jpeg is used for encoding
+	// below anyway, but gif & png are only imported for this, hence the _
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// MaxPixels is the most pixels an uploaded photo can have. A small file can still unpack into a huge
+	// image, so this is checked before the photo is decoded
+	MaxPixels = 40_000_000
+
+	// DisplayWidth & DisplayHeight bound the size photos are shown at on a room's page
+	DisplayWidth  = 1600
+	DisplayHeight = 1200
+	// ThumbnailWidth & ThumbnailHeight bound the small copies shown in lists & search results
+	ThumbnailWidth  = 400
+	ThumbnailHeight = 300
+
+	jpegQuality = 85
+)
+
+var (
+	// ErrUnsupportedType is returned for uploads that are not JPEG, PNG or GIF images
+	ErrUnsupportedType = errors.New("photos must be JPEG, PNG or GIF images")
+	// ErrTooManyPixels is returned for images bigger than MaxPixels
+	ErrTooManyPixels = errors.New("photo is too big")
+)
+
+// supportedTypes are the content types photos can be uploaded as
+var supportedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Variants are the JPEG files made from an uploaded photo
+type Variants struct {
+	Display   []byte
+	Thumbnail []byte
+}
+
+// Process checks that data is a photo we accept & makes its display & thumbnail variants from it. Photos
+// are only ever made smaller. Transparent parts of PNGs & GIFs become white, as JPEGs have no transparency
+func Process(data []byte) (Variants, error) {
+	var v Variants
+
+	// NOTES: DetectContentType looks at the first bytes of the file, so the type can't be faked by renaming
+	// the file or by the Content-Type the browser sends
+	if !supportedTypes[http.DetectContentType(data)] {
+		return v, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return v, ErrUnsupportedType
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return v, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return v, ErrUnsupportedType
+	}
+
+	display := toRGBA(img)
+	w, h := fit(display.Bounds().Dx(), display.Bounds().Dy(), DisplayWidth, DisplayHeight)
+	display = resize(display, w, h)
+
+	w, h = fit(w, h, ThumbnailWidth, ThumbnailHeight)
+	thumbnail := resize(display, w, h)
+
+	if v.Display, err = encode(display); err != nil {
+		return v, err
+	}
+	if v.Thumbnail, err = encode(thumbnail); err != nil {
+		return v, err
+	}
+
+	return v, nil
+}
+
+func encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	return buf.Bytes(), err
+}
+
+// toRGBA copies img onto a white background, starting at 0,0
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// fit is the size of a w x h image scaled down, keeping its shape, to fit in maxW x maxH. Images that
+// already fit keep their size
+func fit(w, h, maxW, maxH int) (int, int) {
+	if w <= maxW && h <= maxH {
+		return w, h
+	}
+
+	// scale by whichever side is furthest over its limit
+	if w*maxH > h*maxW {
+		return maxW, max(1, (h*maxW+w/2)/w)
+	}
+	return max(1, (w*maxH+h/2)/h), maxH
+}
+
+// resize scales src down to w x h. Each new pixel is the average of the pixels of src it covers (a box
+// filter), which is simple & looks good when shrinking, unlike just picking every nth pixel
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw == w && sh == h {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := span(y, h, sh)
+		for x := 0; x < w; x++ {
+			x0, x1 := span(x, w, sw)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// span is the range of source pixels, along one side, that pixel i of n new pixels covers
+func span(i, n, size int) (int, int) {
+	start := i * size / n
+	end := (i + 1) * size / n
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
+
+// Save puts a photo's variants in store, under a new random name in folder, eg rooms/1. It returns the key
+// to find them again with DisplayKey, ThumbnailKey & Remove
+func Save(ctx context.Context, store Storage, folder string, v Variants) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	key := folder + "/" + hex.EncodeToString(random)
+
+	if err := store.Put(ctx, DisplayKey(key), bytes.NewReader(v.Display)); err != nil {
+		return "", err
+	}
+	if err := store.Put(ctx, ThumbnailKey(key), bytes.NewReader(v.Thumbnail)); err != nil {
+		store.Delete(ctx, DisplayKey(key))
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Remove deletes the variants of the photo saved as key
+func Remove(ctx context.Context, store Storage, key string) error {
+	return errors.Join(store.Delete(ctx, DisplayKey(key)), store.Delete(ctx, ThumbnailKey(key)))
+}
+
+// DisplayKey is where the display variant of the photo saved as key is kept
+func DisplayKey(key string) string {
+	return key + "-display.jpg"
+}
+
+// ThumbnailKey is where the thumbnail of the photo saved as key is kept
+func ThumbnailKey(key string) string {
+	return key + "-thumb.jpg"
+}
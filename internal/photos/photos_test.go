@@ -0,0 +1,159 @@
+package photos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPNG makes a w x h PNG, red on the left half & transparent on the right
+func testPNG(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w/2; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	var tests = []struct {
+		name             string
+		width, height    int
+		displayW, thumbW int
+		displayH, thumbH int
+	}{
+		{"landscape", 3200, 1800, 1600, 400, 900, 225},
+		{"portrait", 1200, 2400, 600, 150, 1200, 300},
+		{"small", 200, 100, 200, 200, 100, 100},
+	}
+
+	for _, e := range tests {
+		v, err := Process(testPNG(t, e.width, e.height))
+		if err != nil {
+			t.Fatalf("failed %s: %s", e.name, err)
+		}
+
+		display, err := jpeg.Decode(bytes.NewReader(v.Display))
+		if err != nil {
+			t.Fatalf("failed %s: display is not a JPEG: %s", e.name, err)
+		}
+		thumb, err := jpeg.Decode(bytes.NewReader(v.Thumbnail))
+		if err != nil {
+			t.Fatalf("failed %s: thumbnail is not a JPEG: %s", e.name, err)
+		}
+
+		if b := display.Bounds(); b.Dx() != e.displayW || b.Dy() != e.displayH {
+			t.Errorf("failed %s: expected display %dx%d, got %dx%d", e.name, e.displayW, e.displayH, b.Dx(), b.Dy())
+		}
+		if b := thumb.Bounds(); b.Dx() != e.thumbW || b.Dy() != e.thumbH {
+			t.Errorf("failed %s: expected thumbnail %dx%d, got %dx%d", e.name, e.thumbW, e.thumbH, b.Dx(), b.Dy())
+		}
+
+		// the red half stays red & the transparent half turns white
+		r, g, _, _ := display.At(1, 1).RGBA()
+		if r>>8 < 240 || g>>8 > 15 {
+			t.Errorf("failed %s: expected the left to be red, got %v", e.name, display.At(1, 1))
+		}
+		r, g, _, _ = display.At(display.Bounds().Dx()-2, 1).RGBA()
+		if r>>8 < 240 || g>>8 < 240 {
+			t.Errorf("failed %s: expected the right to be white, got %v", e.name, display.At(display.Bounds().Dx()-2, 1))
+		}
+	}
+}
+
+func TestProcess_Rejects(t *testing.T) {
+	var tests = []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"text", []byte("not a photo at all"), ErrUnsupportedType},
+		{"pdf", []byte("%PDF-1.4\n..."), ErrUnsupportedType},
+		{"broken png", testPNG(t, 10, 10)[:40], ErrUnsupportedType},
+		{"too many pixels", testPNG(t, 8000, 6000), ErrTooManyPixels},
+	}
+
+	for _, e := range tests {
+		_, err := Process(e.data)
+		if !errors.Is(err, e.expected) {
+			t.Errorf("failed %s: expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestSaveAndRemove(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+
+	key, err := Save(ctx, store, "rooms/1", Variants{Display: []byte("display"), Thumbnail: []byte("thumb")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "rooms/1/") {
+		t.Errorf("expected the key to be in rooms/1, got %s", key)
+	}
+
+	if data, ok := store.Get(DisplayKey(key)); !ok || string(data) != "display" {
+		t.Errorf("expected the display variant to be saved, got %q", data)
+	}
+	if data, ok := store.Get(ThumbnailKey(key)); !ok || string(data) != "thumb" {
+		t.Errorf("expected the thumbnail to be saved, got %q", data)
+	}
+
+	if err := Remove(ctx, store, key); err != nil {
+		t.Fatal(err)
+	}
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Errorf("expected no files left, got %v", keys)
+	}
+}
+
+func TestDiskStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewDiskStorage(dir, "/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(ctx, "rooms/1/photo.jpg", strings.NewReader("photo")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "rooms", "1", "photo.jpg"))
+	if err != nil || string(data) != "photo" {
+		t.Errorf("expected the photo on disk, got %q, %v", data, err)
+	}
+	if url := store.URL("rooms/1/photo.jpg"); url != "/uploads/rooms/1/photo.jpg" {
+		t.Errorf("expected /uploads/rooms/1/photo.jpg, got %s", url)
+	}
+
+	if err := store.Delete(ctx, "rooms/1/photo.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "rooms/1/photo.jpg"); err != nil {
+		t.Errorf("expected deleting a missing photo to be fine, got %v", err)
+	}
+
+	for _, key := range []string{"../config.yml", "/etc/passwd", "rooms/../../x", ""} {
+		if err := store.Put(ctx, key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected %q to be an invalid key, got %v", key, err)
+		}
+	}
+}
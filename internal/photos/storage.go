@@ -0,0 +1,101 @@
+package photos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage keeps the files of uploaded photos. Keys are slash separated, eg rooms/1/ab12cd-thumb.jpg. The app
+// only talks to a Storage, so photos can be moved from the local disk to eg a cloud bucket in one place
+type Storage interface {
+	// Put saves the file read from r as key, replacing any file already there
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Delete removes key. Deleting a key that doesn't exist is not an error
+	Delete(ctx context.Context, key string) error
+	// URL is where the browser gets key from
+	URL(key string) string
+}
+
+// ErrInvalidKey is returned for keys that could end up outside the storage, eg ../config.yml
+var ErrInvalidKey = errors.New("invalid photo storage key")
+
+// checkKey makes sure key is a clean, relative path
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// DiskStorage keeps photos in a folder on the local disk, which the app serves under URLPrefix
+type DiskStorage struct {
+	Dir       string
+	URLPrefix string
+}
+
+// NewDiskStorage creates a DiskStorage, creating dir if needed
+func NewDiskStorage(dir, urlPrefix string) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &DiskStorage{Dir: dir, URLPrefix: strings.TrimSuffix(urlPrefix, "/")}, nil
+}
+
+// Put writes the file to a temporary file first & then renames it, so a half written photo is never served
+func (s *DiskStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	name := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// Delete removes the file for key
+func (s *DiskStorage) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// URL is the file's path under URLPrefix
+func (s *DiskStorage) URL(key string) string {
+	return s.URLPrefix + "/" + key
+}
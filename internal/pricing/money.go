@@ -0,0 +1,34 @@
+package pricing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// currencySymbols are the symbols shown before prices in the currencies we know. Other currencies are shown
+// with their code after the price instead, eg '120.50 CHF'
+var currencySymbols = map[string]string{
+	"usd": "$",
+	"eur": "€",
+	"gbp": "£",
+}
+
+// FormatMoney turns a price in cents of currency, a 3 letter code like "usd", into the price for display, eg
+// 12050 becomes '$120.50' in usd & '€120.50' in eur. An empty currency is usd
+func FormatMoney(cents int, currency string) string {
+	currency = strings.ToLower(currency)
+	if currency == "" {
+		currency = "usd"
+	}
+
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	amount := fmt.Sprintf("%d.%02d", cents/100, cents%100)
+
+	if symbol, ok := currencySymbols[currency]; ok {
+		return sign + symbol + amount
+	}
+	return sign + amount + " " + strings.ToUpper(currency)
+}
@@ -0,0 +1,103 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// ErrNoNights is returned when the departure date is not after the arrival date
+var ErrNoNights = errors.New("departure must be at least one day after arrival")
+
+// MinStayError is returned when a stay is shorter than the minimum stay for the room
+type MinStayError struct {
+	MinStay int
+	Nights  int
+}
+
+func (e *MinStayError) Error() string {
+	return fmt.Sprintf("the minimum stay for these dates is %d nights, but only %d were requested", e.MinStay, e.Nights)
+}
+
+// Quote works out the price of a stay in a room from start (the arrival date) to end (the departure date).
+// Each night is priced at the room's base rate, unless a season covers that night, in which case the
+// season's nightly rate is used. If more than one season covers a night, the one that starts last wins.
+// Friday & Saturday nights then get the room's weekend uplift added on top. The deposit is a percentage of
+// the total, set by the room's rate, or by the season the guest arrives in.
+func Quote(rate models.RoomRate, seasons []models.SeasonalRate, start, end time.Time) (models.Quote, error) {
+	quote := models.Quote{
+		RoomId:    rate.RoomId,
+		StartDate: start,
+		EndDate:   end,
+	}
+
+	nights := int(end.Sub(start).Hours() / 24)
+	if nights < 1 {
+		return quote, ErrNoNights
+	}
+
+	// the minimum stay is set by the room, or by the season the guest arrives in, whichever is longer
+	minStay := rate.MinStay
+	if season, ok := seasonFor(seasons, start); ok && season.MinStay > minStay {
+		minStay = season.MinStay
+	}
+
+	if nights < minStay {
+		return quote, &MinStayError{MinStay: minStay, Nights: nights}
+	}
+
+	// NOTES: we price the nights, not the days, so we stop before the departure date
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := models.NightlyPrice{
+			Date: d,
+			Rate: rate.BaseRate,
+		}
+
+		if season, ok := seasonFor(seasons, d); ok {
+			night.Rate = season.NightlyRate
+			night.SeasonName = season.SeasonName
+		}
+
+		if d.Weekday() == time.Friday || d.Weekday() == time.Saturday {
+			night.Weekend = true
+			night.Rate += night.Rate * rate.WeekendUplift / 100
+		}
+
+		quote.Nights = append(quote.Nights, night)
+		quote.Total += night.Rate
+	}
+
+	// like the minimum stay, the season the guest arrives in can ask for a different deposit than the room
+	quote.DepositPercent = rate.DepositPercent
+	if season, ok := seasonFor(seasons, start); ok && season.DepositPercent > 0 {
+		quote.DepositPercent = season.DepositPercent
+	}
+	quote.Deposit = Deposit(quote.Total, quote.DepositPercent)
+
+	return quote, nil
+}
+
+// Deposit is percent of total, rounded to the nearest cent
+func Deposit(total, percent int) int {
+	return (total*percent + 50) / 100
+}
+
+// seasonFor returns the season covering the given night, if any
+func seasonFor(seasons []models.SeasonalRate, night time.Time) (models.SeasonalRate, bool) {
+	var found models.SeasonalRate
+	ok := false
+
+	for _, s := range seasons {
+		if night.Before(s.StartDate) || night.After(s.EndDate) {
+			continue
+		}
+		if !ok || s.StartDate.After(found.StartDate) {
+			found = s
+			ok = true
+		}
+	}
+
+	return found, ok
+}
@@ -0,0 +1,160 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+var testRate = models.RoomRate{
+	RoomId:        1,
+	BaseRate:      10000,
+	WeekendUplift: 20,
+	MinStay:       1,
+}
+
+var testSeasons = []models.SeasonalRate{
+	{
+		SeasonName:  "Winter",
+		StartDate:   date("2050-12-01"),
+		EndDate:     date("2050-12-31"),
+		NightlyRate: 15000,
+	},
+	{
+		SeasonName:  "Christmas",
+		StartDate:   date("2050-12-23"),
+		EndDate:     date("2050-12-27"),
+		NightlyRate: 20000,
+		MinStay:     3,
+	},
+}
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+// quoteTests is the data for the Quote tests. 2050-01-03 is a Monday
+var quoteTests = []struct {
+	name          string
+	start         string
+	end           string
+	expectedTotal int
+	expectedErr   bool
+}{
+	{"one weekday night", "2050-01-03", "2050-01-04", 10000, false},
+	{"mon to mon", "2050-01-03", "2050-01-10", 5*10000 + 2*12000, false},
+	{"winter season", "2050-12-05", "2050-12-07", 2 * 15000, false},
+	{"into christmas", "2050-12-21", "2050-12-24", 15000 + 15000 + 24000, false},
+	{"christmas too short", "2050-12-24", "2050-12-25", 0, true},
+	{"no nights", "2050-01-03", "2050-01-03", 0, true},
+}
+
+func TestQuote(t *testing.T) {
+	for _, e := range quoteTests {
+		q, err := Quote(testRate, testSeasons, date(e.start), date(e.end))
+		if e.expectedErr {
+			if err == nil {
+				t.Errorf("%s: expected an error but did not get one", e.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: got unexpected error %s", e.name, err)
+			continue
+		}
+
+		if q.Total != e.expectedTotal {
+			t.Errorf("%s: expected total %d but got %d", e.name, e.expectedTotal, q.Total)
+		}
+
+		nights := int(date(e.end).Sub(date(e.start)).Hours() / 24)
+		if len(q.Nights) != nights {
+			t.Errorf("%s: expected %d nights but got %d", e.name, nights, len(q.Nights))
+		}
+	}
+}
+
+func TestQuote_MinStay(t *testing.T) {
+	_, err := Quote(testRate, testSeasons, date("2050-12-24"), date("2050-12-25"))
+
+	var minStay *MinStayError
+	if !errors.As(err, &minStay) {
+		t.Fatalf("expected a *MinStayError but got %v", err)
+	}
+
+	if minStay.MinStay != 3 {
+		t.Errorf("expected min stay of 3 but got %d", minStay.MinStay)
+	}
+}
+
+func TestQuote_Deposit(t *testing.T) {
+	rate := testRate
+	rate.DepositPercent = 20
+
+	// christmas asks for half up front
+	seasons := append([]models.SeasonalRate{}, testSeasons...)
+	seasons[1].DepositPercent = 50
+
+	var tests = []struct {
+		name            string
+		rate            models.RoomRate
+		start           string
+		end             string
+		expectedPercent int
+		expectedDeposit int
+	}{
+		{"no deposit", testRate, "2050-01-03", "2050-01-04", 0, 0},
+		{"room's deposit", rate, "2050-01-03", "2050-01-05", 20, 4000},
+		{"season without a deposit", rate, "2050-12-05", "2050-12-07", 20, 6000},
+		{"christmas", rate, "2050-12-23", "2050-12-26", 50, (20000 + 24000 + 24000) / 2},
+	}
+
+	for _, e := range tests {
+		q, err := Quote(e.rate, seasons, date(e.start), date(e.end))
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if q.DepositPercent != e.expectedPercent || q.Deposit != e.expectedDeposit {
+			t.Errorf("%s: expected a deposit of %d%% (%d) but got %d%% (%d)", e.name, e.expectedPercent,
+				e.expectedDeposit, q.DepositPercent, q.Deposit)
+		}
+	}
+}
+
+func TestDeposit(t *testing.T) {
+	// 33% of 10001 cents is 3300.33, which rounds down, & 50% of 333 is 166.5, which rounds up
+	if d := Deposit(10001, 33); d != 3300 {
+		t.Errorf("expected 3300 but got %d", d)
+	}
+	if d := Deposit(10050, 10); d != 1005 {
+		t.Errorf("expected 1005 but got %d", d)
+	}
+	if d := Deposit(333, 50); d != 167 {
+		t.Errorf("expected 167 but got %d", d)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		cents    int
+		currency string
+		expected string
+	}{
+		{12050, "usd", "$120.50"},
+		{12050, "", "$120.50"},
+		{12050, "EUR", "€120.50"},
+		{5, "gbp", "£0.05"},
+		{12050, "chf", "120.50 CHF"},
+		{-2500, "eur", "-€25.00"},
+	}
+
+	for _, e := range tests {
+		if s := FormatMoney(e.cents, e.currency); s != e.expected {
+			t.Errorf("%d %s: expected %s but got %s", e.cents, e.currency, e.expected, s)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/guestlinks"
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+// DefaultSchedules is a reminder 3 days before arrival, & a thank-you the day after departure
+const DefaultSchedules = "pre-arrival:3:before:reminder,post-stay:1:after:thank-you"
+
+// catchUp is how long after its due day a post-stay email can still go out, eg if the app was down that day
+const catchUp = 7 * 24 * time.Hour
+
+// stayingStatuses are the statuses of reservations whose guests get scheduled emails, ie whose stays have been
+// confirmed. Pending reservations, eg ones whose deposit hasn't been paid, get nothing, nor do cancelled &
+// no-show ones
+var stayingStatuses = map[string]bool{
+	models.ReservationConfirmed:  true,
+	models.ReservationCheckedIn:  true,
+	models.ReservationCheckedOut: true,
+}
+
+// Schedule is one kind of email sent to every guest, Days before they arrive, or Days after they leave
+type Schedule struct {
+	// Kind names the notification, & is what we record for each reservation so it is only sent once
+	Kind string
+	Days int
+	// After is true for emails sent after departure, & false for emails sent before arrival
+	After bool
+	// Template is the email template, eg 'reminder' for email-templates/reminder.email.html
+	Template string
+}
+
+// ParseSchedules reads schedules written as kind:days:before|after:template, separated by commas, eg
+// DefaultSchedules. An empty string means no schedules
+func ParseSchedules(spec string) ([]Schedule, error) {
+	schedules := []Schedule{}
+	kinds := map[string]bool{}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("schedule %q should be kind:days:before|after:template", part)
+		}
+
+		s := Schedule{Kind: fields[0], Template: fields[3]}
+		if s.Kind == "" || s.Template == "" {
+			return nil, fmt.Errorf("schedule %q needs a kind & a template", part)
+		}
+		if kinds[s.Kind] {
+			return nil, fmt.Errorf("schedule kind %q is used more than once", s.Kind)
+		}
+		kinds[s.Kind] = true
+
+		days, err := strconv.Atoi(fields[1])
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("schedule %q: days must be a whole number, 0 or more", part)
+		}
+		s.Days = days
+
+		switch fields[2] {
+		case "before":
+		case "after":
+			s.After = true
+		default:
+			return nil, fmt.Errorf("schedule %q: use before (arrival) or after (departure), not %q", part, fields[2])
+		}
+
+		schedules = append(schedules, s)
+	}
+
+	return schedules, nil
+}
+
+// CheckTemplates checks that every schedule's email template exists & defines its own subject, so a typo in
+// the schedules stops the app from starting rather than every one of its emails failing later
+func CheckTemplates(schedules []Schedule, templates *mail.Templates) error {
+	var errs []error
+	for _, s := range schedules {
+		found, subject := templates.Defines(s.Template)
+		switch {
+		case !found:
+			errs = append(errs, fmt.Errorf("schedule %s: there is no email template %s.email.html", s.Kind, s.Template))
+		case !subject:
+			errs = append(errs, fmt.Errorf("schedule %s: the email template %s.email.html needs a \"subject\" block", s.Kind, s.Template))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Scheduler queues the scheduled emails of reservations that are due them. Each email is recorded against
+// its reservation as it is queued, so running the scheduler again, or on several servers, never sends it twice
+type Scheduler struct {
+	DB        repository.DatabaseRepo
+	Templates *mail.Templates
+	Schedules []Schedule
+	// BaseURL & SigningKey make the links guests use to manage their reservations
+	BaseURL    string
+	SigningKey []byte
+}
+
+// NewScheduler creates a Scheduler
+func NewScheduler(db repository.DatabaseRepo, templates *mail.Templates, schedules []Schedule, baseURL string, signingKey []byte) *Scheduler {
+	return &Scheduler{
+		DB:         db,
+		Templates:  templates,
+		Schedules:  schedules,
+		BaseURL:    baseURL,
+		SigningKey: signingKey,
+	}
+}
+
+// Run queues every email that is due on the day of now, & returns how many were queued. A reservation
+// booked less than Days before arrival still gets its pre-arrival email, straight away
+func (s *Scheduler) Run(ctx context.Context, now time.Time) (int, error) {
+	property, err := s.DB.GetProperty(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	queued := 0
+	var errs []error
+
+	for _, sc := range s.Schedules {
+		n, err := s.runSchedule(ctx, sc, today, property.Name, property.SenderEmail)
+		queued += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s emails: %w", sc.Kind, err))
+		}
+	}
+
+	return queued, errors.Join(errs...)
+}
+
+func (s *Scheduler) runSchedule(ctx context.Context, sc Schedule, today time.Time, propertyName, from string) (int, error) {
+	days := time.Duration(sc.Days) * 24 * time.Hour
+
+	var err error
+	var reservations []models.Reservation
+	if sc.After {
+		due := today.Add(-days)
+		reservations, err = s.DB.ReservationsDepartingBetween(ctx, due.Add(-catchUp), due, sc.Kind)
+	} else {
+		reservations, err = s.DB.ReservationsArrivingBetween(ctx, today, today.Add(days), sc.Kind)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	var errs []error
+
+	for _, res := range reservations {
+		if !stayingStatuses[res.Status] {
+			continue
+		}
+
+		msg, err := s.Templates.Render(res.Email, from, mail.Reminder{
+			Name:         sc.Template,
+			Reservation:  res,
+			ManageURL:    fmt.Sprintf("%s/reservations/manage/%s", s.BaseURL, guestlinks.Sign(s.SigningKey, res.ID)),
+			PropertyName: propertyName,
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		ok, err := s.DB.QueueReservationNotification(ctx, res.ID, sc.Kind, msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reservation %d: %w", res.ID, err))
+			continue
+		}
+		if ok {
+			queued++
+		}
+	}
+
+	return queued, errors.Join(errs...)
+}
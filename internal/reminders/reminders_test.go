@@ -0,0 +1,165 @@
+package reminders
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/mail"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+)
+
+func TestParseSchedules(t *testing.T) {
+	schedules, err := ParseSchedules(DefaultSchedules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Schedule{
+		{Kind: "pre-arrival", Days: 3, Template: "reminder"},
+		{Kind: "post-stay", Days: 1, After: true, Template: "thank-you"},
+	}
+	if len(schedules) != len(want) || schedules[0] != want[0] || schedules[1] != want[1] {
+		t.Errorf("expected %+v, got %+v", want, schedules)
+	}
+
+	if schedules, err := ParseSchedules(""); err != nil || len(schedules) != 0 {
+		t.Errorf("expected no schedules, got %+v %v", schedules, err)
+	}
+
+	for _, spec := range []string{
+		"pre-arrival:3:before",
+		"pre-arrival:x:before:reminder",
+		"pre-arrival:-1:before:reminder",
+		"pre-arrival:3:during:reminder",
+		":3:before:reminder",
+		"a:1:before:reminder,a:2:after:thank-you",
+	} {
+		if _, err := ParseSchedules(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+// notificationRepo holds reservations & the notifications they have had. Any other repository method
+// panics, since the scheduler should not be calling it
+type notificationRepo struct {
+	repository.DatabaseRepo
+	reservations []models.Reservation
+	sent         map[string]bool
+	queued       []models.MailData
+}
+
+func (r *notificationRepo) GetProperty(ctx context.Context) (models.Property, error) {
+	return models.Property{Name: "Hotel Bookings", SenderEmail: "bookings@here.ca"}, nil
+}
+
+func (r *notificationRepo) between(start, end time.Time, kind string, date func(models.Reservation) time.Time) []models.Reservation {
+	var out []models.Reservation
+	for _, res := range r.reservations {
+		d := date(res)
+		if !d.Before(start) && !d.After(end) && !r.sent[key(res.ID, kind)] {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+func (r *notificationRepo) ReservationsArrivingBetween(ctx context.Context, start, end time.Time, kind string) ([]models.Reservation, error) {
+	return r.between(start, end, kind, func(res models.Reservation) time.Time { return res.StartDate }), nil
+}
+
+func (r *notificationRepo) ReservationsDepartingBetween(ctx context.Context, start, end time.Time, kind string) ([]models.Reservation, error) {
+	return r.between(start, end, kind, func(res models.Reservation) time.Time { return res.EndDate }), nil
+}
+
+func (r *notificationRepo) QueueReservationNotification(ctx context.Context, reservationID int, kind string, msg models.MailData) (bool, error) {
+	if r.sent[key(reservationID, kind)] {
+		return false, nil
+	}
+	r.sent[key(reservationID, kind)] = true
+	r.queued = append(r.queued, msg)
+	return true, nil
+}
+
+func key(id int, kind string) string {
+	return fmt.Sprintf("%s:%d", kind, id)
+}
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestScheduler_Run(t *testing.T) {
+	templates, err := mail.NewTemplates("./../../email-templates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &notificationRepo{
+		sent: map[string]bool{},
+		reservations: []models.Reservation{
+			// arrives in 2 days
+			{ID: 1, FirstName: "John", Email: "john@smith.com", StartDate: date("2050-01-03"), EndDate: date("2050-01-05"), Status: models.ReservationConfirmed},
+			// arrives in 10 days
+			{ID: 2, FirstName: "Jane", Email: "jane@smith.com", StartDate: date("2050-01-11"), EndDate: date("2050-01-12"), Status: models.ReservationConfirmed},
+			// left yesterday
+			{ID: 3, FirstName: "Jim", Email: "jim@smith.com", StartDate: date("2049-12-28"), EndDate: date("2049-12-31"), Status: models.ReservationCheckedOut},
+			// arrives in 2 days, but hasn't paid their deposit, so isn't confirmed
+			{ID: 4, FirstName: "Joe", Email: "joe@smith.com", StartDate: date("2050-01-03"), EndDate: date("2050-01-05"), Status: models.ReservationPending},
+			// would have left yesterday, but cancelled
+			{ID: 5, FirstName: "Jen", Email: "jen@smith.com", StartDate: date("2049-12-28"), EndDate: date("2049-12-31"), Status: models.ReservationCancelled},
+		},
+	}
+
+	schedules, _ := ParseSchedules(DefaultSchedules)
+	s := NewScheduler(db, templates, schedules, "http://localhost:8080", []byte("key"))
+
+	now := time.Date(2050, 1, 1, 9, 30, 0, 0, time.UTC)
+	queued, err := s.Run(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued != 2 {
+		t.Fatalf("expected 2 emails queued, got %d", queued)
+	}
+
+	if db.queued[0].To != "john@smith.com" || db.queued[0].Template != "reminder" || !strings.Contains(db.queued[0].Content, "/reservations/manage/1.") {
+		t.Errorf("unexpected reminder %+v", db.queued[0])
+	}
+	if db.queued[1].To != "jim@smith.com" || db.queued[1].Template != "thank-you" || db.queued[1].From != "bookings@here.ca" {
+		t.Errorf("unexpected thank-you %+v", db.queued[1])
+	}
+
+	// running again later the same day sends nothing new
+	if queued, err := s.Run(context.Background(), now.Add(time.Hour)); err != nil || queued != 0 {
+		t.Errorf("expected no emails the second time, got %d %v", queued, err)
+	}
+}
+
+func TestCheckTemplates(t *testing.T) {
+	templates, err := mail.NewTemplates("./../../email-templates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedules, _ := ParseSchedules(DefaultSchedules)
+	if err := CheckTemplates(schedules, templates); err != nil {
+		t.Errorf("expected the default schedules to be fine, got %s", err)
+	}
+
+	for _, spec := range []string{
+		// no such template
+		"pre-arrival:3:before:remindr",
+		// a template without a subject of its own
+		"pre-arrival:3:before:cancellation",
+	} {
+		schedules, _ := ParseSchedules(spec)
+		if err := CheckTemplates(schedules, templates); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"path/filepath"
 	"time"
@@ -13,6 +12,8 @@ import (
 
 	"github.com/gustavNdamukong/hotel-bookings/internal/config"
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/pricing"
+	"github.com/gustavNdamukong/hotel-bookings/internal/roles"
 	"github.com/justinas/nosurf"
 )
 
@@ -36,10 +37,12 @@ in your target view template and can be used inside of it like so:
 	<td>{{ myCustomFunction .StartDate }}</td>
 */
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"add":         Add,
+	"formatMoney": FormatMoney,
+	"atLeast":     AtLeast,
 }
 
 var app *config.AppConfig
@@ -82,6 +85,20 @@ func FormatDate(t time.Time, f string) string {
 	return t.Format(f)
 }
 
+// FormatMoney turns a price in cents into the app's currency for display eg 12050 becomes '$120.50' in usd
+func FormatMoney(cents int) string {
+	currency := ""
+	if app != nil {
+		currency = app.Currency
+	}
+	return pricing.FormatMoney(cents, currency)
+}
+
+// AtLeast checks in a view if a role is min or a more trusted one eg {{ if atLeast .Role "manager" }}
+func AtLeast(role, min string) bool {
+	return roles.Role(role).AtLeast(roles.Role(min))
+}
+
 // NOTES: AddDefaultData will be used to pass to views data that should be sent to all views by default
 // PopString is a built-in method on the Session library which puts something in the session
 // which only lasts until the page is refreshed.
@@ -95,12 +112,19 @@ func AddDefaultData(tData *models.TemplateData, request *http.Request) *models.T
 	// NOTES: How to check if the session contains a variable
 	if app.Session.Exists(request.Context(), "user_id") {
 		tData.IsAuthenticated = 1
+		tData.Role = app.Session.GetString(request.Context(), "role")
 	}
 	return tData
 }
 
-// Template renders templates using html/template
+// Template renders templates using html/template. If the template can't be rendered, nothing is sent &
+// the error is returned, so the caller can send an error page instead
 func Template(w http.ResponseWriter, request *http.Request, requestedTemplateName string, tData *models.TemplateData) error {
+	return TemplateWithStatus(w, request, http.StatusOK, requestedTemplateName, tData)
+}
+
+// TemplateWithStatus is Template for pages that are sent with a status other than 200, eg the 404 page
+func TemplateWithStatus(w http.ResponseWriter, request *http.Request, status int, requestedTemplateName string, tData *models.TemplateData) error {
 
 	var templateCache map[string]*template.Template
 	//if in development env
@@ -128,16 +152,23 @@ func Template(w http.ResponseWriter, request *http.Request, requestedTemplateNam
 
 	//we do not have do go via the buffer, but we do it for fine-grained
 	//control over being able to tell where a potential error may be coming from
+	// NOTES: this used to call log.Fatal, so one broken template took the whole server down. Nothing has been
+	// written to w yet, so returning the error lets the caller send an error page instead
 	err := parsedTemplate.Execute(buffer, tData)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("executing template %s: %w", requestedTemplateName, err)
+	}
+
+	// NOTES: the status has to be written before the body. 200 is what's sent if WriteHeader() is never called
+	if status != http.StatusOK {
+		w.WriteHeader(status)
 	}
 
 	// render the template
 	_, err = buffer.WriteTo(w)
 
 	if err != nil {
-		fmt.Println("error writing template to browser", err)
+		app.Logger.ErrorContext(request.Context(), "error writing template to browser", "error", err)
 		return err
 	}
 
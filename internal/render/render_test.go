@@ -1,7 +1,9 @@
 package render
 
 import (
+	"html/template"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
@@ -62,6 +64,28 @@ func TestRenderTemplate(t *testing.T) {
 
 }
 
+func TestRenderTemplate_ExecuteError(t *testing.T) {
+	// a template that fails when it is executed, as calling a missing method does. This used to call log.Fatal
+	broken := template.Must(template.New("broken.page.tmpl").Parse(`{{ .NoSuchField.Oops }}`))
+	app.TemplateCache = map[string]*template.Template{"broken.page.tmpl": broken}
+	defer func() { app.TemplateCache = nil }()
+
+	r, err := getSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	err = TemplateWithStatus(rr, r, http.StatusNotFound, "broken.page.tmpl", &models.TemplateData{})
+	if err == nil {
+		t.Fatal("expected an error for a template that fails to execute")
+	}
+	// nothing is sent, so the caller can still send an error page
+	if rr.Body.Len() > 0 || rr.Code != http.StatusOK {
+		t.Errorf("expected nothing to be written, got status %d & %q", rr.Code, rr.Body.String())
+	}
+}
+
 // getSession() creates and returns a session object
 func getSession() (*http.Request, error) {
 	// NOTES: this is how we create a request within a test environment (using 'http.NewRequest()')
@@ -93,3 +117,18 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestFormatMoney(t *testing.T) {
+	currency := app.Currency
+	defer func() { app.Currency = currency }()
+
+	app.Currency = "usd"
+	if s := FormatMoney(12050); s != "$120.50" {
+		t.Errorf("expected $120.50 but got %s", s)
+	}
+
+	app.Currency = "eur"
+	if s := FormatMoney(12050); s != "€120.50" {
+		t.Errorf("expected €120.50 but got %s", s)
+	}
+}
@@ -2,7 +2,7 @@ package render
 
 import (
 	"encoding/gob"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
@@ -10,6 +10,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/gustavNdamukong/hotel-bookings/internal/config"
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
 )
 
@@ -31,13 +32,11 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	testApp.InProduction = false
 
-	// set up logging. Create a new logger that writes to the terminal (os.Stdout), prefix the msg
-	// with "INFO" & a tab, followed by the date & time
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errorLog
+	// set up logging, the same way as the main app
+	logger, _ := logging.New(os.Stdout, logging.FormatText, slog.LevelInfo)
+	testApp.Logger = logger
+	testApp.InfoLog = slog.NewLogLogger(logger.Handler(), slog.LevelInfo)
+	testApp.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelError)
 
 	// set up the session
 	session = scs.New()
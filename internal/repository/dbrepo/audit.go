@@ -0,0 +1,261 @@
+package dbrepo
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gustavNdamukong/hotel-bookings/internal/audit"
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
+	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+)
+
+// NOTES: every method that changes data records what it changed in the audit_events table, in the same
+// transaction as the change itself, so there is never a change without its event or the other way round.
+// Who made the change, & from where, comes from the request's context (see logging.RequestInfo).
+// Emails being queued & sent (& the reminders that queue them) aren't audited, as the email outbox is a
+// record of them already, with the ID of the request that queued each one
+
+// snapshot reads one row of table as JSON, keyed by column name, for the audit trail. table is always one of
+// our own table names, never user input, as it goes straight into the query
+func snapshot(ctx context.Context, tx *sql.Tx, table string, id int) (audit.Fields, error) {
+	var data []byte
+
+	query := fmt.Sprintf(`SELECT to_jsonb(t) FROM %s t WHERE t.id = $1`, table)
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&data); err != nil {
+		return nil, err
+	}
+
+	return decodeFields(data)
+}
+
+// decodeFields reads a row snapshot made by to_jsonb()
+func decodeFields(data []byte) (audit.Fields, error) {
+	var fields audit.Fields
+
+	// NOTES: UseNumber keeps numbers as they are, eg a price of 1000000 cents, instead of turning them into
+	// float64s, which would show as 1e+06
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&fields)
+
+	return fields, err
+}
+
+// recordAudit records, in tx, that the row entityID of the entity table was changed from before to after.
+// A nil before is a new row & a nil after a deleted one. Changes to nothing but eg updated_at aren't recorded
+func recordAudit(ctx context.Context, tx execer, action, entity string, entityID int, before, after audit.Fields) error {
+	changes := audit.Diff(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	// changes made outside of a request, eg by the calendar import job, have no one to record
+	var userID, apiKeyID int
+	var ip string
+	if info := logging.Info(ctx); info != nil {
+		userID, apiKeyID, ip = info.UserID, info.APIKeyID, info.IP
+	}
+
+	stmt := `INSERT INTO audit_events (user_id, api_key_id, action, entity, entity_id, changes, ip, request_id,
+			created_at, updated_at)
+			VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9, $9)`
+
+	_, err = tx.ExecContext(ctx, stmt, userID, apiKeyID, action, entity, entityID, data, ip,
+		logging.RequestID(ctx), time.Now())
+
+	return err
+}
+
+// auditRow records how the row id of table, as it is now in tx, differs from before
+func auditRow(ctx context.Context, tx *sql.Tx, action, table string, id int, before audit.Fields) error {
+	after, err := snapshot(ctx, tx, table, id)
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(ctx, tx, action, table, id, before, after)
+}
+
+// updateAudited runs stmt, an UPDATE of the row id of table, in a transaction of its own & records what it
+// changed. It returns stmt's result, eg to check how many rows it updated
+func (m *postgresDBRepo) updateAudited(ctx context.Context, action, table string, id int, stmt string, args ...any) (sql.Result, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := snapshot(ctx, tx, table, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = auditRow(ctx, tx, action, table, id, before); err != nil {
+		return nil, err
+	}
+
+	return result, tx.Commit()
+}
+
+// deleteAudited deletes the rows of table that match where, in tx, & records each of them as deleted. It
+// returns how many rows were deleted. Like snapshot's table, where is never user input; its values go in args
+func deleteAudited(ctx context.Context, tx *sql.Tx, action, table, where string, args ...any) (int, error) {
+	query := fmt.Sprintf(`DELETE FROM %s t WHERE %s RETURNING t.id, to_jsonb(t)`, table, where)
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	// NOTES: a transaction can't run another statement while it still has rows to read, so all the deleted
+	// rows are read before their events are written
+	type deleted struct {
+		id     int
+		fields audit.Fields
+	}
+	var gone []deleted
+
+	for rows.Next() {
+		var d deleted
+		var data []byte
+		if err := rows.Scan(&d.id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if d.fields, err = decodeFields(data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		gone = append(gone, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range gone {
+		if err := recordAudit(ctx, tx, action, table, d.id, d.fields, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(gone), nil
+}
+
+// AllAuditEvents returns the audit events that match filter, newest first
+func (m *postgresDBRepo) AllAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var events []models.AuditEvent
+
+	// NOTES: each filter is skipped when its value is empty, so one query covers every combination of them.
+	// A LIMIT of NULL means no limit
+	query := `
+		SELECT e.id, COALESCE(e.user_id, 0), COALESCE(u.first_name || ' ' || u.last_name, ''),
+			COALESCE(e.api_key_id, 0), e.action, e.entity, e.entity_id, e.changes, e.ip, e.request_id, e.created_at
+		FROM audit_events e
+		LEFT JOIN users u
+		ON (e.user_id = u.id)
+		WHERE ($1 = '' OR e.action ILIKE '%' || $1 || '%' OR e.entity ILIKE '%' || $1 || '%'
+			OR e.ip ILIKE '%' || $1 || '%' OR e.request_id = $1 OR e.changes::text ILIKE '%' || $1 || '%'
+			OR (u.first_name || ' ' || u.last_name) ILIKE '%' || $1 || '%')
+		AND ($2 = '' OR e.entity = $2)
+		AND ($3 = 0 OR e.entity_id = $3)
+		AND ($4::timestamp IS NULL OR e.created_at >= $4)
+		AND ($5::timestamp IS NULL OR e.created_at < $5)
+		ORDER BY e.id DESC
+		LIMIT NULLIF($6, 0)`
+
+	// To is the last day to list, so everything before the start of the day after it
+	var from, to sql.NullTime
+	if !filter.From.IsZero() {
+		from = sql.NullTime{Time: filter.From, Valid: true}
+	}
+	if !filter.To.IsZero() {
+		to = sql.NullTime{Time: filter.To.AddDate(0, 0, 1), Valid: true}
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, filter.Search, filter.Entity, filter.EntityID, from, to, filter.Limit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEvent
+		var changes []byte
+		err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.UserName,
+			&e.APIKeyID,
+			&e.Action,
+			&e.Entity,
+			&e.EntityID,
+			&changes,
+			&e.IP,
+			&e.RequestID,
+			&e.Created_at,
+		)
+		if err != nil {
+			return events, err
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(changes))
+		dec.UseNumber()
+		if err := dec.Decode(&e.Changes); err != nil {
+			return events, err
+		}
+
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
+
+// AuditEntities returns the names of the tables that have audit events, eg to filter the trail by
+func (m *postgresDBRepo) AuditEntities(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var entities []string
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT DISTINCT entity FROM audit_events ORDER BY entity`)
+	if err != nil {
+		return entities, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entity string
+		if err := rows.Scan(&entity); err != nil {
+			return entities, err
+		}
+		entities = append(entities, entity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entities, err
+	}
+
+	return entities, nil
+}
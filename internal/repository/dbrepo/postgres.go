@@ -2,32 +2,59 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
-	"log"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/gustavNdamukong/hotel-bookings/internal/audit"
+	"github.com/gustavNdamukong/hotel-bookings/internal/logging"
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
+func (m *postgresDBRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
 
+// Ping checks the database can be reached
+func (m *postgresDBRepo) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	return m.DB.PingContext(ctx)
+}
+
+// Stats returns the stats of the DB connection pool
+func (m *postgresDBRepo) Stats() sql.DBStats {
+	return m.DB.Stats()
+}
+
 // InsertReservation inserts a reservation to the DB
 // NOTES: to return multiple values, comma-separate them in parentheses eg (int, error) below.
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 	/*
 	 NOTES: Once the DB connectionn is open, we want to be able to close it when its done doing its job, or if it crashes, or times out.
-	 To do so, Go has a concept of 'context' which you set with a timeout for it to be cancelled. Lets go for a 3 seconds timeout.
+	 To do so, Go has a concept of 'context' which you set with a timeout for it to be cancelled. We build on the context passed in by
+	 the caller (usually the request's r.Context()), so if the client disconnects the query is cancelled too, and we add a timeout
+	 to it which is set in the app config (DBTimeout).
 	 Further below where we make the DB execution, instead of using 'DB.Exec()' like so: '_, err := m.DB.Exec(stmt, ...)' which knows
 	 nothing about context, use 'DB.ExecContext(ctx, stmt, ...)' or even 'DB.QueryRowContext(ctx, stmt, ...)'.
 
 	 Only with 'DB.QueryRowContext()' can you chain a Scan() method after to extract a returned insert ID into a variable for later use.
 	*/
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	var newID int
 
 	// NOTES: This is how to get the last inserted record ID in postgreSQL
@@ -35,7 +62,7 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 			end_date, room_id, created_at, updated_at) 
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
 
-	err := m.DB.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
 		stmt,
 		res.FirstName,
@@ -54,20 +81,36 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		return 0, err
 	}
 
+	if err = auditRow(ctx, tx, "create", "reservations", newID, nil); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
 	return newID, nil
 }
 
 // InsertRoomRestriction inserts a room restriction into the DB
-func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, res models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
 	// NOTES: This is how to get the last inserted record ID in postgreSQL
 	stmt := `INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id, 
 			created_at, updated_at, restriction_id) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7)`
+			VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
 
-	_, err := m.DB.ExecContext(
+	err = tx.QueryRowContext(
 		ctx,
 		stmt,
 		res.StartDate,
@@ -77,33 +120,356 @@ func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) error
 		time.Now(),
 		time.Now(),
 		res.RestrictionID,
-	)
+	).Scan(&newID)
 
 	// we return 0 for no last inserted ID returned
 	if err != nil {
 		return err
 	}
 
+	if err = auditRow(ctx, tx, "create", "room_restrictions", newID, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertReservationWithRestriction re-checks availability for the room, then inserts the reservation
+// and its room restriction. All three run in one serializable transaction, so two guests can never
+// book the same room for overlapping dates, and we never end up with a reservation without its restriction.
+// It returns a *repository.RoomNotAvailableError if the room has been taken in the meantime.
+// The emails from the emails func (which may be nil) are queued in the same transaction, so a reservation
+// is never saved without its confirmation email, or the email sent for a reservation that was not saved.
+func (m *postgresDBRepo) InsertReservationWithRestriction(ctx context.Context, res models.Reservation, emails repository.ReservationEmails) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	/*
+	 NOTES: Here is how to run DB transactions in Go. BeginTx() gives us a *sql.Tx which has the same
+	 ExecContext(), QueryRowContext() etc methods as *sql.DB. Nothing is saved until we call Commit() on it.
+	 Deferring Rollback() is safe; once Commit() has succeeded, Rollback() does nothing.
+	 With the serializable isolation level, if another transaction books the same room at the same time,
+	 postgres aborts one of them with a serialization failure (error code 40001) instead of letting both through.
+	*/
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newID, err := insertReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	notAvailable := roomNotAvailable(res)
+
+	if emails != nil {
+		res.ID = newID
+		msgs, err := emails(res)
+		if err != nil {
+			return 0, err
+		}
+		if err = queueEmails(ctx, tx, msgs); err != nil {
+			return 0, serializationError(err, notAvailable)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, serializationError(err, notAvailable)
+	}
+
+	return newID, nil
+}
+
+// InsertBooking inserts a booking & each of its reservations with their room restrictions, like
+// InsertReservationWithRestriction does for one reservation. It all happens in one serializable transaction,
+// so either every room of the booking is booked or none of them are. It returns the booking with its ID &
+// the IDs of its reservations, or a *repository.RoomNotAvailableError for the first room that has been taken
+// in the meantime. The emails from the emails func (which may be nil) are queued in the same transaction.
+func (m *postgresDBRepo) InsertBooking(ctx context.Context, b models.Booking, emails repository.BookingEmails) (models.Booking, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	if len(b.Reservations) == 0 {
+		return b, errors.New("a booking needs at least one reservation")
+	}
+
+	// if the transaction can't commit because another booking took one of the rooms, we can't tell which
+	// room it was, so we blame the first
+	notAvailable := roomNotAvailable(b.Reservations[0])
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return b, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO bookings (first_name, last_name, email, phone, total_price, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, b.FirstName, b.LastName, b.Email, b.Phone, b.TotalPrice,
+		time.Now(), time.Now()).Scan(&b.ID)
+	if err != nil {
+		return b, serializationError(err, notAvailable)
+	}
+
+	if err = auditRow(ctx, tx, "create", "bookings", b.ID, nil); err != nil {
+		return b, serializationError(err, notAvailable)
+	}
+
+	// NOTES: ranging over a slice gives us a copy of each item, so to change the reservations in the
+	// booking itself we index into it instead
+	for i := range b.Reservations {
+		b.Reservations[i].BookingID = b.ID
+		b.Reservations[i].ID, err = insertReservation(ctx, tx, b.Reservations[i])
+		if err != nil {
+			return b, err
+		}
+	}
+
+	if emails != nil {
+		msgs, err := emails(b)
+		if err != nil {
+			return b, err
+		}
+		if err = queueEmails(ctx, tx, msgs); err != nil {
+			return b, serializationError(err, notAvailable)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return b, serializationError(err, notAvailable)
+	}
+
+	return b, nil
+}
+
+// insertReservation re-checks that the room of res is free for its dates & not archived, then inserts res &
+// the room restriction that blocks the room for it, in tx. It returns the new reservation's ID
+func insertReservation(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	notAvailable := roomNotAvailable(res)
+
+	var numRows int
+
+	query := `
+		SELECT count(id) FROM room_restrictions
+		WHERE room_id = $1
+		AND $2 < end_date AND $3 > start_date`
+
+	err := tx.QueryRowContext(ctx, query, res.RoomId, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, serializationError(err, notAvailable)
+	}
+
+	if numRows > 0 {
+		return 0, notAvailable
+	}
+
+	// an archived room can't be booked any more, even though it may be free
+	var archived bool
+	err = tx.QueryRowContext(ctx, `SELECT archived_at IS NOT NULL FROM rooms WHERE id = $1`, res.RoomId).Scan(&archived)
+	if err != nil {
+		return 0, serializationError(err, notAvailable)
+	}
+	if archived {
+		return 0, notAvailable
+	}
+
+	// a reservation booked on its own has no booking, so its booking_id is NULL
+	bookingID := sql.NullInt64{Int64: int64(res.BookingID), Valid: res.BookingID != 0}
+
+	var newID int
+
+	stmt := `INSERT INTO reservations (first_name, last_name, email, phone, start_date,
+			end_date, room_id, total_price, booking_id, adults, children, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
+
+	err = tx.QueryRowContext(
+		ctx,
+		stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomId,
+		res.TotalPrice,
+		bookingID,
+		res.Adults,
+		res.Children,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, serializationError(err, notAvailable)
+	}
+
+	if err = auditRow(ctx, tx, "create", "reservations", newID, nil); err != nil {
+		return 0, serializationError(err, notAvailable)
+	}
+
+	var restrictionID int
+
+	// restriction_id 1 is the 'Reservation' restriction
+	stmt = `INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id,
+			created_at, updated_at, restriction_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(
+		ctx,
+		stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomId,
+		newID,
+		time.Now(),
+		time.Now(),
+		1,
+	).Scan(&restrictionID)
+	if err != nil {
+		return 0, serializationError(err, notAvailable)
+	}
+
+	if err = auditRow(ctx, tx, "create", "room_restrictions", restrictionID, nil); err != nil {
+		return 0, serializationError(err, notAvailable)
+	}
+
+	return newID, nil
+}
+
+// roomNotAvailable is the error for when the room of res is not free for its dates
+func roomNotAvailable(res models.Reservation) *repository.RoomNotAvailableError {
+	return &repository.RoomNotAvailableError{
+		RoomID:    res.RoomId,
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+	}
+}
+
+// ChangeReservationDates moves a reservation to res.StartDate - res.EndDate & sets its new total price.
+// Like InsertReservationWithRestriction, the availability check & the updates happen in one serializable
+// transaction. The reservation's own room restriction does not count against the new dates, so guests can
+// shorten or extend a stay. The emails from the emails func (which may be nil) are queued in the same transaction.
+func (m *postgresDBRepo) ChangeReservationDates(ctx context.Context, res models.Reservation, emails repository.ReservationEmails) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	notAvailable := &repository.RoomNotAvailableError{
+		RoomID:    res.RoomId,
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+	}
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var numRows int
+
+	query := `
+		SELECT count(id) FROM room_restrictions
+		WHERE room_id = $1
+		AND $2 < end_date AND $3 > start_date
+		AND (reservation_id IS NULL OR reservation_id <> $4)`
+
+	err = tx.QueryRowContext(ctx, query, res.RoomId, res.StartDate, res.EndDate, res.ID).Scan(&numRows)
+	if err != nil {
+		return serializationError(err, notAvailable)
+	}
+
+	if numRows > 0 {
+		return notAvailable
+	}
+
+	before, err := snapshot(ctx, tx, "reservations", res.ID)
+	if err != nil {
+		return serializationError(err, notAvailable)
+	}
+
+	stmt := `UPDATE reservations SET start_date = $1, end_date = $2, total_price = $3, updated_at = $4
+			WHERE id = $5`
+
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.TotalPrice, time.Now(), res.ID)
+	if err != nil {
+		return serializationError(err, notAvailable)
+	}
+
+	// the room restriction just follows the reservation's dates, so only the reservation's change is recorded
+	if err = auditRow(ctx, tx, "change-dates", "reservations", res.ID, before); err != nil {
+		return serializationError(err, notAvailable)
+	}
+
+	stmt = `UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3
+			WHERE reservation_id = $4`
+
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, time.Now(), res.ID)
+	if err != nil {
+		return serializationError(err, notAvailable)
+	}
+
+	if emails != nil {
+		msgs, err := emails(res)
+		if err != nil {
+			return err
+		}
+		if err = queueEmails(ctx, tx, msgs); err != nil {
+			return serializationError(err, notAvailable)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return serializationError(err, notAvailable)
+	}
+
 	return nil
 }
 
+// serializationError returns notAvailable if err is a postgres serialization failure, which is what
+// a serializable transaction gets when a concurrent transaction has just booked the same room.
+// Any other error is returned as is.
+func serializationError(err error, notAvailable *repository.RoomNotAvailableError) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "40001" {
+		return notAvailable
+	}
+	return err
+}
+
 // SearchAvailabilityByDatesByRoomId returns true if availability exists for roomID & false if no availability exists
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
 	var numRows int
 
+	// an archived room counts as taken, so it can't be booked any more
 	query := `
 		SELECT count(id) FROM room_restrictions 
 		WHERE room_id = $1
-		AND NOT ($2 > end_date OR $3 < start_date);`
+		AND NOT ($2 > end_date OR $3 < start_date)
+		UNION ALL
+		SELECT count(id) FROM rooms WHERE id = $1 AND archived_at IS NOT NULL`
 
-	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
-	err := row.Scan(&numRows)
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
 	if err != nil {
 		return false, err
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			return false, err
+		}
+		numRows += n
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
 
 	if numRows == 0 {
 		return true, nil
@@ -112,23 +478,27 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time,
 	return false, nil
 }
 
-// SearchAvailabilityForAllRooms returns a slice of available rooms if any for given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// SearchAvailabilityForAllRooms returns a slice of available rooms if any for given date range, leaving out
+// rooms too small for the number of guests
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
 	// initialise an empty slice
 	var rooms []models.Room
 
 	query := `
-		SELECT r.id, r.room_name 
+		SELECT r.id, r.room_name, r.slug, r.capacity
 		FROM rooms r 
-		WHERE r.id NOT IN (
+		WHERE r.archived_at IS NULL
+		AND r.capacity >= $3
+		AND r.id NOT IN (
 			SELECT rr.room_id FROM room_restrictions rr WHERE $1 < rr.end_date AND $2 > rr.start_date
-			);
+			)
+		ORDER BY r.sort_order, r.id;
 		`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, guests)
 	if err != nil {
 		return rooms, err
 	}
@@ -141,6 +511,8 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 		err := rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.Slug,
+			&room.Capacity,
 		)
 		if err != nil {
 			return rooms, err
@@ -154,40 +526,22 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 		return rooms, err
 	}
 
+	// the search results show each room's photo
+	if err := m.loadRoomPhotos(ctx, rooms); err != nil {
+		return rooms, err
+	}
+
 	return rooms, nil
 
 }
 
 // GetRoomById returns a room by ID
-func (m *postgresDBRepo) GetRoomById(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	var room models.Room
-
-	query := `
-		SELECT id, room_name, created_at, updated_at
-		FROM rooms
-		WHERE id = $1;
-		`
-
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.Created_at,
-		&room.Updated_at,
-	)
-
-	if err != nil {
-		return room, err
-	}
-
-	return room, nil
+func (m *postgresDBRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	return m.getRoom(ctx, "id = $1", id)
 }
 
-func (m *postgresDBRepo) GetUserById(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
 	query := `SELECT id, first_name, last_name, email, password, access_level, created_at, updated_at
@@ -214,74 +568,93 @@ func (m *postgresDBRepo) GetUserById(id int) (models.User, error) {
 }
 
 // UpdateUser a user in the database
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := snapshot(ctx, tx, "users", u.ID)
+	if err != nil {
+		return err
+	}
+
 	query := `UPDATE users SET first_name = $1, 
 		last_name = $2, 
 		email = $3, 
 		access_level = $4, 
 		updated_at = $5
-		FROM users WHERE id = $1`
-	_, err := m.DB.ExecContext(ctx, query,
+		WHERE id = $6`
+	_, err = tx.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.AccessLevel,
 		time.Now(),
+		u.ID,
 	)
 
 	if err != nil {
 		return err
 	}
-	return nil
+
+	if err = auditRow(ctx, tx, "update", "users", u.ID, before); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Authenticate authenticates a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
 	var id int
 	var hashedPassword string
+	var accessLevel int
 
-	query := `SELECT id, password
+	query := `SELECT id, password, access_level
 		FROM users WHERE email = $1`
 	row := m.DB.QueryRowContext(ctx, query, email)
 
-	err := row.Scan(&id, &hashedPassword)
+	err := row.Scan(&id, &hashedPassword, &accessLevel)
 
 	if err != nil {
-		return id, "", err
+		return id, 0, err
 	}
 
 	// now compare their password with password in the system
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
 	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", errors.New("incorrect password!")
+		return 0, 0, errors.New("incorrect password!")
 	} else if err != nil {
-		return 0, "", err
+		return 0, 0, err
 	}
-	return id, hashedPassword, nil
+	return id, accessLevel, nil
 }
 
-// AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// AllReservations returns the reservations with the given status, or all of them if status is empty
+func (m *postgresDBRepo) AllReservations(ctx context.Context, status string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
 	var reservations []models.Reservation
 
 	query := `
 		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-		r.end_date, r.room_id, r.created_at, r.updated_at, rm.id, rm.room_name
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.status, rm.id, rm.room_name
 		FROM reservations r 
 		LEFT JOIN rooms rm 
 		ON (r.room_id = rm.id)
+		WHERE $1 = '' OR r.status = $1
 		ORDER BY r.start_date ASC`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, status)
 	if err != nil {
 		return reservations, err
 	}
@@ -300,6 +673,7 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 			&i.RoomId,
 			&i.Created_at,
 			&i.Updated_at,
+			&i.Status,
 			&i.Room.ID,
 			&i.Room.RoomName,
 		)
@@ -316,103 +690,66 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
-// AllNewReservations returns a slice of all new reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetReservationById gets one reservation by its ID
+func (m *postgresDBRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
-	var reservations []models.Reservation
+	var res models.Reservation
 
 	query := `
 		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
-		FROM reservations r 
-		LEFT JOIN rooms rm 
-		ON (r.room_id = rm.id)
-		WHERE processed = 0
-		ORDER BY r.start_date ASC`
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.status, r.total_price,
+		COALESCE(r.booking_id, 0), r.adults, r.children, rm.id, rm.room_name
+		FROM reservations r
+		LEFT JOIN rooms rm
+		ON (r.room_id = rm.id) 
+		WHERE r.id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomId,
+		&res.Created_at,
+		&res.Updated_at,
+		&res.Status,
+		&res.TotalPrice,
+		&res.BookingID,
+		&res.Adults,
+		&res.Children,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
 
-	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		return reservations, err
+		return res, err
 	}
-	defer rows.Close()
+	return res, nil
 
-	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomId,
-			&i.Created_at,
-			&i.Updated_at,
-			&i.Processed,
-			&i.Room.ID,
-			&i.Room.RoomName,
-		)
-
-		if err != nil {
-			return reservations, err
-		}
-		reservations = append(reservations, i)
-	}
-
-	if err = rows.Err(); err != nil {
-		return reservations, err
-	}
-	return reservations, nil
 }
 
-// GetReservationById gets one reservation by its ID
-func (m *postgresDBRepo) GetReservationById(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateReservation updates a reservation in the database
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
-	var res models.Reservation
-
-	query := `
-		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-		rm.id, rm.room_name
-		FROM reservations r
-		LEFT JOIN rooms rm
-		ON (r.room_id = rm.id) 
-		WHERE r.id = $1`
-
-	row := m.DB.QueryRowContext(ctx, query, id)
-
-	err := row.Scan(
-		&res.ID,
-		&res.FirstName,
-		&res.LastName,
-		&res.Email,
-		&res.Phone,
-		&res.StartDate,
-		&res.EndDate,
-		&res.RoomId,
-		&res.Created_at,
-		&res.Updated_at,
-		&res.Processed,
-		&res.Room.ID,
-		&res.Room.RoomName,
-	)
-
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return res, err
+		return err
 	}
-	return res, nil
+	defer tx.Rollback()
 
-}
-
-// UpdateReservation updates a reservation in the database
-func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	before, err := snapshot(ctx, tx, "reservations", res.ID)
+	if err != nil {
+		return err
+	}
 
 	query := `
 		UPDATE reservations SET 
@@ -422,7 +759,7 @@ func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
 		phone = $4,  
 		updated_at = $5
 		WHERE id = $6`
-	_, err := m.DB.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -434,84 +771,145 @@ func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
 	if err != nil {
 		return err
 	}
-	return nil
+
+	if err = auditRow(ctx, tx, "update", "reservations", res.ID, before); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// DeleteReservation deletes a reservation by id
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// ChangeReservationStatus moves a reservation to change.ToStatus, if it is allowed to go there from the status
+// it has now, & records the change with who made it & why. Cancelling a reservation deletes its room
+// restriction, which frees the room for those dates, but the reservation itself & its history are kept
+func (m *postgresDBRepo) ChangeReservationStatus(ctx context.Context, change models.ReservationStatusChange) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
-	query := `
-		DELETE FROM reservations 
-		WHERE id = $1`
-	_, err := m.DB.ExecContext(ctx, query, id)
-
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	if err = changeReservationStatus(ctx, tx, change); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// UpdateProcessed updates processed field for a reservation by id
-func (m *postgresDBRepo) UpdateProcessed(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// changeReservationStatus is ChangeReservationStatus, in tx, so that other changes (eg to a payment) can be
+// made in the same transaction
+func changeReservationStatus(ctx context.Context, tx *sql.Tx, change models.ReservationStatusChange) error {
+	// NOTES: 'FOR UPDATE' locks the reservation's row until the transaction ends, so two members of staff
+	// can't both move it on from the same status at the same time
+	query := `SELECT status FROM reservations WHERE id = $1 FOR UPDATE`
+	err := tx.QueryRowContext(ctx, query, change.ReservationID).Scan(&change.FromStatus)
+	if err != nil {
+		return err
+	}
 
-	query := `
-		UPDATE reservations SET 
-		processed = $1 
-		WHERE id = $2`
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	if !models.CanChangeStatus(change.FromStatus, change.ToStatus) {
+		return fmt.Errorf("%w: from %s to %s", repository.ErrStatusChange, change.FromStatus, change.ToStatus)
+	}
 
+	before, err := snapshot(ctx, tx, "reservations", change.ReservationID)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	stmt := `UPDATE reservations SET status = $1, updated_at = $2 WHERE id = $3`
+	_, err = tx.ExecContext(ctx, stmt, change.ToStatus, time.Now(), change.ReservationID)
+	if err != nil {
+		return err
+	}
+
+	if err = auditRow(ctx, tx, "change-status", "reservations", change.ReservationID, before); err != nil {
+		return err
+	}
+
+	if change.ToStatus == models.ReservationCancelled {
+		_, err = deleteAudited(ctx, tx, "delete", "room_restrictions", "t.reservation_id = $1", change.ReservationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt = `INSERT INTO reservation_status_changes (reservation_id, from_status, to_status, user_id, source,
+			reason, created_at, updated_at)
+			VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6, $7, $8)`
+	_, err = tx.ExecContext(ctx, stmt,
+		change.ReservationID,
+		change.FromStatus,
+		change.ToStatus,
+		change.UserID,
+		change.Source,
+		change.Reason,
+		time.Now(),
+		time.Now(),
+	)
+
+	return err
 }
 
-// AllRooms returns all rooms
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// ReservationStatusChanges returns a reservation's status changes, oldest first, with the names of the staff
+// who made them
+func (m *postgresDBRepo) ReservationStatusChanges(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
-	var rooms []models.Room
+	var changes []models.ReservationStatusChange
 
 	query := `
-		SELECT id, room_name, created_at, updated_at
-		FROM rooms
-		ORDER BY room_name`
-
-	rows, err := m.DB.QueryContext(ctx, query)
+		SELECT c.id, c.reservation_id, c.from_status, c.to_status, COALESCE(c.user_id, 0),
+			COALESCE(u.first_name || ' ' || u.last_name, ''), c.source, c.reason, c.created_at
+		FROM reservation_status_changes c
+		LEFT JOIN users u
+		ON (c.user_id = u.id)
+		WHERE c.reservation_id = $1
+		ORDER BY c.created_at, c.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
 	if err != nil {
-		return rooms, err
+		return changes, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var rm models.Room
+		var c models.ReservationStatusChange
 		err := rows.Scan(
-			&rm.ID,
-			&rm.RoomName,
-			&rm.Created_at,
-			&rm.Updated_at,
+			&c.ID,
+			&c.ReservationID,
+			&c.FromStatus,
+			&c.ToStatus,
+			&c.UserID,
+			&c.UserName,
+			&c.Source,
+			&c.Reason,
+			&c.Created_at,
 		)
 		if err != nil {
-			return rooms, err
+			return changes, err
 		}
-		rooms = append(rooms, rm)
+		changes = append(changes, c)
 	}
 
 	if err = rows.Err(); err != nil {
-		return rooms, err
+		return changes, err
 	}
 
-	return rooms, nil
+	return changes, nil
+}
+
+// AllRooms returns all rooms
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	return m.listRooms(ctx, "TRUE")
 }
 
 // GetRestrictionsForRoomByDate returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
 	var restrictions []models.RoomRestriction
@@ -522,7 +920,8 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end tim
 
 	//TODO: MODIFIED THIS TO QUERY BELOW - NEEDS TESTING
 	query := `
-		SELECT id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
+		SELECT id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date,
+		coalesce(external_uid, '')
 		FROM room_restrictions
 		WHERE $1 < end_date
 		AND $2 >= start_date
@@ -549,6 +948,7 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end tim
 			&rr.RoomId,
 			&rr.StartDate,
 			&rr.EndDate,
+			&rr.ExternalUID,
 		)
 		if err != nil {
 			return nil, err
@@ -564,16 +964,24 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end tim
 }
 
 // InsertBlockForRoom inserts a room restriction
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
 	query := `
 		INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id,
 		created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)`
+		VALUES ($1, $2, $3, $4, $5, $6) returning id`
 
-	_, err := m.DB.ExecContext(
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		startDate,
@@ -581,32 +989,1611 @@ func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
 		id,
 		2,
 		time.Now(),
-		time.Now())
+		time.Now()).Scan(&newID)
 
 	// we return 0 for no last inserted ID returned
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 
-	return nil
+	if err = auditRow(ctx, tx, "create", "room_restrictions", newID, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // DeleteBlockById deletes a room restriction
-func (m *postgresDBRepo) DeleteBlockById(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) DeleteBlockById(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = deleteAudited(ctx, tx, "delete", "room_restrictions", "t.id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRoomRateByRoomId returns the base rate, weekend uplift, minimum stay & deposit for a room
+func (m *postgresDBRepo) GetRoomRateByRoomId(ctx context.Context, roomID int) (models.RoomRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
 	defer cancel()
 
+	var rate models.RoomRate
+
 	query := `
-		DELETE FROM room_restrictions WHERE id = $1`
+		SELECT id, room_id, base_rate, weekend_uplift, min_stay, created_at, updated_at, deposit_percent
+		FROM room_rates
+		WHERE room_id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, roomID)
+	err := row.Scan(
+		&rate.ID,
+		&rate.RoomId,
+		&rate.BaseRate,
+		&rate.WeekendUplift,
+		&rate.MinStay,
+		&rate.Created_at,
+		&rate.Updated_at,
+		&rate.DepositPercent,
+	)
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return rate, err
+	}
 
-	// we return 0 for no last inserted ID returned
+	return rate, nil
+}
+
+// GetSeasonalRatesForRoomByDate returns the seasonal rates for a room that overlap a stay from start to end
+func (m *postgresDBRepo) GetSeasonalRatesForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	// a season's end_date is the last night it covers, while a stay's end is the departure date
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM seasonal_rates
+		WHERE room_id = $1
+		AND $2 <= end_date
+		AND $3 > start_date
+		ORDER BY start_date`, seasonalRateColumns)
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSeasonalRates(rows)
+}
+
+// SeasonalRatesByRoomId returns all of a room's seasonal rates, past ones too, by the date they start
+func (m *postgresDBRepo) SeasonalRatesByRoomId(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT %s FROM seasonal_rates WHERE room_id = $1 ORDER BY start_date`, seasonalRateColumns)
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSeasonalRates(rows)
+}
+
+// GetSeasonalRateById returns a seasonal rate by id
+func (m *postgresDBRepo) GetSeasonalRateById(ctx context.Context, id int) (models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT %s FROM seasonal_rates WHERE id = $1`, seasonalRateColumns)
+
+	return scanSeasonalRate(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertSeasonalRate adds a seasonal rate to a room & returns its id
+func (m *postgresDBRepo) InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `INSERT INTO seasonal_rates (room_id, season_name, start_date, end_date, nightly_rate, min_stay,
+			deposit_percent, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+			RETURNING id`
+
+	err = tx.QueryRowContext(ctx, stmt, s.RoomId, s.SeasonName, s.StartDate, s.EndDate, s.NightlyRate, s.MinStay,
+		s.DepositPercent, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = auditRow(ctx, tx, "create", "seasonal_rates", newID, nil); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateSeasonalRate changes a seasonal rate, but not the room it is for. It returns sql.ErrNoRows if there is
+// no such seasonal rate
+func (m *postgresDBRepo) UpdateSeasonalRate(ctx context.Context, s models.SeasonalRate) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	stmt := `UPDATE seasonal_rates SET season_name = $1, start_date = $2, end_date = $3, nightly_rate = $4,
+			min_stay = $5, deposit_percent = $6, updated_at = $7
+			WHERE id = $8`
+
+	result, err := m.updateAudited(ctx, "update", "seasonal_rates", s.ID, stmt, s.SeasonName, s.StartDate,
+		s.EndDate, s.NightlyRate, s.MinStay, s.DepositPercent, time.Now(), s.ID)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
 
 	return nil
 }
+
+// DeleteSeasonalRate removes a seasonal rate, so its room's base rate applies to its dates again
+func (m *postgresDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = deleteAudited(ctx, tx, "delete", "seasonal_rates", "t.id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const seasonalRateColumns = `id, room_id, season_name, start_date, end_date, nightly_rate, min_stay, created_at,
+	updated_at, deposit_percent`
+
+// scanSeasonalRate scans a row of seasonalRateColumns from either a *sql.Row or *sql.Rows
+func scanSeasonalRate(row interface{ Scan(dest ...any) error }) (models.SeasonalRate, error) {
+	var s models.SeasonalRate
+	err := row.Scan(&s.ID, &s.RoomId, &s.SeasonName, &s.StartDate, &s.EndDate, &s.NightlyRate, &s.MinStay,
+		&s.Created_at, &s.Updated_at, &s.DepositPercent)
+	return s, err
+}
+
+// scanSeasonalRates reads every seasonal rate in rows, & closes them
+func scanSeasonalRates(rows *sql.Rows) ([]models.SeasonalRate, error) {
+	defer rows.Close()
+
+	var seasons []models.SeasonalRate
+	for rows.Next() {
+		s, err := scanSeasonalRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, s)
+	}
+
+	return seasons, rows.Err()
+}
+
+// InsertAPIKey stores a new API key & returns its id. Only the hash of the key is stored
+func (m *postgresDBRepo) InsertAPIKey(ctx context.Context, k models.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		k.UserID,
+		k.Name,
+		k.Prefix,
+		k.KeyHash,
+		strings.Join(k.Scopes, ","),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// the key's hash is redacted in the audit trail, like a password's
+	if err = auditRow(ctx, tx, "create", "api_keys", newID, nil); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// AllAPIKeys returns all API keys, newest first, including revoked ones
+func (m *postgresDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var keys []models.APIKey
+
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, revoked_at, created_at, updated_at
+		FROM api_keys
+		ORDER BY created_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the API key with the given hash, whether or not it has been revoked
+func (m *postgresDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, revoked_at, created_at, updated_at
+		FROM api_keys
+		WHERE key_hash = $1`
+
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, hash))
+}
+
+// RevokeAPIKey stops an API key from working. The key is kept so that admins can still see it
+func (m *postgresDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := snapshot(ctx, tx, "api_keys", id)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE api_keys SET revoked_at = $1, updated_at = $1
+		WHERE id = $2 AND revoked_at IS NULL`
+
+	_, err = tx.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	// revoking a key that's already revoked changes nothing, so isn't recorded
+	if err = auditRow(ctx, tx, "revoke", "api_keys", id, before); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// scanAPIKey scans one api_keys row from either a *sql.Row or *sql.Rows
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (models.APIKey, error) {
+	var k models.APIKey
+	var scopes string
+	// NOTES: revoked_at can be NULL, which can't be scanned into a time.Time, so we scan it into sql.NullTime
+	var revokedAt sql.NullTime
+
+	err := row.Scan(
+		&k.ID,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&scopes,
+		&revokedAt,
+		&k.Created_at,
+		&k.Updated_at,
+	)
+	if err != nil {
+		return k, err
+	}
+
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	k.RevokedAt = revokedAt.Time
+
+	return k, nil
+}
+
+// GetRoomCalendarByRoomId returns a room's iCal settings
+func (m *postgresDBRepo) GetRoomCalendarByRoomId(ctx context.Context, roomID int) (models.RoomCalendar, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := `
+		SELECT c.id, c.room_id, c.export_token, c.import_url, c.last_import_at, c.created_at, c.updated_at,
+		r.id, r.room_name
+		FROM room_calendars c
+		LEFT JOIN rooms r ON (c.room_id = r.id)
+		WHERE c.room_id = $1`
+
+	return scanRoomCalendar(m.DB.QueryRowContext(ctx, query, roomID))
+}
+
+// GetRoomCalendarByExportToken returns the iCal settings of the room whose export feed uses token
+func (m *postgresDBRepo) GetRoomCalendarByExportToken(ctx context.Context, token string) (models.RoomCalendar, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := `
+		SELECT c.id, c.room_id, c.export_token, c.import_url, c.last_import_at, c.created_at, c.updated_at,
+		r.id, r.room_name
+		FROM room_calendars c
+		LEFT JOIN rooms r ON (c.room_id = r.id)
+		WHERE c.export_token = $1`
+
+	return scanRoomCalendar(m.DB.QueryRowContext(ctx, query, token))
+}
+
+// AllRoomCalendarsWithImports returns the iCal settings of every room that imports an external calendar
+func (m *postgresDBRepo) AllRoomCalendarsWithImports(ctx context.Context) ([]models.RoomCalendar, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var calendars []models.RoomCalendar
+
+	query := `
+		SELECT c.id, c.room_id, c.export_token, c.import_url, c.last_import_at, c.created_at, c.updated_at,
+		r.id, r.room_name
+		FROM room_calendars c
+		LEFT JOIN rooms r ON (c.room_id = r.id)
+		WHERE c.import_url <> ''
+		ORDER BY c.room_id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return calendars, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanRoomCalendar(rows)
+		if err != nil {
+			return calendars, err
+		}
+		calendars = append(calendars, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return calendars, err
+	}
+
+	return calendars, nil
+}
+
+// InsertRoomCalendar stores a room's iCal settings & returns their id
+func (m *postgresDBRepo) InsertRoomCalendar(ctx context.Context, c models.RoomCalendar) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO room_calendars (room_id, export_token, import_url, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	err = tx.QueryRowContext(ctx, stmt, c.RoomId, c.ExportToken, c.ImportURL, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = auditRow(ctx, tx, "create", "room_calendars", newID, nil); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoomCalendar updates a room's iCal settings
+func (m *postgresDBRepo) UpdateRoomCalendar(ctx context.Context, c models.RoomCalendar) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var lastImportAt sql.NullTime
+	if !c.LastImportAt.IsZero() {
+		lastImportAt = sql.NullTime{Time: c.LastImportAt, Valid: true}
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := snapshot(ctx, tx, "room_calendars", c.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		UPDATE room_calendars SET export_token = $1, import_url = $2, last_import_at = $3, updated_at = $4
+		WHERE id = $5`
+
+	_, err = tx.ExecContext(ctx, stmt, c.ExportToken, c.ImportURL, lastImportAt, time.Now(), c.ID)
+	if err != nil {
+		return err
+	}
+
+	// NOTES: the import job updates last_import_at every time it runs, which the audit trail ignores, so
+	// only changes made in admin are recorded
+	if err = auditRow(ctx, tx, "update", "room_calendars", c.ID, before); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpsertExternalBlock adds an owner block for an event from an external calendar. If the room already has
+// a block for that event's UID, its dates are updated instead, so importing the same calendar twice is harmless
+func (m *postgresDBRepo) UpsertExternalBlock(ctx context.Context, roomID int, uid string, start, end time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the block as it was before this import, if the room had one for the event already
+	var before audit.Fields
+	var data []byte
+	query := `SELECT to_jsonb(t) FROM room_restrictions t WHERE t.room_id = $1 AND t.external_uid = $2`
+	err = tx.QueryRowContext(ctx, query, roomID, uid).Scan(&data)
+	if err == nil {
+		before, err = decodeFields(data)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var id int
+
+	// NOTES: ON CONFLICT turns an INSERT into an UPDATE when a row with the same unique key already exists.
+	// It needs a unique index on the conflict columns, here (room_id, external_uid)
+	stmt := `
+		INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, external_uid,
+		created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (room_id, external_uid)
+		DO UPDATE SET start_date = EXCLUDED.start_date, end_date = EXCLUDED.end_date, updated_at = EXCLUDED.updated_at
+		RETURNING id`
+
+	err = tx.QueryRowContext(ctx, stmt, start, end, roomID, 2, uid, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	// re-importing an event whose dates haven't changed isn't recorded
+	if err = auditRow(ctx, tx, "import", "room_restrictions", id, before); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExternalBlocksNotIn removes the imported blocks of a room whose UIDs are not in uids, ie events
+// that have been removed from the external calendar. Blocks added in admin are left alone
+func (m *postgresDBRepo) DeleteExternalBlocksNotIn(ctx context.Context, roomID int, uids []string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	if uids == nil {
+		uids = []string{}
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where := `t.room_id = $1 AND t.external_uid IS NOT NULL AND NOT (t.external_uid = ANY($2))`
+
+	_, err = deleteAudited(ctx, tx, "import", "room_restrictions", where, roomID, uids)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// scanRoomCalendar scans one room_calendars row, joined to its room, from either a *sql.Row or *sql.Rows
+func scanRoomCalendar(row interface{ Scan(dest ...any) error }) (models.RoomCalendar, error) {
+	var c models.RoomCalendar
+	var lastImportAt sql.NullTime
+
+	err := row.Scan(
+		&c.ID,
+		&c.RoomId,
+		&c.ExportToken,
+		&c.ImportURL,
+		&lastImportAt,
+		&c.Created_at,
+		&c.Updated_at,
+		&c.Room.ID,
+		&c.Room.RoomName,
+	)
+	if err != nil {
+		return c, err
+	}
+
+	c.LastImportAt = lastImportAt.Time
+
+	return c, nil
+}
+
+// execer is anything we can run an INSERT or UPDATE with, ie a *sql.DB or a *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// queueEmails adds msgs to the email outbox. Pass it a *sql.Tx to queue them in the same transaction as
+// other changes
+func queueEmails(ctx context.Context, db execer, msgs []models.MailData) error {
+	stmt := `INSERT INTO email_outbox (to_address, from_address, subject, content, text_content, template,
+			request_id, status, attempts, last_error, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 0, '', $9, $9)`
+
+	// the ID of the request that queued the emails is kept with them, so the mail worker's logs about
+	// sending them can be traced back to it
+	requestID := logging.RequestID(ctx)
+
+	for _, msg := range msgs {
+		_, err := db.ExecContext(ctx, stmt, msg.To, msg.From, msg.Subject, msg.Content, msg.Text, msg.Template,
+			requestID, models.OutboxPending, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// QueueEmail adds an email to the outbox, for the mail worker to send
+func (m *postgresDBRepo) QueueEmail(ctx context.Context, msg models.MailData) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	return queueEmails(ctx, m.DB, []models.MailData{msg})
+}
+
+// ClaimOutboxEmails marks up to limit emails as sending & returns them, oldest first. Emails that have been
+// sending for longer than lease are claimed again, as the worker that had them probably crashed.
+func (m *postgresDBRepo) ClaimOutboxEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var emails []models.OutboxEmail
+
+	/*
+	 NOTES: FOR UPDATE locks the rows we select until our statement is done, & SKIP LOCKED makes any other
+	 worker doing the same thing at the same time skip those rows rather than wait for them. So several
+	 copies of the app can share one outbox without two of them sending the same email.
+	*/
+	query := `
+		WITH claimed AS (
+			SELECT id FROM email_outbox
+			WHERE status = $1 OR (status = $2 AND updated_at < $3)
+			ORDER BY id
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE email_outbox e
+		SET status = $2, attempts = e.attempts + 1, updated_at = $5
+		FROM claimed
+		WHERE e.id = claimed.id
+		RETURNING e.id, e.to_address, e.from_address, e.subject, e.content, e.text_content, e.template, e.request_id,
+			e.status, e.attempts, e.last_error, e.sent_at, e.created_at, e.updated_at`
+
+	now := time.Now()
+	rows, err := m.DB.QueryContext(ctx, query, models.OutboxPending, models.OutboxSending, now.Add(-lease), limit, now)
+	if err != nil {
+		return emails, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+
+	return emails, nil
+}
+
+// MarkOutboxEmailSent records that an email has been sent
+func (m *postgresDBRepo) MarkOutboxEmailSent(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	stmt := `UPDATE email_outbox SET status = $1, last_error = '', sent_at = $2, updated_at = $2 WHERE id = $3`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxSent, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MarkOutboxEmailFailed records that sending an email failed, & why
+func (m *postgresDBRepo) MarkOutboxEmailFailed(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	stmt := `UPDATE email_outbox SET status = $1, last_error = $2, updated_at = $3 WHERE id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxFailed, lastError, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllOutboxEmails returns the 200 newest emails in the outbox with the given status, or with any status
+// if status is empty
+func (m *postgresDBRepo) AllOutboxEmails(ctx context.Context, status string) ([]models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var emails []models.OutboxEmail
+
+	query := `
+		SELECT id, to_address, from_address, subject, content, text_content, template, request_id,
+			status, attempts, last_error, sent_at, created_at, updated_at
+		FROM email_outbox
+		WHERE $1 = '' OR status = $1
+		ORDER BY id DESC
+		LIMIT 200`
+
+	rows, err := m.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return emails, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+
+	return emails, nil
+}
+
+// ResendOutboxEmail makes a failed email pending again, so the mail worker tries it again
+func (m *postgresDBRepo) ResendOutboxEmail(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	stmt := `UPDATE email_outbox SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4`
+
+	result, err := m.updateAudited(ctx, "resend", "email_outbox", id, stmt, models.OutboxPending, time.Now(), id,
+		models.OutboxFailed)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scanOutboxEmail scans one email_outbox row from either a *sql.Row or *sql.Rows
+func scanOutboxEmail(row interface{ Scan(dest ...any) error }) (models.OutboxEmail, error) {
+	var e models.OutboxEmail
+	var sentAt sql.NullTime
+
+	err := row.Scan(
+		&e.ID,
+		&e.Msg.To,
+		&e.Msg.From,
+		&e.Msg.Subject,
+		&e.Msg.Content,
+		&e.Msg.Text,
+		&e.Msg.Template,
+		&e.RequestID,
+		&e.Status,
+		&e.Attempts,
+		&e.LastError,
+		&sentAt,
+		&e.Created_at,
+		&e.Updated_at,
+	)
+	if err != nil {
+		return e, err
+	}
+
+	e.SentAt = sentAt.Time
+
+	return e, nil
+}
+
+// GetProperty returns the property's settings
+func (m *postgresDBRepo) GetProperty(ctx context.Context) (models.Property, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var p models.Property
+
+	query := `
+		SELECT id, name, owner_name, owner_email, sender_email, created_at, updated_at
+		FROM properties
+		ORDER BY id
+		LIMIT 1`
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(
+		&p.ID,
+		&p.Name,
+		&p.OwnerName,
+		&p.OwnerEmail,
+		&p.SenderEmail,
+		&p.Created_at,
+		&p.Updated_at,
+	)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// UpdateProperty updates the property's settings
+func (m *postgresDBRepo) UpdateProperty(ctx context.Context, p models.Property) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	stmt := `UPDATE properties SET name = $1, owner_name = $2, owner_email = $3, sender_email = $4, updated_at = $5
+			WHERE id = $6`
+
+	_, err := m.updateAudited(ctx, "update", "properties", p.ID, stmt, p.Name, p.OwnerName, p.OwnerEmail,
+		p.SenderEmail, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateRoomOwner sets who gets the notifications for a room. Empty values mean the property's owner does
+func (m *postgresDBRepo) UpdateRoomOwner(ctx context.Context, room models.Room) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	stmt := `UPDATE rooms SET owner_name = $1, owner_email = $2, updated_at = $3 WHERE id = $4`
+
+	_, err := m.updateAudited(ctx, "update", "rooms", room.ID, stmt, room.OwnerName, room.OwnerEmail, time.Now(),
+		room.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// roomColumns are the columns getRoom & listRooms read, in the order scanRoom scans them
+const roomColumns = `id, room_name, slug, description, capacity, amenities, sort_order, archived_at,
+		owner_name, owner_email, created_at, updated_at`
+
+// scanRoom scans a row of roomColumns into a room
+func scanRoom(row interface{ Scan(dest ...any) error }) (models.Room, error) {
+	var rm models.Room
+	var amenities string
+	// NOTES: archived_at is NULL for rooms on the site, so like revoked_at it is scanned into a sql.NullTime
+	var archivedAt sql.NullTime
+
+	err := row.Scan(
+		&rm.ID,
+		&rm.RoomName,
+		&rm.Slug,
+		&rm.Description,
+		&rm.Capacity,
+		&amenities,
+		&rm.SortOrder,
+		&archivedAt,
+		&rm.OwnerName,
+		&rm.OwnerEmail,
+		&rm.Created_at,
+		&rm.Updated_at,
+	)
+	if err != nil {
+		return rm, err
+	}
+
+	rm.Amenities = splitLines(amenities)
+	rm.ArchivedAt = archivedAt.Time
+
+	return rm, nil
+}
+
+// splitLines splits text kept one item per line, eg a room's amenities, ignoring blank lines
+func splitLines(s string) []string {
+	var items []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			items = append(items, line)
+		}
+	}
+	return items
+}
+
+// getRoom returns the room matching where, with its photos. where is always our own SQL, never user input
+func (m *postgresDBRepo) getRoom(ctx context.Context, where string, args ...any) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT %s FROM rooms WHERE %s`, roomColumns, where)
+
+	room, err := scanRoom(m.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return room, err
+	}
+
+	rooms := []models.Room{room}
+	if err := m.loadRoomPhotos(ctx, rooms); err != nil {
+		return room, err
+	}
+
+	return rooms[0], nil
+}
+
+// listRooms returns the rooms matching where, in the order they are shown on the site. where is always our
+// own SQL, never user input
+func (m *postgresDBRepo) listRooms(ctx context.Context, where string) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := fmt.Sprintf(`SELECT %s FROM rooms WHERE %s ORDER BY sort_order, id`, roomColumns, where)
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rm, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	if err := m.loadRoomPhotos(ctx, rooms); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// roomPhotoColumns are the columns of room_photos, in the order scanRoomPhoto scans them
+const roomPhotoColumns = `id, room_id, path, thumbnail_path, caption, storage_key, sort_order, created_at, updated_at`
+
+func scanRoomPhoto(row interface{ Scan(dest ...any) error }) (models.RoomPhoto, error) {
+	var p models.RoomPhoto
+	err := row.Scan(&p.ID, &p.RoomID, &p.Path, &p.ThumbnailPath, &p.Caption, &p.StorageKey, &p.SortOrder,
+		&p.Created_at, &p.Updated_at)
+	return p, err
+}
+
+// loadRoomPhotos fills in the photos of rooms, in the order they are shown, with one query for all of them
+func (m *postgresDBRepo) loadRoomPhotos(ctx context.Context, rooms []models.Room) error {
+	if len(rooms) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(rooms))
+	byID := make(map[int]*models.Room, len(rooms))
+	for i := range rooms {
+		ids[i] = rooms[i].ID
+		byID[rooms[i].ID] = &rooms[i]
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM room_photos WHERE room_id = ANY($1) ORDER BY sort_order, id`, roomPhotoColumns)
+
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanRoomPhoto(rows)
+		if err != nil {
+			return err
+		}
+		if room, ok := byID[p.RoomID]; ok {
+			room.Photos = append(room.Photos, p)
+		}
+	}
+
+	return rows.Err()
+}
+
+// ActiveRooms returns the rooms that are not archived, in the order they are shown on the site
+func (m *postgresDBRepo) ActiveRooms(ctx context.Context) ([]models.Room, error) {
+	return m.listRooms(ctx, "archived_at IS NULL")
+}
+
+// GetRoomBySlug returns a room, archived or not, by its slug
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	return m.getRoom(ctx, "slug = $1", slug)
+}
+
+// InsertRoom inserts a room & the nightly rate it starts with in one transaction, so a room is never on the
+// site without a price. New rooms are listed last
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, room models.Room, rate models.RoomRate) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `INSERT INTO rooms (room_name, slug, description, capacity, amenities, sort_order, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, (SELECT coalesce(max(sort_order), 0) + 1 FROM rooms), $6, $6)
+			RETURNING id`
+
+	err = tx.QueryRowContext(ctx, stmt, room.RoomName, room.Slug, room.Description, room.Capacity,
+		strings.Join(room.Amenities, "\n"), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, slugError(err)
+	}
+
+	if err = auditRow(ctx, tx, "create", "rooms", newID, nil); err != nil {
+		return 0, err
+	}
+
+	var rateID int
+
+	stmt = `INSERT INTO room_rates (room_id, base_rate, weekend_uplift, min_stay, deposit_percent, created_at,
+			updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $6)
+			RETURNING id`
+
+	err = tx.QueryRowContext(ctx, stmt, newID, rate.BaseRate, rate.WeekendUplift, rate.MinStay, rate.DepositPercent,
+		time.Now()).Scan(&rateID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = auditRow(ctx, tx, "create", "room_rates", rateID, nil); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom updates a room's details. Its photos are changed with the RoomPhoto methods
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	stmt := `UPDATE rooms SET room_name = $1, slug = $2, description = $3, capacity = $4, amenities = $5,
+			updated_at = $6
+			WHERE id = $7`
+
+	result, err := m.updateAudited(ctx, "update", "rooms", room.ID, stmt, room.RoomName, room.Slug,
+		room.Description, room.Capacity, strings.Join(room.Amenities, "\n"), time.Now(), room.ID)
+	if err != nil {
+		return slugError(err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// slugError turns the error for breaking the unique index on rooms.slug into repository.ErrSlugTaken
+func slugError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return repository.ErrSlugTaken
+	}
+	return err
+}
+
+// SetRoomArchived takes a room off the site, or puts it back. It returns sql.ErrNoRows if there is no such room
+func (m *postgresDBRepo) SetRoomArchived(ctx context.Context, id int, archived bool) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var archivedAt sql.NullTime
+	action := "restore"
+	if archived {
+		archivedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		action = "archive"
+	}
+
+	result, err := m.updateAudited(ctx, action, "rooms", id,
+		`UPDATE rooms SET archived_at = $1, updated_at = $2 WHERE id = $3`, archivedAt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ReorderRooms sets the order rooms are shown in to the order of ids, in one transaction
+func (m *postgresDBRepo) ReorderRooms(ctx context.Context, ids []int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		before, err := snapshot(ctx, tx, "rooms", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE rooms SET sort_order = $1, updated_at = $2 WHERE id = $3`,
+			i+1, time.Now(), id)
+		if err != nil {
+			return err
+		}
+
+		// rooms that kept their place aren't recorded
+		if err = auditRow(ctx, tx, "reorder", "rooms", id, before); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRoomPhoto returns a room photo by ID
+func (m *postgresDBRepo) GetRoomPhoto(ctx context.Context, id int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT %s FROM room_photos WHERE id = $1`, roomPhotoColumns)
+
+	return scanRoomPhoto(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertRoomPhoto adds a photo to a room, after its other photos
+func (m *postgresDBRepo) InsertRoomPhoto(ctx context.Context, p models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `INSERT INTO room_photos (room_id, path, thumbnail_path, caption, storage_key, sort_order,
+			created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5,
+			(SELECT coalesce(max(sort_order), 0) + 1 FROM room_photos WHERE room_id = $1), $6, $6)
+			RETURNING id`
+
+	err = tx.QueryRowContext(ctx, stmt, p.RoomID, p.Path, p.ThumbnailPath, p.Caption, p.StorageKey,
+		time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = auditRow(ctx, tx, "create", "room_photos", newID, nil); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoomPhotoCaption changes a photo's caption. It returns sql.ErrNoRows if there is no such photo
+func (m *postgresDBRepo) UpdateRoomPhotoCaption(ctx context.Context, id int, caption string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	result, err := m.updateAudited(ctx, "update", "room_photos", id,
+		`UPDATE room_photos SET caption = $1, updated_at = $2 WHERE id = $3`, caption, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteRoomPhoto removes a photo from its room. The photo's files are not touched
+func (m *postgresDBRepo) DeleteRoomPhoto(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = deleteAudited(ctx, tx, "delete", "room_photos", "t.id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReorderRoomPhotos sets the order a room's photos are shown in to the order of ids, in one transaction.
+// ids of other rooms' photos are ignored
+func (m *postgresDBRepo) ReorderRoomPhotos(ctx context.Context, roomID int, ids []int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		before, err := snapshot(ctx, tx, "room_photos", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE room_photos SET sort_order = $1, updated_at = $2
+			WHERE id = $3 AND room_id = $4`, i+1, time.Now(), id, roomID)
+		if err != nil {
+			return err
+		}
+
+		// as other rooms' photos aren't changed, they aren't recorded either
+		if err = auditRow(ctx, tx, "reorder", "room_photos", id, before); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReservationsArrivingBetween returns the reservations starting between start & end (inclusive) that have not
+// had the notification kind yet
+func (m *postgresDBRepo) ReservationsArrivingBetween(ctx context.Context, start, end time.Time, kind string) ([]models.Reservation, error) {
+	return m.reservationsNeedingNotification(ctx, "start_date", start, end, kind)
+}
+
+// ReservationsDepartingBetween returns the reservations ending between start & end (inclusive) that have not
+// had the notification kind yet
+func (m *postgresDBRepo) ReservationsDepartingBetween(ctx context.Context, start, end time.Time, kind string) ([]models.Reservation, error) {
+	return m.reservationsNeedingNotification(ctx, "end_date", start, end, kind)
+}
+
+// reservationsNeedingNotification does the work for ReservationsArrivingBetween & ReservationsDepartingBetween.
+// Only confirmed stays are listed (as in the reminders package), so pending, cancelled & no-show reservations
+// are left out.
+// dateColumn is always one of our own column names, never user input, as it goes straight into the query
+func (m *postgresDBRepo) reservationsNeedingNotification(ctx context.Context, dateColumn string, start, end time.Time, kind string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := fmt.Sprintf(`
+		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, r.total_price, r.created_at, r.updated_at, r.status, rm.id, rm.room_name
+		FROM reservations r
+		LEFT JOIN rooms rm
+		ON (r.room_id = rm.id)
+		WHERE r.%s BETWEEN $1 AND $2
+		AND r.status IN ('confirmed', 'checked-in', 'checked-out')
+		AND NOT EXISTS (
+			SELECT 1 FROM reservation_notifications n
+			WHERE n.reservation_id = r.id AND n.kind = $3
+		)
+		ORDER BY r.%s, r.id`, dateColumn, dateColumn)
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, kind)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomId,
+			&i.TotalPrice,
+			&i.Created_at,
+			&i.Updated_at,
+			&i.Status,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// QueueReservationNotification records that a reservation has had the notification kind & queues its email,
+// in one transaction. The unique index on (reservation_id, kind) means a notification is only ever queued
+// once, even if two copies of the app try at the same time. It returns false if it had already been queued
+func (m *postgresDBRepo) QueueReservationNotification(ctx context.Context, reservationID int, kind string, msg models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO reservation_notifications (reservation_id, kind, created_at, updated_at)
+			VALUES ($1, $2, $3, $3)
+			ON CONFLICT (reservation_id, kind) DO NOTHING`
+
+	result, err := tx.ExecContext(ctx, stmt, reservationID, kind, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	if err = queueEmails(ctx, tx, []models.MailData{msg}); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// paymentColumns are the columns scanPayment scans, in order
+const paymentColumns = `id, reservation_id, provider, intent_id, amount, refunded, status, created_at, updated_at`
+
+// scanPayment scans a row of paymentColumns from either a *sql.Row or *sql.Rows
+func scanPayment(row interface{ Scan(dest ...any) error }) (models.Payment, error) {
+	var p models.Payment
+
+	err := row.Scan(
+		&p.ID,
+		&p.ReservationID,
+		&p.Provider,
+		&p.IntentID,
+		&p.Amount,
+		&p.Refunded,
+		&p.Status,
+		&p.Created_at,
+		&p.Updated_at,
+	)
+
+	return p, err
+}
+
+// InsertPayments adds the payments of a booking, in one transaction
+func (m *postgresDBRepo) InsertPayments(ctx context.Context, payments []models.Payment) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO payments (reservation_id, provider, intent_id, amount, refunded, status, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, 0, $5, $6, $6)
+			RETURNING id`
+
+	for _, p := range payments {
+		var newID int
+		err = tx.QueryRowContext(ctx, stmt, p.ReservationID, p.Provider, p.IntentID, p.Amount,
+			models.PaymentPending, time.Now()).Scan(&newID)
+		if err != nil {
+			return err
+		}
+
+		if err = auditRow(ctx, tx, "create", "payments", newID, nil); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPaymentById returns a payment by ID
+func (m *postgresDBRepo) GetPaymentById(ctx context.Context, id int) (models.Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT %s FROM payments WHERE id = $1`, paymentColumns)
+
+	return scanPayment(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// PaymentsByReservationId returns a reservation's payments, oldest first
+func (m *postgresDBRepo) PaymentsByReservationId(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT %s FROM payments WHERE reservation_id = $1 ORDER BY id`, paymentColumns)
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPayments(rows)
+}
+
+// PaymentsByIntentId returns the payments of a provider's intent
+func (m *postgresDBRepo) PaymentsByIntentId(ctx context.Context, provider, intentID string) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT %s FROM payments WHERE provider = $1 AND intent_id = $2 ORDER BY id`, paymentColumns)
+
+	rows, err := m.DB.QueryContext(ctx, query, provider, intentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPayments(rows)
+}
+
+// scanPayments reads every payment in rows, & closes them
+func scanPayments(rows *sql.Rows) ([]models.Payment, error) {
+	defer rows.Close()
+
+	var payments []models.Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	return payments, rows.Err()
+}
+
+// UpdatePaymentStatus moves the payments of a provider's intent to status, & confirms their reservations once
+// the guest has paid. It returns the payments of reservations that were cancelled before the guest paid
+func (m *postgresDBRepo) UpdatePaymentStatus(ctx context.Context, provider, intentID, status string) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// NOTES: like in ChangeReservationStatus, 'FOR UPDATE' locks the payments until we are done, so if the
+	// provider sends the same webhook twice at once, the second one waits for the first & then finds that
+	// there is nothing left to change
+	query := fmt.Sprintf(`SELECT %s FROM payments WHERE provider = $1 AND intent_id = $2 ORDER BY id FOR UPDATE`,
+		paymentColumns)
+
+	rows, err := tx.QueryContext(ctx, query, provider, intentID)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := scanPayments(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var cancelled []models.Payment
+	for _, p := range payments {
+		if !models.CanChangePaymentStatus(p.Status, status) {
+			continue
+		}
+
+		before, err := snapshot(ctx, tx, "payments", p.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE payments SET status = $1, updated_at = $2 WHERE id = $3`,
+			status, time.Now(), p.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = auditRow(ctx, tx, "change-status", "payments", p.ID, before); err != nil {
+			return nil, err
+		}
+
+		if status != models.PaymentAuthorized && status != models.PaymentSucceeded {
+			continue
+		}
+
+		err = changeReservationStatus(ctx, tx, models.ReservationStatusChange{
+			ReservationID: p.ReservationID,
+			ToStatus:      models.ReservationConfirmed,
+			Source:        models.StatusChangedByPayment,
+			Reason:        "Deposit paid",
+		})
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, repository.ErrStatusChange) {
+			return nil, err
+		}
+
+		// reservations that aren't pending any more, eg ones an admin has confirmed already, are left as
+		// they are. But a cancelled one can't be confirmed, so the guest has paid for a room they don't have
+		var resStatus string
+		err = tx.QueryRowContext(ctx, `SELECT status FROM reservations WHERE id = $1`, p.ReservationID).Scan(&resStatus)
+		if err != nil {
+			return nil, err
+		}
+		if resStatus == models.ReservationCancelled {
+			p.Status = status
+			cancelled = append(cancelled, p)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return cancelled, nil
+}
+
+// RefundPayment records that amount cents of a payment were given back. Once all of it has been, the payment
+// is refunded
+func (m *postgresDBRepo) RefundPayment(ctx context.Context, id, amount int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.App.DBTimeout)
+	defer cancel()
+
+	stmt := `UPDATE payments SET refunded = refunded + $1,
+			status = CASE WHEN refunded + $1 >= amount THEN $2 ELSE status END,
+			updated_at = $3
+			WHERE id = $4`
+
+	_, err := m.updateAudited(ctx, "refund", "payments", id, stmt, amount, models.PaymentRefunded, time.Now(), id)
+
+	return err
+}
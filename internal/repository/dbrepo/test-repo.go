@@ -1,19 +1,34 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/gustavNdamukong/hotel-bookings/internal/apikeys"
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
+	"github.com/gustavNdamukong/hotel-bookings/internal/repository"
 )
 
-func (m *testDBRepo) AllUsers() bool {
+func (m *testDBRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
 
+// Ping checks the database can be reached. The test database always can
+func (m *testDBRepo) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Stats returns the stats of the DB connection pool. The test database has no pool
+func (m *testDBRepo) Stats() sql.DBStats {
+	return sql.DBStats{}
+}
+
 // InsertReservation inserts a reservation to the DB
 // NOTES: to return multiple values from a func, comma-separate them in parentheses eg (int, error) below.
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 	// if the room id is 2, then fail; otherwise, pass
 	if res.RoomId == 2 {
 		return 0, errors.New("Some error")
@@ -22,98 +37,724 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 }
 
 // InsertRoomRestriction inserts a room restriction into the DB
-func (m *testDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
+func (m *testDBRepo) InsertRoomRestriction(ctx context.Context, res models.RoomRestriction) error {
 	if res.RoomId == 1000 {
 		return errors.New("Some error")
 	}
 	return nil
 }
 
+// InsertReservationWithRestriction inserts a reservation, its room restriction & its emails in one transaction
+func (m *testDBRepo) InsertReservationWithRestriction(ctx context.Context, res models.Reservation, emails repository.ReservationEmails) (int, error) {
+	// if the room id is 2, then fail; otherwise, pass
+	if res.RoomId == 2 {
+		return 0, errors.New("Some error")
+	}
+
+	// a start date after 2069-12-31 simulates someone else having just booked the room
+	layout := "2006-01-02"
+	taken, _ := time.Parse(layout, "2069-12-31")
+	if res.StartDate.After(taken) {
+		return 0, &repository.RoomNotAvailableError{
+			RoomID:    res.RoomId,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+		}
+	}
+
+	// build the emails, as the real repo would, to make sure that doesn't blow up
+	if emails != nil {
+		res.ID = 1
+		if _, err := emails(res); err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
+// InsertBooking inserts a booking, its reservations, their room restrictions & its emails in one transaction
+func (m *testDBRepo) InsertBooking(ctx context.Context, b models.Booking, emails repository.BookingEmails) (models.Booking, error) {
+	if len(b.Reservations) == 0 {
+		return b, errors.New("a booking needs at least one reservation")
+	}
+
+	// like InsertReservationWithRestriction, room 2 fails & a start date after 2069-12-31 simulates someone
+	// else having just booked the room. Either way, none of the booking is saved
+	layout := "2006-01-02"
+	taken, _ := time.Parse(layout, "2069-12-31")
+	for _, res := range b.Reservations {
+		if res.RoomId == 2 {
+			return b, errors.New("Some error")
+		}
+		if res.StartDate.After(taken) {
+			return b, &repository.RoomNotAvailableError{
+				RoomID:    res.RoomId,
+				StartDate: res.StartDate,
+				EndDate:   res.EndDate,
+			}
+		}
+	}
+
+	b.ID = 1
+	for i := range b.Reservations {
+		b.Reservations[i].ID = i + 1
+		b.Reservations[i].BookingID = b.ID
+	}
+
+	// build the emails, as the real repo would, to make sure that doesn't blow up
+	if emails != nil {
+		if _, err := emails(b); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// ChangeReservationDates moves a reservation to new dates & queues its emails
+func (m *testDBRepo) ChangeReservationDates(ctx context.Context, res models.Reservation, emails repository.ReservationEmails) error {
+	// a start date of 2060-01-01 simulates a database error
+	layout := "2006-01-02"
+	errDate, _ := time.Parse(layout, "2060-01-01")
+	if res.StartDate.Equal(errDate) {
+		return errors.New("Some error")
+	}
+
+	// a start date after 2069-12-31 simulates the room being booked for the new dates
+	taken, _ := time.Parse(layout, "2069-12-31")
+	if res.StartDate.After(taken) {
+		return &repository.RoomNotAvailableError{
+			RoomID:    res.RoomId,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+		}
+	}
+
+	if emails != nil {
+		if _, err := emails(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // SearchAvailabilityByDatesByRoomId returns true if availability exists for roomID & false if no availability exists
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time, roomID int) (bool, error) {
-	return false, nil
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	// a start date of 2060-01-01 simulates a database error
+	layout := "2006-01-02"
+	str := "2060-01-01"
+	errDate, _ := time.Parse(layout, str)
+	if start.Equal(errDate) {
+		return false, errors.New("Some error")
+	}
+
+	// a start date after 2049-12-31 means the room is not available
+	str = "2049-12-31"
+	t, _ := time.Parse(layout, str)
+	if start.After(t) {
+		return false, nil
+	}
+
+	return true, nil
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms if any for given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error) {
 	var rooms []models.Room
 
+	// like SearchAvailabilityByDatesByRoomId, a start date after 2049-12-31 means no room is available
+	layout := "2006-01-02"
+	t, _ := time.Parse(layout, "2049-12-31")
+	if start.After(t) {
+		return rooms, nil
+	}
+
+	for _, room := range testRooms {
+		if room.Capacity >= guests {
+			rooms = append(rooms, room)
+		}
+	}
+
 	return rooms, nil
 
 }
 
 // GetRoomById returns a room by ID
-func (m *testDBRepo) GetRoomById(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
 
 	if id > 2 {
 		return room, errors.New("Some error")
 	}
 
+	for _, r := range testRooms {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+
 	return room, nil
 }
 
-func (m *testDBRepo) GetUserById(id int) (models.User, error) {
+// GetUserById returns a user. User 1 is a manager & user 9 does not exist
+func (m *testDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
 	var u models.User
 
+	switch id {
+	case 1:
+		u = models.User{ID: 1, FirstName: "Admin", Email: "admin@here.ca", AccessLevel: 2}
+	case 9:
+		return u, sql.ErrNoRows
+	}
+
 	return u, nil
 }
 
-func (m *testDBRepo) UpdateUser(u models.User) error {
+func (m *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
 	return nil
 }
 
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, int, error) {
 	if email == "me@here.ca" {
-		return 1, "", nil
+		return 1, 3, nil
 	}
-	return 0, "", errors.New("some error")
-}
-
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-	var reservations []models.Reservation
-	return reservations, nil
+	if email == "desk@here.ca" {
+		return 2, 1, nil
+	}
+	return 0, 0, errors.New("some error")
 }
 
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+// AllReservations returns one reservation with the given status, or none if status is empty
+func (m *testDBRepo) AllReservations(ctx context.Context, status string) ([]models.Reservation, error) {
 	var reservations []models.Reservation
+	if status != "" {
+		reservations = append(reservations, models.Reservation{ID: 1, FirstName: "John", Status: status})
+	}
 	return reservations, nil
 }
 
-func (m *testDBRepo) GetReservationById(id int) (models.Reservation, error) {
+func (m *testDBRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
 	var res models.Reservation
+	// any id over 100 is a reservation that does not exist
+	if id > 100 {
+		return res, sql.ErrNoRows
+	}
+
+	layout := "2006-01-02"
+	res.ID = id
+	res.RoomId = 1
+	res.Room = models.Room{ID: 1, RoomName: "General's Quarters"}
+	res.Adults = 2
+	res.Status = models.ReservationConfirmed
+	res.StartDate, _ = time.Parse(layout, "2050-01-01")
+	res.EndDate, _ = time.Parse(layout, "2050-01-03")
+
+	// id 99 is a reservation that has been cancelled
+	if id == 99 {
+		res.Status = models.ReservationCancelled
+	}
+
+	// id 100 is a reservation whose guest has already arrived
+	if id == 100 {
+		res.StartDate, _ = time.Parse(layout, "2020-01-01")
+		res.EndDate, _ = time.Parse(layout, "2020-01-03")
+	}
 	return res, nil
 }
 
-func (m *testDBRepo) UpdateReservation(u models.Reservation) error {
+func (m *testDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
 	return nil
 }
 
-func (m *testDBRepo) DeleteReservation(id int) error {
+// ChangeReservationStatus moves the reservations of GetReservationById on, if they are allowed to go to the
+// new status. A reason of 'fail' simulates a database error
+func (m *testDBRepo) ChangeReservationStatus(ctx context.Context, change models.ReservationStatusChange) error {
+	res, err := m.GetReservationById(ctx, change.ReservationID)
+	if err != nil {
+		return err
+	}
+
+	if !models.CanChangeStatus(res.Status, change.ToStatus) {
+		return fmt.Errorf("%w: from %s to %s", repository.ErrStatusChange, res.Status, change.ToStatus)
+	}
+
+	if change.Reason == "fail" {
+		return errors.New("Some error")
+	}
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessed(id, processed int) error {
-	return nil
+// ReservationStatusChanges returns the one change that confirmed the reservation
+func (m *testDBRepo) ReservationStatusChanges(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error) {
+	return []models.ReservationStatusChange{
+		{ID: 1, ReservationID: reservationID, FromStatus: models.ReservationPending, ToStatus: models.ReservationConfirmed,
+			UserID: 1, UserName: "Admin User", Source: models.StatusChangedByAdmin, Created_at: time.Now()},
+	}, nil
 }
 
 // AllRooms returns all rooms
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
 	var rooms []models.Room
 	return rooms, nil
 }
 
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
 	return restrictions, nil
 }
 
 // InsertBlockForRoom inserts a room restriction
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+func (m *testDBRepo) InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error {
 	return nil
 }
 
 // DeleteBlockById deletes a room restriction
-func (m *testDBRepo) DeleteBlockById(id int) error {
+func (m *testDBRepo) DeleteBlockById(ctx context.Context, id int) error {
+	return nil
+}
+
+// GetRoomRateByRoomId returns the rate for a room
+func (m *testDBRepo) GetRoomRateByRoomId(ctx context.Context, roomID int) (models.RoomRate, error) {
+	rate := models.RoomRate{
+		RoomId:        roomID,
+		BaseRate:      10000,
+		WeekendUplift: 20,
+		MinStay:       1,
+	}
+
+	if roomID > 2 {
+		return rate, errors.New("Some error")
+	}
+
+	return rate, nil
+}
+
+// GetSeasonalRatesForRoomByDate returns the seasonal rates for a room by date range
+func (m *testDBRepo) GetSeasonalRatesForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	var seasons []models.SeasonalRate
+	return seasons, nil
+}
+
+// testSeasonalRates are the seasonal rates of room 1
+var testSeasonalRates = []models.SeasonalRate{
+	{ID: 1, RoomId: 1, SeasonName: "Summer", StartDate: time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate: time.Date(2050, 8, 31, 0, 0, 0, 0, time.UTC), NightlyRate: 15000, MinStay: 3},
+}
+
+// SeasonalRatesByRoomId returns the test seasonal rates of a room
+func (m *testDBRepo) SeasonalRatesByRoomId(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	var seasons []models.SeasonalRate
+	for _, s := range testSeasonalRates {
+		if s.RoomId == roomID {
+			seasons = append(seasons, s)
+		}
+	}
+	return seasons, nil
+}
+
+// GetSeasonalRateById returns the test seasonal rate with the id, or sql.ErrNoRows
+func (m *testDBRepo) GetSeasonalRateById(ctx context.Context, id int) (models.SeasonalRate, error) {
+	for _, s := range testSeasonalRates {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return models.SeasonalRate{}, sql.ErrNoRows
+}
+
+// InsertSeasonalRate adds a seasonal rate. A season named "fail" fails
+func (m *testDBRepo) InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error) {
+	if s.SeasonName == "fail" {
+		return 0, errors.New("Some error")
+	}
+	return 2, nil
+}
+
+// UpdateSeasonalRate changes a seasonal rate. A season named "fail" fails
+func (m *testDBRepo) UpdateSeasonalRate(ctx context.Context, s models.SeasonalRate) error {
+	if s.SeasonName == "fail" {
+		return errors.New("Some error")
+	}
+	return nil
+}
+
+// DeleteSeasonalRate removes a seasonal rate
+func (m *testDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	return nil
+}
+
+// InsertAPIKey inserts an API key
+func (m *testDBRepo) InsertAPIKey(ctx context.Context, k models.APIKey) (int, error) {
+	if k.Name == "fail" {
+		return 0, errors.New("Some error")
+	}
+	return 1, nil
+}
+
+// AllAPIKeys returns all API keys
+func (m *testDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns an API key by its hash. The keys "hb_test_read" & "hb_test_write" carry the
+// matching scope, "hb_test_revoked" has been revoked & any other key does not exist
+func (m *testDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	switch hash {
+	case apikeys.Hash("hb_test_read"):
+		return models.APIKey{ID: 1, Scopes: []string{apikeys.ScopeReservationsRead}}, nil
+	case apikeys.Hash("hb_test_write"):
+		return models.APIKey{ID: 2, Scopes: []string{apikeys.ScopeReservationsWrite}}, nil
+	case apikeys.Hash("hb_test_revoked"):
+		return models.APIKey{ID: 3, Scopes: apikeys.Scopes, RevokedAt: time.Now()}, nil
+	}
+	return models.APIKey{}, sql.ErrNoRows
+}
+
+// RevokeAPIKey revokes an API key
+func (m *testDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	return nil
+}
+
+// GetRoomCalendarByRoomId returns a room's iCal settings. Room 1 has them, room 2 has none yet &
+// any other room fails
+func (m *testDBRepo) GetRoomCalendarByRoomId(ctx context.Context, roomID int) (models.RoomCalendar, error) {
+	switch roomID {
+	case 1:
+		return models.RoomCalendar{ID: 1, RoomId: 1, ExportToken: "room-1-token", Room: models.Room{ID: 1, RoomName: "General's Quarters"}}, nil
+	case 2:
+		return models.RoomCalendar{}, sql.ErrNoRows
+	}
+	return models.RoomCalendar{}, errors.New("Some error")
+}
+
+// GetRoomCalendarByExportToken returns a room's iCal settings by their export token
+func (m *testDBRepo) GetRoomCalendarByExportToken(ctx context.Context, token string) (models.RoomCalendar, error) {
+	if token == "room-1-token" {
+		return models.RoomCalendar{ID: 1, RoomId: 1, ExportToken: token, Room: models.Room{ID: 1, RoomName: "General's Quarters"}}, nil
+	}
+	return models.RoomCalendar{}, sql.ErrNoRows
+}
+
+// AllRoomCalendarsWithImports returns the iCal settings of rooms that import a calendar
+func (m *testDBRepo) AllRoomCalendarsWithImports(ctx context.Context) ([]models.RoomCalendar, error) {
+	var calendars []models.RoomCalendar
+	return calendars, nil
+}
+
+// InsertRoomCalendar inserts a room's iCal settings
+func (m *testDBRepo) InsertRoomCalendar(ctx context.Context, c models.RoomCalendar) (int, error) {
+	return 2, nil
+}
+
+// UpdateRoomCalendar updates a room's iCal settings
+func (m *testDBRepo) UpdateRoomCalendar(ctx context.Context, c models.RoomCalendar) error {
+	return nil
+}
+
+// UpsertExternalBlock inserts or moves an imported block. A uid of "fail" simulates a database error
+func (m *testDBRepo) UpsertExternalBlock(ctx context.Context, roomID int, uid string, start, end time.Time) error {
+	if uid == "fail" {
+		return errors.New("Some error")
+	}
+	return nil
+}
+
+// DeleteExternalBlocksNotIn deletes imported blocks that are no longer in the external calendar
+func (m *testDBRepo) DeleteExternalBlocksNotIn(ctx context.Context, roomID int, uids []string) error {
+	return nil
+}
+
+// QueueEmail adds an email to the outbox
+func (m *testDBRepo) QueueEmail(ctx context.Context, msg models.MailData) error {
+	return nil
+}
+
+// ClaimOutboxEmails claims emails for sending. The test outbox is always empty
+func (m *testDBRepo) ClaimOutboxEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	var emails []models.OutboxEmail
+	return emails, nil
+}
+
+// MarkOutboxEmailSent records that an email has been sent
+func (m *testDBRepo) MarkOutboxEmailSent(ctx context.Context, id int) error {
+	return nil
+}
+
+// MarkOutboxEmailFailed records that sending an email failed
+func (m *testDBRepo) MarkOutboxEmailFailed(ctx context.Context, id int, lastError string) error {
+	return nil
+}
+
+// AllOutboxEmails returns the emails in the outbox. There is one failed email
+func (m *testDBRepo) AllOutboxEmails(ctx context.Context, status string) ([]models.OutboxEmail, error) {
+	emails := []models.OutboxEmail{
+		{
+			ID:        1,
+			Msg:       models.MailData{To: "john@smith.com", From: "me@here.ca", Subject: "Reservation Confirmation"},
+			Status:    models.OutboxFailed,
+			Attempts:  1,
+			LastError: "connection refused",
+		},
+	}
+
+	if status != "" && status != models.OutboxFailed {
+		return nil, nil
+	}
+	return emails, nil
+}
+
+// ResendOutboxEmail makes a failed email pending again. Emails with an id over 100 do not exist
+func (m *testDBRepo) ResendOutboxEmail(ctx context.Context, id int) error {
+	if id > 100 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetProperty returns the property's settings
+func (m *testDBRepo) GetProperty(ctx context.Context) (models.Property, error) {
+	return models.Property{
+		ID:          1,
+		Name:        "Hotel Bookings",
+		OwnerName:   "Owner",
+		OwnerEmail:  "owner@here.ca",
+		SenderEmail: "bookings@here.ca",
+	}, nil
+}
+
+// UpdateProperty updates the property's settings. A name of "fail" fails
+func (m *testDBRepo) UpdateProperty(ctx context.Context, p models.Property) error {
+	if p.Name == "fail" {
+		return errors.New("Some error")
+	}
+	return nil
+}
+
+// UpdateRoomOwner sets who gets the notifications for a room. Rooms with an id over 2 fail
+func (m *testDBRepo) UpdateRoomOwner(ctx context.Context, room models.Room) error {
+	if room.ID > 2 {
+		return errors.New("Some error")
+	}
+	return nil
+}
+
+// testRooms are the rooms the test repository knows about
+var testRooms = []models.Room{
+	{ID: 1, RoomName: "General's Quarters", Slug: "generals-quarters", Capacity: 2, SortOrder: 1,
+		Amenities: []string{"Ocean view"}, Photos: testRoomPhotos},
+	{ID: 2, RoomName: "Major's Suite", Slug: "majors-suite", Capacity: 4, SortOrder: 2},
+}
+
+// testRoomPhotos are the photos of room 1: one that is part of the site & one that was uploaded
+var testRoomPhotos = []models.RoomPhoto{
+	{ID: 1, RoomID: 1, Path: "/static/images/generals-quarters.png", SortOrder: 1},
+	{ID: 2, RoomID: 1, Path: "/uploads/rooms/1/ab12-display.jpg", ThumbnailPath: "/uploads/rooms/1/ab12-thumb.jpg",
+		Caption: "The view", StorageKey: "rooms/1/ab12", SortOrder: 2},
+}
+
+// ActiveRooms returns the test rooms
+func (m *testDBRepo) ActiveRooms(ctx context.Context) ([]models.Room, error) {
+	return testRooms, nil
+}
+
+// GetRoomBySlug returns the test room with the slug, or sql.ErrNoRows
+func (m *testDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	for _, room := range testRooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// InsertRoom inserts a room. A room named "fail" fails, & the slug "taken" is already taken
+func (m *testDBRepo) InsertRoom(ctx context.Context, room models.Room, rate models.RoomRate) (int, error) {
+	if room.RoomName == "fail" {
+		return 0, errors.New("Some error")
+	}
+	if room.Slug == "taken" {
+		return 0, repository.ErrSlugTaken
+	}
+	return 3, nil
+}
+
+// UpdateRoom updates a room. Rooms with an id over 2 don't exist, & the slug "taken" is already taken
+func (m *testDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if room.ID > 2 {
+		return sql.ErrNoRows
+	}
+	if room.Slug == "taken" {
+		return repository.ErrSlugTaken
+	}
+	return nil
+}
+
+// SetRoomArchived archives or restores a room. Rooms with an id over 2 don't exist
+func (m *testDBRepo) SetRoomArchived(ctx context.Context, id int, archived bool) error {
+	if id > 2 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ReorderRooms sets the order of the rooms
+func (m *testDBRepo) ReorderRooms(ctx context.Context, ids []int) error {
+	return nil
+}
+
+// GetRoomPhoto returns one of testRoomPhotos, or sql.ErrNoRows
+func (m *testDBRepo) GetRoomPhoto(ctx context.Context, id int) (models.RoomPhoto, error) {
+	for _, p := range testRoomPhotos {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return models.RoomPhoto{}, sql.ErrNoRows
+}
+
+// InsertRoomPhoto adds a photo to a room. A photo with the caption "fail" fails
+func (m *testDBRepo) InsertRoomPhoto(ctx context.Context, p models.RoomPhoto) (int, error) {
+	if p.Caption == "fail" {
+		return 0, errors.New("Some error")
+	}
+	return 3, nil
+}
+
+// UpdateRoomPhotoCaption changes a photo's caption. Photos with an id over 2 don't exist
+func (m *testDBRepo) UpdateRoomPhotoCaption(ctx context.Context, id int, caption string) error {
+	if id > 2 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteRoomPhoto removes a photo
+func (m *testDBRepo) DeleteRoomPhoto(ctx context.Context, id int) error {
+	return nil
+}
+
+// ReorderRoomPhotos sets the order of a room's photos
+func (m *testDBRepo) ReorderRoomPhotos(ctx context.Context, roomID int, ids []int) error {
+	return nil
+}
+
+// ReservationsArrivingBetween returns the reservations arriving between start & end. There is one, arriving
+// on start, unless start is 2060-01-01 which simulates a database error
+func (m *testDBRepo) ReservationsArrivingBetween(ctx context.Context, start, end time.Time, kind string) ([]models.Reservation, error) {
+	if start.Format("2006-01-02") == "2060-01-01" {
+		return nil, errors.New("Some error")
+	}
+
+	return []models.Reservation{
+		{ID: 1, FirstName: "John", Email: "john@smith.com", RoomId: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2),
+			Status: models.ReservationConfirmed},
+	}, nil
+}
+
+// ReservationsDepartingBetween returns the reservations departing between start & end. There are none
+func (m *testDBRepo) ReservationsDepartingBetween(ctx context.Context, start, end time.Time, kind string) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	return reservations, nil
+}
+
+// QueueReservationNotification queues a notification email. Reservation 2 has had it already
+func (m *testDBRepo) QueueReservationNotification(ctx context.Context, reservationID int, kind string, msg models.MailData) (bool, error) {
+	return reservationID != 2, nil
+}
+
+// AllAuditEvents returns one event, an admin confirming reservation 1. Searching for "fail" simulates a
+// database error
+func (m *testDBRepo) AllAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	if filter.Search == "fail" {
+		return nil, errors.New("Some error")
+	}
+
+	return []models.AuditEvent{
+		{
+			ID:         1,
+			UserID:     1,
+			UserName:   "Admin User",
+			Action:     "change-status",
+			Entity:     "reservations",
+			EntityID:   1,
+			Changes:    map[string]models.AuditChange{"status": {Before: models.ReservationPending, After: models.ReservationConfirmed}},
+			IP:         "127.0.0.1",
+			RequestID:  "test-request",
+			Created_at: time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}, nil
+}
+
+// AuditEntities returns the tables that have audit events
+func (m *testDBRepo) AuditEntities(ctx context.Context) ([]string, error) {
+	return []string{"reservations", "rooms"}, nil
+}
+
+// testPayments are the payments of the test repo: 1 has succeeded, 2 is authorized, 3 has been refunded
+// & 4 was taken with a provider that isn't set up
+var testPayments = []models.Payment{
+	{ID: 1, ReservationID: 1, Provider: "fake", IntentID: "fake_pi_test", Amount: 5000, Status: models.PaymentSucceeded},
+	{ID: 2, ReservationID: 1, Provider: "fake", IntentID: "fake_pi_held", Amount: 5000, Status: models.PaymentAuthorized},
+	{ID: 3, ReservationID: 1, Provider: "fake", IntentID: "fake_pi_test", Amount: 5000, Refunded: 5000, Status: models.PaymentRefunded},
+	{ID: 4, ReservationID: 1, Provider: "stripe", IntentID: "pi_123", Amount: 5000, Status: models.PaymentSucceeded},
+}
+
+// InsertPayments adds payments
+func (m *testDBRepo) InsertPayments(ctx context.Context, payments []models.Payment) error {
+	return nil
+}
+
+// GetPaymentById returns one of testPayments, or sql.ErrNoRows
+func (m *testDBRepo) GetPaymentById(ctx context.Context, id int) (models.Payment, error) {
+	for _, p := range testPayments {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return models.Payment{}, sql.ErrNoRows
+}
+
+// PaymentsByReservationId returns the deposit paid for the reservation
+func (m *testDBRepo) PaymentsByReservationId(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	return testPayments[:1], nil
+}
+
+// PaymentsByIntentId returns a deposit of 5000 cents paid with the intent. The intent "fake_pi_unknown" has
+// none, & the deposit of "fake_pi_cancelled" is for reservation 99, which is cancelled
+func (m *testDBRepo) PaymentsByIntentId(ctx context.Context, provider, intentID string) ([]models.Payment, error) {
+	switch intentID {
+	case "fake_pi_unknown":
+		return nil, nil
+	case "fake_pi_cancelled":
+		return []models.Payment{cancelledPayment}, nil
+	}
+
+	p := testPayments[0]
+	p.Provider, p.IntentID, p.Status = provider, intentID, models.PaymentPending
+	return []models.Payment{p}, nil
+}
+
+// cancelledPayment is the deposit paid with the intent "fake_pi_cancelled", for a cancelled reservation
+var cancelledPayment = models.Payment{ID: 5, ReservationID: 99, Provider: "fake", IntentID: "fake_pi_cancelled",
+	Amount: 5000, Status: models.PaymentPending}
+
+// UpdatePaymentStatus moves an intent's payments to status. The intent "fake_pi_fail" simulates a database
+// error, & the deposit of "fake_pi_cancelled" is paid for a cancelled reservation
+func (m *testDBRepo) UpdatePaymentStatus(ctx context.Context, provider, intentID, status string) ([]models.Payment, error) {
+	switch intentID {
+	case "fake_pi_fail":
+		return nil, errors.New("Some error")
+	case "fake_pi_cancelled":
+		if status == models.PaymentAuthorized || status == models.PaymentSucceeded {
+			p := cancelledPayment
+			p.Status = status
+			return []models.Payment{p}, nil
+		}
+	}
+	return nil, nil
+}
+
+// RefundPayment records a refund
+func (m *testDBRepo) RefundPayment(ctx context.Context, id, amount int) error {
 	return nil
 }
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSlugTaken is returned when a room is saved with a slug another room already has
+var ErrSlugTaken = errors.New("another room already has that slug")
+
+// ErrStatusChange is returned when a reservation is moved to a status it can't go to from its current one,
+// eg a cancelled reservation being checked in
+var ErrStatusChange = errors.New("the reservation can't be moved to that status")
+
+// RoomNotAvailableError is returned when a room is already restricted (booked or blocked)
+// for some or all of the requested dates by the time we try to book it
+type RoomNotAvailableError struct {
+	RoomID    int
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+func (e *RoomNotAvailableError) Error() string {
+	return fmt.Sprintf("room %d is no longer available from %s to %s",
+		e.RoomID, e.StartDate.Format("2006-01-02"), e.EndDate.Format("2006-01-02"))
+}
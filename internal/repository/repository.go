@@ -1,33 +1,148 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
 	"time"
 
 	"github.com/gustavNdamukong/hotel-bookings/internal/models"
 )
 
+// ReservationEmails builds the emails to send about a reservation. It is given the reservation once it has
+// its ID, & the emails it returns are queued in the email outbox in the same transaction as the reservation.
+// If it returns an error, the reservation is not saved either
+type ReservationEmails func(res models.Reservation) ([]models.MailData, error)
+
+// BookingEmails is like ReservationEmails, for a booking of several rooms. It is given the booking once it &
+// all its reservations have their IDs
+type BookingEmails func(b models.Booking) ([]models.MailData, error)
+
 type DatabaseRepo interface {
-	AllUsers() bool
+	// Check the database can be reached, for /readyz
+	Ping(ctx context.Context) error
+	// Stats of the DB connection pool, for /metrics
+	Stats() sql.DBStats
+
+	AllUsers(ctx context.Context) bool
 
 	// Write a reservation to the DB
 	// NOTES: to return multiple values, comma-separate them in parentheses eg (int, error) below.
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(res models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomId(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomById(id int) (models.Room, error)
-	GetUserById(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationById(id int) (models.Reservation, error)
-	UpdateReservation(u models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessed(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
-	DeleteBlockById(id int) error
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, res models.RoomRestriction) error
+	// Check availability, write a reservation, its room restriction & its emails to the DB in one transaction
+	InsertReservationWithRestriction(ctx context.Context, res models.Reservation, emails ReservationEmails) (int, error)
+	// Check availability of all the rooms of a booking, write the booking, its reservations, their room
+	// restrictions & its emails to the DB in one transaction
+	InsertBooking(ctx context.Context, b models.Booking, emails BookingEmails) (models.Booking, error)
+	// Move a reservation & its room restriction to new dates, if the room is free then, & queue its emails
+	// in one transaction
+	ChangeReservationDates(ctx context.Context, res models.Reservation, emails ReservationEmails) error
+	SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	// List the rooms that are free from start to end & sleep at least guests people
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error)
+	GetRoomById(ctx context.Context, id int) (models.Room, error)
+	GetUserById(ctx context.Context, id int) (models.User, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, int, error)
+
+	// List the reservations with the given status, or with any status if it is empty
+	AllReservations(ctx context.Context, status string) ([]models.Reservation, error)
+	GetReservationById(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, u models.Reservation) error
+	// Move a reservation to change.ToStatus & record the change, in one transaction. Cancelling a reservation
+	// also releases its room restriction, so the room can be booked again
+	ChangeReservationStatus(ctx context.Context, change models.ReservationStatusChange) error
+	// List a reservation's status changes, oldest first
+	ReservationStatusChanges(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error
+	DeleteBlockById(ctx context.Context, id int) error
+
+	GetRoomRateByRoomId(ctx context.Context, roomID int) (models.RoomRate, error)
+	GetSeasonalRatesForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error)
+	// List all of a room's seasonal rates, by the date they start
+	SeasonalRatesByRoomId(ctx context.Context, roomID int) ([]models.SeasonalRate, error)
+	GetSeasonalRateById(ctx context.Context, id int) (models.SeasonalRate, error)
+	InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error)
+	UpdateSeasonalRate(ctx context.Context, s models.SeasonalRate) error
+	DeleteSeasonalRate(ctx context.Context, id int) error
+
+	GetRoomCalendarByRoomId(ctx context.Context, roomID int) (models.RoomCalendar, error)
+	GetRoomCalendarByExportToken(ctx context.Context, token string) (models.RoomCalendar, error)
+	AllRoomCalendarsWithImports(ctx context.Context) ([]models.RoomCalendar, error)
+	InsertRoomCalendar(ctx context.Context, c models.RoomCalendar) (int, error)
+	UpdateRoomCalendar(ctx context.Context, c models.RoomCalendar) error
+	// Insert or move the block for an event imported from an external calendar, keyed by its UID
+	UpsertExternalBlock(ctx context.Context, roomID int, uid string, start, end time.Time) error
+	// Delete the imported blocks for a room whose UIDs are no longer in its external calendar
+	DeleteExternalBlocksNotIn(ctx context.Context, roomID int, uids []string) error
+
+	InsertAPIKey(ctx context.Context, k models.APIKey) (int, error)
+	AllAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+
+	GetProperty(ctx context.Context) (models.Property, error)
+	UpdateProperty(ctx context.Context, p models.Property) error
+	// Set who gets a room's notifications instead of the property's owner
+	UpdateRoomOwner(ctx context.Context, room models.Room) error
+
+	// List the rooms that are not archived, in the order they are shown on the site
+	ActiveRooms(ctx context.Context) ([]models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	// Insert a room & the nightly rate it starts with, in one transaction
+	InsertRoom(ctx context.Context, room models.Room, rate models.RoomRate) (int, error)
+	// Update a room's details, but not its photos
+	UpdateRoom(ctx context.Context, room models.Room) error
+	// Take a room off the site, or put it back
+	SetRoomArchived(ctx context.Context, id int, archived bool) error
+	// Set the order rooms are shown in, to the order of ids
+	ReorderRooms(ctx context.Context, ids []int) error
+
+	GetRoomPhoto(ctx context.Context, id int) (models.RoomPhoto, error)
+	// Add a photo to a room, after its other photos
+	InsertRoomPhoto(ctx context.Context, p models.RoomPhoto) (int, error)
+	UpdateRoomPhotoCaption(ctx context.Context, id int, caption string) error
+	DeleteRoomPhoto(ctx context.Context, id int) error
+	// Set the order a room's photos are shown in, to the order of ids
+	ReorderRoomPhotos(ctx context.Context, roomID int, ids []int) error
+
+	// List confirmed reservations arriving (or departing) between start & end that have not had the notification
+	// kind yet
+	ReservationsArrivingBetween(ctx context.Context, start, end time.Time, kind string) ([]models.Reservation, error)
+	ReservationsDepartingBetween(ctx context.Context, start, end time.Time, kind string) ([]models.Reservation, error)
+	// Record that a reservation has had the notification kind & queue its email, unless it has had it already
+	QueueReservationNotification(ctx context.Context, reservationID int, kind string, msg models.MailData) (bool, error)
+
+	// Add an email to the outbox, to be sent by the mail worker
+	QueueEmail(ctx context.Context, msg models.MailData) error
+	// Mark up to limit pending emails (or emails stuck sending for longer than lease) as sending & return them
+	ClaimOutboxEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error)
+	MarkOutboxEmailSent(ctx context.Context, id int) error
+	MarkOutboxEmailFailed(ctx context.Context, id int, lastError string) error
+	// List the newest emails in the outbox with the given status, or with any status if it is empty
+	AllOutboxEmails(ctx context.Context, status string) ([]models.OutboxEmail, error)
+	// Make a failed email pending again
+	ResendOutboxEmail(ctx context.Context, id int) error
+
+	// List the audit events that match filter, newest first
+	AllAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+	// List the tables that have audit events
+	AuditEntities(ctx context.Context) ([]string, error)
+
+	// Add the payments of a booking, one for each of its reservations that has a deposit
+	InsertPayments(ctx context.Context, payments []models.Payment) error
+	GetPaymentById(ctx context.Context, id int) (models.Payment, error)
+	// List a reservation's payments, oldest first
+	PaymentsByReservationId(ctx context.Context, reservationID int) ([]models.Payment, error)
+	// List the payments of a provider's intent, oldest first
+	PaymentsByIntentId(ctx context.Context, provider, intentID string) ([]models.Payment, error)
+	// Move the payments of a provider's intent to status, in one transaction. Payments that can't move to
+	// status (see models.CanChangePaymentStatus) are left alone. Once a payment is authorized or has
+	// succeeded, its reservation is confirmed if it was still pending. It returns the payments that were paid
+	// for reservations that had been cancelled already, which the guest should get back
+	UpdatePaymentStatus(ctx context.Context, provider, intentID, status string) ([]models.Payment, error)
+	// Record that amount cents of a payment were given back to the guest
+	RefundPayment(ctx context.Context, id, amount int) error
 }
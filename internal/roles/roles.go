@@ -0,0 +1,37 @@
+package roles
+
+// Role is the name of a staff role. Each role can do everything the roles below it can, so a manager can do
+// everything front-desk can, & an owner can do everything a manager can
+type Role string
+
+// The roles, from least to most trusted. They map to the access_level column of the users table
+const (
+	FrontDesk Role = "front-desk"
+	Manager   Role = "manager"
+	Owner     Role = "owner"
+)
+
+// ranks orders the roles. A role that is not in here (eg the empty role of someone not logged in) ranks 0
+var ranks = map[Role]int{
+	FrontDesk: 1,
+	Manager:   2,
+	Owner:     3,
+}
+
+// FromAccessLevel returns the role for a users.access_level value. Levels above the highest role are
+// treated as that role, & anything below 1 as front-desk, the least trusted one
+func FromAccessLevel(level int) Role {
+	switch {
+	case level >= ranks[Owner]:
+		return Owner
+	case level == ranks[Manager]:
+		return Manager
+	default:
+		return FrontDesk
+	}
+}
+
+// AtLeast reports whether r is min or a more trusted role
+func (r Role) AtLeast(min Role) bool {
+	return ranks[r] > 0 && ranks[r] >= ranks[min]
+}
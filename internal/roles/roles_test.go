@@ -0,0 +1,44 @@
+package roles
+
+import "testing"
+
+var atLeastTests = []struct {
+	role     Role
+	min      Role
+	expected bool
+}{
+	{FrontDesk, FrontDesk, true},
+	{FrontDesk, Manager, false},
+	{Manager, FrontDesk, true},
+	{Manager, Owner, false},
+	{Owner, Manager, true},
+	{"", FrontDesk, false},
+	{"cleaner", FrontDesk, false},
+}
+
+func TestRole_AtLeast(t *testing.T) {
+	for _, e := range atLeastTests {
+		if got := e.role.AtLeast(e.min); got != e.expected {
+			t.Errorf("%q.AtLeast(%q): expected %t but got %t", e.role, e.min, e.expected, got)
+		}
+	}
+}
+
+var accessLevelTests = []struct {
+	level    int
+	expected Role
+}{
+	{0, FrontDesk},
+	{1, FrontDesk},
+	{2, Manager},
+	{3, Owner},
+	{4, Owner},
+}
+
+func TestFromAccessLevel(t *testing.T) {
+	for _, e := range accessLevelTests {
+		if got := FromAccessLevel(e.level); got != e.expected {
+			t.Errorf("access level %d: expected %s but got %s", e.level, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1 @@
+delete from room_rates;
@@ -0,0 +1,4 @@
+INSERT INTO public.room_rates (room_id,base_rate,weekend_uplift,min_stay,created_at,updated_at) VALUES
+	 (1,12000,20,1,'2026-10-17 00:00:00','2026-10-17 00:00:00'),
+	 (2,9500,15,1,'2026-10-17 00:00:00','2026-10-17 00:00:00');
+
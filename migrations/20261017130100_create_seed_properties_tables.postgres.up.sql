@@ -0,0 +1,2 @@
+INSERT INTO public.properties (name,owner_name,owner_email,sender_email,created_at,updated_at) VALUES
+	 ('Hotel Bookings','','','noreply@example.com','2026-10-17 00:00:00','2026-10-17 00:00:00');
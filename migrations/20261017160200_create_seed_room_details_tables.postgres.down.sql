@@ -0,0 +1,2 @@
+delete from room_photos;
+UPDATE public.rooms SET slug = '', description = '', amenities = '', sort_order = 0;
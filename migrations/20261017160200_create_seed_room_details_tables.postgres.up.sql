@@ -0,0 +1,12 @@
+UPDATE public.rooms SET slug = 'generals-quarters', capacity = 2, sort_order = 1,
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.',
+	amenities = E'Ocean view\nKing size bed\nFree Wi-Fi'
+	WHERE id = 1;
+UPDATE public.rooms SET slug = 'majors-suite', room_name = 'Major''s Suite', capacity = 2, sort_order = 2,
+	description = 'Unwinding, good resting, fine dining, you name it. We have it all. Experiencing is believing.',
+	amenities = E'Queen size bed\nRoom service\nFree Wi-Fi'
+	WHERE id = 2;
+UPDATE public.rooms SET slug = 'room-' || id, sort_order = id WHERE slug = '';
+INSERT INTO public.room_photos (room_id,path,sort_order,created_at,updated_at) VALUES
+	 (1,'/static/images/generals-quarters.png',1,'2026-10-17 00:00:00','2026-10-17 00:00:00'),
+	 (2,'/static/images/marjors-suite.png',1,'2026-10-17 00:00:00','2026-10-17 00:00:00');
@@ -1,4 +1,4 @@
 #!/bin/bash
 
 go build -o bookings cmd/web/*.go && ./bookings
-./bookings -dbname=hotel-bookings -dbuser=user -cache=false production=false
\ No newline at end of file
+./bookings -dbname=hotel-bookings -dbuser=user -cache=false -production=false
\ No newline at end of file